@@ -4,6 +4,7 @@ import (
 	"os"
 
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obicleandb"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
@@ -24,7 +25,13 @@ func main() {
 	fs, err := obiconvert.CLIReadBioSequences(args...)
 	obiconvert.OpenSequenceDataErrorMessage(args, err)
 
-	cleaned := obicleandb.ICleanDB(fs)
+	var cleaned obiiter.IBioSequence
+
+	if obicleandb.CLICheckTaxonomy() {
+		cleaned = obicleandb.ICheckTaxonomyDB(fs)
+	} else {
+		cleaned = obicleandb.ICleanDB(fs)
+	}
 
 	toconsume, _ := obiconvert.CLIWriteBioSequences(cleaned, false)
 	toconsume.Consume()
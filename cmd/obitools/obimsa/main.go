@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obioptions"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obimsa"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+func main() {
+
+	optionParser := obioptions.GenerateOptionParser(
+		"obimsa",
+		"builds the multiple alignment of groups of sequences",
+		obimsa.OptionSet)
+
+	_, args := optionParser(os.Args)
+
+	if threshold := obimsa.CLIConsensusThreshold(); threshold <= 0 || threshold > 1 {
+		log.Fatalf("The consensus threshold must be in ]0,1] (%f)", threshold)
+	}
+
+	sequences, err := obiconvert.CLIReadBioSequences(args...)
+	obiconvert.OpenSequenceDataErrorMessage(args, err)
+
+	aligned := obimsa.CLIMultipleAlignment(sequences)
+
+	obiconvert.CLIWriteBioSequences(aligned, true)
+	obiutils.WaitForLastPipe()
+}
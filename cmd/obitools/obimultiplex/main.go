@@ -45,12 +45,21 @@ func main() {
 		os.Exit(1)
 	}
 
+	if obimultiplex.CLIAskCheck() {
+		if !obimultiplex.CLICheckNGSFilter() {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	sequences, err := obiconvert.CLIReadBioSequences(args...)
 	obiconvert.OpenSequenceDataErrorMessage(args, err)
 
+	sequences = obimultiplex.CLIAddIndexReads(sequences)
 	amplicons, _ := obimultiplex.IExtractBarcode(sequences)
 	obiconvert.CLIWriteBioSequences(amplicons, true)
 	amplicons.Wait()
 	obiutils.WaitForLastPipe()
+	obimultiplex.CLIWriteStats()
 
 }
@@ -5,6 +5,7 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obialign"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obioptions"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
@@ -51,6 +52,41 @@ func main() {
 		os.Exit(1)
 	}
 
+	var calibration *obialign.QualityCalibration
+
+	switch {
+	case obipairing.CLIHasCalibrationFile():
+		calibration, err = obialign.LoadQualityCalibration(obipairing.CLICalibrationFile())
+		if err != nil {
+			log.Errorf("Cannot load the quality calibration (%v)", err)
+			os.Exit(1)
+		}
+		log.Infof("Quality calibration loaded from file: %s", obipairing.CLICalibrationFile())
+	case obipairing.CLICalibrate():
+		calibration, pairs = obipairing.CalibrateQualities(pairs,
+			obipairing.CLIGapPenality(),
+			obipairing.CLIPenalityScale(),
+			obipairing.CLIDelta(),
+			obipairing.CLIMinOverlap(),
+			obipairing.CLIMinIdentity(),
+			obipairing.CLIFastMode(),
+			obipairing.CLIFastRelativeScore(),
+			obipairing.CLICalibrationReads(),
+		)
+	}
+
+	if calibration != nil {
+		obipairing.LogQualityCalibration(calibration)
+	}
+
+	if calibration != nil && obipairing.CLISaveCalibration() {
+		if err := calibration.Save(obipairing.CLISaveCalibrationFile()); err != nil {
+			log.Errorf("Cannot save the quality calibration (%v)", err)
+			os.Exit(1)
+		}
+		log.Infof("Quality calibration saved in file: %s", obipairing.CLISaveCalibrationFile())
+	}
+
 	paired := obipairing.IAssemblePESequencesBatch(pairs,
 		obipairing.CLIGapPenality(),
 		obipairing.CLIPenalityScale(),
@@ -60,6 +96,7 @@ func main() {
 		obipairing.CLIFastMode(),
 		obipairing.CLIFastRelativeScore(),
 		obipairing.CLIWithStats(),
+		calibration,
 		obidefault.ParallelWorkers(),
 	)
 
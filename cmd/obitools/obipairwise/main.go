@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obioptions"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obipairwise"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+func main() {
+
+	optionParser := obioptions.GenerateOptionParser(
+		"obipairwise",
+		"aligns each sequence against a set of target sequences",
+		obipairwise.OptionSet)
+
+	_, args := optionParser(os.Args)
+
+	sequences, err := obiconvert.CLIReadBioSequences(args...)
+	obiconvert.OpenSequenceDataErrorMessage(args, err)
+
+	aligned := obipairwise.CLIAlignSequences(sequences)
+
+	obiconvert.CLIWriteBioSequences(aligned, true)
+	obiutils.WaitForLastPipe()
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obirefidx"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
@@ -21,7 +22,15 @@ func main() {
 	fs, err := obiconvert.CLIReadBioSequences(args...)
 	obiconvert.OpenSequenceDataErrorMessage(args, err)
 
-	indexed := obirefidx.IndexReferenceDB(fs)
+	var indexed obiiter.IBioSequence
+
+	if obirefidx.CLIHasIndexedDB() {
+		indexed = obirefidx.CLIUpdateReferenceDB(fs)
+	} else {
+		indexed = obirefidx.IndexReferenceDB(fs)
+	}
+
+	indexed = obirefidx.CLISaveReferenceDB(indexed)
 
 	obiconvert.CLIWriteBioSequences(indexed, true)
 	obiutils.WaitForLastPipe()
@@ -10,6 +10,7 @@ import (
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obirefidx"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obitag"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obitaxonomy"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
@@ -52,40 +53,67 @@ func main() {
 
 	taxo := obitax.DefaultTaxonomy()
 
-	references := obitag.CLIRefDB()
+	var references *obirefidx.ReferenceDB
 
-	if references == nil {
-		log.Panicln("No loaded reference database")
-	}
+	switch {
+	case obitag.CLIBayesMode() && obitag.CLIHasBayesModel():
+		if taxo == nil {
+			log.Fatalf("A naive Bayes model requires the taxonomy it was trained with (--taxonomy option)")
+		}
 
-	if taxo == nil {
-		taxo, err = references.ExtractTaxonomy(nil, obitaxonomy.CLINewickWithLeaves())
+	case !obitag.CLIHasRefDB():
+		log.Fatalf("A reference database must be provided (--reference-db option)")
 
-		if err != nil {
-			log.Fatalf("No taxonomy specified or extractable from reference database: %v", err)
+	case obitag.CLIHasBinaryRefDB():
+		if taxo == nil {
+			log.Fatalf("A binary reference database requires the taxonomy it was built with (--taxonomy option)")
 		}
 
-		taxo.SetAsDefault()
-	}
+		references = obitag.CLIReferenceDB(taxo)
+
+	default:
+		sequences := obitag.CLIRefDB()
+
+		if sequences == nil {
+			log.Panicln("No loaded reference database")
+		}
 
-	if taxo == nil {
-		log.Panicln("No loaded taxonomy")
+		if taxo == nil {
+			taxo, err = sequences.ExtractTaxonomy(nil, obitaxonomy.CLINewickWithLeaves())
+
+			if err != nil {
+				log.Fatalf("No taxonomy specified or extractable from reference database: %v", err)
+			}
+
+			taxo.SetAsDefault()
+		}
+
+		references = obirefidx.MakeReferenceDB(sequences, taxo)
 	}
 
 	var identified obiiter.IBioSequence
 
 	fsrb := fs.Rebatch(obidefault.BatchSize())
 
-	if obitag.CLIGeometricMode() {
-		identified = obitag.CLIGeomAssignTaxonomy(fsrb, references, taxo)
-	} else {
-		identified = obitag.CLIAssignTaxonomy(fsrb, references, taxo)
+	switch obitag.CLIMethod() {
+	case "bayes":
+		model := obitag.CLIBayesModel(references, taxo)
+		identified = obitag.CLIBayesAssignTaxonomy(fsrb, model)
+	case "placement":
+		identified = obitag.CLIPlacementAssignTaxonomy(fsrb, references)
+	case "geometric":
+		identified = obitag.CLIGeomAssignTaxonomy(fsrb, references.Sequences, taxo)
+	default:
+		identified = obitag.CLIAssignTaxonomy(fsrb, references)
 	}
 
 	obiconvert.CLIWriteBioSequences(identified, true)
 	obiutils.WaitForLastPipe()
+	obitag.CLICloseHitTable()
 
-	obitag.CLISaveRefetenceDB(references)
+	if references != nil {
+		obitag.CLISaveRefetenceDB(references)
+	}
 
 	fmt.Println("")
 }
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obioptions"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obitagjump"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+func main() {
+
+	optionParser := obioptions.GenerateOptionParser(
+		"obitagjump",
+		"removes the sample counts resulting from tag jumps",
+		obitagjump.OptionSet)
+
+	_, args := optionParser(os.Args)
+
+	if err := obitagjump.CLICheckOptions(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	sequences, err := obiconvert.CLIReadBioSequences(args...)
+	obiconvert.OpenSequenceDataErrorMessage(args, err)
+
+	filtered := obitagjump.CLITagJumpFilter(sequences)
+
+	obiconvert.CLIWriteBioSequences(filtered, true)
+	obiutils.WaitForLastPipe()
+}
@@ -24,6 +24,7 @@ func main() {
 	_, args := optionParser(os.Args)
 
 	var iterator *obitax.ITaxon
+	withSequenceTaxa := false
 
 	if obitaxonomy.CLIDownloadNCBI() {
 		err := obitaxonomy.CLIDownloadNCBITaxdump()
@@ -109,6 +110,10 @@ func main() {
 			iterator = iterator.AddMetadata("query", taxon.String())
 		}
 
+	case len(args) == 0 && obitaxonomy.CLIHasTaxaFromSequences():
+		iterator = obitaxonomy.CLISequenceTaxaIterator()
+		withSequenceTaxa = true
+
 	case len(args) == 0:
 		iterator = obitax.DefaultTaxonomy().Iterator()
 	default:
@@ -131,9 +136,16 @@ func main() {
 
 	iterator = obitaxonomy.CLITaxonRestrictions(iterator)
 
-	if obitaxonomy.CLIAsNewick() {
+	if obitaxonomy.CLIHasTaxaFromSequences() && !withSequenceTaxa {
+		iterator = iterator.Concat(obitaxonomy.CLISequenceTaxaIterator())
+	}
+
+	switch {
+	case obitaxonomy.CLIAsTaxdump():
+		obitaxonomy.CLITaxdumpWriter(iterator)
+	case obitaxonomy.CLIAsNewick():
 		obitaxonomy.CLINewickWriter(iterator, true)
-	} else {
+	default:
 		obitaxonomy.CLICSVTaxaWriter(iterator, true)
 	}
 
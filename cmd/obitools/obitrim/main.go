@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obioptions"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obitrim"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+func main() {
+
+	optionParser := obioptions.GenerateOptionParser(
+		"obitrim",
+		"removes the adapters, the primers and the low quality ends of the reads",
+		obitrim.OptionSet)
+
+	_, args := optionParser(os.Args)
+
+	if err := obitrim.CLICheckOptions(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	sequences, err := obiconvert.CLIReadBioSequences(args...)
+	obiconvert.OpenSequenceDataErrorMessage(args, err)
+
+	trimmed := obitrim.CLITrimSequences(sequences)
+
+	obiconvert.CLIWriteBioSequences(trimmed, true)
+	trimmed.Wait()
+	obiutils.WaitForLastPipe()
+	obitrim.CLIWriteStats()
+}
@@ -0,0 +1,469 @@
+package obialign
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// AlignmentMode selects which end gaps are penalized by AlignAffine.
+type AlignmentMode int
+
+const (
+	// GlobalAlignment aligns both sequences over their whole length
+	// (Needleman-Wunsch).
+	GlobalAlignment AlignmentMode = iota
+
+	// LocalAlignment aligns the best scoring pair of subsequences
+	// (Smith-Waterman).
+	LocalAlignment
+
+	// EndGapFreeAlignment aligns both sequences over their whole length,
+	// but the gaps located at the ends of the alignment are not penalized.
+	EndGapFreeAlignment
+)
+
+// String returns the name of the alignment mode.
+func (mode AlignmentMode) String() string {
+	switch mode {
+	case GlobalAlignment:
+		return "global"
+	case LocalAlignment:
+		return "local"
+	case EndGapFreeAlignment:
+		return "egf"
+	}
+
+	return fmt.Sprintf("AlignmentMode(%d)", int(mode))
+}
+
+// ParseAlignmentMode converts the name of an alignment mode, as returned by
+// AlignmentMode.String, to an AlignmentMode. The names "endgapfree" and
+// "semiglobal" are accepted for EndGapFreeAlignment.
+func ParseAlignmentMode(name string) (AlignmentMode, error) {
+	switch strings.ToLower(name) {
+	case "global":
+		return GlobalAlignment, nil
+	case "local":
+		return LocalAlignment, nil
+	case "egf", "endgapfree", "semiglobal":
+		return EndGapFreeAlignment, nil
+	}
+
+	return GlobalAlignment, fmt.Errorf("unknown alignment mode: %s", name)
+}
+
+// _affineIndex maps a symbol to its row in the substitution matrix. The
+// symbols that are not letters share the row 0, which is not a nucleotide.
+var _affineIndex = func() (index [256]byte) {
+	for c := 'a'; c <= 'z'; c++ {
+		index[c] = byte(c) & 31
+		index[c-'a'+'A'] = byte(c) & 31
+	}
+	return
+}()
+
+// ScoringScheme describes the scores used by AlignAffine.
+//
+// The score of a pair of nucleotides is looked up in a substitution matrix
+// indexed by IUPAC codes. A gap of length k costs GapOpen + (k-1) * GapExtend.
+// Both gap penalties are positive values subtracted from the score.
+type ScoringScheme struct {
+	matrix    [32][32]int
+	GapOpen   int
+	GapExtend int
+}
+
+// NewScoringScheme builds a scoring scheme from a match and a mismatch score.
+// Ambiguous IUPAC codes are scored according to the probability that both
+// symbols represent the same nucleotide: a pair matching with probability
+// p scores p * match + (1-p) * mismatch. Symbols that are not nucleotides
+// score as a mismatch.
+//
+// Parameters:
+//   - match: The score of two identical nucleotides.
+//   - mismatch: The score of two different nucleotides, usually negative.
+//   - gapOpen: The penalty of the first position of a gap.
+//   - gapExtend: The penalty of each following position of a gap.
+//
+// Returns:
+//   - A pointer to the new scoring scheme.
+func NewScoringScheme(match, mismatch, gapOpen, gapExtend int) *ScoringScheme {
+	_InitDNAScoreMatrix()
+
+	scheme := &ScoringScheme{
+		GapOpen:   gapOpen,
+		GapExtend: gapExtend,
+	}
+
+	for i := range scheme.matrix {
+		for j := range scheme.matrix[i] {
+			p := _NucPartMatch[i][j]
+			scheme.matrix[i][j] = int(math.Round(p*float64(match) + (1-p)*float64(mismatch)))
+		}
+	}
+
+	return scheme
+}
+
+// The scores of the default scoring scheme.
+const (
+	DefaultMatchScore    = 5
+	DefaultMismatchScore = -4
+	DefaultGapOpen       = 10
+	DefaultGapExtend     = 1
+)
+
+var _defaultScoringScheme *ScoringScheme
+var _defaultScoringSchemeOnce sync.Once
+
+// DefaultScoringScheme returns the scoring scheme used when none is
+// provided. The returned scheme is shared and must not be modified.
+func DefaultScoringScheme() *ScoringScheme {
+	_defaultScoringSchemeOnce.Do(func() {
+		_defaultScoringScheme = NewScoringScheme(DefaultMatchScore, DefaultMismatchScore,
+			DefaultGapOpen, DefaultGapExtend)
+	})
+
+	return _defaultScoringScheme
+}
+
+// Score returns the score of aligning the nucleotide a with the nucleotide b.
+func (scheme *ScoringScheme) Score(a, b byte) int {
+	return scheme.matrix[_affineIndex[a]][_affineIndex[b]]
+}
+
+// SetScore changes the score of aligning the nucleotides a and b. The
+// matrix is kept symmetric, and upper and lower cases share their scores.
+func (scheme *ScoringScheme) SetScore(a, b byte, score int) {
+	a, b = _affineIndex[a], _affineIndex[b]
+	scheme.matrix[a][b] = score
+	scheme.matrix[b][a] = score
+}
+
+// PairwiseAlignment describes an alignment computed by AlignAffine.
+//
+// The first sequence is the query, the second one the target. The CIGAR
+// string describes the query: M for aligned positions, I for query
+// positions facing a gap, D for target positions facing a gap, and S for
+// the query ends left out of a local or end gap free alignment.
+//
+// Positions are 1-based and inclusive. Length, gaps and identity only
+// account for the aligned region.
+type PairwiseAlignment struct {
+	Mode       AlignmentMode
+	Score      int    // The score of the alignment
+	Cigar      string // The CIGAR string, * when nothing is aligned
+	Length     int    // The number of alignment columns
+	Matches    int    // The number of identical aligned nucleotides
+	Mismatches int    // The number of different aligned nucleotides
+	Gaps       int    // The number of gap positions
+	GapOpens   int    // The number of gaps
+	StartA     int    // The first aligned position on the query
+	EndA       int    // The last aligned position on the query
+	StartB     int    // The first aligned position on the target
+	EndB       int    // The last aligned position on the target
+}
+
+// Identity returns the fraction of the alignment columns that are identical
+// nucleotides.
+func (ali PairwiseAlignment) Identity() float64 {
+	if ali.Length == 0 {
+		return 0
+	}
+
+	return float64(ali.Matches) / float64(ali.Length)
+}
+
+// ToMap returns the description of the alignment as a map, numbers being
+// stored as float64 values as expected by the expression languages.
+func (ali PairwiseAlignment) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"mode":       ali.Mode.String(),
+		"score":      float64(ali.Score),
+		"cigar":      ali.Cigar,
+		"length":     float64(ali.Length),
+		"matches":    float64(ali.Matches),
+		"mismatches": float64(ali.Mismatches),
+		"gaps":       float64(ali.Gaps),
+		"gap_opens":  float64(ali.GapOpens),
+		"identity":   ali.Identity(),
+		"start_a":    float64(ali.StartA),
+		"end_a":      float64(ali.EndA),
+		"start_b":    float64(ali.StartB),
+		"end_b":      float64(ali.EndB),
+	}
+}
+
+const _affineMinusInf = math.MinInt32 / 2
+
+// The traceback of a cell stores the origin of the H matrix in its two
+// lowest bits, and whether the E and F matrices extend a gap.
+const (
+	_affineDiag byte = iota
+	_affineLeft
+	_affineUp
+	_affineStop
+
+	_affineEExt byte = 4
+	_affineFExt byte = 8
+)
+
+// AlignAffine aligns two sequences with affine gap penalties following the
+// Gotoh algorithm.
+//
+// The computation can be restricted to a band of diagonals. The band
+// contains the diagonals joining both ends of the dynamic programming
+// matrix, extended on each side by the band parameter.
+//
+// Parameters:
+//   - seqA: The query sequence.
+//   - seqB: The target sequence.
+//   - mode: The alignment mode.
+//   - scoring: The scoring scheme. If nil, DefaultScoringScheme is used.
+//   - band: The extension of the band, a negative value disables the band.
+//
+// Returns:
+//   - The description of the alignment.
+func AlignAffine(seqA, seqB *obiseq.BioSequence,
+	mode AlignmentMode,
+	scoring *ScoringScheme,
+	band int) PairwiseAlignment {
+
+	if scoring == nil {
+		scoring = DefaultScoringScheme()
+	}
+
+	bA := seqA.Sequence()
+	bB := seqB.Sequence()
+	lA := len(bA)
+	lB := len(bB)
+
+	lo, hi := -lA, lB
+	if band >= 0 {
+		lo = min(0, lB-lA) - band
+		hi = max(0, lB-lA) + band
+	}
+
+	width := min(lB, hi-lo) + 1
+	rowStart := func(i int) int { return max(0, i+lo) }
+
+	open := scoring.GapOpen
+	extend := scoring.GapExtend
+	local := mode == LocalAlignment
+
+	// The border of the matrix
+	border := func(n int) int {
+		if mode != GlobalAlignment || n == 0 {
+			return 0
+		}
+		return -(open + (n-1)*extend)
+	}
+
+	H := make([]int, lB+1)
+	F := make([]int, lB+1)
+	trace := make([]byte, (lA+1)*width)
+
+	for j := range H {
+		F[j] = _affineMinusInf
+		if j <= hi {
+			H[j] = border(j)
+		} else {
+			H[j] = _affineMinusInf
+		}
+	}
+
+	bestScore, bestI, bestJ := 0, 0, 0
+	if mode == EndGapFreeAlignment {
+		bestScore, bestJ = H[lB], lB
+	}
+
+	for i := 1; i <= lA; i++ {
+		js := max(1, i+lo)
+		je := min(lB, i+hi)
+		offset := i*width - rowStart(i)
+
+		diag := H[js-1]
+		left := _affineMinusInf
+		if js == 1 {
+			if i+lo <= 0 {
+				H[0] = border(i)
+			} else {
+				H[0] = _affineMinusInf
+			}
+			left = H[0]
+		}
+		e := _affineMinusInf
+		a := _affineIndex[bA[i-1]]
+
+		for j := js; j <= je; j++ {
+			up := H[j]
+			var t byte
+
+			if ext := e - extend; ext > left-open {
+				e = ext
+				t |= _affineEExt
+			} else {
+				e = left - open
+			}
+
+			if ext := F[j] - extend; ext > up-open {
+				F[j] = ext
+				t |= _affineFExt
+			} else {
+				F[j] = up - open
+			}
+
+			h := diag + scoring.matrix[a][_affineIndex[bB[j-1]]]
+			src := _affineDiag
+			if e > h {
+				h, src = e, _affineLeft
+			}
+			if F[j] > h {
+				h, src = F[j], _affineUp
+			}
+			if local && h <= 0 {
+				h, src = 0, _affineStop
+			}
+
+			trace[offset+j] = t | src
+			diag = up
+			H[j] = h
+			left = h
+
+			if local && h > bestScore {
+				bestScore, bestI, bestJ = h, i, j
+			}
+		}
+
+		if mode == EndGapFreeAlignment && je == lB && H[lB] > bestScore {
+			bestScore, bestI, bestJ = H[lB], i, lB
+		}
+	}
+
+	switch mode {
+	case GlobalAlignment:
+		bestScore, bestI, bestJ = H[lB], lA, lB
+	case EndGapFreeAlignment:
+		for j := max(0, lA+lo); j <= lB; j++ {
+			if H[j] > bestScore || (j == lB && H[j] == bestScore) {
+				bestScore, bestI, bestJ = H[j], lA, j
+			}
+		}
+	}
+
+	ali := PairwiseAlignment{
+		Mode:  mode,
+		Score: bestScore,
+		EndA:  bestI,
+		EndB:  bestJ,
+	}
+
+	// Backtracking, the operations are collected in reverse order
+	ops := make([]byte, 0, lA+lB)
+	i, j := bestI, bestJ
+	state := _affineDiag
+
+	for i > 0 && j > 0 {
+		t := trace[i*width-rowStart(i)+j]
+
+		switch state {
+		case _affineLeft:
+			ops = append(ops, 'D')
+			j--
+			if t&_affineEExt == 0 {
+				state = _affineDiag
+			}
+			continue
+		case _affineUp:
+			ops = append(ops, 'I')
+			i--
+			if t&_affineFExt == 0 {
+				state = _affineDiag
+			}
+			continue
+		}
+
+		src := t & 3
+		if src == _affineStop {
+			break
+		}
+
+		if src == _affineDiag {
+			if bA[i-1]|32 == bB[j-1]|32 {
+				ali.Matches++
+			} else {
+				ali.Mismatches++
+			}
+			ops = append(ops, 'M')
+			i--
+			j--
+		} else {
+			state = src
+		}
+	}
+
+	if mode == GlobalAlignment {
+		for ; i > 0; i-- {
+			ops = append(ops, 'I')
+		}
+		for ; j > 0; j-- {
+			ops = append(ops, 'D')
+		}
+	}
+
+	ali.StartA = i + 1
+	ali.StartB = j + 1
+	ali.Length = len(ops)
+
+	var cigar strings.Builder
+
+	writeOp := func(n int, op byte) {
+		if n > 0 {
+			fmt.Fprintf(&cigar, "%d%c", n, op)
+		}
+	}
+
+	writeOp(i, 'S')
+
+	for k := len(ops) - 1; k >= 0; {
+		op := ops[k]
+		n := 0
+		for ; k >= 0 && ops[k] == op; k-- {
+			n++
+		}
+		writeOp(n, op)
+
+		if op != 'M' {
+			ali.Gaps += n
+			ali.GapOpens++
+		}
+	}
+
+	writeOp(lA-bestI, 'S')
+
+	if ali.Length == 0 {
+		ali.Cigar = "*"
+	} else {
+		ali.Cigar = cigar.String()
+	}
+
+	return ali
+}
+
+// NeedlemanWunsch computes the global alignment of two sequences with
+// affine gap penalties. It is a shortcut for AlignAffine in the
+// GlobalAlignment mode.
+func NeedlemanWunsch(seqA, seqB *obiseq.BioSequence, scoring *ScoringScheme, band int) PairwiseAlignment {
+	return AlignAffine(seqA, seqB, GlobalAlignment, scoring, band)
+}
+
+// SmithWaterman computes the local alignment of two sequences with affine
+// gap penalties. It is a shortcut for AlignAffine in the LocalAlignment
+// mode.
+func SmithWaterman(seqA, seqB *obiseq.BioSequence, scoring *ScoringScheme, band int) PairwiseAlignment {
+	return AlignAffine(seqA, seqB, LocalAlignment, scoring, band)
+}
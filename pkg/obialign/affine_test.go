@@ -0,0 +1,202 @@
+package obialign
+
+import (
+	"math/rand"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// referenceAffineScore computes the optimal score of an alignment with full
+// Gotoh matrices.
+func referenceAffineScore(a, b []byte, mode AlignmentMode, scoring *ScoringScheme) int {
+	const inf = 1 << 40
+	lA, lB := len(a), len(b)
+	H := make([][]int, lA+1)
+	E := make([][]int, lA+1)
+	F := make([][]int, lA+1)
+
+	for i := range H {
+		H[i] = make([]int, lB+1)
+		E[i] = make([]int, lB+1)
+		F[i] = make([]int, lB+1)
+		for j := range H[i] {
+			E[i][j], F[i][j] = -inf, -inf
+			switch {
+			case i == 0 && j == 0:
+			case mode != GlobalAlignment:
+			case i == 0:
+				H[i][j] = -(scoring.GapOpen + (j-1)*scoring.GapExtend)
+			case j == 0:
+				H[i][j] = -(scoring.GapOpen + (i-1)*scoring.GapExtend)
+			}
+		}
+	}
+
+	best := H[0][0]
+	if mode == EndGapFreeAlignment {
+		best = -inf
+	}
+
+	for i := 1; i <= lA; i++ {
+		for j := 1; j <= lB; j++ {
+			E[i][j] = max(H[i][j-1]-scoring.GapOpen, E[i][j-1]-scoring.GapExtend)
+			F[i][j] = max(H[i-1][j]-scoring.GapOpen, F[i-1][j]-scoring.GapExtend)
+			H[i][j] = max(H[i-1][j-1]+scoring.Score(a[i-1], b[j-1]), E[i][j], F[i][j])
+			if mode == LocalAlignment {
+				H[i][j] = max(H[i][j], 0)
+				best = max(best, H[i][j])
+			}
+		}
+	}
+
+	switch mode {
+	case GlobalAlignment:
+		best = H[lA][lB]
+	case EndGapFreeAlignment:
+		for i := 0; i <= lA; i++ {
+			best = max(best, H[i][lB])
+		}
+		for j := 0; j <= lB; j++ {
+			best = max(best, H[lA][j])
+		}
+	}
+
+	return best
+}
+
+var _cigarOp = regexp.MustCompile(`(\d+)([MIDS])`)
+
+// cigarScore recomputes the score of an alignment from its CIGAR string.
+func cigarScore(t *testing.T, a, b []byte, ali PairwiseAlignment, scoring *ScoringScheme) int {
+	i, j := ali.StartA-1, ali.StartB-1
+	score := 0
+
+	for _, op := range _cigarOp.FindAllStringSubmatch(ali.Cigar, -1) {
+		n, _ := strconv.Atoi(op[1])
+		switch op[2] {
+		case "M":
+			for k := 0; k < n; k++ {
+				score += scoring.Score(a[i], b[j])
+				i++
+				j++
+			}
+		case "I":
+			score -= scoring.GapOpen + (n-1)*scoring.GapExtend
+			i += n
+		case "D":
+			score -= scoring.GapOpen + (n-1)*scoring.GapExtend
+			j += n
+		}
+	}
+
+	if i != ali.EndA || j != ali.EndB {
+		t.Fatalf("CIGAR %s ends at (%d,%d), alignment ends at (%d,%d)",
+			ali.Cigar, i, j, ali.EndA, ali.EndB)
+	}
+
+	return score
+}
+
+func randomDNA(rng *rand.Rand, n int) []byte {
+	seq := make([]byte, n)
+	for i := range seq {
+		seq[i] = "acgt"[rng.Intn(4)]
+	}
+	return seq
+}
+
+func TestAlignAffine(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	scoring := DefaultScoringScheme()
+
+	for round := 0; round < 300; round++ {
+		a := randomDNA(rng, rng.Intn(40))
+		b := append(randomDNA(rng, rng.Intn(5)), a...)
+		b = append(b, randomDNA(rng, rng.Intn(5))...)
+		for e := rng.Intn(6); e > 0 && len(b) > 0; e-- {
+			b[rng.Intn(len(b))] = "acgtn"[rng.Intn(5)]
+		}
+
+		seqA := obiseq.NewBioSequence("a", a, "")
+		seqB := obiseq.NewBioSequence("b", b, "")
+
+		for _, mode := range []AlignmentMode{GlobalAlignment, LocalAlignment, EndGapFreeAlignment} {
+			ali := AlignAffine(seqA, seqB, mode, scoring, -1)
+			expected := referenceAffineScore(a, b, mode, scoring)
+
+			if ali.Score != expected {
+				t.Fatalf("%s alignment of %s and %s: score %d, expected %d",
+					mode, a, b, ali.Score, expected)
+			}
+
+			if ali.Length > 0 {
+				if score := cigarScore(t, a, b, ali, scoring); score != ali.Score {
+					t.Fatalf("%s alignment of %s and %s: CIGAR %s scores %d, alignment scores %d",
+						mode, a, b, ali.Cigar, score, ali.Score)
+				}
+			}
+
+			if mode == GlobalAlignment && (ali.StartA != 1 || ali.EndA != len(a) || ali.StartB != 1 || ali.EndB != len(b)) {
+				t.Fatalf("global alignment of %s and %s does not cover the sequences: %+v", a, b, ali)
+			}
+
+			// A band wide enough includes the optimal alignment
+			if banded := AlignAffine(seqA, seqB, mode, scoring, len(a)+len(b)); banded != ali {
+				t.Fatalf("%s alignment of %s and %s: banded %+v, unbanded %+v", mode, a, b, banded, ali)
+			}
+		}
+	}
+}
+
+func TestAlignAffineCigar(t *testing.T) {
+	query := obiseq.NewBioSequence("query", []byte("acgtacgtacgtacgtacgt"), "")
+	target := obiseq.NewBioSequence("target", []byte("ttttacgtacgtacgaaaaaaaatacgtacgtgggg"), "")
+
+	ali := AlignAffine(query, target, LocalAlignment, nil, -1)
+	if ali.Cigar != "11M8D9M" || ali.StartB != 5 || ali.EndB != 32 || ali.Identity() != 20.0/28.0 {
+		t.Errorf("local alignment: %+v", ali)
+	}
+
+	ali = AlignAffine(query, target, GlobalAlignment, nil, -1)
+	if ali.StartB != 1 || ali.EndB != target.Len() || ali.Length != target.Len() {
+		t.Errorf("global alignment: %+v", ali)
+	}
+
+	shifted := obiseq.NewBioSequence("shifted", []byte("ggggacgtacgtacgtacgtacgt"), "")
+	ali = AlignAffine(shifted, query, EndGapFreeAlignment, nil, -1)
+	if ali.Cigar != "4S20M" || ali.Score != 100 {
+		t.Errorf("end gap free alignment: %+v", ali)
+	}
+
+	ali = AlignAffine(shifted, query, GlobalAlignment, nil, 2)
+	if ali.Cigar != "4I20M" || ali.Score != 100-13 {
+		t.Errorf("banded global alignment: %+v", ali)
+	}
+}
+
+func TestScoringSchemeIUPAC(t *testing.T) {
+	scoring := NewScoringScheme(4, -4, 10, 1)
+
+	for _, c := range []struct {
+		a, b  byte
+		score int
+	}{
+		{'a', 'a', 4},
+		{'a', 'C', -4},
+		{'a', 'r', 0},
+		{'n', 'g', -2},
+		{'-', 'a', -4},
+	} {
+		if s := scoring.Score(c.a, c.b); s != c.score {
+			t.Errorf("score of %c/%c is %d, expected %d", c.a, c.b, s, c.score)
+		}
+	}
+
+	scoring.SetScore('a', 'g', 1)
+	if scoring.Score('G', 'a') != 1 {
+		t.Errorf("SetScore is not symmetric")
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"strings"
 
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
-	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
 )
 
 // // A pool of byte slices.
@@ -127,6 +126,29 @@ func BuildAlignment(seqA, seqB *obiseq.BioSequence,
 // return.
 func BuildQualityConsensus(seqA, seqB *obiseq.BioSequence, path []int, statOnMismatch bool,
 	arenaAlign PEAlignArena) (*obiseq.BioSequence, int) {
+	return BuildCalibratedQualityConsensus(seqA, seqB, path, statOnMismatch, nil, arenaAlign)
+}
+
+// BuildCalibratedQualityConsensus builds the consensus of two aligned reads
+// like BuildQualityConsensus, but using a quality calibration. Where two
+// nucleotides are aligned, the consensus and its quality are computed from
+// the error rates learnt for their pair of qualities by the calibration.
+// Elsewhere, the reported qualities are replaced by the calibrated ones. The
+// pairing_mismatches attribute keeps the reported qualities.
+//
+// Parameters:
+//   - seqA, seqB: The aligned reads.
+//   - path: The alignment path.
+//   - statOnMismatch: Whether the mismatches are annotated.
+//   - calibration: The quality calibration, nil to use the reported qualities.
+//   - arenaAlign: The arena used to build the alignment.
+//
+// Returns:
+//   - The consensus sequence.
+//   - The number of matches in the alignment.
+func BuildCalibratedQualityConsensus(seqA, seqB *obiseq.BioSequence, path []int, statOnMismatch bool,
+	calibration *QualityCalibration,
+	arenaAlign PEAlignArena) (*obiseq.BioSequence, int) {
 
 	bufferSA := arenaAlign.pointer.aligneSeqA
 	bufferSB := arenaAlign.pointer.aligneSeqB
@@ -159,15 +181,9 @@ func BuildQualityConsensus(seqA, seqB *obiseq.BioSequence, path []int, statOnMis
 
 	match := 0
 
-	left := obiutils.Abs(path[0])
-	right := 0
-	if path[len(path)-1] == 0 {
-		right = path[len(path)-2]
-	}
-
-	right = obiutils.Abs(right)
-
-	right = len(*bufferQA) - right
+	startA, startB, endA, endB := PEUnalignedEnds(path)
+	left := startA + startB
+	right := len(*bufferQA) - endA - endB
 
 	// obilog.Warnf("BuildQualityConsensus: left = %d right = %d\n", left, right)
 
@@ -186,6 +202,24 @@ func BuildQualityConsensus(seqA, seqB *obiseq.BioSequence, path []int, statOnMis
 			mismatches[strings.ToUpper(fmt.Sprintf("(%c:%02d)->(%c:%02d)", nA, qA, nB, qB))] = i + 1
 		}
 
+		if calibration != nil && _IsCalibrationBase(nA|32) && _IsCalibrationBase(nB|32) {
+			if nA == nB {
+				match++
+			}
+
+			(*bufferSA)[i], (*bufferQA)[i] = _CalibratedConsensus(nA, nB, qA, qB, calibration)
+			continue
+		}
+
+		if calibration != nil {
+			if nA != ' ' && nA != '-' {
+				qA = calibration.Quality(qA)
+			}
+			if nB != ' ' && nB != '-' {
+				qB = calibration.Quality(qB)
+			}
+		}
+
 		if qA > qB {
 			qM = qA
 			qm = qB
@@ -260,3 +294,29 @@ func BuildQualityConsensus(seqA, seqB *obiseq.BioSequence, path []int, statOnMis
 // 		}
 // 	}
 // }
+
+// _CalibratedConsensus returns the consensus of two aligned nucleotides and
+// its quality, from the error rates of their pair of qualities. Errors
+// replace a nucleotide by any of the three other ones with the same
+// probability. When the nucleotides mismatch, the most reliable one is
+// kept, or their IUPAC ambiguity code if they are equally reliable.
+func _CalibratedConsensus(nA, nB, qA, qB byte, calibration *QualityCalibration) (byte, byte) {
+	eA, eB := calibration.PairErrors(qA, qB)
+	nuc := nA
+	var e float64
+
+	switch mismatch := _MismatchRate(eA, eB); {
+	case nA == nB:
+		e = eA * eB / 3 / ((1-eA)*(1-eB) + eA*eB/3)
+	case eA < eB:
+		e = 1 - (1-eA)*eB/mismatch
+	case eB < eA:
+		nuc = nB
+		e = 1 - (1-eB)*eA/mismatch
+	default:
+		nuc = _FourBitsBaseDecode[_FourBitsBaseCode[nA&31]|_FourBitsBaseCode[nB&31]]
+		e = 2.0 / 3.0 * eA * eB / mismatch
+	}
+
+	return nuc, byte(min(max(math.Round(-10*math.Log10(e)), 0), 90))
+}
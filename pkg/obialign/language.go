@@ -0,0 +1,78 @@
+package obialign
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/PaesslerAG/gval"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+// languageSequence converts an argument of an alignment function to a
+// sequence. The argument can be a sequence or a string.
+func languageSequence(arg interface{}) (*obiseq.BioSequence, error) {
+	switch value := arg.(type) {
+	case *obiseq.BioSequence:
+		return value, nil
+	case string:
+		return obiseq.NewBioSequence("", []byte(strings.ToLower(value)), ""), nil
+	}
+
+	return nil, fmt.Errorf("obi: cannot align a value of type %T", arg)
+}
+
+// languageAlign implements the align function of the expression language:
+// align(query, target[, mode]) aligns two sequences with the default
+// scoring scheme and returns the description of the alignment as a map.
+func languageAlign(args ...interface{}) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, errors.New("obi: align expects two sequences")
+	}
+
+	query, err := languageSequence(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := languageSequence(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	mode := GlobalAlignment
+	if len(args) > 2 {
+		name, err := obiutils.InterfaceToString(args[2])
+		if err != nil {
+			return nil, err
+		}
+
+		if mode, err = ParseAlignmentMode(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return AlignAffine(query, target, mode, nil, -1).ToMap(), nil
+}
+
+func init() {
+	obiseq.ExtendOBILang(
+		gval.Function("align", languageAlign),
+		gval.Function("cigar", func(args ...interface{}) (interface{}, error) {
+			ali, err := languageAlign(args...)
+			if err != nil {
+				return nil, err
+			}
+			return ali.(map[string]interface{})["cigar"], nil
+		}),
+		gval.Function("identity", func(args ...interface{}) (interface{}, error) {
+			ali, err := languageAlign(args...)
+			if err != nil {
+				return nil, err
+			}
+			return ali.(map[string]interface{})["identity"], nil
+		}),
+	)
+}
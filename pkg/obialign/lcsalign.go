@@ -0,0 +1,147 @@
+package obialign
+
+import (
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// LCSAlignment describes a global alignment maximising the longest common
+// subsequence of two sequences, as scored by FastLCSScore.
+//
+// The length, the mismatches and the gaps account for the whole alignment,
+// terminal gaps included. Positions are 1-based and inclusive. They delimit
+// the aligned region once the terminal gaps have been excluded.
+type LCSAlignment struct {
+	Score      int // The length of the longest common subsequence
+	Length     int // The length of the alignment
+	Mismatches int // The number of mismatched columns
+	Gaps       int // The number of gap positions
+	GapOpens   int // The number of gap openings
+	StartA     int // The first aligned position on the first sequence
+	EndA       int // The last aligned position on the first sequence
+	StartB     int // The first aligned position on the second sequence
+	EndB       int // The last aligned position on the second sequence
+}
+
+// Identity returns the fraction of the alignment columns that are matches.
+func (ali LCSAlignment) Identity() float64 {
+	if ali.Length == 0 {
+		return 0
+	}
+
+	return float64(ali.Score) / float64(ali.Length)
+}
+
+const (
+	_lcsDiag byte = iota
+	_lcsUp
+	_lcsLeft
+)
+
+// AlignLCS computes the full global alignment of two sequences maximising
+// their longest common subsequence. Among the alignments with the same LCS,
+// the shortest one is retained, which is the alignment whose length is
+// returned by FastLCSScore.
+//
+// Contrary to FastLCSScore, the alignment is not banded and its memory cost
+// is proportional to the product of the sequence lengths. It is intended to
+// describe a few selected alignments.
+//
+// Parameters:
+//   - seqA: The first bio sequence.
+//   - seqB: The second bio sequence.
+//
+// Returns:
+//   - The description of the alignment.
+func AlignLCS(seqA, seqB *obiseq.BioSequence) LCSAlignment {
+	bA := seqA.Sequence()
+	bB := seqB.Sequence()
+	lA := len(bA)
+	lB := len(bB)
+	width := lA + 1
+
+	score := make([]int, width)
+	length := make([]int, width)
+	path := make([]byte, width*(lB+1))
+
+	for j := 1; j <= lA; j++ {
+		length[j] = j
+		path[j] = _lcsLeft
+	}
+
+	for i := 1; i <= lB; i++ {
+		diagScore, diagLength := score[0], length[0]
+		length[0] = i
+		path[i*width] = _lcsUp
+
+		for j := 1; j <= lA; j++ {
+			upScore, upLength := score[j], length[j]+1
+			leftScore, leftLength := score[j-1], length[j-1]+1
+
+			dScore, dLength := diagScore, diagLength+1
+			if obiseq.SameIUPACNuc(bA[j-1], bB[i-1]) {
+				dScore++
+			}
+
+			diagScore, diagLength = score[j], length[j]
+
+			s, l, step := dScore, dLength, _lcsDiag
+			if upScore > s || (upScore == s && upLength < l) {
+				s, l, step = upScore, upLength, _lcsUp
+			}
+			if leftScore > s || (leftScore == s && leftLength < l) {
+				s, l, step = leftScore, leftLength, _lcsLeft
+			}
+
+			score[j], length[j] = s, l
+			path[i*width+j] = step
+		}
+	}
+
+	ali := LCSAlignment{
+		Score:  score[lA],
+		Length: length[lA],
+	}
+
+	// The backtracking walks the alignment from its end. The aligned region
+	// starts and ends on columns where both sequences have a residue.
+	i, j := lB, lA
+	previous := _lcsDiag
+
+	for i > 0 || j > 0 {
+		var step byte
+		switch {
+		case i == 0:
+			step = _lcsLeft
+		case j == 0:
+			step = _lcsUp
+		default:
+			step = path[i*width+j]
+		}
+
+		if step == _lcsDiag {
+			if ali.EndA == 0 {
+				ali.EndA, ali.EndB = j, i
+			}
+			ali.StartA, ali.StartB = j, i
+			if !obiseq.SameIUPACNuc(bA[j-1], bB[i-1]) {
+				ali.Mismatches++
+			}
+			i--
+			j--
+		} else {
+			ali.Gaps++
+			if step != previous {
+				ali.GapOpens++
+			}
+			if step == _lcsUp {
+				i--
+			} else {
+				j--
+			}
+		}
+
+		previous = step
+	}
+
+	return ali
+}
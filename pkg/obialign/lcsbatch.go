@@ -0,0 +1,396 @@
+package obialign
+
+import (
+	"slices"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+// The batch LCS compares a query sequence to several reference sequences at
+// once. Each reference is assigned to a lane, and the dynamic programming
+// matrices of the lanes are filled simultaneously, cell by cell, using SIMD
+// instructions when they are available. The computation reproduces exactly
+// the banded algorithm of FastLCSScore.
+//
+// A cell is encoded on 32 bits following the same principle as the 64 bits
+// encoding of FastLCSEGFScoreByte:
+//   - bit 31 is set for cells inside the band;
+//   - bits 16 to 30 store the score;
+//   - bits 0 to 15 store the path length as (^length - 1).
+//
+// The maximum of two encoded cells is therefore the best of them.
+
+const (
+	lcsLanes = 8
+
+	lcsInFlag    = uint32(1) << 31
+	lcsScoreIncr = uint32(1) << 16
+	lcsLowMask   = uint32(1)<<16 - 1
+
+	// The longest sequence pair handled by the batch algorithm,
+	// longer ones are aligned by FastLCSScore.
+	lcsBatchMaxLength = 1<<15 - 1
+)
+
+// lcsOut is the value of the cells outside of the band.
+var lcsOut = encodeValues32(0, 30000, true)
+
+func encodeValues32(score, length int, out bool) uint32 {
+	v := uint32(score)<<16 | (uint32((^length)-1) & lcsLowMask)
+	if !out {
+		v |= lcsInFlag
+	}
+	return v
+}
+
+func decodeValues32(value uint32) (int, int, bool) {
+	score := int((value >> 16) & (lcsLowMask >> 1))
+	length := int(((value + 1) ^ lcsLowMask) & lcsLowMask)
+	out := (value & lcsInFlag) == 0
+	return score, length, out
+}
+
+// lcsBand applies the band constraints to a cell located on diagonal d.
+// Cells outside of the band are replaced by lcsOut, cells on the border of
+// the band are marked as out.
+func lcsBand(value uint32, d, lo, hi int32) uint32 {
+	switch {
+	case d < lo || d > hi:
+		return lcsOut
+	case d == lo || d == hi:
+		return value &^ lcsInFlag
+	}
+	return value
+}
+
+// lcsCodes encodes a sequence for the batch algorithm. Nucleotides are
+// encoded by their IUPAC bit sets, so that two nucleotides match when their
+// codes share a bit, as for obiseq.SameIUPACNuc. A sequence containing
+// other symbols cannot be encoded.
+func lcsCodes(sequence []byte, buffer []uint32) ([]uint32, bool) {
+	buffer = buffer[:0]
+
+	for _, nuc := range sequence {
+		nuc |= 32
+		if nuc < 'a' || nuc > 'z' {
+			return buffer, false
+		}
+
+		code := uint32(0)
+		for b, ref := range [4]byte{'a', 'c', 'g', 't'} {
+			if obiseq.SameIUPACNuc(nuc, ref) {
+				code |= 1 << b
+			}
+		}
+		buffer = append(buffer, code)
+	}
+
+	return buffer, true
+}
+
+// lcsBatchKernelGeneric fills the dynamic programming matrices of the
+// lanes. The query is along the rows, the references along the columns.
+//
+// Parameters:
+//   - query: The codes of the query.
+//   - refs: The codes of the references, column by column, one per lane.
+//   - ncols: The number of columns.
+//   - col0: The cells of the first column, row by row, one per lane.
+//   - lohi: The lowest then the highest diagonal of the band of each lane.
+//   - row: The cells of the first row, one per lane. It contains the cells
+//     of the last row on return.
+func lcsBatchKernelGeneric(query, refs []uint32, ncols int, col0 []uint32, lohi *[2 * lcsLanes]int32, row []uint32) {
+	for i := 1; i <= len(query); i++ {
+		q := query[i-1]
+		for lane := 0; lane < lcsLanes; lane++ {
+			lo, hi := lohi[lane], lohi[lcsLanes+lane]
+			diag := row[lane]
+			left := col0[i*lcsLanes+lane]
+			row[lane] = left
+
+			for j := 1; j <= ncols; j++ {
+				k := j*lcsLanes + lane
+				up := row[k]
+				sdiag := diag - 1
+				if q&refs[k-lcsLanes] != 0 {
+					sdiag += lcsScoreIncr
+				}
+
+				value := lcsBand(max(sdiag, up-1, left-1), int32(j-i), lo, hi)
+
+				diag = up
+				row[k] = value
+				left = value
+			}
+		}
+	}
+}
+
+// lcsBatchKernel is the fastest kernel available on the running processor.
+var lcsBatchKernel = lcsBatchKernelGeneric
+
+// LCSBatchBuffer holds the memory used by FastLCSScoreBatch. It can be
+// reused between calls to limit allocations, but not shared by
+// concurrent calls.
+type LCSBatchBuffer struct {
+	query  []uint32
+	codes  []uint32
+	refs   []uint32
+	col0   []uint32
+	row    []uint32
+	matrix []uint64
+}
+
+// FastLCSScoreBatch computes FastLCSScore between a query sequence and each
+// sequence of a set of references. Results are identical to those of
+// FastLCSScore, the references being compared several at a time.
+//
+// Parameters:
+//   - query: The query sequence, used as first sequence of each comparison.
+//   - references: The reference sequences.
+//   - maxError: The maximum allowed error between the sequences. If set to -1, no limit is applied.
+//   - buffer: A pointer to a LCSBatchBuffer to reuse memory. If nil, a new buffer is created.
+//
+// Returns:
+//   - The score of the longest common subsequence for each reference, -1 if
+//     the maximum number of errors is exceeded.
+//   - The length of the shortest alignment corresponding to each LCS, -1 if
+//     the maximum number of errors is exceeded.
+func FastLCSScoreBatch(query *obiseq.BioSequence,
+	references obiseq.BioSequenceSlice,
+	maxError int,
+	buffer *LCSBatchBuffer) ([]int, []int) {
+
+	if buffer == nil {
+		buffer = &LCSBatchBuffer{}
+	}
+
+	scores := make([]int, len(references))
+	lengths := make([]int, len(references))
+
+	var ok bool
+	lq := query.Len()
+	buffer.query, ok = lcsCodes(query.Sequence(), buffer.query)
+	ok = ok && lq > 0 && lq <= lcsBatchMaxLength
+
+	lanes := make([]int, 0, len(references))
+
+	for i, ref := range references {
+		lr := ref.Len()
+		lA, lB := max(lq, lr), min(lq, lr)
+		maxe := maxError
+		if maxe == -1 {
+			maxe = lA * 2
+		}
+
+		if ok && lr > 0 && lq+lr <= lcsBatchMaxLength && lA-lB <= maxe {
+			lanes = append(lanes, i)
+		} else {
+			scores[i], lengths[i] = FastLCSScore(query, ref, maxError, &buffer.matrix)
+		}
+	}
+
+	// Grouping references of similar lengths limits the padding
+	slices.SortStableFunc(lanes, func(a, b int) int {
+		return references[a].Len() - references[b].Len()
+	})
+
+	for start := 0; start < len(lanes); start += lcsLanes {
+		group := lanes[start:min(start+lcsLanes, len(lanes))]
+		buffer.lcsGroup(query, references, group, maxError, scores, lengths)
+	}
+
+	return scores, lengths
+}
+
+// lcsGroup compares the query to a group of at most lcsLanes references.
+func (buffer *LCSBatchBuffer) lcsGroup(query *obiseq.BioSequence,
+	references obiseq.BioSequenceSlice,
+	group []int,
+	maxError int,
+	scores, lengths []int) {
+
+	lq := query.Len()
+	ncols := 0
+	for _, r := range group {
+		ncols = max(ncols, references[r].Len())
+	}
+
+	buffer.refs = slices.Grow(buffer.refs[:0], ncols*lcsLanes)[:ncols*lcsLanes]
+	buffer.row = slices.Grow(buffer.row[:0], (ncols+1)*lcsLanes)[:(ncols+1)*lcsLanes]
+	buffer.col0 = slices.Grow(buffer.col0[:0], (lq+1)*lcsLanes)[:(lq+1)*lcsLanes]
+	clear(buffer.refs)
+
+	var lohi [2 * lcsLanes]int32
+
+	for lane, r := range group {
+		ref := references[r]
+		lr := ref.Len()
+
+		buffer.codes, _ = lcsCodes(ref.Sequence(), buffer.codes)
+		for j, code := range buffer.codes {
+			buffer.refs[j*lcsLanes+lane] = code
+		}
+
+		// The band of FastLCSEGFScoreByte is defined along the longest
+		// sequence, the query being the longest when lengths are equal.
+		lA, lB := max(lq, lr), min(lq, lr)
+		maxe := maxError
+		if maxe == -1 {
+			maxe = lA * 2
+		}
+		delta := lA - lB
+		extra := maxe - delta + 1
+
+		if lr > lq {
+			lohi[lane], lohi[lcsLanes+lane] = int32(-2*extra), int32(2*delta+2*extra)
+		} else {
+			lohi[lane], lohi[lcsLanes+lane] = int32(-2*delta-2*extra), int32(2*extra)
+		}
+	}
+
+	for lane := 0; lane < lcsLanes; lane++ {
+		lo, hi := lohi[lane], lohi[lcsLanes+lane]
+		for j := 0; j <= ncols; j++ {
+			buffer.row[j*lcsLanes+lane] = lcsBand(encodeValues32(0, j, false), int32(j), lo, hi)
+		}
+		for i := 0; i <= lq; i++ {
+			buffer.col0[i*lcsLanes+lane] = lcsBand(encodeValues32(0, i, false), int32(-i), lo, hi)
+		}
+	}
+
+	lcsBatchKernel(buffer.query, buffer.refs, ncols, buffer.col0, &lohi, buffer.row)
+
+	for lane, r := range group {
+		score, length, out := decodeValues32(buffer.row[references[r].Len()*lcsLanes+lane])
+		if out {
+			scores[r], lengths[r] = -1, -1
+		} else {
+			scores[r], lengths[r] = score, length
+		}
+	}
+}
+
+// LCSBatchScan computes FastLCSScore between a query sequence and
+// references examined one by one in a given order, as done when looking for
+// the closest references of a sequence. The maximum number of errors of
+// such a scan only decreases as better references are found.
+//
+// The references are scored lcsLanes at a time by FastLCSScoreBatch, ahead
+// of their examination, with the maximum number of errors known at that
+// time. An alignment within a bound being also within any looser one, its
+// score only has to be compared to the bound of the reference when it is
+// examined. Results are therefore those of FastLCSScore, except that a
+// score exceeding the bound is always reported as -1.
+type LCSBatchScan struct {
+	query      *obiseq.BioSequence
+	references obiseq.BioSequenceSlice
+	order      []int
+	buffer     *LCSBatchBuffer
+	chunk      obiseq.BioSequenceSlice
+	positions  []int
+	scores     []int
+	lengths    []int
+}
+
+// MakeLCSBatchScan prepares the scan of a set of references.
+//
+// Parameters:
+//   - query: The query sequence, used as first sequence of each comparison.
+//   - references: The reference sequences.
+//   - order: The indices in references of the scanned references, in the
+//     order of their examination.
+//   - buffer: A pointer to a LCSBatchBuffer to reuse memory. If nil, a new buffer is created.
+//
+// Returns:
+//   - The scan, positions being indices in order.
+func MakeLCSBatchScan(query *obiseq.BioSequence,
+	references obiseq.BioSequenceSlice,
+	order []int,
+	buffer *LCSBatchBuffer) LCSBatchScan {
+
+	if buffer == nil {
+		buffer = &LCSBatchBuffer{}
+	}
+
+	return LCSBatchScan{
+		query:      query,
+		references: references,
+		order:      order,
+		buffer:     buffer,
+		chunk:      make(obiseq.BioSequenceSlice, 0, lcsLanes),
+		positions:  make([]int, 0, lcsLanes),
+	}
+}
+
+// Score returns FastLCSScore between the query and the reference examined
+// at a position of the scan. When the reference has not been scored yet,
+// it is scored with the next ones accepted by the bound function.
+//
+// Parameters:
+//   - position: The position of the reference in the scan order.
+//   - maxError: The maximum allowed error between the sequences. If set to -1, no limit is applied.
+//   - bound: The maximum allowed error of the reference examined at a
+//     position, and false if that reference will not be scored. The bound
+//     of a reference must not be lower than the one used when it is examined.
+//
+// Returns:
+//   - The score of the longest common subsequence, -1 if the maximum number
+//     of errors is exceeded.
+//   - The length of the shortest alignment corresponding to the LCS, -1 if
+//     the maximum number of errors is exceeded.
+func (scan *LCSBatchScan) Score(position, maxError int,
+	bound func(position int) (int, bool)) (int, int) {
+
+	k := slices.Index(scan.positions, position)
+	if k < 0 {
+		if !scan.batchable(position, maxError) {
+			return FastLCSScore(scan.query, scan.references[scan.order[position]], maxError, &scan.buffer.matrix)
+		}
+		scan.fill(position, maxError, bound)
+		k = 0
+	}
+
+	lcs, length := scan.scores[k], scan.lengths[k]
+	if lcs >= 0 && maxError >= 0 && length-lcs > maxError {
+		return -1, -1
+	}
+
+	return lcs, length
+}
+
+// fill scores the reference at a position and the next ones accepted by the
+// bound function, up to lcsLanes references.
+func (scan *LCSBatchScan) fill(position, maxError int,
+	bound func(position int) (int, bool)) {
+
+	scan.positions = append(scan.positions[:0], position)
+	scan.chunk = append(scan.chunk[:0], scan.references[scan.order[position]])
+
+	for p := position + 1; p < len(scan.order) && len(scan.positions) < lcsLanes; p++ {
+		maxe, ok := bound(p)
+		if !ok || !scan.batchable(p, maxe) {
+			continue
+		}
+
+		if maxe == -1 || maxError == -1 {
+			maxError = -1
+		} else {
+			maxError = max(maxError, maxe)
+		}
+
+		scan.positions = append(scan.positions, p)
+		scan.chunk = append(scan.chunk, scan.references[scan.order[p]])
+	}
+
+	scan.scores, scan.lengths = FastLCSScoreBatch(scan.query, scan.chunk, maxError, scan.buffer)
+}
+
+// batchable tests if the reference at a position has to be aligned. When
+// the length difference exceeds the bound, FastLCSScore rejects it at once,
+// and it would only take a lane for nothing.
+func (scan *LCSBatchScan) batchable(position, maxError int) bool {
+	lr := scan.references[scan.order[position]].Len()
+	return maxError == -1 || obiutils.Abs(scan.query.Len()-lr) <= maxError
+}
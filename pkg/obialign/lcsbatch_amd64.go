@@ -0,0 +1,20 @@
+package obialign
+
+import "golang.org/x/sys/cpu"
+
+//go:noescape
+func lcsBatchAVX2(query *uint32, nrows int, refs *uint32, ncols int, col0 *uint32, lohi *[2 * lcsLanes]int32, row *uint32)
+
+func lcsBatchKernelAVX2(query, refs []uint32, ncols int, col0 []uint32, lohi *[2 * lcsLanes]int32, row []uint32) {
+	if len(query) == 0 || ncols == 0 {
+		return
+	}
+
+	lcsBatchAVX2(&query[0], len(query), &refs[0], ncols, &col0[0], lohi, &row[0])
+}
+
+func init() {
+	if cpu.X86.HasAVX2 {
+		lcsBatchKernel = lcsBatchKernelAVX2
+	}
+}
@@ -0,0 +1,111 @@
+#include "textflag.h"
+
+// func lcsBatchAVX2(query *uint32, nrows int, refs *uint32, ncols int, col0 *uint32, lohi *[16]int32, row *uint32)
+//
+// AVX2 version of lcsBatchKernelGeneric: the eight lanes of a cell are
+// processed by a single YMM register.
+//
+// Registers:
+//   Y0: 1        Y4: lowest band diagonals   Y8:  diagonal cell
+//   Y1: 1 << 16  Y5: highest band diagonals  Y9:  left cell
+//   Y2: in flag  Y6: query code              Y10: up cell
+//   Y3: lcsOut   Y7: current diagonal        Y11-Y13: temporaries
+TEXT ·lcsBatchAVX2(SB), NOSPLIT, $0-56
+	MOVQ query+0(FP), SI
+	MOVQ nrows+8(FP), R8
+	MOVQ refs+16(FP), R9
+	MOVQ ncols+24(FP), R10
+	MOVQ col0+32(FP), R11
+	MOVQ lohi+40(FP), R12
+	MOVQ row+48(FP), DI
+
+	TESTQ R8, R8
+	JLE   done
+	TESTQ R10, R10
+	JLE   done
+
+	MOVL         $1, AX
+	MOVQ         AX, X0
+	VPBROADCASTD X0, Y0
+	MOVL         $0x10000, AX
+	MOVQ         AX, X1
+	VPBROADCASTD X1, Y1
+	MOVL         $0x80000000, AX
+	MOVQ         AX, X2
+	VPBROADCASTD X2, Y2
+	MOVL         $35534, AX // encodeValues32(0, 30000, true)
+	MOVQ         AX, X3
+	VPBROADCASTD X3, Y3
+	VMOVDQU      (R12), Y4
+	VMOVDQU      32(R12), Y5
+
+	MOVQ $1, BX     // current row
+	ADDQ $32, R11   // first column cell of row 1
+
+rowloop:
+	CMPQ BX, R8
+	JGT  done
+
+	VPBROADCASTD (SI), Y6
+	ADDQ         $4, SI
+
+	// The diagonal of the first column cell is 1 - i
+	MOVQ         $1, AX
+	SUBQ         BX, AX
+	MOVQ         AX, X7
+	VPBROADCASTD X7, Y7
+
+	VMOVDQU (DI), Y8
+	VMOVDQU (R11), Y9
+	VMOVDQU Y9, (DI)
+	ADDQ    $32, R11
+
+	MOVQ DI, R13
+	MOVQ R9, R14
+	MOVQ R10, CX
+
+colloop:
+	ADDQ    $32, R13
+	VMOVDQU (R13), Y10
+
+	// Diagonal move, scored when the nucleotide codes share a bit
+	VPAND   (R14), Y6, Y11
+	VPMINUD Y0, Y11, Y11
+	VPSLLD  $16, Y11, Y11
+	VPADDD  Y11, Y8, Y11
+	VPSUBD  Y0, Y11, Y11
+
+	// Best of the three moves
+	VPSUBD  Y0, Y10, Y12
+	VPSUBD  Y0, Y9, Y13
+	VPMAXUD Y12, Y11, Y11
+	VPMAXUD Y13, Y11, Y11
+
+	// Cells on the band borders are marked as out
+	VPCMPEQD Y7, Y4, Y12
+	VPCMPEQD Y7, Y5, Y13
+	VPOR     Y13, Y12, Y12
+	VPAND    Y2, Y12, Y12
+	VPANDN   Y11, Y12, Y11
+
+	// Cells outside of the band are set to lcsOut
+	VPCMPGTD  Y7, Y4, Y12
+	VPCMPGTD  Y5, Y7, Y13
+	VPOR      Y13, Y12, Y12
+	VPBLENDVB Y12, Y3, Y11, Y11
+
+	VMOVDQU Y11, (R13)
+	VMOVDQA Y10, Y8
+	VMOVDQA Y11, Y9
+	VPADDD  Y0, Y7, Y7
+
+	ADDQ $32, R14
+	DECQ CX
+	JNZ  colloop
+
+	INCQ BX
+	JMP  rowloop
+
+done:
+	VZEROUPPER
+	RET
@@ -0,0 +1,17 @@
+package obialign
+
+//go:noescape
+func lcsBatchNEON(query *uint32, nrows int, refs *uint32, ncols int, col0 *uint32, lohi *[2 * lcsLanes]int32, row *uint32)
+
+func lcsBatchKernelNEON(query, refs []uint32, ncols int, col0 []uint32, lohi *[2 * lcsLanes]int32, row []uint32) {
+	if len(query) == 0 || ncols == 0 {
+		return
+	}
+
+	lcsBatchNEON(&query[0], len(query), &refs[0], ncols, &col0[0], lohi, &row[0])
+}
+
+// NEON is part of the base arm64 instruction set.
+func init() {
+	lcsBatchKernel = lcsBatchKernelNEON
+}
@@ -0,0 +1,135 @@
+#include "textflag.h"
+
+// func lcsBatchNEON(query *uint32, nrows int, refs *uint32, ncols int, col0 *uint32, lohi *[16]int32, row *uint32)
+//
+// NEON version of lcsBatchKernelGeneric: the eight lanes of a cell are
+// processed by a pair of 128 bits registers.
+//
+// Registers:
+//   V0: 1               V4, V5: lowest band diagonals    V10, V11: diagonal cell
+//   V2: in flag         V6, V7: highest band diagonals   V12, V13: left cell
+//   V3: lcsOut          V8: query code                   V14, V15: up cell
+//   V9: current diagonal                                 V16-V18: temporaries
+//   V20, V21: new cell  V24, V25: reference codes
+TEXT ·lcsBatchNEON(SB), NOSPLIT, $0-56
+	MOVD query+0(FP), R0
+	MOVD nrows+8(FP), R1
+	MOVD refs+16(FP), R2
+	MOVD ncols+24(FP), R3
+	MOVD col0+32(FP), R11
+	MOVD lohi+40(FP), R12
+	MOVD row+48(FP), R6
+
+	CMP $0, R1
+	BLE done
+	CMP $0, R3
+	BLE done
+
+	MOVW  $1, R4
+	VDUP  R4, V0.S4
+	MOVW  $0x80000000, R4
+	VDUP  R4, V2.S4
+	MOVW  $35534, R4 // encodeValues32(0, 30000, true)
+	VDUP  R4, V3.S4
+	VLD1  (R12), [V4.S4, V5.S4, V6.S4, V7.S4]
+
+	MOVD $1, R5     // current row
+	ADD  $32, R11   // first column cell of row 1
+
+rowloop:
+	CMP R1, R5
+	BGT done
+
+	MOVWU (R0), R4
+	VDUP  R4, V8.S4
+	ADD   $4, R0
+
+	// The diagonal of the first column cell is 1 - i
+	MOVD $1, R4
+	SUB  R5, R4, R4
+	VDUP R4, V9.S4
+
+	VLD1   (R6), [V10.S4, V11.S4]
+	VLD1.P 32(R11), [V12.S4, V13.S4]
+	VST1   [V12.S4, V13.S4], (R6)
+
+	ADD  $32, R6, R13
+	MOVD R2, R14
+	MOVD R3, R7
+
+colloop:
+	VLD1.P 32(R14), [V24.S4, V25.S4]
+	VLD1   (R13), [V14.S4, V15.S4]
+
+	// Lanes 0 to 3
+
+	// Diagonal move, scored when the nucleotide codes share a bit
+	VAND  V24.B16, V8.B16, V16.B16
+	VUMIN V0.S4, V16.S4, V16.S4
+	VSHL  $16, V16.S4, V16.S4
+	VADD  V16.S4, V10.S4, V16.S4
+	VSUB  V0.S4, V16.S4, V16.S4
+
+	// Best of the three moves
+	VSUB  V0.S4, V14.S4, V17.S4
+	VSUB  V0.S4, V12.S4, V18.S4
+	VUMAX V17.S4, V16.S4, V16.S4
+	VUMAX V18.S4, V16.S4, V20.S4
+
+	// Cells on the band borders are marked as out
+	VCMEQ V9.S4, V4.S4, V17.S4
+	VCMEQ V9.S4, V6.S4, V18.S4
+	VORR  V18.B16, V17.B16, V17.B16
+	VAND  V2.B16, V17.B16, V17.B16
+	VBIC  V17.B16, V20.B16, V20.B16
+
+	// Cells outside of the band are set to lcsOut
+	VCMGT V9.S4, V4.S4, V17.S4
+	VCMGT V6.S4, V9.S4, V18.S4
+	VORR  V18.B16, V17.B16, V17.B16
+	VBIC  V17.B16, V20.B16, V20.B16
+	VAND  V17.B16, V3.B16, V18.B16
+	VORR  V18.B16, V20.B16, V20.B16
+
+	// Lanes 4 to 7
+
+	VAND  V25.B16, V8.B16, V16.B16
+	VUMIN V0.S4, V16.S4, V16.S4
+	VSHL  $16, V16.S4, V16.S4
+	VADD  V16.S4, V11.S4, V16.S4
+	VSUB  V0.S4, V16.S4, V16.S4
+
+	VSUB  V0.S4, V15.S4, V17.S4
+	VSUB  V0.S4, V13.S4, V18.S4
+	VUMAX V17.S4, V16.S4, V16.S4
+	VUMAX V18.S4, V16.S4, V21.S4
+
+	VCMEQ V9.S4, V5.S4, V17.S4
+	VCMEQ V9.S4, V7.S4, V18.S4
+	VORR  V18.B16, V17.B16, V17.B16
+	VAND  V2.B16, V17.B16, V17.B16
+	VBIC  V17.B16, V21.B16, V21.B16
+
+	VCMGT V9.S4, V5.S4, V17.S4
+	VCMGT V7.S4, V9.S4, V18.S4
+	VORR  V18.B16, V17.B16, V17.B16
+	VBIC  V17.B16, V21.B16, V21.B16
+	VAND  V17.B16, V3.B16, V18.B16
+	VORR  V18.B16, V21.B16, V21.B16
+
+	VST1.P [V20.S4, V21.S4], 32(R13)
+
+	VMOV V14.B16, V10.B16
+	VMOV V15.B16, V11.B16
+	VMOV V20.B16, V12.B16
+	VMOV V21.B16, V13.B16
+	VADD V0.S4, V9.S4, V9.S4
+
+	SUBS $1, R7, R7
+	BNE  colloop
+
+	ADD $1, R5, R5
+	B   rowloop
+
+done:
+	RET
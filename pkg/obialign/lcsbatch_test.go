@@ -0,0 +1,295 @@
+package obialign
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// randomRelatives builds a random sequence and a set of relatives carrying
+// substitutions and indels. Some IUPAC ambiguity codes and upper case
+// letters are included.
+func randomRelatives(rng *rand.Rand, length, n, events int) (*obiseq.BioSequence, obiseq.BioSequenceSlice) {
+	symbols := []byte("acgtacgtacgtacgtnrykACGT")
+	random := func() byte { return symbols[rng.Intn(len(symbols))] }
+
+	query := make([]byte, length)
+	for i := range query {
+		query[i] = random()
+	}
+
+	references := obiseq.MakeBioSequenceSlice(n)
+	for r := range references {
+		seq := append([]byte{}, query...)
+		for e := rng.Intn(events + 1); e > 0 && len(seq) > 1; e-- {
+			i := rng.Intn(len(seq))
+			switch rng.Intn(4) {
+			case 0:
+				seq = append(seq[:i], seq[i+1:]...)
+			case 1:
+				seq = append(seq[:i], append([]byte{random()}, seq[i:]...)...)
+			default:
+				seq[i] = random()
+			}
+		}
+		references[r] = obiseq.NewBioSequence(fmt.Sprintf("ref_%d", r), seq, "")
+	}
+
+	return obiseq.NewBioSequence("query", query, ""), references
+}
+
+func TestFastLCSScoreBatch(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	buffer := &LCSBatchBuffer{}
+	var matrix []uint64
+
+	for round := 0; round < 200; round++ {
+		query, references := randomRelatives(rng, 1+rng.Intn(150), 1+rng.Intn(20), 1+rng.Intn(30))
+
+		for _, maxError := range []int{-1, 0, 1, 2, 3, 5, 10, 25} {
+			scores, lengths := FastLCSScoreBatch(query, references, maxError, buffer)
+
+			for i, ref := range references {
+				score, length := FastLCSScore(query, ref, maxError, &matrix)
+				if scores[i] != score || lengths[i] != length {
+					t.Fatalf("maxError %d: %s vs %s: batch gives (%d,%d), FastLCSScore gives (%d,%d)",
+						maxError, query.String(), ref.String(), scores[i], lengths[i], score, length)
+				}
+			}
+		}
+	}
+}
+
+func TestFastLCSScoreBatchFallback(t *testing.T) {
+	query := obiseq.NewBioSequence("query", []byte("acgt..acgtacgt"), "")
+	references := obiseq.BioSequenceSlice{
+		obiseq.NewBioSequence("r1", []byte("acgt..acgtacgt"), ""),
+		obiseq.NewBioSequence("r2", []byte("acgtacgtacgt"), ""),
+	}
+
+	scores, lengths := FastLCSScoreBatch(query, references, -1, nil)
+
+	for i, ref := range references {
+		score, length := FastLCSScore(query, ref, -1, nil)
+		if scores[i] != score || lengths[i] != length {
+			t.Errorf("%s: batch gives (%d,%d), FastLCSScore gives (%d,%d)",
+				ref.Id(), scores[i], lengths[i], score, length)
+		}
+	}
+}
+
+// The kernel selected for the running processor must behave exactly as
+// the generic one, including on the band borders.
+func TestLCSBatchKernel(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+
+	for round := 0; round < 100; round++ {
+		nrows := 1 + rng.Intn(60)
+		ncols := 1 + rng.Intn(60)
+
+		query := make([]uint32, nrows)
+		for i := range query {
+			query[i] = uint32(rng.Intn(16))
+		}
+		refs := make([]uint32, ncols*lcsLanes)
+		for i := range refs {
+			refs[i] = uint32(rng.Intn(16))
+		}
+
+		var lohi [2 * lcsLanes]int32
+		for lane := 0; lane < lcsLanes; lane++ {
+			lohi[lane] = -int32(rng.Intn(2 * nrows))
+			lohi[lcsLanes+lane] = int32(rng.Intn(2 * ncols))
+		}
+
+		col0 := make([]uint32, (nrows+1)*lcsLanes)
+		row := make([]uint32, (ncols+1)*lcsLanes)
+		for lane := 0; lane < lcsLanes; lane++ {
+			for i := 0; i <= nrows; i++ {
+				col0[i*lcsLanes+lane] = lcsBand(encodeValues32(0, i, false), int32(-i), lohi[lane], lohi[lcsLanes+lane])
+			}
+			for j := 0; j <= ncols; j++ {
+				row[j*lcsLanes+lane] = lcsBand(encodeValues32(0, j, false), int32(j), lohi[lane], lohi[lcsLanes+lane])
+			}
+		}
+		expected := append([]uint32{}, row...)
+
+		lcsBatchKernelGeneric(query, refs, ncols, col0, &lohi, expected)
+		lcsBatchKernel(query, refs, ncols, col0, &lohi, row)
+
+		for k := range row {
+			if row[k] != expected[k] {
+				t.Fatalf("round %d: cell %d of lane %d is %x, expected %x",
+					round, k/lcsLanes, k%lcsLanes, row[k], expected[k])
+			}
+		}
+	}
+}
+
+func benchmarkReferences() (*obiseq.BioSequence, obiseq.BioSequenceSlice) {
+	rng := rand.New(rand.NewSource(1))
+	return randomRelatives(rng, 120, 256, 20)
+}
+
+func BenchmarkFastLCSScore(b *testing.B) {
+	query, references := benchmarkReferences()
+	var matrix []uint64
+
+	for b.Loop() {
+		for _, ref := range references {
+			FastLCSScore(query, ref, -1, &matrix)
+		}
+	}
+}
+
+func BenchmarkFastLCSScoreBatch(b *testing.B) {
+	query, references := benchmarkReferences()
+	buffer := &LCSBatchBuffer{}
+
+	for b.Loop() {
+		FastLCSScoreBatch(query, references, -1, buffer)
+	}
+}
+
+func BenchmarkFastLCSScoreBatchGeneric(b *testing.B) {
+	query, references := benchmarkReferences()
+	buffer := &LCSBatchBuffer{}
+
+	kernel := lcsBatchKernel
+	lcsBatchKernel = lcsBatchKernelGeneric
+	defer func() { lcsBatchKernel = kernel }()
+
+	for b.Loop() {
+		FastLCSScoreBatch(query, references, -1, buffer)
+	}
+}
+
+func BenchmarkFastLCSScoreBounded(b *testing.B) {
+	query, references := benchmarkReferences()
+	var matrix []uint64
+
+	for b.Loop() {
+		for _, ref := range references {
+			FastLCSScore(query, ref, 10, &matrix)
+		}
+	}
+}
+
+func BenchmarkFastLCSScoreBatchBounded(b *testing.B) {
+	query, references := benchmarkReferences()
+	buffer := &LCSBatchBuffer{}
+
+	for b.Loop() {
+		FastLCSScoreBatch(query, references, 10, buffer)
+	}
+}
+
+// The scan must give the results of FastLCSScore within the bound whatever
+// its decrease along the scan, and -1 beyond it.
+func TestLCSBatchScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+	var matrix []uint64
+
+	for round := 0; round < 200; round++ {
+		query, references := randomRelatives(rng, 1+rng.Intn(150), 1+rng.Intn(40), 1+rng.Intn(30))
+
+		order := rng.Perm(len(references))
+		skipped := make([]bool, len(references))
+		for p := range skipped {
+			skipped[p] = rng.Intn(4) == 0
+		}
+
+		maxe := -1
+		bound := func(p int) (int, bool) { return maxe, !skipped[p] }
+		scan := MakeLCSBatchScan(query, references, order, nil)
+
+		for p, r := range order {
+			if skipped[p] {
+				continue
+			}
+
+			score, length := scan.Score(p, maxe, bound)
+			escore, elength := FastLCSScore(query, references[r], maxe, &matrix)
+			if escore >= 0 && maxe >= 0 && elength-escore > maxe {
+				escore, elength = -1, -1
+			}
+			if score != escore || length != elength {
+				t.Fatalf("maxError %d: %s vs %s: scan gives (%d,%d), FastLCSScore gives (%d,%d)",
+					maxe, query.String(), references[r].String(), score, length, escore, elength)
+			}
+
+			if score >= 0 && (maxe == -1 || length-score < maxe) {
+				maxe = length - score
+			} else if maxe > 0 && rng.Intn(4) == 0 {
+				maxe--
+			}
+		}
+	}
+}
+
+// benchmarkScan emulates the search of the closest references, the
+// maximum number of errors being lowered to the best found. As in the
+// actual scans, D1Or0 is used once at most one error is allowed.
+func benchmarkScan(query *obiseq.BioSequence, references obiseq.BioSequenceSlice,
+	score func(p, maxe int) (int, int)) {
+	maxe := -1
+	for p, ref := range references {
+		errs := -1
+		if maxe == 0 || maxe == 1 {
+			errs, _, _, _ = D1Or0(query, ref)
+		} else if lcs, length := score(p, maxe); lcs >= 0 {
+			errs = length - lcs
+		}
+
+		if errs >= 0 && (maxe == -1 || errs < maxe) {
+			maxe = errs
+		}
+	}
+}
+
+func benchmarkScanReferences() (*obiseq.BioSequence, obiseq.BioSequenceSlice) {
+	rng := rand.New(rand.NewSource(1))
+	query, references := randomRelatives(rng, 120, 1024, 40)
+
+	// Remove the closest references to keep the bound of the scan above one
+	distant := obiseq.MakeBioSequenceSlice()
+	for _, ref := range references {
+		if d, _, _, _ := D1Or0(query, ref); d < 0 {
+			distant = append(distant, ref)
+		}
+	}
+
+	return query, distant
+}
+
+func BenchmarkLCSScan(b *testing.B) {
+	query, references := benchmarkScanReferences()
+	var matrix []uint64
+
+	for b.Loop() {
+		benchmarkScan(query, references, func(p, maxe int) (int, int) {
+			return FastLCSScore(query, references[p], maxe, &matrix)
+		})
+	}
+}
+
+func BenchmarkLCSBatchScan(b *testing.B) {
+	query, references := benchmarkScanReferences()
+	order := make([]int, len(references))
+	for i := range order {
+		order[i] = i
+	}
+	buffer := &LCSBatchBuffer{}
+
+	for b.Loop() {
+		maxe := -1
+		bound := func(p int) (int, bool) { return maxe, maxe != 0 && maxe != 1 }
+		scan := MakeLCSBatchScan(query, references, order, buffer)
+		benchmarkScan(query, references, func(p, e int) (int, int) {
+			maxe = e
+			return scan.Score(p, e, bound)
+		})
+	}
+}
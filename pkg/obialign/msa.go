@@ -0,0 +1,451 @@
+package obialign
+
+import (
+	"math"
+	"slices"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidist"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// LCSDistanceMatrix computes the distances between every pair of sequences
+// of a set. The distance between two sequences is one minus their LCS
+// identity, the length of their longest common subsequence divided by the
+// length of the corresponding alignment, as computed by FastLCSScore.
+//
+// Parameters:
+//   - sequences: The sequences to compare.
+//
+// Returns:
+//   - A distance matrix labelled by the sequence identifiers.
+func LCSDistanceMatrix(sequences obiseq.BioSequenceSlice) *obidist.DistMatrix {
+	labels := make([]string, len(sequences))
+	for i, sequence := range sequences {
+		labels[i] = sequence.Id()
+	}
+
+	dm := obidist.NewDistMatrixWithLabels(labels)
+	buffer := &LCSBatchBuffer{}
+
+	for i := 0; i < len(sequences)-1; i++ {
+		scores, lengths := FastLCSScoreBatch(sequences[i], sequences[i+1:], -1, buffer)
+
+		for k, score := range scores {
+			distance := 1.0
+			if lengths[k] > 0 {
+				distance = 1 - float64(score)/float64(lengths[k])
+			}
+			dm.Set(i, i+1+k, distance)
+		}
+	}
+
+	return dm
+}
+
+// msaNode is a node of the guide tree of a multiple alignment. Leaves
+// store the index of their sequence.
+type msaNode struct {
+	left, right *msaNode
+	index       int
+}
+
+// upgmaGuideTree builds the guide tree of a multiple alignment by UPGMA
+// clustering of a distance matrix.
+func upgmaGuideTree(dm *obidist.DistMatrix) *msaNode {
+	n := dm.Size()
+	if n == 0 {
+		return nil
+	}
+
+	nodes := make([]*msaNode, n)
+	sizes := make([]int, n)
+	distances := dm.ToFullMatrix()
+
+	for i := range nodes {
+		nodes[i] = &msaNode{index: i}
+		sizes[i] = 1
+	}
+
+	for active := n; active > 1; active-- {
+		bi, bj := -1, -1
+		for i := range nodes {
+			if nodes[i] == nil {
+				continue
+			}
+			for j := i + 1; j < n; j++ {
+				if nodes[j] != nil && (bi < 0 || distances[i][j] < distances[bi][bj]) {
+					bi, bj = i, j
+				}
+			}
+		}
+
+		for k := range nodes {
+			if nodes[k] != nil && k != bi && k != bj {
+				d := (distances[bi][k]*float64(sizes[bi]) + distances[bj][k]*float64(sizes[bj])) /
+					float64(sizes[bi]+sizes[bj])
+				distances[bi][k], distances[k][bi] = d, d
+			}
+		}
+
+		nodes[bi] = &msaNode{left: nodes[bi], right: nodes[bj], index: -1}
+		sizes[bi] += sizes[bj]
+		nodes[bj] = nil
+	}
+
+	return nodes[0]
+}
+
+// msaProfile is a set of aligned sequences. Each column is summarized by the
+// frequencies of the four nucleotides, ambiguous symbols being split between
+// the nucleotides they represent.
+type msaProfile struct {
+	members []int
+	rows    [][]byte
+	freqs   [][4]float64
+}
+
+func newMSAProfile(index int, sequence []byte) *msaProfile {
+	profile := &msaProfile{
+		members: []int{index},
+		rows:    [][]byte{slices.Clone(sequence)},
+	}
+	profile.computeFrequencies()
+	return profile
+}
+
+func (profile *msaProfile) Len() int {
+	return len(profile.rows[0])
+}
+
+func (profile *msaProfile) computeFrequencies() {
+	profile.freqs = make([][4]float64, profile.Len())
+	weight := 1 / float64(len(profile.rows))
+
+	for _, row := range profile.rows {
+		for c, nuc := range row {
+			bits := obiseq.IUPACBits(nuc)
+			nbits := _FourBitsCount[bits]
+			for b := 0; b < 4; b++ {
+				if bits&(1<<b) != 0 {
+					profile.freqs[c][b] += weight / nbits
+				}
+			}
+		}
+	}
+}
+
+// align aligns two profiles with affine gap penalties, scoring a pair of
+// columns by the mean score of the pairs of nucleotides they contain. The
+// alignment covers both profiles, and the returned operations are in
+// reverse order: M for a pair of columns, I for a column of the first
+// profile facing a gap, D for a column of the second profile facing a gap.
+func (profile *msaProfile) align(other *msaProfile, scores *[4][4]float64, open, extend float64, freeEnds bool) []byte {
+	lA, lB := profile.Len(), other.Len()
+	width := lB + 1
+	minusInf := math.Inf(-1)
+
+	border := func(n int) float64 {
+		if freeEnds || n == 0 {
+			return 0
+		}
+		return -(open + float64(n-1)*extend)
+	}
+
+	// Scores of the columns of the second profile against each nucleotide
+	columns := make([][4]float64, lB)
+	for j, f := range other.freqs {
+		for a := 0; a < 4; a++ {
+			for b := 0; b < 4; b++ {
+				columns[j][a] += f[b] * scores[a][b]
+			}
+		}
+	}
+
+	H := make([]float64, lB+1)
+	F := make([]float64, lB+1)
+	trace := make([]byte, (lA+1)*width)
+
+	for j := range H {
+		H[j] = border(j)
+		F[j] = minusInf
+	}
+
+	bestScore, bestI, bestJ := H[lB], 0, lB
+
+	for i := 1; i <= lA; i++ {
+		diag := H[0]
+		H[0] = border(i)
+		left := H[0]
+		e := minusInf
+		fA := profile.freqs[i-1]
+
+		for j := 1; j <= lB; j++ {
+			up := H[j]
+			var t byte
+
+			if ext := e - extend; ext > left-open {
+				e = ext
+				t |= _affineEExt
+			} else {
+				e = left - open
+			}
+
+			if ext := F[j] - extend; ext > up-open {
+				F[j] = ext
+				t |= _affineFExt
+			} else {
+				F[j] = up - open
+			}
+
+			c := &columns[j-1]
+			h := diag + fA[0]*c[0] + fA[1]*c[1] + fA[2]*c[2] + fA[3]*c[3]
+			src := _affineDiag
+			if e > h {
+				h, src = e, _affineLeft
+			}
+			if F[j] > h {
+				h, src = F[j], _affineUp
+			}
+
+			trace[i*width+j] = t | src
+			diag = up
+			H[j] = h
+			left = h
+		}
+
+		if freeEnds && H[lB] > bestScore {
+			bestScore, bestI, bestJ = H[lB], i, lB
+		}
+	}
+
+	if freeEnds {
+		for j := 0; j <= lB; j++ {
+			if H[j] > bestScore || (j == lB && H[j] == bestScore) {
+				bestScore, bestI, bestJ = H[j], lA, j
+			}
+		}
+	} else {
+		bestI, bestJ = lA, lB
+	}
+
+	ops := make([]byte, 0, lA+lB)
+	for k := bestI; k < lA; k++ {
+		ops = append(ops, 'I')
+	}
+	for k := bestJ; k < lB; k++ {
+		ops = append(ops, 'D')
+	}
+
+	i, j := bestI, bestJ
+	state := _affineDiag
+
+	for i > 0 && j > 0 {
+		t := trace[i*width+j]
+
+		switch state {
+		case _affineLeft:
+			ops = append(ops, 'D')
+			j--
+			if t&_affineEExt == 0 {
+				state = _affineDiag
+			}
+		case _affineUp:
+			ops = append(ops, 'I')
+			i--
+			if t&_affineFExt == 0 {
+				state = _affineDiag
+			}
+		default:
+			if src := t & 3; src == _affineDiag {
+				ops = append(ops, 'M')
+				i--
+				j--
+			} else {
+				state = src
+			}
+		}
+	}
+
+	for ; i > 0; i-- {
+		ops = append(ops, 'I')
+	}
+	for ; j > 0; j-- {
+		ops = append(ops, 'D')
+	}
+
+	return ops
+}
+
+// merge builds the profile of the alignment of two profiles described by
+// the operations returned by align.
+func (profile *msaProfile) merge(other *msaProfile, ops []byte) *msaProfile {
+	merged := &msaProfile{
+		members: append(slices.Clone(profile.members), other.members...),
+		rows:    make([][]byte, 0, len(profile.rows)+len(other.rows)),
+	}
+
+	gapped := func(row []byte, gap byte) []byte {
+		result := make([]byte, 0, len(ops))
+		p := 0
+		for k := len(ops) - 1; k >= 0; k-- {
+			if ops[k] == gap {
+				result = append(result, '-')
+			} else {
+				result = append(result, row[p])
+				p++
+			}
+		}
+		return result
+	}
+
+	for _, row := range profile.rows {
+		merged.rows = append(merged.rows, gapped(row, 'D'))
+	}
+
+	for _, row := range other.rows {
+		merged.rows = append(merged.rows, gapped(row, 'I'))
+	}
+
+	merged.computeFrequencies()
+
+	return merged
+}
+
+// MultipleAlignment aligns a set of sequences by progressive alignment.
+// The sequences are clustered by UPGMA according to their LCS distances,
+// and the profiles of the aligned groups are aligned following the
+// resulting guide tree.
+//
+// Parameters:
+//   - sequences: The sequences to align.
+//   - scoring: The scoring scheme. If nil, DefaultScoringScheme is used.
+//   - freeEndGaps: A boolean value indicating whether the gaps at the ends
+//     of the profile alignments are not penalized.
+//
+// Returns:
+//   - Copies of the sequences, in the same order, where gaps are
+//     represented by dashes. Quality scores are discarded.
+func MultipleAlignment(sequences obiseq.BioSequenceSlice,
+	scoring *ScoringScheme,
+	freeEndGaps bool) obiseq.BioSequenceSlice {
+
+	if scoring == nil {
+		scoring = DefaultScoringScheme()
+	}
+
+	var scores [4][4]float64
+	for a := 0; a < 4; a++ {
+		for b := 0; b < 4; b++ {
+			scores[a][b] = float64(scoring.Score("acgt"[a], "acgt"[b]))
+		}
+	}
+
+	open := float64(scoring.GapOpen)
+	extend := float64(scoring.GapExtend)
+
+	var progressive func(node *msaNode) *msaProfile
+	progressive = func(node *msaNode) *msaProfile {
+		if node.index >= 0 {
+			return newMSAProfile(node.index, sequences[node.index].Sequence())
+		}
+
+		left := progressive(node.left)
+		right := progressive(node.right)
+		ops := left.align(right, &scores, open, extend, freeEndGaps)
+
+		return left.merge(right, ops)
+	}
+
+	aligned := make(obiseq.BioSequenceSlice, len(sequences))
+
+	if len(sequences) == 0 {
+		return aligned
+	}
+
+	profile := progressive(upgmaGuideTree(LCSDistanceMatrix(sequences)))
+
+	for k, index := range profile.members {
+		sequence := sequences[index].Copy()
+		sequence.TakeSequence(profile.rows[k])
+		sequence.SetQualities(nil)
+		aligned[index] = sequence
+	}
+
+	return aligned
+}
+
+// AlignmentConsensus computes the consensus of a multiple alignment. Each
+// sequence is weighted by its count. Columns where gaps represent more than
+// half of the weight are left out of the consensus. In the other columns,
+// the nucleotides are taken by decreasing frequency until their cumulated
+// frequency reaches the threshold, and the consensus is the IUPAC symbol
+// representing them.
+//
+// Parameters:
+//   - aligned: The aligned sequences, as returned by MultipleAlignment.
+//   - threshold: The fraction of the nucleotides represented by the consensus symbols.
+//
+// Returns:
+//   - The consensus sequence without gaps.
+func AlignmentConsensus(aligned obiseq.BioSequenceSlice, threshold float64) []byte {
+	if len(aligned) == 0 {
+		return []byte{}
+	}
+
+	length := aligned[0].Len()
+	consensus := make([]byte, 0, length)
+	order := []int{0, 1, 2, 3}
+
+	for c := 0; c < length; c++ {
+		var freqs [4]float64
+		total, gaps := 0.0, 0.0
+
+		for _, sequence := range aligned {
+			weight := float64(sequence.Count())
+			total += weight
+
+			bits := obiseq.IUPACBits(sequence.Sequence()[c])
+			if bits == 0 {
+				gaps += weight
+				continue
+			}
+
+			nbits := _FourBitsCount[bits]
+			for b := 0; b < 4; b++ {
+				if bits&(1<<b) != 0 {
+					freqs[b] += weight / nbits
+				}
+			}
+		}
+
+		if gaps > total/2 || gaps == total {
+			continue
+		}
+
+		slices.SortStableFunc(order, func(a, b int) int {
+			switch {
+			case freqs[a] > freqs[b]:
+				return -1
+			case freqs[a] < freqs[b]:
+				return 1
+			}
+			return 0
+		})
+
+		limit := threshold * (total - gaps) * (1 - 1e-9)
+		bits := byte(0)
+		cumulated := 0.0
+
+		for _, b := range order {
+			if cumulated >= limit || freqs[b] == 0 {
+				break
+			}
+			bits |= 1 << b
+			cumulated += freqs[b]
+		}
+
+		consensus = append(consensus, obiseq.IUPACNuc(bits))
+	}
+
+	return consensus
+}
@@ -0,0 +1,87 @@
+package obialign
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+func TestMultipleAlignment(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+
+	for round := 0; round < 30; round++ {
+		_, sequences := randomRelatives(rng, 20+rng.Intn(80), 1+rng.Intn(12), 1+rng.Intn(8))
+
+		for _, freeEndGaps := range []bool{false, true} {
+			aligned := MultipleAlignment(sequences, nil, freeEndGaps)
+
+			if len(aligned) != len(sequences) {
+				t.Fatalf("%d aligned sequences for %d sequences", len(aligned), len(sequences))
+			}
+
+			for i, sequence := range aligned {
+				if sequence.Len() != aligned[0].Len() {
+					t.Fatalf("aligned sequences have different lengths: %d and %d",
+						sequence.Len(), aligned[0].Len())
+				}
+
+				ungapped := bytes.ReplaceAll(sequence.Sequence(), []byte("-"), nil)
+				if !bytes.Equal(ungapped, bytes.ToLower(sequences[i].Sequence())) {
+					t.Fatalf("aligned sequence %s is %s, original is %s",
+						sequence.Id(), ungapped, sequences[i].Sequence())
+				}
+
+				if sequence.Id() != sequences[i].Id() {
+					t.Fatalf("aligned sequence %d is %s, expected %s", i, sequence.Id(), sequences[i].Id())
+				}
+			}
+		}
+	}
+}
+
+func TestMultipleAlignmentIdentical(t *testing.T) {
+	sequences := obiseq.BioSequenceSlice{
+		obiseq.NewBioSequence("s1", []byte("acgtacgtacgtaaaccc"), ""),
+		obiseq.NewBioSequence("s2", []byte("acgtacgtacgtaaaccc"), ""),
+		obiseq.NewBioSequence("s3", []byte("acgtacgtcgtaaaccc"), ""),
+	}
+
+	aligned := MultipleAlignment(sequences, nil, false)
+
+	if s := aligned[0].String(); s != "acgtacgtacgtaaaccc" {
+		t.Errorf("s1 is aligned as %s", s)
+	}
+
+	if s := aligned[2].String(); bytes.Count([]byte(s), []byte("-")) != 1 {
+		t.Errorf("s3 is aligned as %s", s)
+	}
+}
+
+func TestAlignmentConsensus(t *testing.T) {
+	aligned := obiseq.BioSequenceSlice{
+		obiseq.NewBioSequence("s1", []byte("acgt-a"), ""),
+		obiseq.NewBioSequence("s2", []byte("acgt-a"), ""),
+		obiseq.NewBioSequence("s3", []byte("acctga"), ""),
+	}
+
+	for _, c := range []struct {
+		threshold float64
+		consensus string
+	}{
+		{0.5, "acgta"},
+		{0.6, "acgta"},
+		{0.7, "acsta"},
+		{1.0, "acsta"},
+	} {
+		if consensus := string(AlignmentConsensus(aligned, c.threshold)); consensus != c.consensus {
+			t.Errorf("threshold %.1f: consensus is %s, expected %s", c.threshold, consensus, c.consensus)
+		}
+	}
+
+	aligned[2].SetCount(3)
+	if consensus := string(AlignmentConsensus(aligned, 0.5)); consensus != "acctga" {
+		t.Errorf("weighted consensus is %s, expected acctga", consensus)
+	}
+}
@@ -414,6 +414,78 @@ func _FillMatrixPeCenterAlign(seqA, qualA, seqB, qualB []byte, gap, scale float6
 	return _GetMatrix(scoreMatrix, la, la-1, lb1)
 }
 
+// Gaps at the beginning and at the end of both A and B are free
+// With A spanning over lines and B over columns
+//   - First line and first column gaps = 0
+//   - Last line and last column gaps = 0
+//
+// It allows for aligning reads overhanging each other on both sides,
+// as when the insert is shorter than one of the reads, or when a read
+// is included in the other.
+func _FillMatrixPeOverlapAlign(seqA, qualA, seqB, qualB []byte, gap, scale float64,
+	scoreMatrix, pathMatrix *[]int) int {
+
+	la := len(seqA)
+	lb := len(seqB)
+
+	// The actual gap score is the gap score times the mismatch between
+	// two bases with a score of 40
+	gapPenalty := int(scale*gap*float64(_NucScorePartMatchMismatch[40][40]) + 0.5)
+
+	needed := (la + 1) * (lb + 1)
+
+	if needed > cap(*scoreMatrix) {
+		*scoreMatrix = make([]int, needed)
+	}
+
+	if needed > cap(*pathMatrix) {
+		*pathMatrix = make([]int, needed)
+	}
+
+	*scoreMatrix = (*scoreMatrix)[:needed]
+	*pathMatrix = (*pathMatrix)[:needed]
+
+	// Sets the first position of the matrix with 0 score
+	_SetMatrices(scoreMatrix, pathMatrix, la, -1, -1, 0, 0)
+
+	// Fills the first column with score 0
+	for i := 0; i < la; i++ {
+		_SetMatrices(scoreMatrix, pathMatrix, la, i, -1, 0, -1)
+	}
+
+	la1 := la - 1 // The last line (left gaps are free on it)
+	lb1 := lb - 1 // The last column (top gaps are free on it)
+
+	for j := 0; j < lb; j++ {
+
+		// Fill the first line with score 0
+		_SetMatrices(scoreMatrix, pathMatrix, la, -1, j, 0, 1)
+
+		for i := 0; i < la; i++ {
+			left, diag, top := _GetMatrixFrom(scoreMatrix, la, i, j)
+
+			diag += _PairingScorePeAlign(seqA[i], qualA[i], seqB[j], qualB[j], scale)
+			if i < la1 {
+				left += gapPenalty
+			}
+			if j < lb1 {
+				top += gapPenalty
+			}
+
+			switch {
+			case diag >= left && diag >= top:
+				_SetMatrices(scoreMatrix, pathMatrix, la, i, j, diag, 0)
+			case left >= diag && left >= top:
+				_SetMatrices(scoreMatrix, pathMatrix, la, i, j, left, +1)
+			default:
+				_SetMatrices(scoreMatrix, pathMatrix, la, i, j, top, -1)
+			}
+		}
+	}
+
+	return _GetMatrix(scoreMatrix, la, la1, lb1)
+}
+
 func PELeftAlign(seqA, seqB *obiseq.BioSequence, gap, scale float64,
 	arena PEAlignArena) (int, []int) {
 
@@ -483,6 +555,83 @@ func PECenterAlign(seqA, seqB *obiseq.BioSequence, gap, scale float64,
 	return score, path
 }
 
+// PEOverlapAlign aligns two paired reads allowing both of them to
+// overhang the other one on both sides.
+func PEOverlapAlign(seqA, seqB *obiseq.BioSequence, gap, scale float64,
+	arena PEAlignArena) (int, []int) {
+
+	if !_InitializedDnaScore {
+		_InitDNAScoreMatrix()
+	}
+
+	if arena.pointer == nil {
+		arena = MakePEAlignArena(seqA.Len(), seqB.Len())
+	}
+
+	score := _FillMatrixPeOverlapAlign(seqA.Sequence(), seqA.Qualities(),
+		seqB.Sequence(), seqB.Qualities(), gap, scale,
+		&arena.pointer.scoreMatrix,
+		&arena.pointer.pathMatrix)
+
+	path := _Backtracking(arena.pointer.pathMatrix,
+		seqA.Len(), seqB.Len(),
+		&arena.pointer.path)
+
+	return score, path
+}
+
+// PEUnalignedEnds measures the parts of two aligned reads facing gaps
+// before the first and after the last aligned position of an alignment
+// path. Both reads can have such a part on the same side of the alignment.
+//
+// Parameters:
+//   - path: The alignment path as returned by PEAlign.
+//
+// Returns:
+//   - The lengths of the beginnings of seqA and seqB facing gaps.
+//   - The lengths of the ends of seqA and seqB facing gaps.
+func PEUnalignedEnds(path []int) (int, int, int, int) {
+	var startA, startB, endA, endB int
+
+	// Each step of the path is a gap followed by a run of aligned
+	// positions, the gaps preceding the first run are leading gaps.
+	first := 0
+	for ; first < len(path); first += 2 {
+		if path[first] < 0 {
+			startA -= path[first]
+		} else {
+			startB += path[first]
+		}
+
+		if path[first+1] > 0 {
+			break
+		}
+	}
+
+	for last := len(path) - 2; last > first && path[last+1] == 0; last -= 2 {
+		if path[last] < 0 {
+			endA -= path[last]
+		} else {
+			endB += path[last]
+		}
+	}
+
+	return startA, startB, endA, endB
+}
+
+// _PeUsualGeometry checks that an alignment path only has free end gaps:
+// at the beginning of seqA and at the end of seqB for a left alignment,
+// at the beginning of seqB and at the end of seqA for a right one.
+func _PeUsualGeometry(path []int, isLeftAlign bool) bool {
+	startA, startB, endA, endB := PEUnalignedEnds(path)
+
+	if isLeftAlign {
+		return startB == 0 && endA == 0
+	}
+
+	return startA == 0 && endB == 0
+}
+
 func PEAlign(seqA, seqB *obiseq.BioSequence,
 	gap, scale float64, fastAlign bool, delta int, fastScoreRel bool,
 	arena PEAlignArena, shift_buff *map[int]int) (bool, int, []int, int, int, float64) {
@@ -511,14 +660,43 @@ func PEAlign(seqA, seqB *obiseq.BioSequence,
 
 		shift, fastCount, fastScore = obikmer.FastShiftFourMer(index, shift_buff, seqA.Len(), seqB, fastScoreRel, nil)
 
-		if shift > 0 {
-			over = seqA.Len() - shift
-		} else {
-			over = seqB.Len() + shift
-		}
+		over = min(seqA.Len(), shift+seqB.Len()) - max(shift, 0)
+
+		// In the usual geometries, seqA starts and ends before seqB (left
+		// alignment) or seqB starts and ends before seqA (right alignment).
+		// Other geometries occur when the insert is shorter than one of the
+		// reads, or when a read is included in the other one.
+		usual := (shift > 0 && shift+seqB.Len() >= seqA.Len()) ||
+			(shift <= 0 && shift+seqB.Len() <= seqA.Len())
+
+		if !usual {
+			if shift > 0 {
+				startA = max(shift-delta, 0)
+				startB = 0
+				extra5 = -startA
+			} else {
+				startA = 0
+				startB = max(-shift-delta, 0)
+				extra5 = startB
+			}
 
-		// At least one mismatch exists in the overlaping region
-		if fastCount+3 < over {
+			rawSeqA = seqA.Sequence()[startA:]
+			qualSeqA = seqA.Qualities()[startA:]
+			rawSeqB = seqB.Sequence()[startB:]
+			qualSeqB = seqB.Qualities()[startB:]
+			extra3 = 0
+			isLeftAlign = shift > 0
+			score = _FillMatrixPeOverlapAlign(
+				rawSeqA, qualSeqA, rawSeqB, qualSeqB, gap, scale,
+				&arena.pointer.scoreMatrix,
+				&arena.pointer.pathMatrix)
+
+			path = _Backtracking(arena.pointer.pathMatrix,
+				len(rawSeqA), len(rawSeqB),
+				&arena.pointer.path)
+
+		} else if fastCount+3 < over {
+			// At least one mismatch exists in the overlaping region
 
 			if shift > 0 {
 				startA = shift - delta
@@ -604,9 +782,17 @@ func PEAlign(seqA, seqB *obiseq.BioSequence,
 			path = append(path, 0, partLen)
 		}
 
-		path[0] += extra5
-		if path[len(path)-1] == 0 {
-			path[len(path)-2] += extra3
+		// The trimmed ends are merged with the end gaps of the path when
+		// they concern the same read.
+		if path[0] == 0 || extra5 == 0 || (path[0] > 0) == (extra5 > 0) {
+			path[0] += extra5
+		} else {
+			path = append([]int{extra5, 0}, path...)
+		}
+
+		last := len(path) - 2
+		if path[last+1] == 0 && (path[last] == 0 || extra3 == 0 || (path[last] > 0) == (extra3 > 0)) {
+			path[last] += extra3
 		} else {
 			path = append(path, extra3, 0)
 		}
@@ -646,6 +832,25 @@ func PEAlign(seqA, seqB *obiseq.BioSequence,
 			score = scoreL
 		}
 
+		// Reads overhanging each other on both sides are only
+		// considered when the best alignment pays for end gaps that
+		// the geometry of its alignment mode leaves free, and if they
+		// align better than in the usual geometries.
+		if !_PeUsualGeometry(path, isLeftAlign) {
+			scoreO := _FillMatrixPeOverlapAlign(
+				rawSeqA, qualSeqA, rawSeqB, qualSeqB, gap, scale,
+				&arena.pointer.scoreMatrix,
+				&arena.pointer.pathMatrix)
+
+			if scoreO > score {
+				path = _Backtracking(arena.pointer.pathMatrix,
+					len(rawSeqA), len(rawSeqB),
+					&(arena.pointer.path))
+				isLeftAlign = path[0] < 0
+				score = scoreO
+			}
+		}
+
 	}
 
 	return isLeftAlign, score, path, fastCount, over, fastScore
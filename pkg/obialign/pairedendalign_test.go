@@ -0,0 +1,35 @@
+package obialign
+
+import "testing"
+
+func TestPEUnalignedEnds(t *testing.T) {
+	tests := []struct {
+		path  []int
+		ends  [4]int
+		left  bool
+		usual bool
+	}{
+		{[]int{-50, 100, 50, 0}, [4]int{50, 0, 0, 50}, true, true},
+		{[]int{50, 100, -50, 0}, [4]int{0, 50, 50, 0}, false, true},
+		{[]int{0, 150}, [4]int{0, 0, 0, 0}, true, true},
+		{[]int{-30, 100, -20, 0}, [4]int{30, 0, 20, 0}, true, false},
+		{[]int{20, 60, -2, 40, 30, 0}, [4]int{0, 20, 0, 30}, false, false},
+
+		// Trimmed ends prepended or appended by the FAST heuristic to the
+		// end gaps of the other read.
+		{[]int{-10, 0, 4, 100, 5, 0}, [4]int{10, 4, 0, 5}, true, false},
+		{[]int{12, 0, -3, 100, -6, 0, 8, 0}, [4]int{3, 12, 6, 8}, false, false},
+	}
+
+	for _, test := range tests {
+		startA, startB, endA, endB := PEUnalignedEnds(test.path)
+		if ends := [4]int{startA, startB, endA, endB}; ends != test.ends {
+			t.Errorf("path %v: unaligned ends are %v, expected %v", test.path, ends, test.ends)
+		}
+
+		if usual := _PeUsualGeometry(test.path, test.left); usual != test.usual {
+			t.Errorf("path %v (left: %v): usual geometry is %v, expected %v",
+				test.path, test.left, usual, test.usual)
+		}
+	}
+}
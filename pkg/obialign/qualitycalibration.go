@@ -0,0 +1,426 @@
+package obialign
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+// _CalibrationMaxQuality is the highest Phred quality considered by the
+// calibration, higher qualities are merged with it.
+const _CalibrationMaxQuality = 93
+
+// _CalibrationPriorWeight is the number of aligned positions given to the
+// reported error rate of a quality when its calibrated error rate is
+// estimated. Qualities rarely observed keep their reported error rate.
+const _CalibrationPriorWeight = 100.0
+
+// _CalibrationIterations is the number of rounds of the fit of the error
+// rates.
+const _CalibrationIterations = 50
+
+// QualityCalibration learns from the overlaps of paired reads the actual
+// error rate associated to each Phred quality.
+//
+// For every aligned position of a high confidence overlap, the qualities of
+// both the nucleotides are recorded with whether they mismatch. An error
+// replacing a nucleotide by any of the three other ones with the same
+// probability, two nucleotides of error rates eA and eB mismatch with the
+// probability:
+//
+//	m(eA, eB) = eA + eB - 4/3 * eA * eB
+//
+// The error rate of each quality is fitted on the observed mismatch table
+// with this model. The model assumes independent errors, the mismatch
+// rate observed for a pair of qualities can differ from its prediction.
+// The consensus therefore uses the error rates of each pair of qualities,
+// rescaled so that the model reproduces the observed mismatch rate of the
+// pair.
+type QualityCalibration struct {
+	mutex        sync.Mutex
+	pairs        int
+	observations [_CalibrationMaxQuality + 1][_CalibrationMaxQuality + 1]int
+	mismatches   [_CalibrationMaxQuality + 1][_CalibrationMaxQuality + 1]int
+	errors       [_CalibrationMaxQuality + 1]float64
+	qualities    [_CalibrationMaxQuality + 1]byte
+	pairErrors   [_CalibrationMaxQuality + 1][_CalibrationMaxQuality + 1][2]float64
+}
+
+// CalibratedQuality describes the calibration of a Phred quality.
+type CalibratedQuality struct {
+	Quality           int     `json:"quality" yaml:"quality"`
+	Observations      int     `json:"observations" yaml:"observations"`
+	ReportedError     float64 `json:"reported_error" yaml:"reported_error"`
+	ObservedError     float64 `json:"observed_error" yaml:"observed_error"`
+	CalibratedQuality int     `json:"calibrated_quality" yaml:"calibrated_quality"`
+}
+
+// QualityPairRate describes the mismatches observed between the
+// nucleotides of a pair of qualities. The expected rate is predicted by the
+// mismatch model from the fitted error rates of both qualities, the
+// reported rate from their Phred error rates.
+type QualityPairRate struct {
+	QualityA     int     `json:"quality_a" yaml:"quality_a"`
+	QualityB     int     `json:"quality_b" yaml:"quality_b"`
+	Observations int     `json:"observations" yaml:"observations"`
+	Mismatches   int     `json:"mismatches" yaml:"mismatches"`
+	ObservedRate float64 `json:"observed_rate" yaml:"observed_rate"`
+	ExpectedRate float64 `json:"expected_rate" yaml:"expected_rate"`
+	ReportedRate float64 `json:"reported_rate" yaml:"reported_rate"`
+}
+
+// QualityCalibrationReport compares the observed and the reported error
+// rates. It is also the format used to save a calibration.
+type QualityCalibrationReport struct {
+	Pairs      int                 `json:"pairs" yaml:"pairs"`
+	Positions  int                 `json:"positions" yaml:"positions"`
+	Mismatches int                 `json:"mismatches" yaml:"mismatches"`
+	Qualities  []CalibratedQuality `json:"qualities" yaml:"qualities"`
+	Table      []QualityPairRate   `json:"table" yaml:"table"`
+}
+
+// NewQualityCalibration creates an empty calibration. Until some
+// observations are recorded and fitted, the qualities are unchanged.
+func NewQualityCalibration() *QualityCalibration {
+	calibration := &QualityCalibration{}
+
+	for q := range calibration.qualities {
+		calibration.errors[q] = _PhredError(q)
+		calibration.qualities[q] = byte(q)
+	}
+
+	for a := range calibration.pairErrors {
+		for b := range calibration.pairErrors[a] {
+			calibration.pairErrors[a][b] = [2]float64{_PhredError(a), _PhredError(b)}
+		}
+	}
+
+	return calibration
+}
+
+// _PhredError returns the error probability associated to a Phred quality.
+func _PhredError(quality int) float64 {
+	return math.Pow(10, -float64(quality)/10)
+}
+
+// _MismatchRate returns the probability that two nucleotides of error rates
+// eA and eB mismatch.
+func _MismatchRate(eA, eB float64) float64 {
+	return eA + eB - 4.0/3.0*eA*eB
+}
+
+// _PairScale returns the factor s such that the mismatch rate of two
+// nucleotides of error rates s * eA and s * eB is rate. It is the smallest
+// root of 4/3 eA eB s^2 - (eA + eB) s + rate = 0.
+func _PairScale(eA, eB, rate float64) float64 {
+	sum, product := eA+eB, 4.0/3.0*eA*eB
+
+	if product < 1e-12 {
+		return rate / sum
+	}
+
+	// Beyond the largest reachable rate, the scale of that rate is used
+	delta := max(sum*sum-4*product*rate, 0)
+
+	return (sum - math.Sqrt(delta)) / (2 * product)
+}
+
+// _CalibrationQuality restricts a quality to the range of the calibration.
+func _CalibrationQuality(quality byte) int {
+	return min(int(quality), _CalibrationMaxQuality)
+}
+
+// Record adds to the calibration the overlap of a pair of aligned reads if
+// it is long and similar enough to be trusted.
+//
+// Parameters:
+//   - seqA, seqB: The aligned reads, seqB being reverse complemented.
+//   - path: The alignment path as returned by PEAlign.
+//   - minOverlap: The minimum length of the overlap.
+//   - minIdentity: The minimum identity of the overlap.
+//   - arenaAlign: The arena used to build the alignment.
+//
+// Returns:
+//   - true if the overlap has been recorded.
+func (calibration *QualityCalibration) Record(seqA, seqB *obiseq.BioSequence, path []int,
+	minOverlap int, minIdentity float64,
+	arenaAlign PEAlignArena) bool {
+
+	if !seqA.HasQualities() || !seqB.HasQualities() || len(path) == 0 {
+		return false
+	}
+
+	bufferSA := arenaAlign.pointer.aligneSeqA
+	bufferSB := arenaAlign.pointer.aligneSeqB
+	bufferQA := arenaAlign.pointer.aligneQualA
+	bufferQB := arenaAlign.pointer.aligneQualB
+
+	_BuildAlignment(seqA.Sequence(), seqB.Sequence(), path, ' ',
+		bufferSA, bufferSB)
+	_BuildAlignment(seqA.Qualities(), seqB.Qualities(), path, byte(0),
+		bufferQA, bufferQB)
+
+	left := obiutils.Abs(path[0])
+	right := 0
+	if path[len(path)-1] == 0 {
+		right = path[len(path)-2]
+	}
+	right = len(*bufferQA) - obiutils.Abs(right)
+
+	if right-left < minOverlap {
+		return false
+	}
+
+	match := 0
+	for i := left; i < right; i++ {
+		if (*bufferSA)[i] == (*bufferSB)[i] {
+			match++
+		}
+	}
+
+	if float64(match)/float64(right-left) < minIdentity {
+		return false
+	}
+
+	calibration.mutex.Lock()
+	defer calibration.mutex.Unlock()
+
+	calibration.pairs++
+	for i := left; i < right; i++ {
+		nA := (*bufferSA)[i] | 32
+		nB := (*bufferSB)[i] | 32
+
+		if !_IsCalibrationBase(nA) || !_IsCalibrationBase(nB) {
+			continue
+		}
+
+		qA := _CalibrationQuality((*bufferQA)[i])
+		qB := _CalibrationQuality((*bufferQB)[i])
+
+		calibration.observations[qA][qB]++
+		if nA != nB {
+			calibration.mismatches[qA][qB]++
+		}
+	}
+
+	return true
+}
+
+// _IsCalibrationBase tests if a nucleotide is unambiguous.
+func _IsCalibrationBase(nuc byte) bool {
+	return nuc == 'a' || nuc == 'c' || nuc == 'g' || nuc == 't'
+}
+
+// Pairs returns the number of overlaps recorded in the calibration.
+func (calibration *QualityCalibration) Pairs() int {
+	calibration.mutex.Lock()
+	defer calibration.mutex.Unlock()
+
+	return calibration.pairs
+}
+
+// Fit estimates the error rate of each quality from the recorded
+// mismatches, and the calibrated quality associated to each reported one.
+//
+// The mismatch rate of two qualities a and b is modeled as
+// m(e(a), e(b)), which is linear in e(a) when e(b) is fixed. The error rates
+// are estimated by alternatively fitting each of them with the others
+// fixed. The reported error rate of a quality is used as a prior worth
+// _CalibrationPriorWeight positions.
+//
+// The error rates of each pair of qualities are then rescaled to match the
+// mismatch rate observed for the pair, the rate predicted by the model
+// being used as a prior worth _CalibrationPriorWeight positions.
+func (calibration *QualityCalibration) Fit() {
+	calibration.mutex.Lock()
+	defer calibration.mutex.Unlock()
+
+	const size = _CalibrationMaxQuality + 1
+	var observations, mismatches [size][size]float64
+	var errors [size]float64
+
+	for a := 0; a < size; a++ {
+		errors[a] = _PhredError(a)
+		for b := 0; b < size; b++ {
+			observations[a][b] = float64(calibration.observations[a][b] + calibration.observations[b][a])
+			mismatches[a][b] = float64(calibration.mismatches[a][b] + calibration.mismatches[b][a])
+		}
+	}
+
+	minError := _PhredError(_CalibrationMaxQuality)
+
+	for iteration := 0; iteration < _CalibrationIterations; iteration++ {
+		for a := 0; a < size; a++ {
+			excess := _CalibrationPriorWeight * _PhredError(a)
+			total := _CalibrationPriorWeight
+
+			for b := 0; b < size; b++ {
+				if observations[a][b] > 0 {
+					excess += mismatches[a][b] - observations[a][b]*errors[b]
+					total += observations[a][b] * (1 - 4.0/3.0*errors[b])
+				}
+			}
+
+			errors[a] = min(max(excess/total, minError), 0.75)
+		}
+	}
+
+	for q := 0; q < size; q++ {
+		calibration.errors[q] = errors[q]
+		calibration.qualities[q] = byte(min(
+			math.Round(-10*math.Log10(errors[q])),
+			_CalibrationMaxQuality))
+	}
+
+	for a := 0; a < size; a++ {
+		for b := 0; b < size; b++ {
+			expected := _MismatchRate(errors[a], errors[b])
+			n := float64(calibration.observations[a][b])
+			m := float64(calibration.mismatches[a][b])
+			rate := (m + _CalibrationPriorWeight*expected) / (n + _CalibrationPriorWeight)
+
+			scale := _PairScale(errors[a], errors[b], rate)
+			calibration.pairErrors[a][b] = [2]float64{
+				min(max(scale*errors[a], minError), 0.75),
+				min(max(scale*errors[b], minError), 0.75),
+			}
+		}
+	}
+}
+
+// Quality returns the calibrated quality corresponding to a reported one.
+func (calibration *QualityCalibration) Quality(quality byte) byte {
+	return calibration.qualities[_CalibrationQuality(quality)]
+}
+
+// PairErrors returns the error rates of two aligned nucleotides according
+// to the mismatch rate observed for their pair of reported qualities.
+//
+// Parameters:
+//   - qA: The reported quality of the nucleotide of the first read.
+//   - qB: The reported quality of the nucleotide of the second read.
+//
+// Returns:
+//   - The error rate of the nucleotide of the first read.
+//   - The error rate of the nucleotide of the second read.
+func (calibration *QualityCalibration) PairErrors(qA, qB byte) (float64, float64) {
+	errors := calibration.pairErrors[_CalibrationQuality(qA)][_CalibrationQuality(qB)]
+	return errors[0], errors[1]
+}
+
+// Report compares the observed and the reported error rates of the
+// qualities, and of the pairs of qualities recorded by the calibration.
+func (calibration *QualityCalibration) Report() QualityCalibrationReport {
+	calibration.mutex.Lock()
+	defer calibration.mutex.Unlock()
+
+	report := QualityCalibrationReport{
+		Pairs:     calibration.pairs,
+		Qualities: make([]CalibratedQuality, 0),
+		Table:     make([]QualityPairRate, 0),
+	}
+
+	var counts [_CalibrationMaxQuality + 1]int
+
+	for a, row := range calibration.observations {
+		for b, n := range row {
+			if n == 0 {
+				continue
+			}
+
+			m := calibration.mismatches[a][b]
+			report.Positions += n
+			report.Mismatches += m
+			counts[a] += n
+			counts[b] += n
+
+			report.Table = append(report.Table, QualityPairRate{
+				QualityA:     a,
+				QualityB:     b,
+				Observations: n,
+				Mismatches:   m,
+				ObservedRate: float64(m) / float64(n),
+				ExpectedRate: _MismatchRate(calibration.errors[a], calibration.errors[b]),
+				ReportedRate: _MismatchRate(_PhredError(a), _PhredError(b)),
+			})
+		}
+	}
+
+	for q, n := range counts {
+		if n == 0 {
+			continue
+		}
+
+		report.Qualities = append(report.Qualities, CalibratedQuality{
+			Quality:           q,
+			Observations:      n,
+			ReportedError:     _PhredError(q),
+			ObservedError:     calibration.errors[q],
+			CalibratedQuality: int(calibration.qualities[q]),
+		})
+	}
+
+	return report
+}
+
+// Save writes the calibration to a file in JSON format. The file can be
+// read back by LoadQualityCalibration.
+//
+// Parameters:
+//   - filename: The name of the file.
+//
+// Returns:
+//   - An error if the file cannot be written.
+func (calibration *QualityCalibration) Save(filename string) error {
+	output, err := json.MarshalIndent(calibration.Report(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, append(output, '\n'), 0o644)
+}
+
+// LoadQualityCalibration reads a calibration saved by the Save method and
+// fits it.
+//
+// Parameters:
+//   - filename: The name of the file.
+//
+// Returns:
+//   - The calibration.
+//   - An error if the file cannot be read or is not a valid calibration.
+func LoadQualityCalibration(filename string) (*QualityCalibration, error) {
+	input, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var report QualityCalibrationReport
+	if err := json.Unmarshal(input, &report); err != nil {
+		return nil, fmt.Errorf("%s is not a quality calibration: %v", filename, err)
+	}
+
+	calibration := NewQualityCalibration()
+	calibration.pairs = report.Pairs
+
+	for _, rate := range report.Table {
+		if rate.QualityA < 0 || rate.QualityA > _CalibrationMaxQuality ||
+			rate.QualityB < 0 || rate.QualityB > _CalibrationMaxQuality ||
+			rate.Mismatches > rate.Observations {
+			return nil, fmt.Errorf("%s: invalid calibration entry (%d,%d)",
+				filename, rate.QualityA, rate.QualityB)
+		}
+
+		calibration.observations[rate.QualityA][rate.QualityB] += rate.Observations
+		calibration.mismatches[rate.QualityA][rate.QualityB] += rate.Mismatches
+	}
+
+	calibration.Fit()
+
+	return calibration, nil
+}
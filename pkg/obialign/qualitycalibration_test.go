@@ -0,0 +1,134 @@
+package obialign
+
+import (
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// fillCalibration simulates the aligned positions of overlaps where the
+// actual error rates of the qualities differ from the reported ones.
+func fillCalibration(rng *rand.Rand, calibration *QualityCalibration, actual map[int]float64, n int) {
+	qualities := make([]int, 0, len(actual))
+	for q := range actual {
+		qualities = append(qualities, q)
+	}
+
+	for i := 0; i < n; i++ {
+		qA := qualities[rng.Intn(len(qualities))]
+		qB := qualities[rng.Intn(len(qualities))]
+		errA := rng.Float64() < actual[qA]
+		errB := rng.Float64() < actual[qB]
+
+		calibration.observations[qA][qB]++
+		if errA != errB || (errA && rng.Intn(3) > 0) {
+			calibration.mismatches[qA][qB]++
+		}
+	}
+}
+
+func TestQualityCalibrationFit(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	actual := map[int]float64{12: 0.1, 23: 0.02, 37: 0.005}
+
+	calibration := NewQualityCalibration()
+	fillCalibration(rng, calibration, actual, 2000000)
+	calibration.Fit()
+
+	for q, e := range actual {
+		expected := byte(math.Round(-10 * math.Log10(e)))
+		got := calibration.Quality(byte(q))
+		if got+1 < expected || got > expected+1 {
+			t.Errorf("quality %d is calibrated to %d, expected %d", q, got, expected)
+		}
+	}
+
+	if got := calibration.Quality(40); got != 40 {
+		t.Errorf("unobserved quality 40 is calibrated to %d", got)
+	}
+}
+
+func TestQualityCalibrationSaveLoad(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+
+	calibration := NewQualityCalibration()
+	fillCalibration(rng, calibration, map[int]float64{2: 0.4, 14: 0.05, 30: 0.003}, 200000)
+	calibration.Fit()
+
+	filename := filepath.Join(t.TempDir(), "calibration.json")
+	if err := calibration.Save(filename); err != nil {
+		t.Fatalf("cannot save the calibration: %v", err)
+	}
+
+	loaded, err := LoadQualityCalibration(filename)
+	if err != nil {
+		t.Fatalf("cannot load the calibration: %v", err)
+	}
+
+	for q := 0; q <= _CalibrationMaxQuality; q++ {
+		if calibration.Quality(byte(q)) != loaded.Quality(byte(q)) {
+			t.Errorf("quality %d is calibrated to %d, %d once loaded",
+				q, calibration.Quality(byte(q)), loaded.Quality(byte(q)))
+		}
+	}
+}
+
+// The mismatch rates predicted by the fitted model must be the observed
+// ones when the errors follow the model.
+func TestQualityCalibrationReportModel(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+
+	calibration := NewQualityCalibration()
+	fillCalibration(rng, calibration, map[int]float64{8: 0.2, 20: 0.03, 33: 0.004}, 2000000)
+	calibration.Fit()
+
+	for _, rate := range calibration.Report().Table {
+		tolerance := 4*math.Sqrt(rate.ExpectedRate/float64(rate.Observations)) + 0.05*rate.ExpectedRate
+		if math.Abs(rate.ObservedRate-rate.ExpectedRate) > tolerance {
+			t.Errorf("qualities (%d,%d): observed rate %f, expected %f",
+				rate.QualityA, rate.QualityB, rate.ObservedRate, rate.ExpectedRate)
+		}
+	}
+}
+
+// A pair of qualities mismatching more than predicted by the error rates
+// of its qualities must lower the quality of the consensus.
+func TestQualityCalibrationPairTable(t *testing.T) {
+	rng := rand.New(rand.NewSource(9))
+	actual := map[int]float64{20: 0.01, 30: 0.001}
+
+	calibration := NewQualityCalibration()
+	fillCalibration(rng, calibration, actual, 2000000)
+	calibration.Fit()
+
+	_, matchQ := _CalibratedConsensus('a', 'a', 30, 30, calibration)
+	_, otherQ := _CalibratedConsensus('a', 'a', 20, 30, calibration)
+
+	// The pair (30,30) mismatches five times more than predicted
+	eA, eB := calibration.PairErrors(30, 30)
+	n := 200000
+	calibration.observations[30][30] += n
+	calibration.mismatches[30][30] += int(5 * _MismatchRate(eA, eB) * float64(n))
+	calibration.Fit()
+
+	eA, eB = calibration.PairErrors(30, 30)
+	observed := float64(calibration.mismatches[30][30]) / float64(calibration.observations[30][30])
+	if rate := _MismatchRate(eA, eB); math.Abs(rate-observed) > 0.1*observed {
+		t.Errorf("pair (30,30) error rates predict a mismatch rate of %f, %f observed", rate, observed)
+	}
+
+	// The excess of mismatches also raises a little the error rate of the
+	// quality 30, but it is mainly charged to the pair (30,30)
+	_, q := _CalibratedConsensus('a', 'a', 30, 30, calibration)
+	_, other := _CalibratedConsensus('a', 'a', 20, 30, calibration)
+	if q >= matchQ-5 || int(matchQ)-int(q) < 2*(int(otherQ)-int(other)) {
+		t.Errorf("consensus qualities of the pairs (30,30) and (20,30) are %d and %d, %d and %d before the excess of mismatches",
+			q, other, matchQ, otherQ)
+	}
+
+	// On a mismatch the most reliable nucleotide is kept
+	if nuc, _ := _CalibratedConsensus('a', 'c', 20, 30, calibration); nuc != 'c' {
+		t.Errorf("consensus of a:20 and c:30 is %c", nuc)
+	}
+}
@@ -14,6 +14,10 @@ type __options__ struct {
 	batchSize       int
 	parallelWorkers int
 	noSingleton     bool
+	umi             string
+	umiSample       string
+	umiDistance     int
+	umiMethod       string
 }
 
 type Options struct {
@@ -32,6 +36,10 @@ func MakeOptions(setters []WithOption) Options {
 		batchSize:       obidefault.BatchSize(),
 		parallelWorkers: obidefault.ParallelWorkers(),
 		noSingleton:     false,
+		umi:             "",
+		umiSample:       "sample",
+		umiDistance:     1,
+		umiMethod:       UMIDirectional,
 	}
 
 	opt := Options{&o}
@@ -84,6 +92,24 @@ func (opt Options) NoSingleton() bool {
 	return opt.pointer.noSingleton
 }
 
+// UMI returns the attribute containing the UMIs, or an empty string if
+// the dereplication does not consider UMIs.
+func (opt Options) UMI() string {
+	return opt.pointer.umi
+}
+
+func (opt Options) UMISample() string {
+	return opt.pointer.umiSample
+}
+
+func (opt Options) UMIDistance() int {
+	return opt.pointer.umiDistance
+}
+
+func (opt Options) UMIMethod() string {
+	return opt.pointer.umiMethod
+}
+
 func OptionSortOnDisk() WithOption {
 	f := WithOption(func(opt Options) {
 		opt.pointer.cacheOnDisk = true
@@ -165,3 +191,43 @@ func OptionsWithSingleton() WithOption {
 
 	return f
 }
+
+// OptionUMI makes the dereplication count molecules instead of reads,
+// using the UMIs stored in the given attribute.
+func OptionUMI(key string) WithOption {
+	f := WithOption(func(opt Options) {
+		opt.pointer.umi = key
+	})
+
+	return f
+}
+
+// OptionUMISample sets the attribute identifying the samples, the UMIs
+// being grouped separately in each sample.
+func OptionUMISample(key string) WithOption {
+	f := WithOption(func(opt Options) {
+		opt.pointer.umiSample = key
+	})
+
+	return f
+}
+
+// OptionUMIDistance sets the maximum number of differences between two
+// UMIs resulting from the same molecule.
+func OptionUMIDistance(distance int) WithOption {
+	f := WithOption(func(opt Options) {
+		opt.pointer.umiDistance = distance
+	})
+
+	return f
+}
+
+// OptionUMIMethod sets the method used to group the UMIs, one of
+// UMIExact, UMINetwork or UMIDirectional.
+func OptionUMIMethod(method string) WithOption {
+	f := WithOption(func(opt Options) {
+		opt.pointer.umiMethod = method
+	})
+
+	return f
+}
@@ -0,0 +1,209 @@
+package obichunk
+
+import (
+	"cmp"
+	"slices"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// Methods used to group the UMIs resulting from the same molecule.
+const (
+	// UMIExact considers each distinct UMI as a molecule.
+	UMIExact = "exact"
+	// UMINetwork groups the UMIs connected by a chain of UMIs differing by
+	// at most the allowed distance.
+	UMINetwork = "network"
+	// UMIDirectional only connects a UMI to a less abundant one, observed
+	// at most half as many times plus one, as expected for the errors
+	// occurring during the PCR or the sequencing of the first one.
+	UMIDirectional = "directional"
+)
+
+// umiDistance returns the Hamming distance between two UMIs, or -1 when
+// they do not have the same length.
+func umiDistance(a, b string) int {
+	if len(a) != len(b) {
+		return -1
+	}
+
+	d := 0
+	for i := 0; i < len(a); i++ {
+		if a[i] != b[i] {
+			d++
+		}
+	}
+
+	return d
+}
+
+// umiNeighbourhoods returns the keys indexing a UMI by its Hamming
+// neighbourhood: the UMI with every combination of masked positions. Two
+// UMIs of the same length at a distance at most masked share at least one
+// key, the one masking the positions where they differ.
+func umiNeighbourhoods(umi string, masked int) []string {
+	masked = min(masked, len(umi))
+	keys := make([]string, 0)
+	key := []byte(umi)
+
+	var mask func(from, left int)
+	mask = func(from, left int) {
+		if left == 0 {
+			keys = append(keys, string(key))
+			return
+		}
+
+		for i := from; i <= len(key)-left; i++ {
+			key[i] = '.'
+			mask(i+1, left-1)
+			key[i] = umi[i]
+		}
+	}
+
+	mask(0, masked)
+
+	return keys
+}
+
+// ClusterUMIs groups the UMIs resulting from the same molecule. The UMIs
+// are considered by decreasing abundance, and each cluster is grown from
+// its most abundant UMI by connecting it to the UMIs at a Hamming distance
+// at most distance, following the rule of the method. The candidates are
+// looked for among the UMIs sharing a Hamming neighbourhood key, rather
+// than among all the UMIs.
+//
+// Parameters:
+//   - counts: The number of reads of each UMI.
+//   - distance: The maximum number of differences between two connected UMIs.
+//   - method: One of UMIExact, UMINetwork or UMIDirectional.
+//
+// Returns:
+//   - A map associating each UMI with the most abundant UMI of its cluster.
+func ClusterUMIs(counts map[string]int, distance int, method string) map[string]string {
+	umis := make([]string, 0, len(counts))
+	for umi := range counts {
+		umis = append(umis, umi)
+	}
+
+	slices.SortFunc(umis, func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), cmp.Compare(a, b))
+	})
+
+	clusters := make(map[string]string, len(umis))
+
+	if method == UMIExact || distance < 1 {
+		for _, umi := range umis {
+			clusters[umi] = umi
+		}
+
+		return clusters
+	}
+
+	// The UMIs sharing each key, by decreasing abundance
+	index := make(map[string][]string)
+	for _, umi := range umis {
+		for _, key := range umiNeighbourhoods(umi, distance) {
+			index[key] = append(index[key], umi)
+		}
+	}
+
+	connected := func(a, b string) bool {
+		d := umiDistance(a, b)
+		if d < 0 || d > distance {
+			return false
+		}
+
+		return method != UMIDirectional || counts[a] >= 2*counts[b]-1
+	}
+
+	for _, seed := range umis {
+		if _, ok := clusters[seed]; ok {
+			continue
+		}
+
+		clusters[seed] = seed
+		queue := []string{seed}
+
+		for len(queue) > 0 {
+			umi := queue[0]
+			queue = queue[1:]
+
+			for _, key := range umiNeighbourhoods(umi, distance) {
+				for _, other := range index[key] {
+					if _, ok := clusters[other]; !ok && connected(umi, other) {
+						clusters[other] = seed
+						queue = append(queue, other)
+					}
+				}
+			}
+		}
+	}
+
+	return clusters
+}
+
+// collapseUMIs merges a group of identical sequences into a single one
+// whose count is the number of molecules, rather than the number of reads.
+// The reads of each sample are grouped by UMI with ClusterUMIs, and each
+// cluster is counted as one molecule. The number of reads is stored in the
+// umi_reads attribute.
+func collapseUMIs(sequences obiseq.BioSequenceSlice, opts Options) *obiseq.BioSequence {
+	type molecule struct {
+		sample string
+		umi    string
+	}
+
+	reads := 0
+	counts := make(map[string]map[string]int)
+	representatives := make(map[molecule]*obiseq.BioSequence)
+
+	for _, sequence := range sequences {
+		sample := opts.NAValue()
+		if value, ok := sequence.GetStringAttribute(opts.UMISample()); ok {
+			sample = value
+		}
+
+		umi := opts.NAValue()
+		if value, ok := sequence.GetStringAttribute(opts.UMI()); ok {
+			umi = value
+		}
+
+		if counts[sample] == nil {
+			counts[sample] = make(map[string]int)
+		}
+
+		counts[sample][umi] += sequence.Count()
+		reads += sequence.Count()
+
+		key := molecule{sample, umi}
+		if best, ok := representatives[key]; !ok || sequence.Count() > best.Count() {
+			representatives[key] = sequence
+		}
+	}
+
+	molecules := obiseq.MakeBioSequenceSlice(0)
+
+	for sample, umis := range counts {
+		for umi, cluster := range ClusterUMIs(umis, opts.UMIDistance(), opts.UMIMethod()) {
+			if umi != cluster {
+				continue
+			}
+
+			sequence := representatives[molecule{sample, umi}]
+			for key := range opts.StatsOn() {
+				sequence.DeleteAttribute(obiseq.StatsOnSlotName(key))
+			}
+			sequence.SetCount(1)
+			molecules = append(molecules, sequence)
+		}
+	}
+
+	slices.SortFunc(molecules, func(a, b *obiseq.BioSequence) int {
+		return cmp.Compare(a.Id(), b.Id())
+	})
+
+	merged := molecules.Merge(opts.NAValue(), opts.StatsOn())
+	merged.SetAttribute("umi_reads", reads)
+
+	return merged
+}
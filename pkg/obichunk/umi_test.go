@@ -0,0 +1,264 @@
+package obichunk
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"slices"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// scalarClusterUMIs is ClusterUMIs comparing every pair of UMIs.
+func scalarClusterUMIs(counts map[string]int, distance int, method string) map[string]string {
+	umis := make([]string, 0, len(counts))
+	for umi := range counts {
+		umis = append(umis, umi)
+	}
+
+	slices.SortFunc(umis, func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), cmp.Compare(a, b))
+	})
+
+	connected := func(a, b string) bool {
+		d := umiDistance(a, b)
+		return method != UMIExact && d >= 0 && d <= distance &&
+			(method != UMIDirectional || counts[a] >= 2*counts[b]-1)
+	}
+
+	clusters := make(map[string]string, len(umis))
+	for _, seed := range umis {
+		if _, ok := clusters[seed]; ok {
+			continue
+		}
+
+		clusters[seed] = seed
+		queue := []string{seed}
+
+		for len(queue) > 0 {
+			umi := queue[0]
+			queue = queue[1:]
+
+			for _, other := range umis {
+				if _, ok := clusters[other]; !ok && connected(umi, other) {
+					clusters[other] = seed
+					queue = append(queue, other)
+				}
+			}
+		}
+	}
+
+	return clusters
+}
+
+func TestUMINeighbourhoods(t *testing.T) {
+	tests := []struct {
+		umi    string
+		masked int
+		keys   []string
+	}{
+		{"acgt", 0, []string{"acgt"}},
+		{"acgt", 1, []string{".cgt", "a.gt", "ac.t", "acg."}},
+		{"acg", 2, []string{"..g", ".c.", "a.."}},
+		{"ac", 3, []string{".."}},
+	}
+
+	for _, test := range tests {
+		if keys := umiNeighbourhoods(test.umi, test.masked); !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("neighbourhoods of %s at distance %d are %v, expected %v",
+				test.umi, test.masked, keys, test.keys)
+		}
+	}
+}
+
+func TestClusterUMIs(t *testing.T) {
+	counts := map[string]int{
+		"aaaa": 10,
+		"aaat": 4,
+		"aatt": 3,
+		"attt": 1,
+		"aaag": 10,
+		"cccc": 1,
+		"aaa":  5,
+	}
+
+	tests := []struct {
+		method   string
+		distance int
+		clusters map[string]string
+	}{
+		{UMIExact, 1, map[string]string{
+			"aaaa": "aaaa", "aaat": "aaat", "aatt": "aatt", "attt": "attt",
+			"aaag": "aaag", "cccc": "cccc", "aaa": "aaa"}},
+		{UMINetwork, 1, map[string]string{
+			"aaaa": "aaaa", "aaat": "aaaa", "aatt": "aaaa", "attt": "aaaa",
+			"aaag": "aaaa", "cccc": "cccc", "aaa": "aaa"}},
+		{UMINetwork, 0, map[string]string{
+			"aaaa": "aaaa", "aaat": "aaat", "aatt": "aatt", "attt": "attt",
+			"aaag": "aaag", "cccc": "cccc", "aaa": "aaa"}},
+		{UMIDirectional, 1, map[string]string{
+			"aaaa": "aaaa", "aaat": "aaaa", "aatt": "aatt", "attt": "aatt",
+			"aaag": "aaag", "cccc": "cccc", "aaa": "aaa"}},
+		{UMIDirectional, 2, map[string]string{
+			"aaaa": "aaaa", "aaat": "aaaa", "aatt": "aaaa", "attt": "aaaa",
+			"aaag": "aaag", "cccc": "cccc", "aaa": "aaa"}},
+	}
+
+	for _, test := range tests {
+		if clusters := ClusterUMIs(counts, test.distance, test.method); !reflect.DeepEqual(clusters, test.clusters) {
+			t.Errorf("%s clustering at distance %d gives %v, expected %v",
+				test.method, test.distance, clusters, test.clusters)
+		}
+	}
+}
+
+// The neighbourhood index finds the same clusters as the comparison of
+// every pair of UMIs.
+func TestClusterUMIsIndex(t *testing.T) {
+	rng := rand.New(rand.NewSource(44))
+
+	molecules := make([]string, 30)
+	for i := range molecules {
+		umi := make([]byte, 8)
+		for j := range umi {
+			umi[j] = "acgt"[rng.Intn(4)]
+		}
+		molecules[i] = string(umi)
+	}
+
+	// Reads of the molecules with sequencing errors
+	counts := make(map[string]int)
+	for i := 0; i < 2000; i++ {
+		umi := []byte(molecules[rng.Intn(len(molecules))])
+		for rng.Intn(3) == 0 {
+			umi[rng.Intn(len(umi))] = "acgt"[rng.Intn(4)]
+		}
+		counts[string(umi)]++
+	}
+
+	for _, method := range []string{UMIExact, UMINetwork, UMIDirectional} {
+		for distance := 1; distance <= 2; distance++ {
+			clusters := ClusterUMIs(counts, distance, method)
+			expected := scalarClusterUMIs(counts, distance, method)
+
+			if !reflect.DeepEqual(clusters, expected) {
+				t.Errorf("%s clustering at distance %d differs from the comparison of all the UMIs",
+					method, distance)
+			}
+		}
+	}
+}
+
+// umiReads builds the reads of a sequence, a read being described by its
+// sample, its UMI and its count.
+func umiReads(id, sequence string, reads ...[3]string) obiseq.BioSequenceSlice {
+	slice := obiseq.MakeBioSequenceSlice(0)
+
+	for i, read := range reads {
+		seq := obiseq.NewBioSequence(fmt.Sprintf("%s_%d", id, i), []byte(sequence), "")
+		seq.SetAttribute("sample", read[0])
+		seq.SetAttribute("umi", read[1])
+
+		var count int
+		fmt.Sscan(read[2], &count)
+		seq.SetCount(count)
+
+		slice = append(slice, seq)
+	}
+
+	return slice
+}
+
+func TestCollapseUMIs(t *testing.T) {
+	reads := umiReads("seq", "acgtacgt",
+		[3]string{"s1", "aaaa", "10"},
+		[3]string{"s1", "aaat", "2"},
+		[3]string{"s1", "cccc", "3"},
+		[3]string{"s2", "aaat", "4"},
+		[3]string{"s2", "aaaa", "1"},
+	)
+
+	tests := []struct {
+		method    string
+		molecules int
+	}{
+		{UMIExact, 5},
+		{UMINetwork, 3},
+		{UMIDirectional, 3},
+	}
+
+	for _, test := range tests {
+		sequences := make(obiseq.BioSequenceSlice, len(reads))
+		for i, read := range reads {
+			sequences[i] = read.Copy()
+		}
+
+		opts := MakeOptions([]WithOption{
+			OptionUMI("umi"),
+			OptionUMIMethod(test.method),
+			OptionUMIDistance(1),
+		})
+
+		merged := collapseUMIs(sequences, opts)
+
+		if merged.Count() != test.molecules {
+			t.Errorf("%s: %d molecules counted, expected %d", test.method, merged.Count(), test.molecules)
+		}
+
+		if n, _ := merged.GetIntAttribute("umi_reads"); n != 20 {
+			t.Errorf("%s: %d reads counted, expected 20", test.method, n)
+		}
+	}
+}
+
+func TestIUniqueSequenceUMI(t *testing.T) {
+	reads := umiReads("first", "acgtacgtac",
+		[3]string{"s1", "aaaa", "1"},
+		[3]string{"s1", "aaaa", "5"},
+		[3]string{"s1", "aaac", "1"},
+		[3]string{"s1", "gggg", "2"},
+		[3]string{"s2", "aaaa", "1"},
+	)
+	reads = append(reads, umiReads("second", "ttgcattgca",
+		[3]string{"s1", "aaaa", "1"},
+		[3]string{"s1", "aaaa", "1"},
+		[3]string{"s2", "tttt", "3"},
+	)...)
+
+	iterator, err := IUniqueSequence(obiiter.IBatchOver("test", reads, 2),
+		OptionUMI("umi"),
+		OptionsParallelWorkers(2),
+		OptionBatchCount(4),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, uniques := iterator.Load()
+
+	expected := map[string][2]int{
+		"acgtacgtac": {3, 10},
+		"ttgcattgca": {2, 5},
+	}
+
+	if len(uniques) != len(expected) {
+		t.Fatalf("%d unique sequences, expected %d", len(uniques), len(expected))
+	}
+
+	for _, unique := range uniques {
+		counts, ok := expected[unique.String()]
+		if !ok {
+			t.Errorf("unexpected sequence %s", unique.String())
+			continue
+		}
+
+		n, _ := unique.GetIntAttribute("umi_reads")
+		if unique.Count() != counts[0] || n != counts[1] {
+			t.Errorf("%s counts %d molecules and %d reads, expected %d and %d",
+				unique.String(), unique.Count(), n, counts[0], counts[1])
+		}
+	}
+}
@@ -44,9 +44,24 @@ func IUniqueSequence(iterator obiiter.IBioSequence,
 		uniqueClassifier = obiseq.SequenceClassifier()
 	}
 
+	// In UMI mode, the reads are only pre-merged on disk when they share
+	// the same sample and UMI, to keep the information needed to count
+	// the molecules.
+	derepClassifier := uniqueClassifier
+	if opts.UMI() != "" {
+		cls := []*obiseq.BioSequenceClassifier{obiseq.SequenceClassifier()}
+		for _, c := range cat {
+			cls = append(cls, obiseq.AnnotationClassifier(c, na))
+		}
+		cls = append(cls,
+			obiseq.AnnotationClassifier(opts.UMISample(), na),
+			obiseq.AnnotationClassifier(opts.UMI(), na))
+		derepClassifier = obiseq.CompositeClassifier(cls...)
+	}
+
 	if opts.SortOnDisk() {
 		nworkers = 1
-		iterator, err = ISequenceChunkOnDisk(iterator, bucketClassifier, true, na, opts.StatsOn(), uniqueClassifier)
+		iterator, err = ISequenceChunkOnDisk(iterator, bucketClassifier, true, na, opts.StatsOn(), derepClassifier)
 
 		if err != nil {
 			return obiiter.NilIBioSequence, err
@@ -89,6 +104,10 @@ func IUniqueSequence(iterator obiiter.IBioSequence,
 		for input.Next() {
 			batch := input.Get()
 			if !(opts.NoSingleton() && len(batch.Slice()) == 1 && batch.Slice()[0].Count() == 1) {
+				if opts.UMI() != "" {
+					batch = obiiter.MakeBioSequenceBatch(batch.Source(), batch.Order(),
+						obiseq.BioSequenceSlice{collapseUMIs(batch.Slice(), opts)})
+				}
 				iUnique.Push(batch.Reorder(nextOrder()))
 			}
 		}
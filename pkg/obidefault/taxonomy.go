@@ -1,6 +1,8 @@
 package obidefault
 
-var __taxonomy__ = ""
+import "strings"
+
+var __taxonomy__ = make([]string, 0)
 var __alternative_name__ = false
 var __fail_on_taxonomy__ = false
 var __update_taxid__ = false
@@ -14,19 +16,31 @@ func UseRawTaxidsPtr() *bool {
 	return &__raw_taxid__
 }
 
+// SelectedTaxonomy returns the path of the main taxonomy, the first one
+// provided by the user.
 func SelectedTaxonomy() string {
+	if len(__taxonomy__) == 0 {
+		return ""
+	}
+	return __taxonomy__[0]
+}
+
+// SelectedTaxonomies returns every taxonomy path provided by the user.
+// The first one is the main taxonomy, the following ones are extensions
+// grafted onto it.
+func SelectedTaxonomies() []string {
 	return __taxonomy__
 }
 
 func HasSelectedTaxonomy() bool {
-	return __taxonomy__ != ""
+	return len(__taxonomy__) > 0
 }
 
 func AreAlternativeNamesSelected() bool {
 	return __alternative_name__
 }
 
-func SelectedTaxonomyPtr() *string {
+func SelectedTaxonomyPtr() *[]string {
 	return &__taxonomy__
 }
 
@@ -34,7 +48,7 @@ func AlternativeNamesSelectedPtr() *bool {
 	return &__alternative_name__
 }
 
-func SetSelectedTaxonomy(taxonomy string) {
+func SetSelectedTaxonomy(taxonomy ...string) {
 	__taxonomy__ = taxonomy
 }
 
@@ -65,3 +79,39 @@ func FailOnTaxonomy() bool {
 func UpdateTaxid() bool {
 	return __update_taxid__
 }
+
+var __normalize_ranks__ = false
+var __canonical_ranks__ = ""
+
+// NormalizeRanks returns true if taxonomic lineages have to be normalised
+// onto the canonical rank list.
+func NormalizeRanks() bool {
+	return __normalize_ranks__ || __canonical_ranks__ != ""
+}
+
+func NormalizeRanksPtr() *bool {
+	return &__normalize_ranks__
+}
+
+// CanonicalRanks returns the canonical rank list provided by the user as a
+// comma separated list, or nil to use the default list.
+func CanonicalRanks() []string {
+	if __canonical_ranks__ == "" {
+		return nil
+	}
+
+	ranks := strings.Split(__canonical_ranks__, ",")
+	for i, r := range ranks {
+		ranks[i] = strings.TrimSpace(r)
+	}
+
+	return ranks
+}
+
+func CanonicalRanksPtr() *string {
+	return &__canonical_ranks__
+}
+
+func SetNormalizeRanks(normalize bool) {
+	__normalize_ranks__ = normalize
+}
@@ -3,6 +3,7 @@ package obiformats
 import (
 	"encoding/csv"
 	"errors"
+	"fmt"
 	"strings"
 
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
@@ -118,3 +119,112 @@ func LoadCSVTaxonomy(path string, onlysn, seqAsTaxa bool) (*obitax.Taxonomy, err
 
 	return taxonomy, nil
 }
+
+// GraftCSVTaxonomy loads a taxonomy extension from a CSV file and grafts its
+// taxa onto an existing taxonomy. The file must contain the taxid, parent,
+// rank (or taxonomic_rank) and name (or scientific_name) columns. Every taxid
+// must be prefixed by a code different from the code of the main taxonomy
+// (e.g. "motu:A12"); parents can refer either to the main taxonomy or to other
+// taxa of the extension.
+//
+// Parameters:
+//   - taxonomy: A pointer to the taxonomy to be extended.
+//   - path: The path of the CSV extension file.
+//
+// Returns:
+//   - An error if the file cannot be read or if it is not consistent with the taxonomy.
+func GraftCSVTaxonomy(taxonomy *obitax.Taxonomy, path string) error {
+
+	log.Infof("Grafting taxonomy extension from csv file: %s", path)
+
+	file, err := obiutils.Ropen(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	csvfile := csv.NewReader(file)
+
+	csvfile.Comma = ','
+	csvfile.ReuseRecord = false
+	csvfile.LazyQuotes = true
+	csvfile.Comment = '#'
+	csvfile.FieldsPerRecord = -1
+	csvfile.TrimLeadingSpace = true
+
+	header, err := csvfile.Read()
+
+	if err != nil {
+		return err
+	}
+
+	taxidColIndex := -1
+	parentColIndex := -1
+	nameColIndex := -1
+	rankColIndex := -1
+
+	for i, colName := range header {
+		switch colName {
+		case "taxid":
+			taxidColIndex = i
+		case "parent":
+			parentColIndex = i
+		case "name", "scientific_name":
+			nameColIndex = i
+		case "rank", "taxonomic_rank":
+			rankColIndex = i
+		}
+	}
+
+	if taxidColIndex == -1 {
+		return fmt.Errorf("taxonomy extension %s does not contain taxid column", path)
+	}
+
+	if parentColIndex == -1 {
+		return fmt.Errorf("taxonomy extension %s does not contain parent column", path)
+	}
+
+	if nameColIndex == -1 {
+		return fmt.Errorf("taxonomy extension %s does not contain name column", path)
+	}
+
+	if rankColIndex == -1 {
+		return fmt.Errorf("taxonomy extension %s does not contain rank column", path)
+	}
+
+	grafted := make([]*obitax.Taxon, 0, 100)
+
+	for line, err := csvfile.Read(); err == nil; line, err = csvfile.Read() {
+		taxid := strings.TrimSpace(line[taxidColIndex])
+		parent := strings.TrimSpace(line[parentColIndex])
+		name := strings.TrimSpace(line[nameColIndex])
+		rank := strings.TrimSpace(line[rankColIndex])
+
+		code, id := obiutils.LeftSplitInTwo(taxid, ':')
+
+		if id == "" || code == taxonomy.Code() {
+			return fmt.Errorf("taxid %s of extension %s must use a code distinct from %s",
+				taxid, path, taxonomy.Code())
+		}
+
+		taxon, err := taxonomy.GraftTaxon(code, taxid, parent, rank, name)
+
+		if err != nil {
+			return fmt.Errorf("cannot graft taxon %s: %v", taxid, err)
+		}
+
+		grafted = append(grafted, taxon)
+	}
+
+	for _, taxon := range grafted {
+		if err := taxonomy.CheckLineage(taxon); err != nil {
+			return fmt.Errorf("taxonomy extension %s: %v", path, err)
+		}
+	}
+
+	log.Infof("%d taxa grafted from %s", len(grafted), path)
+
+	return nil
+}
@@ -43,7 +43,7 @@ func loadNodeTable(reader io.Reader, taxonomy *obitax.Taxonomy) {
 		parent := strings.TrimSpace(record[1])
 		rank := strings.TrimSpace(record[2])
 
-		_, err := taxonomy.AddTaxon(taxid, parent, rank, taxid == "1", false)
+		_, err := taxonomy.AddTaxon(taxid, parent, rank, taxid == parent, false)
 
 		if err != nil {
 			log.Fatalf("Error adding taxon %s: %v\n", taxid, err)
@@ -203,12 +203,9 @@ func LoadNCBITaxDump(directory string, onlysn, seqAsTaxa bool) (*obitax.Taxonomy
 	n = loadMergedTable(buffered, taxonomy)
 	log.Printf("%d merged taxa read\n", n)
 
-	root, _, err := taxonomy.Taxon("1")
-
-	if err != nil {
-		log.Fatal("cannot find the root taxon (1) in the NCBI tax dump")
+	if !taxonomy.HasRoot() {
+		log.Fatal("cannot find the root taxon in the NCBI tax dump")
 	}
-	taxonomy.SetRoot(root)
 
 	return taxonomy, nil
 }
@@ -135,13 +135,9 @@ func LoadNCBITarTaxDump(path string, onlysn, seqAsTaxa bool) (*obitax.Taxonomy,
 	n = loadMergedTable(buffered, taxonomy)
 	log.Printf("%d merged taxa read\n", n)
 
-	root, _, err := taxonomy.Taxon("1")
-
-	if err != nil {
-		log.Fatal("cannot find the root taxon (1) in the NCBI tax dump")
+	if !taxonomy.HasRoot() {
+		log.Fatal("cannot find the root taxon in the NCBI tax dump")
 	}
 
-	taxonomy.SetRoot(root)
-
 	return taxonomy, nil
 }
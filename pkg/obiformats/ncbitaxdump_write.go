@@ -0,0 +1,151 @@
+package obiformats
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
+)
+
+// closeTaxonSubset builds the set of taxa needed to describe the taxa provided
+// by the iterator as a self-contained taxonomy: every taxon of the iterator
+// plus all its ancestors up to the root of the taxonomy.
+func closeTaxonSubset(iterator *obitax.ITaxon) (*obitax.TaxonSet, error) {
+	var taxonomy *obitax.Taxonomy
+	var taxa *obitax.TaxonSet
+
+	for iterator.Next() {
+		taxon := iterator.Get()
+
+		if taxonomy == nil {
+			taxonomy = taxon.Taxonomy
+			taxa = taxonomy.NewTaxonSet()
+		}
+
+		if taxon.Taxonomy != taxonomy {
+			return nil, fmt.Errorf("taxdump writer cannot deal with multi-taxonomy iterator")
+		}
+
+		for t := range taxon.IPath() {
+			if taxa.Contains(t.Node.Id()) {
+				break
+			}
+
+			if t.IsExtension() || t.IsPlaceholder() {
+				return nil, fmt.Errorf("taxon %s cannot be written in NCBI taxdump format", t.String())
+			}
+
+			taxa.InsertTaxon(t)
+		}
+	}
+
+	return taxa, nil
+}
+
+// WriteNCBITaxDump writes the taxa provided by the iterator as a NCBI taxdump
+// directory, containing the nodes.dmp, names.dmp and merged.dmp files. The
+// written taxonomy is completed with every ancestor of the provided taxa, so
+// that it can be loaded by LoadNCBITaxDump. The merged.dmp file contains the
+// aliases of the source taxonomy pointing to one of the written taxa.
+//
+// Parameters:
+//   - iterator: An iterator over the taxa to be written.
+//   - directory: The path of the taxdump directory, created if needed.
+//
+// Returns:
+//   - An error if the subset cannot be built or if a file cannot be written.
+func WriteNCBITaxDump(iterator *obitax.ITaxon, directory string) error {
+	taxa, err := closeTaxonSubset(iterator)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return fmt.Errorf("cannot create taxdump directory %s: %v", directory, err)
+	}
+
+	nodes, err := os.Create(path.Join(directory, "nodes.dmp"))
+	if err != nil {
+		return err
+	}
+	defer nodes.Close()
+
+	names, err := os.Create(path.Join(directory, "names.dmp"))
+	if err != nil {
+		return err
+	}
+	defer names.Close()
+
+	merged, err := os.Create(path.Join(directory, "merged.dmp"))
+	if err != nil {
+		return err
+	}
+	defer merged.Close()
+
+	wnodes := bufio.NewWriter(nodes)
+	wnames := bufio.NewWriter(names)
+	wmerged := bufio.NewWriter(merged)
+
+	n := 0
+	if taxa != nil {
+		sorted := taxa.Sort()
+
+		for i := 0; i < sorted.Len(); i++ {
+			taxon := sorted.Taxon(i)
+			n++
+			id := *taxon.Node.Id()
+			parent := *taxon.Node.ParentId()
+
+			// Only the three first columns are meaningful for obitools,
+			// the other ones are set to neutral values to keep the NCBI layout.
+			fmt.Fprintf(wnodes, "%s\t|\t%s\t|\t%s\t|\t\t|\t0\t|\t0\t|\t1\t|\t0\t|\t0\t|\t0\t|\t0\t|\t0\t|\t\t|\n",
+				id, parent, taxon.Rank())
+
+			if taxon.HasScientificName() {
+				fmt.Fprintf(wnames, "%s\t|\t%s\t|\t\t|\tscientific name\t|\n",
+					id, taxon.ScientificName())
+			}
+
+			alternatives := taxon.AlternativeNames()
+			classes := make([]string, 0, len(alternatives))
+			for class := range alternatives {
+				classes = append(classes, class)
+			}
+			slices.Sort(classes)
+
+			for _, class := range classes {
+				fmt.Fprintf(wnames, "%s\t|\t%s\t|\t\t|\t%s\t|\n",
+					id, alternatives[class], class)
+			}
+		}
+
+		aliases := taxa.Taxonomy().AsTaxonSet().Aliases()
+		oldids := make([]string, 0, len(aliases))
+		for oldid, taxon := range aliases {
+			if taxa.Contains(taxon.Node.Id()) {
+				oldids = append(oldids, oldid)
+			}
+		}
+		slices.Sort(oldids)
+
+		for _, oldid := range oldids {
+			fmt.Fprintf(wmerged, "%s\t|\t%s\t|\n", oldid, *aliases[oldid].Node.Id())
+		}
+	}
+
+	for _, w := range []*bufio.Writer{wnodes, wnames, wmerged} {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("%d taxa written to taxdump %s", n, directory)
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package obiformats
+
+import (
+	"maps"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+func buildTaxdumpTestTaxonomy(t *testing.T) *obitax.Taxonomy {
+	taxonomy := obitax.NewTaxonomy("NCBI Taxonomy", "taxon", obiutils.AsciiDigitSet)
+
+	nodes := [][4]string{
+		{"1", "1", "no rank", "root"},
+		{"2759", "1", "superkingdom", "Eukaryota"},
+		{"33208", "2759", "kingdom", "Metazoa"},
+		{"7711", "33208", "phylum", "Chordata"},
+		{"9604", "7711", "family", "Hominidae"},
+		{"9605", "9604", "genus", "Homo"},
+		{"9606", "9605", "species", "Homo sapiens"},
+		{"33090", "2759", "kingdom", "Viridiplantae"},
+		{"3398", "33090", "class", "Magnoliopsida"},
+		{"4479", "3398", "family", "Poaceae"},
+		{"2", "1", "superkingdom", "Bacteria"},
+	}
+
+	if err := taxonomy.AddNodes(nodes); err != nil {
+		t.Fatal(err)
+	}
+
+	human, _, _ := taxonomy.Taxon("9606")
+	human.SetName("human", "genbank common name")
+	human.SetName("Homo sapiens Linnaeus, 1758", "authority")
+
+	if _, err := taxonomy.AddAlias("63221", "9606", false); err != nil {
+		t.Fatalf("cannot add alias: %v", err)
+	}
+	if _, err := taxonomy.AddAlias("4480", "4479", false); err != nil {
+		t.Fatalf("cannot add alias: %v", err)
+	}
+
+	return taxonomy
+}
+
+func TestWriteNCBITaxDumpRoundTrip(t *testing.T) {
+	taxonomy := buildTaxdumpTestTaxonomy(t)
+	directory := t.TempDir()
+
+	poaceae, _, _ := taxonomy.Taxon("4479")
+	subset := taxonomy.ISubTaxonomy("33208").Concat(
+		taxonomy.NewTaxonSlice(0, 1).Push(poaceae).Iterator(),
+	)
+
+	if err := WriteNCBITaxDump(subset, directory); err != nil {
+		t.Fatalf("WriteNCBITaxDump failed: %v", err)
+	}
+
+	loaded, err := LoadNCBITaxDump(directory, false, false)
+	if err != nil {
+		t.Fatalf("LoadNCBITaxDump failed: %v", err)
+	}
+
+	expected := []string{"1", "2759", "33208", "7711", "9604", "9605", "9606",
+		"33090", "3398", "4479"}
+
+	if loaded.Len() != len(expected) {
+		t.Errorf("expected %d taxa, got %d", len(expected), loaded.Len())
+	}
+
+	for _, taxid := range expected {
+		original, _, _ := taxonomy.Taxon(taxid)
+		copy, isAlias, err := loaded.Taxon(taxid)
+
+		if err != nil || isAlias {
+			t.Errorf("taxon %s missing from the reloaded taxonomy: %v", taxid, err)
+			continue
+		}
+
+		if copy.String() != original.String() {
+			t.Errorf("taxon %s: expected %s, got %s", taxid, original.String(), copy.String())
+		}
+
+		if copy.Parent().String() != original.Parent().String() {
+			t.Errorf("taxon %s: parent differs (%s vs %s)",
+				taxid, original.Parent().String(), copy.Parent().String())
+		}
+
+		if !maps.Equal(copy.AlternativeNames(), original.AlternativeNames()) {
+			t.Errorf("taxon %s: alternative names differ (%v vs %v)",
+				taxid, original.AlternativeNames(), copy.AlternativeNames())
+		}
+	}
+
+	if _, _, err := loaded.Taxon("2"); err == nil {
+		t.Errorf("taxon 2 should not be part of the subset")
+	}
+
+	for alias, target := range map[string]string{"63221": "9606", "4480": "4479"} {
+		taxon, isAlias, err := loaded.Taxon(alias)
+		if err != nil || !isAlias || *taxon.Node.Id() != target {
+			t.Errorf("alias %s -> %s not preserved", alias, target)
+		}
+	}
+
+	if *loaded.Root().Node.Id() != "1" {
+		t.Errorf("root of the reloaded taxonomy should be taxon 1")
+	}
+}
@@ -0,0 +1,233 @@
+package obiformats
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obingslibrary"
+)
+
+// NGSFilterProblem describes a problem found in an NGSFilter file.
+type NGSFilterProblem struct {
+	// Line is the line of the file where the problem occurs, 0 when it
+	// concerns the whole file.
+	Line int
+	// Warning is true when the problem does not prevent the use of the file.
+	Warning bool
+	// Message describes the problem.
+	Message string
+}
+
+func (problem NGSFilterProblem) String() string {
+	level := "error"
+	if problem.Warning {
+		level = "warning"
+	}
+
+	if problem.Line == 0 {
+		return fmt.Sprintf("%s: %s", level, problem.Message)
+	}
+
+	return fmt.Sprintf("line %d: %s: %s", problem.Line, level, problem.Message)
+}
+
+// _invalidSymbols returns the distinct symbols of a sequence absent from
+// the allowed ones.
+func _invalidSymbols(sequence, allowed string) string {
+	invalid := make([]rune, 0)
+	for _, symbol := range sequence {
+		if !strings.ContainsRune(allowed, symbol) && !slices.Contains(invalid, symbol) {
+			invalid = append(invalid, symbol)
+		}
+	}
+
+	return string(invalid)
+}
+
+// _checkPrimer checks that a primer is only made of IUPAC nucleotide codes.
+func _checkPrimer(primer string) error {
+	if primer == "" {
+		return fmt.Errorf("empty primer")
+	}
+
+	if bad := _invalidSymbols(primer, "acgtrymkswbdhvn"); bad != "" {
+		return fmt.Errorf("primer %s contains invalid IUPAC codes: %s", primer, bad)
+	}
+
+	return nil
+}
+
+// _checkTag checks that a tag is only made of a, c, g and t.
+func _checkTag(tag string) error {
+	if bad := _invalidSymbols(tag, "acgt"); bad != "" {
+		return fmt.Errorf("tag %s contains invalid nucleotides: %s", tag, bad)
+	}
+
+	return nil
+}
+
+// _markerTag is a tag of a marker with the line where it is first used.
+type _markerTag struct {
+	tag  string
+	line int
+}
+
+// _sortedMarkerTags returns the tags sorted by the line where they are
+// first used.
+func _sortedMarkerTags(lines map[string]int) []_markerTag {
+	tags := make([]_markerTag, 0, len(lines))
+	for tag, line := range lines {
+		tags = append(tags, _markerTag{tag, line})
+	}
+
+	slices.SortFunc(tags, func(a, b _markerTag) int {
+		return cmp.Or(cmp.Compare(a.line, b.line), cmp.Compare(a.tag, b.tag))
+	})
+
+	return tags
+}
+
+// _checkMarkerTags checks the tags used on one side of a marker: their
+// nucleotides, their length when they are not delimited, and their pairwise
+// distances when they are matched with errors.
+//
+// A read tag is assigned to the closest tag of the marker, which is only
+// reliable if that tag is closer to it than any other tag. Tags matched
+// with the hamming distance must therefore be at a distance of at least 3
+// to correct a substitution. With the indel matching, each of the allowed
+// tag indels must also be corrected, and tags must be at a distance of at
+// least 2 * max(1, indels) + 1.
+func _checkMarkerTags(tags []_markerTag,
+	side string,
+	delimiter byte,
+	matching string,
+	indels int,
+	report func(line int, warning bool, err error)) {
+
+	lengths := make(map[int]int)
+	for _, tag := range tags {
+		if err := _checkTag(tag.tag); err != nil {
+			report(tag.line, false, err)
+		}
+		lengths[len(tag.tag)]++
+	}
+
+	if len(lengths) > 1 && delimiter == 0 {
+		expected := 0
+		for length, count := range lengths {
+			if count > lengths[expected] || (count == lengths[expected] && length > expected) {
+				expected = length
+			}
+		}
+
+		for _, tag := range tags {
+			if len(tag.tag) != expected {
+				report(tag.line, false,
+					fmt.Errorf("%s tag %s has %d nucleotides, other tags have %d and no tag delimiter is declared",
+						side, tag.tag, len(tag.tag), expected))
+			}
+		}
+	}
+
+	var distance func(string, string) int
+	corrected := 1
+	switch matching {
+	case "hamming":
+		distance = obingslibrary.Hamming
+	case "indel":
+		distance = obingslibrary.Levenshtein
+		corrected = max(corrected, indels)
+	default:
+		return
+	}
+
+	mindist := 2*corrected + 1
+
+	for i, a := range tags {
+		for _, b := range tags[i+1:] {
+			if d := distance(a.tag, b.tag); d < mindist {
+				report(b.line, true,
+					fmt.Errorf("%s tags %s and %s (line %d) are at distance %d, "+
+						"%s matching corrects %d error(s) only for tags at distance %d or more",
+						side, b.tag, a.tag, a.line, d, matching, corrected, mindist))
+			}
+		}
+	}
+}
+
+// CheckCSVNGSFilter looks for the problems of a CSV NGSFilter file without
+// stopping at the first one. Besides the problems detected when reading the
+// file with ReadCSVNGSFilter, it checks the IUPAC codes of the primers, the
+// nucleotides and the lengths of the tags, and the tags of a marker too
+// close to each other to be told apart when they are matched with errors.
+//
+// Parameters:
+//   - reader: an io.Reader providing the CSV input.
+//
+// Returns:
+//   - The problems sorted by line.
+func CheckCSVNGSFilter(reader io.Reader) []NGSFilterProblem {
+	problems := make([]NGSFilterProblem, 0)
+
+	report := func(line int, warning bool, err error) {
+		problems = append(problems, NGSFilterProblem{
+			Line:    line,
+			Warning: warning,
+			Message: err.Error(),
+		})
+	}
+
+	ngsfilter, lines := _readCSVNGSFilter(reader, report)
+
+	if ngsfilter != nil {
+		for _, library := range ngsfilter.Libraries() {
+			for primers, marker := range library.Markers {
+				forwards := make(map[string]int)
+				reverses := make(map[string]int)
+				first := 0
+
+				for tags, pcr := range marker.Samples() {
+					line := lines[pcr]
+					if first == 0 || line < first {
+						first = line
+					}
+
+					for _, tag := range []struct {
+						tag  string
+						tags map[string]int
+					}{{tags.Forward, forwards}, {tags.Reverse, reverses}} {
+						if l, ok := tag.tags[tag.tag]; tag.tag != "" && (!ok || line < l) {
+							tag.tags[tag.tag] = line
+						}
+					}
+				}
+
+				for _, primer := range []string{primers.Forward, primers.Reverse} {
+					if err := _checkPrimer(primer); err != nil {
+						report(first, false, err)
+					}
+				}
+
+				// Undelimited tags of unexpected length are reported as
+				// errors by _checkMarkerTags
+				if err := marker.CheckTagLength(); err != nil {
+					report(first, true, err)
+				}
+
+				_checkMarkerTags(_sortedMarkerTags(forwards), "forward", marker.Forward_tag_delimiter,
+					marker.Forward_matching, marker.Forward_tag_indels, report)
+				_checkMarkerTags(_sortedMarkerTags(reverses), "reverse", marker.Reverse_tag_delimiter,
+					marker.Reverse_matching, marker.Reverse_tag_indels, report)
+			}
+		}
+	}
+
+	slices.SortStableFunc(problems, func(a, b NGSFilterProblem) int {
+		return cmp.Compare(a.Line, b.Line)
+	})
+
+	return problems
+}
@@ -0,0 +1,87 @@
+package obiformats
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckCSVNGSFilter(t *testing.T) {
+	content := `@param,matching,hamming
+@param,spacer,x
+experiment,sample,sample_tag,forward_primer,reverse_primer
+# a comment line
+exp,s1,aacaagct:acgatcag,TTAGATACCCCACTATGC,TAGAACAGGCTCCTCTAG
+exp,s2,aacaagct:acgatcag,TTAGATACCCCACTATGC,TAGAACAGGCTCCTCTAG
+exp,s3,aacaagcg:ctatgcg,TTAGATACCCCACTATGC,TAGAACAGGCTCCTCTAG
+exp,s4,gtcgtaga:ctatgcgt,TTAGATACCCCACTATGC
+exp,s5,acgtacgt,GGATCCJJ,TTTGGGAAA
+`
+
+	problems := CheckCSVNGSFilter(strings.NewReader(content))
+
+	expected := []struct {
+		line    int
+		warning bool
+		message string
+	}{
+		{2, false, "invalid value for @spacer parameter"},
+		{5, true, "reverse tag length"},
+		{6, false, "used more than once"},
+		{7, true, "forward tags aacaagcg and aacaagct (line 5) are at distance 1"},
+		{7, false, "reverse tag ctatgcg has 7 nucleotides"},
+		{8, false, "row has 4 columns"},
+		{9, false, "primer ggatccjj contains invalid IUPAC codes: j"},
+	}
+
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %d: %v", len(expected), len(problems), problems)
+	}
+
+	for i, e := range expected {
+		p := problems[i]
+		if p.Line != e.line || p.Warning != e.warning || !strings.Contains(p.Message, e.message) {
+			t.Errorf("problem %d: expected line %d (warning %v) %q, got %s", i, e.line, e.warning, e.message, p)
+		}
+	}
+
+	valid := `experiment,sample,sample_tag,forward_primer,reverse_primer
+exp,s1,aacaagct:acgatcag,TTAGATACCCCACTATGC,TAGAACAGGCTCCTCTAG
+exp,s2,gtcgtaga:ctatgcgt,TTAGATACCCCACTATGC,TAGAACAGGCTCCTCTAG
+`
+
+	if problems := CheckCSVNGSFilter(strings.NewReader(valid)); len(problems) != 0 {
+		t.Errorf("expected no problem, got %v", problems)
+	}
+
+	// Tags at distance 2 are too close for the hamming matching, and
+	// delimited tags of different lengths are reported as a warning.
+	close := `@param,matching,hamming
+@param,reverse_tag_delimiter,c
+experiment,sample,sample_tag,forward_primer,reverse_primer
+exp,s1,aacaagct:acgatcag,TTAGATACCCCACTATGC,TAGAACAGGCTCCTCTAG
+exp,s2,aacaagga:ctatgcgat,TTAGATACCCCACTATGC,TAGAACAGGCTCCTCTAG
+exp,s3,gtcgtaga:gtagcatg,TTAGATACCCCACTATGC,TAGAACAGGCTCCTCTAG
+`
+
+	problems = CheckCSVNGSFilter(strings.NewReader(close))
+	if len(problems) != 2 ||
+		!strings.Contains(problems[0].String(), "line 4: warning: reverse tag length") ||
+		!strings.Contains(problems[1].String(), "line 5: warning: forward tags aacaagga and aacaagct (line 4) are at distance 2") {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+
+	// The indel matching must correct each allowed tag indel
+	indels := `@param,matching,indel
+@param,tag_indels,2
+experiment,sample,sample_tag,forward_primer,reverse_primer
+exp,s1,aacaagct:acgatcag,TTAGATACCCCACTATGC,TAGAACAGGCTCCTCTAG
+exp,s2,gtcaagct:ctatgcgt,TTAGATACCCCACTATGC,TAGAACAGGCTCCTCTAG
+`
+
+	problems = CheckCSVNGSFilter(strings.NewReader(indels))
+	if len(problems) != 1 ||
+		!strings.Contains(problems[0].String(), "line 5: warning: forward tags gtcaagct and aacaagct (line 4) are at distance 2, "+
+			"indel matching corrects 2 error(s) only for tags at distance 5 or more") {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+}
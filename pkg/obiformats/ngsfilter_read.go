@@ -230,16 +230,45 @@ func ReadOldNGSFilter(reader io.Reader) (*obingslibrary.NGSLibrary, error) {
 	return &ngsfilter, nil
 }
 
-var library_parameter = map[string]func(library *obingslibrary.NGSLibrary, values ...string){
-	"spacer": func(library *obingslibrary.NGSLibrary, values ...string) {
+// _parseUMIParameter parses the values of the UMI parameters: the distance
+// between the UMI and the primer, and the UMI pattern.
+func _parseUMIParameter(param, offset, pattern string) (int, string, error) {
+	distance, err := strconv.Atoi(strings.TrimSpace(offset))
+
+	if err != nil || distance < 0 {
+		return 0, "", fmt.Errorf("invalid value for @%s parameter: %s is not a valid distance", param, offset)
+	}
+
+	pattern, err = obingslibrary.CheckUMIPattern(pattern)
+
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid value for @%s parameter: %v", param, err)
+	}
+
+	return distance, pattern, nil
+}
+
+// _parseTagDelimiter parses the value of the tag delimiter parameters.
+func _parseTagDelimiter(value string) (byte, error) {
+	value = strings.TrimSpace(value)
+
+	if len(value) != 1 {
+		return 0, fmt.Errorf("invalid tag delimiter %q: a single character is expected", value)
+	}
+
+	return value[0], nil
+}
+
+var library_parameter = map[string]func(library *obingslibrary.NGSLibrary, values ...string) error{
+	"spacer": func(library *obingslibrary.NGSLibrary, values ...string) error {
 		switch len(values) {
 		case 0:
-			log.Fatalln("Missing value for @spacer parameter")
+			return fmt.Errorf("missing value for @spacer parameter")
 		case 1:
 			spacer, err := strconv.Atoi(values[0])
 
 			if err != nil {
-				log.Fatalln("Invalid value for @spacer parameter")
+				return fmt.Errorf("invalid value for @spacer parameter")
 			}
 
 			log.Infof("Set global spacer to %d bp", spacer)
@@ -249,115 +278,200 @@ var library_parameter = map[string]func(library *obingslibrary.NGSLibrary, value
 			spacer, err := strconv.Atoi(values[1])
 
 			if err != nil {
-				log.Fatalln("Invalid value for @spacer parameter")
+				return fmt.Errorf("invalid value for @spacer parameter")
 			}
 
 			log.Infof("Set spacer for primer %s to %d bp", primer, spacer)
 			library.SetTagSpacerFor(primer, spacer)
 		default:
-			log.Fatalln("Invalid value for @spacer parameter")
+			return fmt.Errorf("invalid value for @spacer parameter")
 		}
+
+		return nil
 	},
-	"forward_spacer": func(library *obingslibrary.NGSLibrary, values ...string) {
+	"forward_spacer": func(library *obingslibrary.NGSLibrary, values ...string) error {
 		switch len(values) {
 		case 0:
-			log.Fatalln("Missing value for @forward_spacer parameter")
+			return fmt.Errorf("missing value for @forward_spacer parameter")
 		case 1:
 			spacer, err := strconv.Atoi(values[0])
 
 			if err != nil {
-				log.Fatalln("Invalid value for @forward_spacer parameter")
+				return fmt.Errorf("invalid value for @forward_spacer parameter")
 			}
 
 			log.Infof("Set spacer for forward primer to %d bp", spacer)
 			library.SetForwardTagSpacer(spacer)
 		default:
-			log.Fatalln("Invalid value for @forward_spacer parameter")
+			return fmt.Errorf("invalid value for @forward_spacer parameter")
 		}
+
+		return nil
 	},
-	"reverse_spacer": func(library *obingslibrary.NGSLibrary, values ...string) {
+	"reverse_spacer": func(library *obingslibrary.NGSLibrary, values ...string) error {
 		switch len(values) {
 		case 0:
-			log.Fatalln("Missing value for @reverse_spacer parameter")
+			return fmt.Errorf("missing value for @reverse_spacer parameter")
 		case 1:
 			spacer, err := strconv.Atoi(values[0])
 
 			if err != nil {
-				log.Fatalln("Invalid value for @reverse_spacer parameter")
+				return fmt.Errorf("invalid value for @reverse_spacer parameter")
 			}
 
 			log.Infof("Set spacer for reverse primer to %d bp", spacer)
 			library.SetReverseTagSpacer(spacer)
 		default:
-			log.Fatalln("Invalid value for @reverse_spacer parameter")
+			return fmt.Errorf("invalid value for @reverse_spacer parameter")
+		}
+
+		return nil
+	},
+	"umi": func(library *obingslibrary.NGSLibrary, values ...string) error {
+		switch len(values) {
+		case 0, 1:
+			return fmt.Errorf("missing value for @umi parameter")
+		case 2:
+			offset, pattern, err := _parseUMIParameter("umi", values[0], values[1])
+			if err != nil {
+				return err
+			}
+			log.Infof("Set global UMI to %s at %d bp from the primers", pattern, offset)
+			library.SetUMI(offset, pattern)
+		case 3:
+			primer := values[0]
+			offset, pattern, err := _parseUMIParameter("umi", values[1], values[2])
+			if err != nil {
+				return err
+			}
+			log.Infof("Set UMI for primer %s to %s at %d bp", primer, pattern, offset)
+			library.SetUMIFor(primer, offset, pattern)
+		default:
+			return fmt.Errorf("invalid value for @umi parameter")
+		}
+
+		return nil
+	},
+	"forward_umi": func(library *obingslibrary.NGSLibrary, values ...string) error {
+		switch len(values) {
+		case 0, 1:
+			return fmt.Errorf("missing value for @forward_umi parameter")
+		case 2:
+			offset, pattern, err := _parseUMIParameter("forward_umi", values[0], values[1])
+			if err != nil {
+				return err
+			}
+			log.Infof("Set UMI for forward primer to %s at %d bp", pattern, offset)
+			library.SetForwardUMI(offset, pattern)
+		default:
+			return fmt.Errorf("invalid value for @forward_umi parameter")
+		}
+
+		return nil
+	},
+	"reverse_umi": func(library *obingslibrary.NGSLibrary, values ...string) error {
+		switch len(values) {
+		case 0, 1:
+			return fmt.Errorf("missing value for @reverse_umi parameter")
+		case 2:
+			offset, pattern, err := _parseUMIParameter("reverse_umi", values[0], values[1])
+			if err != nil {
+				return err
+			}
+			log.Infof("Set UMI for reverse primer to %s at %d bp", pattern, offset)
+			library.SetReverseUMI(offset, pattern)
+		default:
+			return fmt.Errorf("invalid value for @reverse_umi parameter")
 		}
+
+		return nil
 	},
-	"tag_delimiter": func(library *obingslibrary.NGSLibrary, values ...string) {
+	"tag_delimiter": func(library *obingslibrary.NGSLibrary, values ...string) error {
 		switch len(values) {
 		case 0:
-			log.Fatalln("Missing value for @tag_delimiter parameter")
+			return fmt.Errorf("missing value for @tag_delimiter parameter")
 		case 1:
-			value := []byte(values[0])[0]
+			value, err := _parseTagDelimiter(values[0])
+			if err != nil {
+				return err
+			}
 
 			log.Infof("Set global tag delimiter to %c", value)
 			library.SetTagDelimiter(value)
 		case 2:
-			value := []byte(values[1])[0]
+			value, err := _parseTagDelimiter(values[1])
+			if err != nil {
+				return err
+			}
 
 			log.Infof("Set tag delimiter for primer %s to %c", values[0], value)
 			library.SetTagDelimiterFor(values[0], value)
 		default:
-			log.Fatalln("Invalid value for @tag_delimiter parameter")
+			return fmt.Errorf("invalid value for @tag_delimiter parameter")
 		}
+
+		return nil
 	},
-	"forward_tag_delimiter": func(library *obingslibrary.NGSLibrary, values ...string) {
+	"forward_tag_delimiter": func(library *obingslibrary.NGSLibrary, values ...string) error {
 		switch len(values) {
 		case 0:
-			log.Fatalln("Missing value for @forward_tag_delimiter parameter")
+			return fmt.Errorf("missing value for @forward_tag_delimiter parameter")
 		case 1:
-			value := []byte(values[0])[0]
+			value, err := _parseTagDelimiter(values[0])
+			if err != nil {
+				return err
+			}
 
 			log.Infof("Set tag delimiter for forward primer to %c", value)
 			library.SetForwardTagDelimiter(value)
 		default:
-			log.Fatalln("Invalid value for @forward_tag_delimiter parameter")
+			return fmt.Errorf("invalid value for @forward_tag_delimiter parameter")
 		}
+
+		return nil
 	},
-	"reverse_tag_delimiter": func(library *obingslibrary.NGSLibrary, values ...string) {
+	"reverse_tag_delimiter": func(library *obingslibrary.NGSLibrary, values ...string) error {
 		switch len(values) {
 		case 0:
-			log.Fatalln("Missing value for @reverse_tag_delimiter parameter")
+			return fmt.Errorf("missing value for @reverse_tag_delimiter parameter")
 		case 1:
-			value := []byte(values[0])[0]
+			value, err := _parseTagDelimiter(values[0])
+			if err != nil {
+				return err
+			}
 
 			log.Infof("Set tag delimiter for reverse primer to %c", value)
 			library.SetReverseTagDelimiter(value)
 		default:
-			log.Fatalln("Invalid value for @reverse_tag_delimiter parameter")
+			return fmt.Errorf("invalid value for @reverse_tag_delimiter parameter")
 		}
+
+		return nil
 	},
-	"matching": func(library *obingslibrary.NGSLibrary, values ...string) {
+	"matching": func(library *obingslibrary.NGSLibrary, values ...string) error {
 		switch len(values) {
 		case 0:
-			log.Fatalln("Missing value for @matching parameter")
+			return fmt.Errorf("missing value for @matching parameter")
 		case 1:
 			if err := library.SetMatching(values[0]); err != nil {
-				log.Fatalf("Invalid value %s for @matching parameter", values[0])
+				return fmt.Errorf("invalid value %s for @matching parameter", values[0])
 			}
 			log.Infof("Set tag matching mode to %s", values[0])
 		default:
-			log.Fatalln("Invalid value for @matching parameter")
+			return fmt.Errorf("invalid value for @matching parameter")
 		}
+
+		return nil
 	},
-	"primer_mismatches": func(library *obingslibrary.NGSLibrary, values ...string) {
+	"primer_mismatches": func(library *obingslibrary.NGSLibrary, values ...string) error {
 		switch len(values) {
 		case 0:
-			log.Fatalln("Missing value for @primer_error parameter")
+			return fmt.Errorf("missing value for @primer_error parameter")
 		case 1:
 			dist, err := strconv.Atoi(values[0])
 
 			if err != nil {
-				log.Fatalf("Invalid value %s for @primer_error parameter", values[0])
+				return fmt.Errorf("invalid value %s for @primer_error parameter", values[0])
 			}
 
 			log.Infof("Set global allowed primer mismatches to %d", dist)
@@ -367,58 +481,64 @@ var library_parameter = map[string]func(library *obingslibrary.NGSLibrary, value
 			dist, err := strconv.Atoi(values[1])
 
 			if err != nil {
-				log.Fatalf("Invalid value %s for @primer_error parameter", values[1])
+				return fmt.Errorf("invalid value %s for @primer_error parameter", values[1])
 			}
 
 			log.Infof("Set allowed primer mismatches for primer %s to %d", primer, dist)
 			library.SetAllowedMismatchesFor(primer, dist)
 		default:
-			log.Fatalln("Invalid value for @primer_error parameter")
+			return fmt.Errorf("invalid value for @primer_error parameter")
 		}
+
+		return nil
 	},
-	"forward_mismatches": func(library *obingslibrary.NGSLibrary, values ...string) {
+	"forward_mismatches": func(library *obingslibrary.NGSLibrary, values ...string) error {
 		switch len(values) {
 		case 0:
-			log.Fatalln("Missing value for @forward_primer_error parameter")
+			return fmt.Errorf("missing value for @forward_primer_error parameter")
 		case 1:
 			dist, err := strconv.Atoi(values[0])
 
 			if err != nil {
-				log.Fatalf("Invalid value %s for @forward_primer_error parameter", values[0])
+				return fmt.Errorf("invalid value %s for @forward_primer_error parameter", values[0])
 			}
 
 			log.Infof("Set allowed mismatches for forward primer to %d", dist)
 			library.SetForwardAllowedMismatches(dist)
 		default:
-			log.Fatalln("Invalid value for @forward_primer_error parameter")
+			return fmt.Errorf("invalid value for @forward_primer_error parameter")
 		}
+
+		return nil
 	},
-	"reverse_mismatches": func(library *obingslibrary.NGSLibrary, values ...string) {
+	"reverse_mismatches": func(library *obingslibrary.NGSLibrary, values ...string) error {
 		switch len(values) {
 		case 0:
-			log.Fatalln("Missing value for @reverse_primer_error parameter")
+			return fmt.Errorf("missing value for @reverse_primer_error parameter")
 		case 1:
 			dist, err := strconv.Atoi(values[0])
 
 			if err != nil {
-				log.Fatalf("Invalid value %s for @reverse_primer_error parameter", values[0])
+				return fmt.Errorf("invalid value %s for @reverse_primer_error parameter", values[0])
 			}
 
 			log.Infof("Set allowed mismatches for reverse primer to %d", dist)
 			library.SetReverseAllowedMismatches(dist)
 		default:
-			log.Fatalln("Invalid value for @reverse_primer_error parameter")
+			return fmt.Errorf("invalid value for @reverse_primer_error parameter")
 		}
+
+		return nil
 	},
-	"tag_indels": func(library *obingslibrary.NGSLibrary, values ...string) {
+	"tag_indels": func(library *obingslibrary.NGSLibrary, values ...string) error {
 		switch len(values) {
 		case 0:
-			log.Fatalln("Missing value for @tag_indels parameter")
+			return fmt.Errorf("missing value for @tag_indels parameter")
 		case 1:
 			indels, err := strconv.Atoi(values[0])
 
 			if err != nil {
-				log.Fatalf("Invalid value %s for @tag_indels parameter", values[0])
+				return fmt.Errorf("invalid value %s for @tag_indels parameter", values[0])
 			}
 
 			log.Infof("Set global maximum tag indels to %d", indels)
@@ -427,52 +547,60 @@ var library_parameter = map[string]func(library *obingslibrary.NGSLibrary, value
 			indels, err := strconv.Atoi(values[1])
 
 			if err != nil {
-				log.Fatalf("Invalid value %s for @tag_indels parameter", values[1])
+				return fmt.Errorf("invalid value %s for @tag_indels parameter", values[1])
 			}
 
 			log.Infof("Set maximum tag indels for primer %s to %d", values[0], indels)
 			library.SetTagIndelsFor(values[0], indels)
+		default:
+			return fmt.Errorf("invalid value for @tag_indels parameter")
 		}
+
+		return nil
 	},
 
-	"forward_tag_indels": func(library *obingslibrary.NGSLibrary, values ...string) {
+	"forward_tag_indels": func(library *obingslibrary.NGSLibrary, values ...string) error {
 		switch len(values) {
 		case 0:
-			log.Fatalln("Missing value for @forward_tag_indels parameter")
+			return fmt.Errorf("missing value for @forward_tag_indels parameter")
 		case 1:
 			indels, err := strconv.Atoi(values[0])
 
 			if err != nil {
-				log.Fatalf("Invalid value %s for @forward_tag_indels parameter", values[0])
+				return fmt.Errorf("invalid value %s for @forward_tag_indels parameter", values[0])
 			}
 
 			log.Infof("Set maximum tag indels for forward primer to %d", indels)
 			library.SetForwardTagIndels(indels)
 		default:
-			log.Fatalln("Invalid value for @forward_tag_indels parameter")
+			return fmt.Errorf("invalid value for @forward_tag_indels parameter")
 		}
+
+		return nil
 	},
-	"reverse_tag_indels": func(library *obingslibrary.NGSLibrary, values ...string) {
+	"reverse_tag_indels": func(library *obingslibrary.NGSLibrary, values ...string) error {
 		switch len(values) {
 		case 0:
-			log.Fatalln("Missing value for @reverse_tag_indels parameter")
+			return fmt.Errorf("missing value for @reverse_tag_indels parameter")
 		case 1:
 			indels, err := strconv.Atoi(values[0])
 
 			if err != nil {
-				log.Fatalf("Invalid value %s for @reverse_tag_indels parameter", values[0])
+				return fmt.Errorf("invalid value %s for @reverse_tag_indels parameter", values[0])
 			}
 
 			log.Infof("Set maximum tag indels for reverse primer to %d", indels)
 			library.SetReverseTagIndels(indels)
 		default:
-			log.Fatalln("Invalid value for @reverse_tag_indels parameter")
+			return fmt.Errorf("invalid value for @reverse_tag_indels parameter")
 		}
+
+		return nil
 	},
-	"indels": func(library *obingslibrary.NGSLibrary, values ...string) {
+	"indels": func(library *obingslibrary.NGSLibrary, values ...string) error {
 		switch len(values) {
 		case 0:
-			log.Fatalln("Missing value for @indels parameter")
+			return fmt.Errorf("missing value for @indels parameter")
 		case 1:
 
 			if values[0] == "true" {
@@ -492,14 +620,16 @@ var library_parameter = map[string]func(library *obingslibrary.NGSLibrary, value
 
 			library.SetAllowsIndelsFor(values[0], values[1] == "true")
 		default:
-			log.Fatalln("Invalid value for @indels parameter")
+			return fmt.Errorf("invalid value for @indels parameter")
 		}
+
+		return nil
 	},
 
-	"forward_indels": func(library *obingslibrary.NGSLibrary, values ...string) {
+	"forward_indels": func(library *obingslibrary.NGSLibrary, values ...string) error {
 		switch len(values) {
 		case 0:
-			log.Fatalln("Missing value for @forward_indels parameter")
+			return fmt.Errorf("missing value for @forward_indels parameter")
 		case 1:
 			if values[0] == "true" {
 				log.Info("Allows indels for forward primer matching")
@@ -509,13 +639,15 @@ var library_parameter = map[string]func(library *obingslibrary.NGSLibrary, value
 
 			library.SetForwardAllowsIndels(values[0] == "true")
 		default:
-			log.Fatalln("Invalid value for @forward_indels parameter")
+			return fmt.Errorf("invalid value for @forward_indels parameter")
 		}
+
+		return nil
 	},
-	"reverse_indels": func(library *obingslibrary.NGSLibrary, values ...string) {
+	"reverse_indels": func(library *obingslibrary.NGSLibrary, values ...string) error {
 		switch len(values) {
 		case 0:
-			log.Fatalln("Missing value for @reverse_indels parameter")
+			return fmt.Errorf("missing value for @reverse_indels parameter")
 		case 1:
 			if values[0] == "true" {
 				log.Info("Allows indels for reverse primer matching")
@@ -524,44 +656,103 @@ var library_parameter = map[string]func(library *obingslibrary.NGSLibrary, value
 			}
 			library.SetReverseAllowsIndels(values[0] == "true")
 		default:
-			log.Fatalln("Invalid value for @reverse_indels parameter")
+			return fmt.Errorf("invalid value for @reverse_indels parameter")
 		}
+
+		return nil
 	},
 }
 
 // ReadCSVNGSFilter reads an NGS filter configuration from a CSV file and returns
 // an NGSLibrary. The CSV file must include columns for 'experiment', 'sample',
-// 'sample_tag', 'forward_primer', and 'reverse_primer'. Additional columns are
-// used to annotate PCR samples.
+// 'sample_tag', 'forward_primer', and 'reverse_primer'. An optional 'index'
+// column gives the Illumina index pair (i7+i5, or i7 alone) identifying the
+// sample in addition to its tags. Additional columns are used to annotate
+// PCR samples.
 //
 // Parameters:
 //   - reader: an io.Reader providing the CSV input.
 //
 // Returns:
 //   - A pointer to an NGSLibrary populated with the data from the CSV file.
-//   - An error if the CSV is malformed or required columns are missing.
+//   - An error listing the problems found in the file, like malformed CSV,
+//     missing columns, duplicated tag pairs or invalid parameters.
 //
 // The function processes both data records and parameter lines starting with
 // '@param'. Parameter lines configure various aspects of the library.
 //
 // Each row in the CSV is validated to ensure it has the correct number of columns.
-// Duplicate tag pairs for the same marker result in an error. Primer unicity is
-// checked, and any unknown parameters are logged as warnings.
+// Duplicate tag pairs for the same marker and index pair result in an error.
+// Primer unicity is checked, as the index pairs too close to be distinguished
+// with the number of mismatches set by the @index_mismatches parameter.
+// Unknown parameters and primers used by several markers are logged as
+// warnings.
 func ReadCSVNGSFilter(reader io.Reader) (*obingslibrary.NGSLibrary, error) {
+	problems := make([]error, 0)
+
+	ngsfilter, _ := _readCSVNGSFilter(reader, func(line int, warning bool, err error) {
+		if warning {
+			obilog.Warnf("At line %d: %v", line, err)
+		} else {
+			problems = append(problems, fmt.Errorf("line %d : %v", line, err))
+		}
+	})
+
+	if len(problems) > 0 {
+		return ngsfilter, errors.Join(problems...)
+	}
+
+	return ngsfilter, nil
+}
+
+// _readCSVNGSFilter builds an NGSLibrary from a CSV NGSFilter file. Each
+// problem found is passed to report with the line where it occurs, and the
+// reading goes on as far as possible to report every problem. A nil library
+// is returned when the file cannot be interpreted at all.
+//
+// Parameters:
+//   - reader: an io.Reader providing the CSV input.
+//   - report: the function called on each problem, warning is true for
+//     the problems not preventing the use of the library.
+//
+// Returns:
+//   - The NGSLibrary described by the file.
+//   - The line declaring each PCR.
+func _readCSVNGSFilter(reader io.Reader,
+	report func(line int, warning bool, err error)) (*obingslibrary.NGSLibrary, map[*obingslibrary.PCR]int) {
+
 	ngsfilter := obingslibrary.MakeNGSLibrary()
 	file := csv.NewReader(reader)
 
 	file.Comma = ','
-	file.ReuseRecord = true
 	file.LazyQuotes = true
 	file.Comment = '#'
 	file.FieldsPerRecord = -1
 	file.TrimLeadingSpace = true
 
-	records, err := file.ReadAll()
+	records := make([][]string, 0)
+	lines := make([]int, 0)
 
-	if err != nil {
-		return nil, err
+	for {
+		record, err := file.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			line := 0
+			if perr, ok := err.(*csv.ParseError); ok {
+				line = perr.Line
+				err = perr.Err
+			}
+			report(line, false, err)
+			return nil, nil
+		}
+
+		line, _ := file.FieldPos(0)
+		records = append(records, record)
+		lines = append(lines, line)
 	}
 
 	i := 0
@@ -571,7 +762,14 @@ func ReadCSVNGSFilter(reader io.Reader) (*obingslibrary.NGSLibrary, error) {
 	log.Infof("%d parameters found", i)
 
 	params := records[0:i]
+	paramLines := lines[0:i]
 	records = records[i:]
+	lines = lines[i:]
+
+	if len(records) == 0 {
+		report(0, false, fmt.Errorf("no header line found in the CSV file"))
+		return nil, nil
+	}
 
 	header := records[0]
 	data := records[1:]
@@ -585,6 +783,7 @@ func ReadCSVNGSFilter(reader io.Reader) (*obingslibrary.NGSLibrary, error) {
 	sample_tagColIndex := -1
 	forward_primerColIndex := -1
 	reverse_primerColIndex := -1
+	indexColIndex := -1
 
 	extraColumns := make([]int, 0)
 
@@ -601,49 +800,69 @@ func ReadCSVNGSFilter(reader io.Reader) (*obingslibrary.NGSLibrary, error) {
 			forward_primerColIndex = i
 		case "reverse_primer":
 			reverse_primerColIndex = i
+		case "index":
+			indexColIndex = i
 		default:
 			extraColumns = append(extraColumns, i)
 		}
 	}
 
-	if experimentColIndex == -1 {
-		return nil, fmt.Errorf("column 'experiment' not found in the CSV file")
-	}
-
-	if sampleColIndex == -1 {
-		return nil, fmt.Errorf("column 'sample' not found in the CSV file")
-	}
-
-	if sample_tagColIndex == -1 {
-		return nil, fmt.Errorf("column 'sample_tag' not found in the CSV file")
+	missing := false
+	for _, column := range []struct {
+		name  string
+		index int
+	}{
+		{"experiment", experimentColIndex},
+		{"sample", sampleColIndex},
+		{"sample_tag", sample_tagColIndex},
+		{"forward_primer", forward_primerColIndex},
+		{"reverse_primer", reverse_primerColIndex},
+	} {
+		if column.index == -1 {
+			report(lines[0], false, fmt.Errorf("column '%s' not found in the CSV file", column.name))
+			missing = true
+		}
 	}
 
-	if forward_primerColIndex == -1 {
-		return nil, fmt.Errorf("column 'forward_primer' not found in the CSV file")
+	if missing {
+		return nil, nil
 	}
 
-	if reverse_primerColIndex == -1 {
-		return nil, fmt.Errorf("column 'reverse_primer' not found in the CSV file")
-	}
+	pcrLines := make(map[*obingslibrary.PCR]int, len(data))
 
 	for i, fields := range data {
+		line := lines[i+1]
+
 		if len(fields) != len(header) {
-			return nil, fmt.Errorf("row %d has %d columns, expected %d", len(data), len(fields), len(header))
+			report(line, false, fmt.Errorf("row has %d columns, expected %d", len(fields), len(header)))
+			continue
 		}
 
 		forward_primer := strings.TrimSpace(fields[forward_primerColIndex])
 		reverse_primer := strings.TrimSpace(fields[reverse_primerColIndex])
 		tags := _parseMainNGSFilterTags(strings.TrimSpace(fields[sample_tagColIndex]))
 
-		marker, _ := ngsfilter.GetMarker(forward_primer, reverse_primer)
+		library := &ngsfilter
+		if indexColIndex >= 0 {
+			index, err := obingslibrary.ParseIndexPair(fields[indexColIndex])
+			if err != nil {
+				report(line, false, err)
+				continue
+			}
+			library = ngsfilter.GetIndexedLibrary(index)
+		}
+
+		marker, _ := library.GetMarker(forward_primer, reverse_primer)
 		pcr, ok := marker.GetPCR(tags.Forward, tags.Reverse)
 
 		if ok {
-			return &ngsfilter,
-				fmt.Errorf("line %d : tag pair (%s,%s) used more than once with marker (%s,%s)",
-					i, tags.Forward, tags.Reverse, forward_primer, reverse_primer)
+			report(line, false,
+				fmt.Errorf("tag pair (%s,%s) used more than once with marker (%s,%s), first declared at line %d",
+					tags.Forward, tags.Reverse, forward_primer, reverse_primer, pcrLines[pcr]))
+			continue
 		}
 
+		pcrLines[pcr] = line
 		pcr.Experiment = strings.TrimSpace(fields[experimentColIndex])
 		pcr.Sample = strings.TrimSpace(fields[sampleColIndex])
 
@@ -656,22 +875,49 @@ func ReadCSVNGSFilter(reader io.Reader) (*obingslibrary.NGSLibrary, error) {
 
 	}
 
-	ngsfilter.CheckPrimerUnicity()
+	for _, library := range ngsfilter.Libraries() {
+		if err := library.CheckPrimerUnicity(); err != nil {
+			report(lines[0], true, err)
+		}
+	}
 
 	for i := 0; i < len(params); i++ {
 		param := params[i][1]
+		line := paramLines[i]
+
 		if len(params[i]) < 3 {
-			log.Fatalf("At line %d: Missing value for parameter %s", i, param)
+			report(line, false, fmt.Errorf("missing value for parameter %s", param))
+			continue
 		}
 		data := params[i][2:]
+
+		if param == "index_mismatches" {
+			mismatches, err := strconv.Atoi(data[0])
+			if err != nil || mismatches < 0 {
+				report(line, false, fmt.Errorf("invalid value for @index_mismatches parameter"))
+				continue
+			}
+			ngsfilter.IndexMismatches = mismatches
+			continue
+		}
+
 		setparam, ok := library_parameter[param]
 
 		if ok {
-			setparam(&ngsfilter, data...)
+			for _, library := range ngsfilter.Libraries() {
+				if err := setparam(library, data...); err != nil {
+					report(line, false, err)
+					break
+				}
+			}
 		} else {
-			obilog.Warnf("At line %d: Skipping unknown parameter %s: %v", i, param, data)
+			report(line, true, fmt.Errorf("skipping unknown parameter %s: %v", param, data))
 		}
 	}
 
-	return &ngsfilter, nil
+	if err := ngsfilter.CheckIndexCollisions(); err != nil {
+		report(lines[0], false, err)
+	}
+
+	return &ngsfilter, pcrLines
 }
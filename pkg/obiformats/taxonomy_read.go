@@ -109,3 +109,27 @@ func LoadTaxonomy(path string, onlysn, seqAsTaxa bool) (*obitax.Taxonomy, error)
 
 	return taxonomy, err
 }
+
+// LoadTaxonomies loads a taxonomy from several sources. The first path is the
+// main taxonomy, loaded with LoadTaxonomy whatever its format. Every following
+// path is a CSV taxonomy extension grafted onto the main taxonomy with
+// GraftCSVTaxonomy.
+func LoadTaxonomies(paths []string, onlysn, seqAsTaxa bool) (*obitax.Taxonomy, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no taxonomy to load")
+	}
+
+	taxonomy, err := LoadTaxonomy(paths[0], onlysn, seqAsTaxa)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths[1:] {
+		if err := GraftCSVTaxonomy(taxonomy, path); err != nil {
+			return nil, err
+		}
+	}
+
+	return taxonomy, nil
+}
@@ -2,6 +2,7 @@ package obiiter
 
 import (
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -96,3 +97,71 @@ func (iter IBioSequence) PairedWith() IBioSequence {
 func (iter IBioSequence) IsPaired() bool {
 	return iter.pointer.paired
 }
+
+// CombineWith reads two iterators in lockstep, like PairTo, and calls a
+// function on each sequence of the iterator and the sequence at the same
+// position in the second one. When the sequences of the first iterator
+// are paired, the function is also called on their paired sequence, so
+// that both reads are annotated. Only the sequences of the first iterator
+// are returned.
+//
+// Parameters:
+//   - p: The second iterator, providing the same number of sequences.
+//   - combine: The function called on each pair of sequences.
+//
+// Returns:
+//   - An iterator over the sequences of the first iterator.
+func (iter IBioSequence) CombineWith(p IBioSequence,
+	combine func(sequence, other *obiseq.BioSequence)) IBioSequence {
+
+	newIter := MakeIBioSequence()
+	paired := iter.IsPaired()
+
+	iter = iter.SortBatches().Rebatch(obidefault.BatchSize())
+	p = p.SortBatches().Rebatch(obidefault.BatchSize())
+
+	newIter.Add(1)
+
+	go func() {
+		newIter.WaitAndClose()
+	}()
+
+	go func() {
+
+		for iter.Next() {
+			batch := iter.Get()
+
+			if !p.Next() {
+				log.Fatalf("the second iterator has fewer sequences than the first one")
+			}
+
+			pbatch := p.Get()
+
+			if batch.Len() != pbatch.Len() {
+				log.Fatalf("both iterators are not synchronized : batch %d has %d and %d sequences",
+					batch.Order(), batch.Len(), pbatch.Len())
+			}
+
+			for i, sequence := range batch.Slice() {
+				combine(sequence, pbatch.Slice()[i])
+				if sequence.IsPaired() {
+					combine(sequence.PairedWith(), pbatch.Slice()[i])
+				}
+			}
+
+			newIter.Push(batch)
+		}
+
+		if p.Next() {
+			log.Fatalf("the second iterator has more sequences than the first one")
+		}
+
+		newIter.Done()
+	}()
+
+	if paired {
+		newIter.MarkAsPaired()
+	}
+
+	return newIter
+}
@@ -0,0 +1,83 @@
+package obilua
+
+import (
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obialign"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// RegisterObiAlign registers the obialign table providing the pairwise
+// alignment functions.
+func RegisterObiAlign(luaState *lua.LState) {
+	table := luaState.NewTable()
+	luaState.SetField(table, "align", luaState.NewFunction(obialignAlign))
+	luaState.SetGlobal("obialign", table)
+}
+
+// checkAlignedSequence returns the sequence at position n of the Lua stack,
+// given either as a BioSequence or as a string.
+func checkAlignedSequence(L *lua.LState, n int) *obiseq.BioSequence {
+	switch value := L.CheckAny(n).(type) {
+	case lua.LString:
+		return obiseq.NewBioSequence("", []byte(strings.ToLower(string(value))), "")
+	case *lua.LUserData:
+		if sequence, ok := value.Value.(*obiseq.BioSequence); ok {
+			return sequence
+		}
+	}
+
+	L.ArgError(n, "obiseq.BioSequence or string expected")
+	return nil
+}
+
+// obialignAlign aligns two sequences:
+//
+//	obialign.align(query, target [, options])
+//
+// The options table can set the mode ("global", "local" or "egf"), the
+// match, mismatch, gap_open and gap_extend scores and the band width. The
+// function returns a table describing the alignment.
+func obialignAlign(L *lua.LState) int {
+	query := checkAlignedSequence(L, 1)
+	target := checkAlignedSequence(L, 2)
+
+	mode := obialign.GlobalAlignment
+	scoring := obialign.DefaultScoringScheme()
+	band := -1
+
+	if L.GetTop() > 2 {
+		options := L.CheckTable(3)
+
+		if name, ok := options.RawGetString("mode").(lua.LString); ok {
+			var err error
+			if mode, err = obialign.ParseAlignmentMode(string(name)); err != nil {
+				L.ArgError(3, err.Error())
+			}
+		}
+
+		number := func(key string, value int) int {
+			if v, ok := options.RawGetString(key).(lua.LNumber); ok {
+				return int(v)
+			}
+			return value
+		}
+
+		match := number("match", obialign.DefaultMatchScore)
+		mismatch := number("mismatch", obialign.DefaultMismatchScore)
+		gapOpen := number("gap_open", obialign.DefaultGapOpen)
+		gapExtend := number("gap_extend", obialign.DefaultGapExtend)
+		band = number("band", band)
+
+		if match != obialign.DefaultMatchScore || mismatch != obialign.DefaultMismatchScore ||
+			gapOpen != obialign.DefaultGapOpen || gapExtend != obialign.DefaultGapExtend {
+			scoring = obialign.NewScoringScheme(match, mismatch, gapOpen, gapExtend)
+		}
+	}
+
+	pushInterfaceToLua(L, obialign.AlignAffine(query, target, mode, scoring, band).ToMap())
+
+	return 1
+}
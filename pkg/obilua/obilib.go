@@ -5,6 +5,7 @@ import lua "github.com/yuin/gopher-lua"
 func RegisterObilib(luaState *lua.LState) {
 	RegisterObiSeq(luaState)
 	RegisterObiTaxonomy(luaState)
+	RegisterObiAlign(luaState)
 	RegisterHTTP(luaState)
 	RegisterJSON(luaState)
 }
@@ -61,6 +61,12 @@ var taxonMethods = map[string]lua.LGFunction{
 	"species":         taxonGetSpecies,
 	"genus":           taxonGetGenus,
 	"family":          taxonGetFamily,
+	"is_extension":    taxonIsExtension,
+	"depth":           taxonDepth,
+	"lca":             taxonLCA,
+	"distance":        taxonDistance,
+	"lca_depth":       taxonLCADepth,
+	"shared_rank":     taxonSharedRank,
 }
 
 func checkTaxon(L *lua.LState, i int) *obitax.Taxon {
@@ -137,3 +143,88 @@ func taxGetTaxonAtRank(luaState *lua.LState) int {
 
 	return 1
 }
+
+func taxonIsExtension(luaState *lua.LState) int {
+	taxon := checkTaxon(luaState, 1)
+
+	luaState.Push(lua.LBool(taxon.IsExtension()))
+
+	return 1
+}
+
+func taxonDepth(luaState *lua.LState) int {
+	taxon := checkTaxon(luaState, 1)
+
+	luaState.Push(lua.LNumber(taxon.Depth()))
+
+	return 1
+}
+
+func taxonLCA(luaState *lua.LState) int {
+	taxon := checkTaxon(luaState, 1)
+	other := checkTaxon(luaState, 2)
+
+	lca, err := taxon.LCA(other)
+
+	if err != nil {
+		luaState.RaiseError("Cannot compute LCA of %s and %s: %v", taxon.String(), other.String(), err)
+		return 0
+	}
+
+	luaState.Push(taxon2Lua(luaState, lca))
+
+	return 1
+}
+
+func taxonDistance(luaState *lua.LState) int {
+	taxon := checkTaxon(luaState, 1)
+	other := checkTaxon(luaState, 2)
+
+	distance, err := taxon.TaxonomicDistance(other)
+
+	if err != nil {
+		luaState.RaiseError("Cannot compute distance between %s and %s: %v", taxon.String(), other.String(), err)
+		return 0
+	}
+
+	luaState.Push(lua.LNumber(distance))
+
+	return 1
+}
+
+func taxonLCADepth(luaState *lua.LState) int {
+	taxon := checkTaxon(luaState, 1)
+	other := checkTaxon(luaState, 2)
+
+	depth, err := taxon.LCADepth(other)
+
+	if err != nil {
+		luaState.RaiseError("Cannot compute LCA of %s and %s: %v", taxon.String(), other.String(), err)
+		return 0
+	}
+
+	luaState.Push(lua.LNumber(depth))
+
+	return 1
+}
+
+func taxonSharedRank(luaState *lua.LState) int {
+	taxon := checkTaxon(luaState, 1)
+	other := checkTaxon(luaState, 2)
+
+	ranks := make([]string, 0, luaState.GetTop()-2)
+	for i := 3; i <= luaState.GetTop(); i++ {
+		ranks = append(ranks, luaState.CheckString(i))
+	}
+
+	rank, err := taxon.SharedRank(other, ranks...)
+
+	if err != nil {
+		luaState.RaiseError("Cannot compute LCA of %s and %s: %v", taxon.String(), other.String(), err)
+		return 0
+	}
+
+	luaState.Push(lua.LString(rank))
+
+	return 1
+}
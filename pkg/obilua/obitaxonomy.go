@@ -70,9 +70,10 @@ func hasDefaultTaxonomy(luaState *lua.LState) int {
 }
 
 var taxonomyMethods = map[string]lua.LGFunction{
-	"name":  taxonomyGetName,
-	"code":  taxonomyGetCode,
-	"taxon": taxonomyGetTaxon,
+	"name":            taxonomyGetName,
+	"code":            taxonomyGetCode,
+	"taxon":           taxonomyGetTaxon,
+	"extension_codes": taxonomyGetExtensionCodes,
 }
 
 func checkTaxonomy(L *lua.LState) *obitax.Taxonomy {
@@ -96,6 +97,18 @@ func taxonomyGetCode(luaState *lua.LState) int {
 	return 1
 }
 
+func taxonomyGetExtensionCodes(luaState *lua.LState) int {
+	taxo := checkTaxonomy(luaState)
+	codes := luaState.NewTable()
+
+	for _, code := range taxo.ExtensionCodes() {
+		codes.Append(lua.LString(code))
+	}
+
+	luaState.Push(codes)
+	return 1
+}
+
 func taxonomyGetTaxon(luaState *lua.LState) int {
 	taxo := checkTaxonomy(luaState)
 	taxid := luaState.CheckString(2)
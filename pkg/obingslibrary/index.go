@@ -0,0 +1,239 @@
+package obingslibrary
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// IndexPair is the pair of Illumina indexes identifying a sequencing
+// library: the i7 index, read as I1, and the i5 index, read as I2. I5 is
+// empty for single indexed libraries.
+type IndexPair struct {
+	I7 string
+	I5 string
+}
+
+// ParseIndexPair parses an index pair written as in the Casava headers,
+// i7+i5 or i7 alone for single indexed libraries.
+func ParseIndexPair(text string) (IndexPair, error) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(text)), "+")
+
+	if len(parts) > 2 || parts[0] == "" {
+		return IndexPair{}, fmt.Errorf("invalid index pair %q", text)
+	}
+
+	for _, part := range parts {
+		if strings.Trim(part, "acgtn") != "" {
+			return IndexPair{}, fmt.Errorf("invalid index pair %q", text)
+		}
+	}
+
+	pair := IndexPair{I7: parts[0]}
+	if len(parts) == 2 {
+		pair.I5 = parts[1]
+	}
+
+	return pair, nil
+}
+
+func (pair IndexPair) String() string {
+	if pair.I5 == "" {
+		return pair.I7
+	}
+	return pair.I7 + "+" + pair.I5
+}
+
+// IsEmpty returns true if the pair has no index.
+func (pair IndexPair) IsEmpty() bool {
+	return pair.I7 == "" && pair.I5 == ""
+}
+
+// ReadIndexes returns the index pair of a read. The indexes are taken from
+// the illumina_index attribute, set when the index reads are read from I1
+// and I2 files, or else from the Casava header of the read, where the
+// definition starts by a word like 1:N:0:ACGTACGT+TTGATTGA.
+func ReadIndexes(sequence *obiseq.BioSequence) (IndexPair, bool) {
+	if index, ok := sequence.GetStringAttribute("illumina_index"); ok {
+		pair, err := ParseIndexPair(index)
+		return pair, err == nil
+	}
+
+	fields := strings.Fields(sequence.Definition())
+	if len(fields) == 0 {
+		return IndexPair{}, false
+	}
+
+	casava := strings.Split(fields[0], ":")
+	if len(casava) != 4 {
+		return IndexPair{}, false
+	}
+
+	pair, err := ParseIndexPair(casava[3])
+	return pair, err == nil
+}
+
+// indexDistance returns the number of mismatches between an observed index
+// and a declared one. Observed indexes longer than the declared one are
+// truncated, and N symbols are counted as mismatches.
+func indexDistance(observed, declared string) int {
+	if len(observed) > len(declared) {
+		observed = observed[:len(declared)]
+	}
+
+	if len(observed) < len(declared) {
+		return math.MaxInt / 4
+	}
+
+	count := 0
+	for i := 0; i < len(declared); i++ {
+		if observed[i] != declared[i] || observed[i] == 'n' {
+			count++
+		}
+	}
+
+	return count
+}
+
+// IsIndexed returns true if the samples of the library are identified by
+// Illumina indexes.
+func (library *NGSLibrary) IsIndexed() bool {
+	return len(library.Indexes) > 0
+}
+
+// GetIndexedLibrary returns the library of the samples identified by an
+// index pair, creating it if needed.
+func (library *NGSLibrary) GetIndexedLibrary(index IndexPair) *NGSLibrary {
+	indexed, ok := library.Indexes[index]
+
+	if !ok {
+		l := MakeNGSLibrary()
+		indexed = &l
+		library.Indexes[index] = indexed
+	}
+
+	return indexed
+}
+
+// Libraries returns the libraries indexed by their index pair. A library
+// whose samples are not identified by indexes is returned with an empty
+// index pair.
+func (library *NGSLibrary) Libraries() map[IndexPair]*NGSLibrary {
+	if library.IsIndexed() {
+		return library.Indexes
+	}
+
+	return map[IndexPair]*NGSLibrary{{}: library}
+}
+
+// MatchIndex looks for the declared index pair closest to the indexes of a
+// read. Each index can differ from the declared one by at most
+// IndexMismatches mismatches. The i5 index of the read is ignored for the
+// single indexed libraries.
+//
+// Parameters:
+//   - observed: The indexes of the read.
+//
+// Returns:
+//   - The declared index pair.
+//   - The total number of mismatches.
+//   - An error if no index pair, or several ones, match the read.
+func (library *NGSLibrary) MatchIndex(observed IndexPair) (IndexPair, int, error) {
+	best := IndexPair{}
+	bestDistance := math.MaxInt
+	ambiguous := false
+
+	for declared := range library.Indexes {
+		d7 := indexDistance(observed.I7, declared.I7)
+		d5 := 0
+		if declared.I5 != "" {
+			d5 = indexDistance(observed.I5, declared.I5)
+		}
+
+		if d7 > library.IndexMismatches || d5 > library.IndexMismatches {
+			continue
+		}
+
+		switch d := d7 + d5; {
+		case d < bestDistance:
+			best, bestDistance, ambiguous = declared, d, false
+		case d == bestDistance:
+			ambiguous = true
+		}
+	}
+
+	if bestDistance == math.MaxInt {
+		return best, -1, fmt.Errorf("no sample index matches the read index (%s)", observed)
+	}
+
+	if ambiguous {
+		return best, bestDistance, fmt.Errorf("several sample indexes match the read index (%s)", observed)
+	}
+
+	return best, bestDistance, nil
+}
+
+// CheckIndexCollisions checks that no read index can match two declared
+// index pairs with the allowed number of mismatches. Such a collision
+// occurs when both indexes of two pairs differ by at most twice the
+// allowed number of mismatches.
+func (library *NGSLibrary) CheckIndexCollisions() error {
+	indexes := make([]IndexPair, 0, len(library.Indexes))
+	for index := range library.Indexes {
+		indexes = append(indexes, index)
+	}
+
+	limit := 2 * library.IndexMismatches
+
+	for i, a := range indexes {
+		for _, b := range indexes[i+1:] {
+			if (a.I5 == "") != (b.I5 == "") {
+				return fmt.Errorf("single (%s) and dual (%s) indexes cannot be mixed", a, b)
+			}
+
+			if Hamming(a.I7, b.I7) <= limit && Hamming(a.I5, b.I5) <= limit {
+				return fmt.Errorf("index pairs %s and %s are too close to be distinguished with %d mismatches",
+					a, b, library.IndexMismatches)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ExtractIndexedMultiBarcode assigns a read to the library identified by
+// its Illumina indexes, and then extracts its barcodes with
+// ExtractMultiBarcode. The observed and the assigned index pairs are stored
+// in the obimultiplex_read_index and obimultiplex_index attributes.
+//
+// Parameters:
+//   - sequence: The read to demultiplex.
+//
+// Returns:
+//   - The extracted barcodes, or the read annotated by an
+//     obimultiplex_error attribute if it cannot be assigned.
+//   - An error if the barcode extraction failed.
+func (library *NGSLibrary) ExtractIndexedMultiBarcode(sequence *obiseq.BioSequence) (obiseq.BioSequenceSlice, error) {
+	observed, ok := ReadIndexes(sequence)
+
+	if !ok {
+		sequence.SetAttribute("obimultiplex_error", "No index found in the read")
+		return obiseq.BioSequenceSlice{sequence}, nil
+	}
+
+	sequence.SetAttribute("obimultiplex_read_index", observed.String())
+
+	index, distance, err := library.MatchIndex(observed)
+
+	if err != nil {
+		sequence.SetAttribute("obimultiplex_error", "Read index not assigned: "+err.Error())
+		return obiseq.BioSequenceSlice{sequence}, nil
+	}
+
+	sequence.SetAttribute("obimultiplex_index", index.String())
+	sequence.SetAttribute("obimultiplex_index_dist", distance)
+
+	return library.Indexes[index].ExtractMultiBarcode(sequence)
+}
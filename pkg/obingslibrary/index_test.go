@@ -0,0 +1,141 @@
+package obingslibrary
+
+import (
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// indexedLibrary builds a library whose samples are identified by the
+// given index pairs.
+func indexedLibrary(mismatches int, indexes ...string) *NGSLibrary {
+	library := MakeNGSLibrary()
+	library.IndexMismatches = mismatches
+
+	for _, index := range indexes {
+		pair, err := ParseIndexPair(index)
+		if err != nil {
+			panic(err)
+		}
+		library.GetIndexedLibrary(pair)
+	}
+
+	return &library
+}
+
+func TestParseIndexPair(t *testing.T) {
+	tests := []struct {
+		text string
+		pair IndexPair
+		ok   bool
+	}{
+		{"ACGTACGT+TTGATTGA", IndexPair{"acgtacgt", "ttgattga"}, true},
+		{" acgtacgt ", IndexPair{"acgtacgt", ""}, true},
+		{"acgtncgt+ttga", IndexPair{"acgtncgt", "ttga"}, true},
+		{"", IndexPair{}, false},
+		{"+ttga", IndexPair{}, false},
+		{"acgt+ttga+ccgg", IndexPair{}, false},
+		{"acgx+ttga", IndexPair{}, false},
+	}
+
+	for _, test := range tests {
+		pair, err := ParseIndexPair(test.text)
+		if (err == nil) != test.ok || (test.ok && pair != test.pair) {
+			t.Errorf("%q is parsed as %v (%v), expected %v", test.text, pair, err, test.pair)
+		}
+	}
+}
+
+func TestReadIndexes(t *testing.T) {
+	tests := []struct {
+		definition string
+		attribute  string
+		pair       IndexPair
+		ok         bool
+	}{
+		{"1:N:0:ACGTACGT+TTGATTGA", "", IndexPair{"acgtacgt", "ttgattga"}, true},
+		{"2:N:0:ACGTACGT other words", "", IndexPair{"acgtacgt", ""}, true},
+		{"1:N:0:ACGTACGT+TTGATTGA", "ccggccgg+aattaatt", IndexPair{"ccggccgg", "aattaatt"}, true},
+		{"", "acgx+ttga", IndexPair{}, false},
+		{"", "", IndexPair{}, false},
+		{"1:N:ACGTACGT", "", IndexPair{}, false},
+		{"1:N:0:12", "", IndexPair{}, false},
+	}
+
+	for _, test := range tests {
+		sequence := obiseq.NewBioSequence("read", []byte("acgt"), test.definition)
+		if test.attribute != "" {
+			sequence.SetAttribute("illumina_index", test.attribute)
+		}
+
+		pair, ok := ReadIndexes(sequence)
+		if ok != test.ok || (ok && pair != test.pair) {
+			t.Errorf("indexes of %q (%q) are %v (%v), expected %v (%v)",
+				test.definition, test.attribute, pair, ok, test.pair, test.ok)
+		}
+	}
+}
+
+func TestMatchIndex(t *testing.T) {
+	dual := indexedLibrary(1, "acgtacgt+ttgattga", "ggccttaa+ccaaggtt", "ggccttaa+ttgattga")
+	single := indexedLibrary(1, "acgtacgt", "ggccttaa")
+	strict := indexedLibrary(0, "acgtacgt+ttgattga", "ggccttaa+ccaaggtt")
+	near := indexedLibrary(1, "acgtacgt", "acgtacct")
+
+	tests := []struct {
+		name     string
+		library  *NGSLibrary
+		observed IndexPair
+		index    IndexPair
+		distance int
+		ok       bool
+	}{
+		{"exact", dual, IndexPair{"acgtacgt", "ttgattga"}, IndexPair{"acgtacgt", "ttgattga"}, 0, true},
+		{"i7 mismatch", dual, IndexPair{"acgtaggt", "ttgattga"}, IndexPair{"acgtacgt", "ttgattga"}, 1, true},
+		{"both mismatches", dual, IndexPair{"ggcctaaa", "ccaagctt"}, IndexPair{"ggccttaa", "ccaaggtt"}, 2, true},
+		{"n symbol", dual, IndexPair{"acgtncgt", "ttgattga"}, IndexPair{"acgtacgt", "ttgattga"}, 1, true},
+		{"too many mismatches", dual, IndexPair{"acgttggt", "ttgattga"}, IndexPair{}, -1, false},
+		{"longer read index", dual, IndexPair{"acgtacgtaa", "ttgattgaaa"}, IndexPair{"acgtacgt", "ttgattga"}, 0, true},
+		{"shorter read index", dual, IndexPair{"acgtacg", "ttgattga"}, IndexPair{}, -1, false},
+		{"i5 ignored", single, IndexPair{"ggccttaa", "acgtacgt"}, IndexPair{"ggccttaa", ""}, 0, true},
+		{"no mismatch allowed", strict, IndexPair{"acgtaggt", "ttgattga"}, IndexPair{}, -1, false},
+		{"ambiguous", near, IndexPair{"acgtacat", ""}, IndexPair{}, 1, false},
+		{"closest", near, IndexPair{"acgtacgt", ""}, IndexPair{"acgtacgt", ""}, 0, true},
+	}
+
+	for _, test := range tests {
+		index, distance, err := test.library.MatchIndex(test.observed)
+
+		if (err == nil) != test.ok {
+			t.Errorf("%s: %s matched with error %v", test.name, test.observed, err)
+			continue
+		}
+
+		if distance != test.distance || (test.ok && index != test.index) {
+			t.Errorf("%s: %s matches %s with %d mismatches, expected %s with %d",
+				test.name, test.observed, index, distance, test.index, test.distance)
+		}
+	}
+}
+
+func TestCheckIndexCollisions(t *testing.T) {
+	tests := []struct {
+		name    string
+		library *NGSLibrary
+		ok      bool
+	}{
+		{"distinct", indexedLibrary(1, "acgtacgt+ttgattga", "ggccttaa+ccaaggtt"), true},
+		{"distinct i5", indexedLibrary(1, "acgtacgt+ttgattga", "acgtacgt+ccaaggtt"), true},
+		{"collision", indexedLibrary(1, "acgtacgt+ttgattga", "acgtacct+ttgatcga"), false},
+		{"distinguished without mismatch", indexedLibrary(0, "acgtacgt+ttgattga", "acgtacct+ttgatcga"), true},
+		{"single collision", indexedLibrary(2, "acgtacgt", "acgaacct"), false},
+		{"mixed", indexedLibrary(1, "acgtacgt+ttgattga", "ggccttaa"), false},
+		{"no index", indexedLibrary(1), true},
+	}
+
+	for _, test := range tests {
+		if err := test.library.CheckIndexCollisions(); (err == nil) != test.ok {
+			t.Errorf("%s: collision check returns %v", test.name, err)
+		}
+	}
+}
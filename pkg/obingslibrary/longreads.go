@@ -0,0 +1,303 @@
+package obingslibrary
+
+import (
+	"fmt"
+	"math"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// LongReadErrorRate is the fraction of errors, substitutions or indels,
+// tolerated on the primers and on the tags of long reads (Oxford Nanopore,
+// PacBio).
+const LongReadErrorRate = 0.15
+
+// longReadErrors returns the number of errors tolerated on a word of a
+// long read.
+func longReadErrors(length int) int {
+	return max(1, int(math.Ceil(LongReadErrorRate*float64(length))))
+}
+
+// SetLongReads configures the marker for long noisy reads. Indels are
+// allowed on the primers, with a number of errors proportional to their
+// length but never lower than the number already allowed, and the tags are
+// identified by an alignment tolerating indels.
+//
+// Parameters:
+//   - primers: The primers of the marker.
+func (marker *Marker) SetLongReads(primers PrimerPair) {
+	marker.Long_reads = true
+	marker.Forward_allows_indels = true
+	marker.Reverse_allows_indels = true
+	marker.Forward_error = max(marker.Forward_error, longReadErrors(len(primers.Forward)))
+	marker.Reverse_error = max(marker.Reverse_error, longReadErrors(len(primers.Reverse)))
+}
+
+// SetLongReads configures every marker of the library for long noisy
+// reads.
+func (library *NGSLibrary) SetLongReads() {
+	for primers, marker := range library.Markers {
+		marker.SetLongReads(primers)
+	}
+}
+
+// HasLongReads returns true if the library is configured for long reads.
+func (library *NGSLibrary) HasLongReads() bool {
+	for _, marker := range library.Markers {
+		if marker.Long_reads {
+			return true
+		}
+	}
+
+	return false
+}
+
+// suffixDistance returns the edit distance between a tag and the best
+// matching part of a window of the read ending at most slack nucleotides
+// before the end of the window. The beginning of the alignment is free.
+func suffixDistance(tag, window string, slack int) int {
+	prev := make([]int, len(window)+1)
+	curr := make([]int, len(window)+1)
+
+	for i := 1; i <= len(tag); i++ {
+		curr[0] = i
+		for j := 1; j <= len(window); j++ {
+			cost := 0
+			if tag[i-1] != window[j-1] {
+				cost = 1
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	best := math.MaxInt
+	for j := max(0, len(window)-slack); j <= len(window); j++ {
+		best = min(best, prev[j])
+	}
+
+	return best
+}
+
+// closestLongReadTag looks for the declared tag best aligned on the end of
+// a window of the read. The number of errors tolerated on a tag depends on
+// its own length, the alignment can also end up to twice this number of
+// nucleotides before the end of the window. As the beginning of the
+// alignment is free, a tag is as close as a longer tag ending like it: the
+// longest of the closest tags is preferred.
+//
+// Parameters:
+//   - window: The part of the read preceding the primer, in the
+//     orientation of the primer.
+//   - tags: The declared tags, they can be of different lengths.
+//
+// Returns:
+//   - The closest tag, an empty string if none or several tags of the same
+//     length are the closest ones, or if it has too many errors.
+//   - The edit distance to the closest tag.
+func closestLongReadTag(window string, tags []string) (string, int) {
+	best := ""
+	bestLength := 0
+	bestDistance := math.MaxInt
+
+	for _, tag := range tags {
+		d := suffixDistance(tag, window, 2*longReadErrors(len(tag)))
+
+		switch {
+		case d < bestDistance || (d == bestDistance && len(tag) > bestLength):
+			best, bestLength, bestDistance = tag, len(tag), d
+		case d == bestDistance && len(tag) == bestLength:
+			best = ""
+		}
+	}
+
+	if best != "" && bestDistance > longReadErrors(len(best)) {
+		best = ""
+	}
+
+	return best, bestDistance
+}
+
+// longestTag returns the length of the longest tag, 0 if there is no tag.
+func longestTag(tags []string) int {
+	length := 0
+	for _, tag := range tags {
+		length = max(length, len(tag))
+	}
+
+	return length
+}
+
+// longReadTagWindows extracts from the read the regions where the tags of
+// an amplicon are expected, in the orientation of their primers. The
+// windows are sized after the longest tags, and enlarged on both sides to
+// tolerate indels. Shorter tags are aligned on the end of the windows.
+func (marker *Marker) longReadTagWindows(
+	sequence *obiseq.BioSequence,
+	begin, end int,
+	forward bool,
+	forwardLength, reverseLength int) (string, string) {
+
+	beginLength, beginSpacer := forwardLength, marker.Forward_spacer
+	endLength, endSpacer := reverseLength, marker.Reverse_spacer
+
+	if !forward {
+		beginLength, beginSpacer = reverseLength, marker.Reverse_spacer
+		endLength, endSpacer = forwardLength, marker.Forward_spacer
+	}
+
+	beginWindow := ""
+	if beginLength > 0 {
+		delta := longReadErrors(beginLength)
+		we := min(sequence.Len(), max(0, begin-beginSpacer+delta))
+		wb := max(0, we-beginLength-3*delta)
+		beginWindow = sequence.String()[wb:we]
+	}
+
+	endWindow := ""
+	if endLength > 0 {
+		delta := longReadErrors(endLength)
+		wb := min(sequence.Len(), max(0, end+endSpacer-delta))
+		we := min(sequence.Len(), wb+endLength+3*delta)
+		if wb < we {
+			window, err := sequence.Subsequence(wb, we, false)
+			if err != nil {
+				log.Fatalf("Cannot extract sequence tag : %v", err)
+			}
+			endWindow = window.ReverseComplement(true).String()
+		}
+	}
+
+	return beginWindow, endWindow
+}
+
+// LongReadSampleIdentifier identifies the sample of an amplicon found in a
+// long read. The declared tags are aligned, with indels, on the regions
+// flanking the primers, and the closest ones identify the sample.
+//
+// The annotations set are the same as the ones set by TagExtractor and
+// SampleIdentifier, the obimultiplex_*_matching attributes being set to
+// long-read.
+//
+// The tags of a marker can be of different lengths, even when they are not
+// delimited: each tag is aligned with a number of errors depending on its
+// own length.
+//
+// Parameters:
+//   - sequence: The read.
+//   - annotations: The annotations of the amplicon.
+//   - primers: The primers of the marker.
+//   - begin: The position of the 5' end of the first primer match.
+//   - end: The position of the 3' end of the second primer match.
+//   - forward: True if the first primer match is the forward primer.
+//
+// Returns:
+//   - The PCR of the sample, nil if it cannot be identified.
+func (library *NGSLibrary) LongReadSampleIdentifier(
+	sequence *obiseq.BioSequence,
+	annotations obiseq.Annotation,
+	primers PrimerPair,
+	begin, end int,
+	forward bool) *PCR {
+
+	marker := library.Markers[primers]
+
+	forwardTags := make([]string, 0)
+	reverseTags := make([]string, 0)
+	for tags := range marker.samples {
+		forwardTags = append(forwardTags, tags.Forward)
+		reverseTags = append(reverseTags, tags.Reverse)
+	}
+
+	forwardTags = uniqueTags(forwardTags)
+	reverseTags = uniqueTags(reverseTags)
+	forwardLength := longestTag(forwardTags)
+	reverseLength := longestTag(reverseTags)
+
+	forwardWindow, reverseWindow := marker.longReadTagWindows(sequence, begin, end, forward,
+		forwardLength, reverseLength)
+	if !forward {
+		forwardWindow, reverseWindow = reverseWindow, forwardWindow
+	}
+
+	proposed := TagPair{}
+
+	if forwardLength > 0 {
+		tag, distance := closestLongReadTag(forwardWindow, forwardTags)
+		proposed.Forward = tag
+		annotations["obimultiplex_forward_tag"] = forwardWindow
+		annotations["obimultiplex_forward_matching"] = "long-read"
+		annotations["obimultiplex_forward_tag_dist"] = distance
+		annotations["obimultiplex_forward_proposed_tag"] = tag
+	}
+
+	if reverseLength > 0 {
+		tag, distance := closestLongReadTag(reverseWindow, reverseTags)
+		proposed.Reverse = tag
+		annotations["obimultiplex_reverse_tag"] = reverseWindow
+		annotations["obimultiplex_reverse_matching"] = "long-read"
+		annotations["obimultiplex_reverse_tag_dist"] = distance
+		annotations["obimultiplex_reverse_proposed_tag"] = tag
+	}
+
+	pcr, ok := marker.samples[proposed]
+
+	if !ok {
+		annotations["obimultiplex_error"] = fmt.Sprintf("Cannot associate sample to the tag pair (%s:%s)",
+			proposed.Forward, proposed.Reverse)
+		return nil
+	}
+
+	annotations["sample"] = pcr.Sample
+	annotations["experiment"] = pcr.Experiment
+	for k, v := range pcr.Annotations {
+		annotations[k] = v
+	}
+
+	return pcr
+}
+
+// uniqueTags removes the duplicated tags.
+func uniqueTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	unique := make([]string, 0, len(tags))
+
+	for _, tag := range tags {
+		if !seen[tag] {
+			seen[tag] = true
+			unique = append(unique, tag)
+		}
+	}
+
+	return unique
+}
+
+// removeOverlappingMatches keeps, among the overlapping matches of the same
+// primer in the same orientation, the one with the fewest errors. Such
+// matches are reported by the approximate matcher when indels are allowed.
+// The matches must be sorted by position.
+func removeOverlappingMatches(matches []PrimerMatch) []PrimerMatch {
+	kept := make([]PrimerMatch, 0, len(matches))
+
+	for _, match := range matches {
+		overlap := false
+
+		for i := len(kept) - 1; i >= 0 && kept[i].End > match.Begin; i-- {
+			if kept[i].Marker == match.Marker && kept[i].Forward == match.Forward {
+				overlap = true
+				if match.Mismatches < kept[i].Mismatches {
+					kept[i] = match
+				}
+				break
+			}
+		}
+
+		if !overlap {
+			kept = append(kept, match)
+		}
+	}
+
+	return kept
+}
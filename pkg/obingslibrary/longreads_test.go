@@ -0,0 +1,234 @@
+package obingslibrary
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+const (
+	_TestForwardPrimer = "gggcaatcctgagccaa"
+	_TestReversePrimer = "ccattgagtctctgcacctatc"
+)
+
+func randomDNA(rng *rand.Rand, length int) string {
+	sequence := make([]byte, length)
+	for i := range sequence {
+		sequence[i] = "acgt"[rng.Intn(4)]
+	}
+	return string(sequence)
+}
+
+func reverseComplement(sequence string) string {
+	return obiseq.NewBioSequence("", []byte(sequence), "").ReverseComplement(true).String()
+}
+
+// noisy introduces a substitution, an insertion or a deletion at a given
+// position of a sequence.
+func noisy(sequence string, position int, kind byte) string {
+	switch kind {
+	case 's':
+		nuc := byte('a')
+		if sequence[position] == 'a' {
+			nuc = 'c'
+		}
+		return sequence[:position] + string(nuc) + sequence[position+1:]
+	case 'i':
+		return sequence[:position] + "t" + sequence[position:]
+	case 'd':
+		return sequence[:position] + sequence[position+1:]
+	}
+	return sequence
+}
+
+func TestSuffixDistance(t *testing.T) {
+	tests := []struct {
+		tag, window string
+		slack       int
+		distance    int
+	}{
+		{"acgtacgt", "ttttacgtacgt", 0, 0},
+		{"acgtacgt", "ttttacgtacgtgg", 2, 0},
+		{"acgtacgt", "ttttacgtacgtggg", 2, 1},
+		{"acgtacgt", "ttttacgtacgtgggg", 2, 2},
+		{"acgtacgt", "ttttacctacgt", 0, 1},
+		{"acgtacgt", "ttttacgacgt", 0, 1},
+		{"acgtacgt", "tttacgtaacgt", 0, 1},
+		{"acgtacgt", "acgt", 0, 4},
+	}
+
+	for _, test := range tests {
+		if d := suffixDistance(test.tag, test.window, test.slack); d != test.distance {
+			t.Errorf("distance of %s to %s (slack %d) is %d, expected %d",
+				test.tag, test.window, test.slack, d, test.distance)
+		}
+	}
+}
+
+func TestClosestLongReadTag(t *testing.T) {
+	tags := []string{"acgatcag", "acgatgag", "tgcaggta", "gtcgtagacata", "cagtcatgcgta"}
+
+	tests := []struct {
+		name   string
+		window string
+		tag    string
+	}{
+		{"exact", "ggttacgatcag", "acgatcag"},
+		{"substitution", "ggttacgttcag", "acgatcag"},
+		{"insertion", "ggtttgcagtgta", "tgcaggta"},
+		{"deletion", "ggttgcggta", "tgcaggta"},
+		{"before the end of the window", "ggtgcaggtaca", "tgcaggta"},
+		{"longer tag", "aagtcgtagacata", "gtcgtagacata"},
+		{"longer tag with errors", "aagtcgtatacatg", "gtcgtagacata"},
+		{"too many errors", "tttttttttttt", ""},
+		{"ambiguous", "ggttacgattag", ""},
+	}
+
+	for _, test := range tests {
+		if tag, d := closestLongReadTag(test.window, tags); tag != test.tag {
+			t.Errorf("%s: closest tag of %s is %q (distance %d), expected %q",
+				test.name, test.window, tag, d, test.tag)
+		}
+	}
+}
+
+func TestRemoveOverlappingMatches(t *testing.T) {
+	matches := []PrimerMatch{
+		{Begin: 10, End: 30, Mismatches: 2, Marker: 1, Forward: true},
+		{Begin: 11, End: 30, Mismatches: 1, Marker: 1, Forward: true},
+		{Begin: 12, End: 31, Mismatches: 3, Marker: 1, Forward: true},
+		{Begin: 20, End: 40, Mismatches: 0, Marker: 2, Forward: true},
+		{Begin: 25, End: 45, Mismatches: 0, Marker: 1, Forward: false},
+		{Begin: 200, End: 220, Mismatches: 1, Marker: -1, Forward: true},
+		{Begin: 205, End: 225, Mismatches: 1, Marker: -1, Forward: true},
+	}
+
+	expected := []PrimerMatch{
+		{Begin: 11, End: 30, Mismatches: 1, Marker: 1, Forward: true},
+		{Begin: 20, End: 40, Mismatches: 0, Marker: 2, Forward: true},
+		{Begin: 25, End: 45, Mismatches: 0, Marker: 1, Forward: false},
+		{Begin: 200, End: 220, Mismatches: 1, Marker: -1, Forward: true},
+	}
+
+	if kept := removeOverlappingMatches(matches); !reflect.DeepEqual(kept, expected) {
+		t.Errorf("kept matches are %v, expected %v", kept, expected)
+	}
+}
+
+// longReadLibrary builds a long read library of one marker, the samples
+// being identified by their forward and reverse tags.
+func longReadLibrary(t *testing.T, samples map[string]TagPair) *NGSLibrary {
+	library := MakeNGSLibrary()
+	marker, _ := library.GetMarker(_TestForwardPrimer, _TestReversePrimer)
+
+	for sample, tags := range samples {
+		pcr, _ := marker.GetPCR(tags.Forward, tags.Reverse)
+		pcr.Experiment = "test"
+		pcr.Sample = sample
+	}
+
+	library.SetLongReads()
+	if err := library.Compile2(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &library
+}
+
+// longReadAmplicon builds the amplicon of a sample: the tagged forward
+// primer, the barcode and the tagged reverse primer reverse complemented.
+func longReadAmplicon(tags TagPair, barcode string) string {
+	return tags.Forward + _TestForwardPrimer + barcode +
+		reverseComplement(tags.Reverse+_TestReversePrimer)
+}
+
+func TestLongReadSampleIdentifier(t *testing.T) {
+	rng := rand.New(rand.NewSource(47))
+
+	samples := map[string]TagPair{
+		"s1": {"acgatcag", "gtcgtaga"},
+		"s2": {"tgcaggta", "gtcgtaga"},
+		"s3": {"acgatcag", "ctatgcgt"},
+		"s4": {"gtcgtagacata", "cagtcatgcgta"},
+		"s5": {"cagtcatgcgta", "ctatgcgt"},
+	}
+
+	library := longReadLibrary(t, samples)
+	barcodes := make([]string, 3)
+	for i := range barcodes {
+		barcodes[i] = randomDNA(rng, 120+rng.Intn(60))
+	}
+
+	flank := func() string { return randomDNA(rng, 30+rng.Intn(30)) }
+
+	noisyTags := func(sample string, fkind, rkind byte) TagPair {
+		tags := samples[sample]
+		return TagPair{noisy(tags.Forward, 3, fkind), noisy(tags.Reverse, 4, rkind)}
+	}
+
+	tests := []struct {
+		name    string
+		read    string
+		samples []string
+	}{
+		{"exact tags",
+			flank() + longReadAmplicon(samples["s1"], barcodes[0]) + flank(),
+			[]string{"s1"}},
+		{"shared reverse tag",
+			flank() + longReadAmplicon(samples["s2"], barcodes[0]) + flank(),
+			[]string{"s2"}},
+		{"substitutions in the tags",
+			flank() + longReadAmplicon(noisyTags("s3", 's', 's'), barcodes[1]) + flank(),
+			[]string{"s3"}},
+		{"indels in the tags",
+			flank() + longReadAmplicon(noisyTags("s1", 'i', 'd'), barcodes[1]) + flank(),
+			[]string{"s1"}},
+		{"reverse complemented read",
+			reverseComplement(flank() + longReadAmplicon(samples["s3"], barcodes[2]) + flank()),
+			[]string{"s3"}},
+		{"reverse complemented noisy read",
+			reverseComplement(flank() + longReadAmplicon(noisyTags("s2", 'd', 's'), barcodes[2]) + flank()),
+			[]string{"s2"}},
+		{"long tags",
+			flank() + longReadAmplicon(samples["s4"], barcodes[0]) + flank(),
+			[]string{"s4"}},
+		{"long and short tags",
+			flank() + longReadAmplicon(noisyTags("s5", 'i', 's'), barcodes[1]) + flank(),
+			[]string{"s5"}},
+		{"concatenated amplicons",
+			flank() + longReadAmplicon(samples["s1"], barcodes[0]) + flank() +
+				longReadAmplicon(noisyTags("s4", 's', 'i'), barcodes[1]) + flank() +
+				reverseComplement(longReadAmplicon(samples["s3"], barcodes[2])) + flank(),
+			[]string{"s1", "s4", "s3"}},
+		{"unknown tags",
+			flank() + longReadAmplicon(TagPair{"ttttgggg", "ggggtttt"}, barcodes[0]) + flank(),
+			[]string{""}},
+	}
+
+	for _, test := range tests {
+		read := obiseq.NewBioSequence(test.name, []byte(test.read), "")
+		results, err := library.ExtractMultiBarcode(read)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if len(results) != len(test.samples) {
+			t.Errorf("%s: %d amplicons found, expected %d", test.name, len(results), len(test.samples))
+			continue
+		}
+
+		for i, result := range results {
+			sample, _ := result.GetStringAttribute("sample")
+			if sample != test.samples[i] {
+				t.Errorf("%s: amplicon %d assigned to sample %q, expected %q (%v)",
+					test.name, i+1, sample, test.samples[i], result.Annotations())
+			}
+
+			if matching, _ := result.GetStringAttribute("obimultiplex_forward_matching"); matching != "long-read" {
+				t.Errorf("%s: amplicon %d forward matching is %q", test.name, i+1, matching)
+			}
+		}
+	}
+}
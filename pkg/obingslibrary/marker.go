@@ -30,6 +30,11 @@ type Marker struct {
 	Reverse_tag_delimiter byte
 	Forward_tag_indels    int
 	Reverse_tag_indels    int
+	Forward_umi_offset    int
+	Reverse_umi_offset    int
+	Forward_umi_pattern   string
+	Reverse_umi_pattern   string
+	Long_reads            bool
 	samples               map[TagPair]*PCR
 }
 
@@ -256,6 +261,11 @@ func (marker *Marker) GetPCR(forward, reverse string) (*PCR, bool) {
 	return &ipcr, false
 }
 
+// Samples returns the PCRs of the marker indexed by their tag pair.
+func (marker *Marker) Samples() map[TagPair]*PCR {
+	return marker.samples
+}
+
 func (marker *Marker) CheckTagLength() error {
 	forward_length := make(map[int]int)
 	reverse_length := make(map[int]int)
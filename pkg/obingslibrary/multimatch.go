@@ -578,6 +578,7 @@ func (library *NGSLibrary) ExtractMultiBarcode(sequence *obiseq.BioSequence) (ob
 	aseq, err := obiapat.MakeApatSequence(sequence, false)
 
 	results := obiseq.MakeBioSequenceSlice()
+	longReads := false
 
 	if err != nil {
 		log.Fatalf("error in building apat sequence : %v\n", err)
@@ -586,6 +587,7 @@ func (library *NGSLibrary) ExtractMultiBarcode(sequence *obiseq.BioSequence) (ob
 	for primers, marker := range library.Markers {
 		markers[i] = marker
 		primerseqs[i] = primers
+		longReads = longReads || marker.Long_reads
 		locs := marker.forward.AllMatches(aseq, 0, -1)
 		if len(locs) > 0 {
 			for _, loc := range locs {
@@ -646,6 +648,10 @@ func (library *NGSLibrary) ExtractMultiBarcode(sequence *obiseq.BioSequence) (ob
 	if len(matches) > 0 {
 		slices.SortFunc(matches, func(a, b PrimerMatch) int { return a.Begin - b.Begin })
 
+		if longReads {
+			matches = removeOverlappingMatches(matches)
+		}
+
 		state := 0
 		var from PrimerMatch
 		q := 0
@@ -715,7 +721,14 @@ func (library *NGSLibrary) ExtractMultiBarcode(sequence *obiseq.BioSequence) (ob
 
 					// if we were  able to extract the primer matches we can extract the barcode
 					if !barcode_error {
-						tags := library.TagExtractor(sequence, annotations, primerseqs[from.Marker], from.Begin, match.End, from.Forward)
+						var tags *TagPair
+						if !markers[from.Marker].Long_reads {
+							tags = library.TagExtractor(sequence, annotations, primerseqs[from.Marker], from.Begin, match.End, from.Forward)
+						}
+
+						if !library.UMIExtractor(sequence, annotations, primerseqs[from.Marker], from.Begin, match.End, from.Forward) {
+							annotations["obimultiplex_error"] = "Cannot extract the UMI"
+						}
 
 						barcode, err := sequence.Subsequence(from.End, match.Begin, false)
 
@@ -726,7 +739,11 @@ func (library *NGSLibrary) ExtractMultiBarcode(sequence *obiseq.BioSequence) (ob
 								barcode = barcode.ReverseComplement(true)
 							}
 
-							if tags != nil {
+							if markers[from.Marker].Long_reads {
+								annotations["obimultiplex_amplicon_start"] = from.Begin + 1
+								annotations["obimultiplex_amplicon_end"] = match.End
+								library.LongReadSampleIdentifier(sequence, annotations, primerseqs[from.Marker], from.Begin, match.End, from.Forward)
+							} else if tags != nil {
 								library.SampleIdentifier(primerseqs[from.Marker], tags, annotations)
 							}
 
@@ -773,18 +790,29 @@ func (library *NGSLibrary) ExtractMultiBarcode(sequence *obiseq.BioSequence) (ob
 func (library *NGSLibrary) ExtractMultiBarcodeSliceWorker(options ...WithOption) obiseq.SeqSliceWorker {
 	opt := MakeOptions(options)
 
-	if opt.AllowsIndels() {
-		library.SetAllowsIndels(true)
-	}
+	for _, l := range library.Libraries() {
+		if opt.AllowsIndels() {
+			l.SetAllowsIndels(true)
+		}
+
+		if opt.AllowedMismatches() > 0 {
+			l.SetAllowedMismatches(opt.AllowedMismatches())
+		}
 
-	if opt.AllowedMismatches() > 0 {
-		library.SetAllowedMismatches(opt.AllowedMismatches())
+		if opt.LongReads() {
+			l.SetLongReads()
+		}
+
+		l.Compile2()
 	}
 
-	library.Compile2()
+	extract := library.ExtractMultiBarcode
+	if library.IsIndexed() {
+		extract = library.ExtractIndexedMultiBarcode
+	}
 
 	worker := func(sequence *obiseq.BioSequence) (obiseq.BioSequenceSlice, error) {
-		res, err := library.ExtractMultiBarcode(sequence)
+		res, err := extract(sequence)
 
 		if err != nil {
 			log.Panic(err)
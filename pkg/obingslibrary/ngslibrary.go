@@ -23,15 +23,21 @@ type PCR struct {
 	Annotations obiseq.Annotation
 }
 
+// NGSLibrary describes the PCRs multiplexed in a sequencing library. When
+// the samples are also identified by Illumina indexes, Indexes associates
+// each index pair with the library of the PCRs it identifies.
 type NGSLibrary struct {
-	Primers map[string]PrimerPair
-	Markers map[PrimerPair]*Marker
+	Primers         map[string]PrimerPair
+	Markers         map[PrimerPair]*Marker
+	Indexes         map[IndexPair]*NGSLibrary
+	IndexMismatches int
 }
 
 func MakeNGSLibrary() NGSLibrary {
 	return NGSLibrary{
 		Primers: make(map[string]PrimerPair, 10),
 		Markers: make(map[PrimerPair]*Marker, 10),
+		Indexes: make(map[IndexPair]*NGSLibrary),
 	}
 }
 
@@ -0,0 +1,355 @@
+package obingslibrary
+
+import (
+	"cmp"
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// markerStats accumulates the demultiplexing results of a marker.
+type markerStats struct {
+	assigned          int
+	unassigned        int
+	errors            map[string]int
+	forwardMismatches map[int]int
+	reverseMismatches map[int]int
+	forwardTagDist    map[int]int
+	reverseTagDist    map[int]int
+	tagPairs          map[TagPair]int
+}
+
+// markerKey identifies a marker in the library of an index pair.
+type markerKey struct {
+	index   IndexPair
+	primers PrimerPair
+}
+
+// DemultiplexStats accumulates the results of the demultiplexing of reads
+// by ExtractMultiBarcode. It is safe for concurrent use.
+type DemultiplexStats struct {
+	mutex   sync.Mutex
+	library *NGSLibrary
+	reads   int
+	errors  map[string]int
+	markers map[markerKey]*markerStats
+}
+
+// SampleStats reports the reads assigned to a sample.
+type SampleStats struct {
+	Experiment string `json:"experiment"`
+	Sample     string `json:"sample"`
+	ForwardTag string `json:"forward_tag"`
+	ReverseTag string `json:"reverse_tag"`
+	Reads      int    `json:"reads"`
+}
+
+// TagMatrix counts the reads of each combination of a forward and a reverse
+// tag declared for a marker, whether the combination is used by a sample or
+// not. Samples[i][j] is the sample using the combination of ForwardTags[i]
+// and ReverseTags[j], or an empty string for an unused combination.
+type TagMatrix struct {
+	ForwardTags []string   `json:"forward_tags"`
+	ReverseTags []string   `json:"reverse_tags"`
+	Counts      [][]int    `json:"counts"`
+	Samples     [][]string `json:"samples"`
+}
+
+// TagJumpStats summarizes the reads observed on the unused tag combinations,
+// resulting from tag jumps between samples.
+type TagJumpStats struct {
+	UsedCombinations   int     `json:"used_combinations"`
+	UnusedCombinations int     `json:"unused_combinations"`
+	UsedReads          int     `json:"used_reads"`
+	UnusedReads        int     `json:"unused_reads"`
+	UnusedFraction     float64 `json:"unused_fraction"`
+	MeanUsedReads      float64 `json:"mean_used_reads"`
+	MeanUnusedReads    float64 `json:"mean_unused_reads"`
+}
+
+// MarkerReport reports the demultiplexing of the reads of a marker.
+type MarkerReport struct {
+	Index             string         `json:"index,omitempty"`
+	ForwardPrimer     string         `json:"forward_primer"`
+	ReversePrimer     string         `json:"reverse_primer"`
+	Assigned          int            `json:"assigned_reads"`
+	Unassigned        int            `json:"unassigned_reads"`
+	Samples           []SampleStats  `json:"samples"`
+	Errors            map[string]int `json:"errors"`
+	ForwardMismatches map[int]int    `json:"forward_primer_mismatches"`
+	ReverseMismatches map[int]int    `json:"reverse_primer_mismatches"`
+	ForwardTagDist    map[int]int    `json:"forward_tag_distances"`
+	ReverseTagDist    map[int]int    `json:"reverse_tag_distances"`
+	TagMatrix         TagMatrix      `json:"tag_matrix"`
+	TagJumps          TagJumpStats   `json:"tag_jumps"`
+}
+
+// DemultiplexReport is the summary of a demultiplexing run.
+type DemultiplexReport struct {
+	Reads      int            `json:"reads"`
+	Assigned   int            `json:"assigned_reads"`
+	Unassigned int            `json:"unassigned_reads"`
+	Errors     map[string]int `json:"errors"`
+	Markers    []MarkerReport `json:"markers"`
+}
+
+// NewDemultiplexStats creates an empty statistics accumulator for a library.
+func NewDemultiplexStats(library *NGSLibrary) *DemultiplexStats {
+	stats := &DemultiplexStats{
+		library: library,
+		errors:  make(map[string]int),
+		markers: make(map[markerKey]*markerStats),
+	}
+
+	for index, indexed := range library.Libraries() {
+		for primers := range indexed.Markers {
+			stats.markers[markerKey{index, primers}] = &markerStats{
+				errors:            make(map[string]int),
+				forwardMismatches: make(map[int]int),
+				reverseMismatches: make(map[int]int),
+				forwardTagDist:    make(map[int]int),
+				reverseTagDist:    make(map[int]int),
+				tagPairs:          make(map[TagPair]int),
+			}
+		}
+	}
+
+	return stats
+}
+
+// errorReason removes from an error message the details specific to a read,
+// like the tags between parentheses.
+func errorReason(message string) string {
+	if i := strings.Index(message, " ("); i >= 0 {
+		return message[:i]
+	}
+	return message
+}
+
+func stringAttribute(sequence *obiseq.BioSequence, key string) string {
+	value, _ := sequence.GetStringAttribute(key)
+	return value
+}
+
+// Add records the demultiplexing of a sequence, as annotated by
+// ExtractMultiBarcode, or ExtractIndexedMultiBarcode for the indexed
+// libraries. Each sequence is weighted by its count.
+func (stats *DemultiplexStats) Add(sequence *obiseq.BioSequence) {
+	count := sequence.Count()
+
+	reason := ""
+	if sequence.HasAttribute("obimultiplex_error") {
+		reason = errorReason(stringAttribute(sequence, "obimultiplex_error"))
+	}
+
+	key := markerKey{
+		primers: PrimerPair{
+			Forward: stringAttribute(sequence, "obimultiplex_forward_primer"),
+			Reverse: stringAttribute(sequence, "obimultiplex_reverse_primer"),
+		},
+	}
+
+	if index, ok := sequence.GetStringAttribute("obimultiplex_index"); ok {
+		key.index, _ = ParseIndexPair(index)
+	}
+
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	stats.reads += count
+
+	marker, ok := stats.markers[key]
+	if !ok {
+		stats.errors[reason] += count
+		return
+	}
+
+	if reason != "" {
+		marker.unassigned += count
+		marker.errors[reason] += count
+	} else {
+		marker.assigned += count
+	}
+
+	if n, ok := sequence.GetIntAttribute("obimultiplex_forward_error"); ok {
+		marker.forwardMismatches[n] += count
+	}
+
+	if n, ok := sequence.GetIntAttribute("obimultiplex_reverse_error"); ok {
+		marker.reverseMismatches[n] += count
+	}
+
+	if n, ok := sequence.GetIntAttribute("obimultiplex_forward_tag_dist"); ok {
+		marker.forwardTagDist[n] += count
+	}
+
+	if n, ok := sequence.GetIntAttribute("obimultiplex_reverse_tag_dist"); ok {
+		marker.reverseTagDist[n] += count
+	}
+
+	tags := TagPair{
+		Forward: stringAttribute(sequence, "obimultiplex_forward_proposed_tag"),
+		Reverse: stringAttribute(sequence, "obimultiplex_reverse_proposed_tag"),
+	}
+	marker.tagPairs[tags] += count
+}
+
+// AddSlice records the demultiplexing of a slice of sequences.
+func (stats *DemultiplexStats) AddSlice(sequences obiseq.BioSequenceSlice) {
+	for _, sequence := range sequences {
+		stats.Add(sequence)
+	}
+}
+
+// displayTag represents a missing tag by a dash, as in the ngsfilter files.
+func displayTag(tag string) string {
+	if tag == "" {
+		return "-"
+	}
+	return tag
+}
+
+// Report builds the summary of the recorded demultiplexing results.
+func (stats *DemultiplexStats) Report() DemultiplexReport {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	report := DemultiplexReport{
+		Reads:   stats.reads,
+		Errors:  make(map[string]int),
+		Markers: make([]MarkerReport, 0, len(stats.markers)),
+	}
+
+	for reason, count := range stats.errors {
+		report.Errors[reason] += count
+		report.Unassigned += count
+	}
+
+	libraries := stats.library.Libraries()
+
+	for key, marker := range stats.markers {
+		primers := key.primers
+		declared := libraries[key.index].Markers[primers].samples
+
+		mr := MarkerReport{
+			Index:             key.index.String(),
+			ForwardPrimer:     primers.Forward,
+			ReversePrimer:     primers.Reverse,
+			Assigned:          marker.assigned,
+			Unassigned:        marker.unassigned,
+			Samples:           make([]SampleStats, 0, len(declared)),
+			Errors:            marker.errors,
+			ForwardMismatches: marker.forwardMismatches,
+			ReverseMismatches: marker.reverseMismatches,
+			ForwardTagDist:    marker.forwardTagDist,
+			ReverseTagDist:    marker.reverseTagDist,
+		}
+
+		report.Assigned += marker.assigned
+		report.Unassigned += marker.unassigned
+		for reason, count := range marker.errors {
+			report.Errors[reason] += count
+		}
+
+		forwards := make([]string, 0)
+		reverses := make([]string, 0)
+
+		for tags, pcr := range declared {
+			mr.Samples = append(mr.Samples, SampleStats{
+				Experiment: pcr.Experiment,
+				Sample:     pcr.Sample,
+				ForwardTag: displayTag(tags.Forward),
+				ReverseTag: displayTag(tags.Reverse),
+				Reads:      marker.tagPairs[tags],
+			})
+
+			if !slices.Contains(forwards, tags.Forward) {
+				forwards = append(forwards, tags.Forward)
+			}
+			if !slices.Contains(reverses, tags.Reverse) {
+				reverses = append(reverses, tags.Reverse)
+			}
+		}
+
+		slices.SortFunc(mr.Samples, func(a, b SampleStats) int {
+			return cmp.Or(cmp.Compare(a.Experiment, b.Experiment), cmp.Compare(a.Sample, b.Sample))
+		})
+		slices.Sort(forwards)
+		slices.Sort(reverses)
+
+		matrix := TagMatrix{
+			ForwardTags: make([]string, len(forwards)),
+			ReverseTags: make([]string, len(reverses)),
+			Counts:      make([][]int, len(forwards)),
+			Samples:     make([][]string, len(forwards)),
+		}
+
+		for j, reverse := range reverses {
+			matrix.ReverseTags[j] = displayTag(reverse)
+		}
+
+		for i, forward := range forwards {
+			matrix.ForwardTags[i] = displayTag(forward)
+			matrix.Counts[i] = make([]int, len(reverses))
+			matrix.Samples[i] = make([]string, len(reverses))
+
+			for j, reverse := range reverses {
+				tags := TagPair{forward, reverse}
+				count := marker.tagPairs[tags]
+				matrix.Counts[i][j] = count
+
+				if pcr, ok := declared[tags]; ok {
+					matrix.Samples[i][j] = pcr.Sample
+					mr.TagJumps.UsedCombinations++
+					mr.TagJumps.UsedReads += count
+				} else {
+					mr.TagJumps.UnusedCombinations++
+					mr.TagJumps.UnusedReads += count
+				}
+			}
+		}
+
+		jumps := &mr.TagJumps
+		if total := jumps.UsedReads + jumps.UnusedReads; total > 0 {
+			jumps.UnusedFraction = float64(jumps.UnusedReads) / float64(total)
+		}
+		if jumps.UsedCombinations > 0 {
+			jumps.MeanUsedReads = float64(jumps.UsedReads) / float64(jumps.UsedCombinations)
+		}
+		if jumps.UnusedCombinations > 0 {
+			jumps.MeanUnusedReads = float64(jumps.UnusedReads) / float64(jumps.UnusedCombinations)
+		}
+
+		mr.TagMatrix = matrix
+		report.Markers = append(report.Markers, mr)
+	}
+
+	slices.SortFunc(report.Markers, func(a, b MarkerReport) int {
+		return cmp.Or(cmp.Compare(a.Index, b.Index),
+			cmp.Compare(a.ForwardPrimer, b.ForwardPrimer),
+			cmp.Compare(a.ReversePrimer, b.ReversePrimer))
+	})
+
+	return report
+}
+
+// WriteReport writes the summary of the recorded demultiplexing results
+// to a file in JSON format.
+//
+// Parameters:
+//   - filename: The name of the file.
+//
+// Returns:
+//   - An error if the file cannot be written.
+func (stats *DemultiplexStats) WriteReport(filename string) error {
+	output, err := json.MarshalIndent(stats.Report(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, append(output, '\n'), 0o644)
+}
@@ -0,0 +1,152 @@
+package obingslibrary
+
+import (
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+func TestDemultiplexStats(t *testing.T) {
+	rng := rand.New(rand.NewSource(41))
+
+	library := longReadLibrary(t, map[string]TagPair{
+		"s1": {"acgatcag", "gtcgtaga"},
+		"s2": {"tgcaggta", "gtcgtaga"},
+		"s3": {"acgatcag", "ctatgcgt"},
+	})
+
+	stats := NewDemultiplexStats(library)
+
+	// The reads of each tag combination, the last one being unused
+	reads := []struct {
+		tags  TagPair
+		count int
+	}{
+		{TagPair{"acgatcag", "gtcgtaga"}, 10},
+		{TagPair{"tgcaggta", "gtcgtaga"}, 6},
+		{TagPair{"acgatcag", "ctatgcgt"}, 4},
+		{TagPair{"tgcaggta", "ctatgcgt"}, 2},
+	}
+
+	barcode := randomDNA(rng, 150)
+	for _, read := range reads {
+		sequence := obiseq.NewBioSequence("read",
+			[]byte(randomDNA(rng, 40)+longReadAmplicon(read.tags, barcode)+randomDNA(rng, 40)), "")
+		sequence.SetCount(read.count)
+
+		results, err := library.ExtractMultiBarcode(sequence)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats.AddSlice(results)
+	}
+
+	noise, err := library.ExtractMultiBarcode(obiseq.NewBioSequence("noise", []byte(randomDNA(rng, 200)), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats.AddSlice(noise)
+
+	report := stats.Report()
+
+	if report.Reads != 23 || report.Assigned != 20 || report.Unassigned != 3 {
+		t.Errorf("report counts %d reads, %d assigned and %d unassigned, expected 23, 20 and 3",
+			report.Reads, report.Assigned, report.Unassigned)
+	}
+
+	errors := map[string]int{
+		"Cannot associate sample to the tag pair": 2,
+		"No barcode identified":                   1,
+	}
+	if !reflect.DeepEqual(report.Errors, errors) {
+		t.Errorf("errors are %v, expected %v", report.Errors, errors)
+	}
+
+	if len(report.Markers) != 1 {
+		t.Fatalf("%d markers reported, expected 1", len(report.Markers))
+	}
+
+	marker := report.Markers[0]
+
+	if marker.Assigned != 20 || marker.Unassigned != 2 {
+		t.Errorf("marker counts %d assigned and %d unassigned reads, expected 20 and 2",
+			marker.Assigned, marker.Unassigned)
+	}
+
+	samples := []SampleStats{
+		{"test", "s1", "acgatcag", "gtcgtaga", 10},
+		{"test", "s2", "tgcaggta", "gtcgtaga", 6},
+		{"test", "s3", "acgatcag", "ctatgcgt", 4},
+	}
+	if !reflect.DeepEqual(marker.Samples, samples) {
+		t.Errorf("samples are %v, expected %v", marker.Samples, samples)
+	}
+
+	matrix := TagMatrix{
+		ForwardTags: []string{"acgatcag", "tgcaggta"},
+		ReverseTags: []string{"ctatgcgt", "gtcgtaga"},
+		Counts:      [][]int{{4, 10}, {2, 6}},
+		Samples:     [][]string{{"s3", "s1"}, {"", "s2"}},
+	}
+	if !reflect.DeepEqual(marker.TagMatrix, matrix) {
+		t.Errorf("tag matrix is %v, expected %v", marker.TagMatrix, matrix)
+	}
+
+	jumps := TagJumpStats{
+		UsedCombinations:   3,
+		UnusedCombinations: 1,
+		UsedReads:          20,
+		UnusedReads:        2,
+		UnusedFraction:     2.0 / 22.0,
+		MeanUsedReads:      20.0 / 3.0,
+		MeanUnusedReads:    2,
+	}
+	if marker.TagJumps != jumps {
+		t.Errorf("tag jumps are %+v, expected %+v", marker.TagJumps, jumps)
+	}
+
+	if marker.ForwardTagDist[0] != 22 || marker.ReverseTagDist[0] != 22 {
+		t.Errorf("tag distances are %v and %v, expected 22 exact tags",
+			marker.ForwardTagDist, marker.ReverseTagDist)
+	}
+
+	// The report is saved as it is built
+	filename := filepath.Join(t.TempDir(), "report.json")
+	if err := stats.WriteReport(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var saved DemultiplexReport
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(saved, report) {
+		t.Errorf("saved report is %+v, expected %+v", saved, report)
+	}
+}
+
+// A marker without unused tag combinations reports no tag jump.
+func TestDemultiplexStatsFullMatrix(t *testing.T) {
+	library := longReadLibrary(t, map[string]TagPair{
+		"s1": {"acgatcag", "gtcgtaga"},
+		"s2": {"tgcaggta", "gtcgtaga"},
+	})
+
+	report := NewDemultiplexStats(library).Report()
+	jumps := report.Markers[0].TagJumps
+
+	if jumps != (TagJumpStats{UsedCombinations: 2}) {
+		t.Errorf("tag jumps are %+v, expected 2 used combinations only", jumps)
+	}
+}
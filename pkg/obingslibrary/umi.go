@@ -0,0 +1,239 @@
+package obingslibrary
+
+import (
+	"fmt"
+	"strings"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// CheckUMIPattern checks the pattern describing a unique molecular
+// identifier (UMI). The n symbols of the pattern are the random positions
+// constituting the UMI. The other symbols (a, c, g or t) are anchors that
+// must be found at their position in the read.
+//
+// Parameters:
+//   - pattern: The UMI pattern, e.g. nnnnnnnn or nnnnannnn.
+//
+// Returns:
+//   - The pattern in lower case.
+//   - An error if the pattern is not valid.
+func CheckUMIPattern(pattern string) (string, error) {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+
+	if strings.Trim(pattern, "acgtn") != "" {
+		return "", fmt.Errorf("invalid UMI pattern %q: only a, c, g, t and n symbols are allowed", pattern)
+	}
+
+	if !strings.Contains(pattern, "n") {
+		return "", fmt.Errorf("invalid UMI pattern %q: no random position (n)", pattern)
+	}
+
+	return pattern, nil
+}
+
+// matchUMI extracts a UMI from a fragment of a read following a pattern.
+// It returns the symbols of the read located at the n positions of the
+// pattern, and false if the fragment does not match the anchors of the
+// pattern.
+func matchUMI(fragment, pattern string) (string, bool) {
+	if len(fragment) != len(pattern) {
+		return "", false
+	}
+
+	fragment = strings.ToLower(fragment)
+	umi := make([]byte, 0, len(pattern))
+
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case 'n':
+			umi = append(umi, fragment[i])
+		default:
+			if fragment[i] != pattern[i] {
+				return "", false
+			}
+		}
+	}
+
+	return string(umi), true
+}
+
+func (marker *Marker) SetForwardUMI(offset int, pattern string) {
+	marker.Forward_umi_offset = offset
+	marker.Forward_umi_pattern = pattern
+}
+
+func (marker *Marker) SetReverseUMI(offset int, pattern string) {
+	marker.Reverse_umi_offset = offset
+	marker.Reverse_umi_pattern = pattern
+}
+
+func (marker *Marker) SetUMI(offset int, pattern string) {
+	marker.SetForwardUMI(offset, pattern)
+	marker.SetReverseUMI(offset, pattern)
+}
+
+// HasUMI returns true if a UMI is declared for one of the primers of the
+// marker.
+func (marker *Marker) HasUMI() bool {
+	return marker.Forward_umi_pattern != "" || marker.Reverse_umi_pattern != ""
+}
+
+// beginUMIExtractor extracts the UMI located on the 5' side of the primer
+// matching the read at position begin. The UMI ends offset nucleotides
+// before the primer.
+func (marker *Marker) beginUMIExtractor(
+	sequence *obiseq.BioSequence,
+	begin int,
+	forward bool) (string, bool) {
+
+	offset := marker.Forward_umi_offset
+	pattern := marker.Forward_umi_pattern
+
+	if !forward {
+		offset = marker.Reverse_umi_offset
+		pattern = marker.Reverse_umi_pattern
+	}
+
+	fe := begin - offset
+	fb := fe - len(pattern)
+	if fb < 0 {
+		return "", false
+	}
+
+	return matchUMI(sequence.String()[fb:fe], pattern)
+}
+
+// endUMIExtractor extracts the UMI located on the 3' side of the reverse
+// complemented primer ending at position end. The UMI is reverse
+// complemented to be read in the orientation of the primer.
+func (marker *Marker) endUMIExtractor(
+	sequence *obiseq.BioSequence,
+	end int,
+	forward bool) (string, bool) {
+
+	offset := marker.Reverse_umi_offset
+	pattern := marker.Reverse_umi_pattern
+
+	if !forward {
+		offset = marker.Forward_umi_offset
+		pattern = marker.Forward_umi_pattern
+	}
+
+	fe := end + offset + len(pattern)
+	if fe > sequence.Len() {
+		return "", false
+	}
+
+	umi_seq, err := sequence.Subsequence(end+offset, fe, false)
+	if err != nil {
+		return "", false
+	}
+	defer umi_seq.Recycle()
+
+	return matchUMI(umi_seq.ReverseComplement(true).String(), pattern)
+}
+
+// UMIExtractor extracts the UMIs declared for the primers of a marker from
+// a read. They are stored in the obimultiplex_forward_umi and
+// obimultiplex_reverse_umi attributes, and their concatenation in the umi
+// attribute.
+//
+// Parameters:
+//   - sequence: The read.
+//   - annotations: The annotations of the barcode extracted from the read.
+//   - primers: The primers of the marker.
+//   - begin: The position of the 5' end of the first primer match.
+//   - end: The position of the 3' end of the second primer match.
+//   - forward: True if the first primer match is the forward primer.
+//
+// Returns:
+//   - False if a declared UMI cannot be extracted from the read.
+func (library *NGSLibrary) UMIExtractor(
+	sequence *obiseq.BioSequence,
+	annotations obiseq.Annotation,
+	primers PrimerPair,
+	begin, end int,
+	forward bool) bool {
+
+	marker, ok := library.Markers[primers]
+
+	if !ok || !marker.HasUMI() {
+		return true
+	}
+
+	beginHasUMI := marker.Forward_umi_pattern != ""
+	endHasUMI := marker.Reverse_umi_pattern != ""
+	if !forward {
+		beginHasUMI, endHasUMI = endHasUMI, beginHasUMI
+	}
+
+	forward_umi, reverse_umi := "", ""
+	ok = true
+
+	if beginHasUMI {
+		umi, found := marker.beginUMIExtractor(sequence, begin, forward)
+		forward_umi = umi
+		ok = ok && found
+	}
+
+	if endHasUMI {
+		umi, found := marker.endUMIExtractor(sequence, end, forward)
+		reverse_umi = umi
+		ok = ok && found
+	}
+
+	if !forward {
+		forward_umi, reverse_umi = reverse_umi, forward_umi
+	}
+
+	if !ok {
+		return false
+	}
+
+	if forward_umi != "" {
+		annotations["obimultiplex_forward_umi"] = forward_umi
+	}
+
+	if reverse_umi != "" {
+		annotations["obimultiplex_reverse_umi"] = reverse_umi
+	}
+
+	annotations["umi"] = forward_umi + reverse_umi
+
+	return true
+}
+
+func (library *NGSLibrary) SetForwardUMI(offset int, pattern string) {
+	for _, marker := range library.Markers {
+		marker.SetForwardUMI(offset, pattern)
+	}
+}
+
+func (library *NGSLibrary) SetReverseUMI(offset int, pattern string) {
+	for _, marker := range library.Markers {
+		marker.SetReverseUMI(offset, pattern)
+	}
+}
+
+func (library *NGSLibrary) SetUMI(offset int, pattern string) {
+	library.SetForwardUMI(offset, pattern)
+	library.SetReverseUMI(offset, pattern)
+}
+
+func (library *NGSLibrary) SetUMIFor(primer string, offset int, pattern string) {
+	primer = strings.ToLower(primer)
+	primers, ok := library.Primers[primer]
+
+	if ok {
+		marker, ok := library.Markers[primers]
+
+		if ok {
+			if primer == primers.Forward {
+				marker.SetForwardUMI(offset, pattern)
+			} else {
+				marker.SetReverseUMI(offset, pattern)
+			}
+		}
+	}
+}
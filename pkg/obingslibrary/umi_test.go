@@ -0,0 +1,126 @@
+package obingslibrary
+
+import (
+	"strings"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+func TestMatchUMI(t *testing.T) {
+	tests := []struct {
+		fragment, pattern string
+		umi               string
+		ok                bool
+	}{
+		{"acgtacgt", "nnnnnnnn", "acgtacgt", true},
+		{"ACGTACGT", "nnnnnnnn", "acgtacgt", true},
+		{"acgtacgt", "nnntnnnn", "acgacgt", true},
+		{"acgtacgt", "nnnannnn", "", false},
+		{"acgtacg", "nnnnnnnn", "", false},
+		{"acgtacgta", "nnnnnnnn", "", false},
+		{"ttacgtgg", "ttnnnngg", "acgt", true},
+	}
+
+	for _, test := range tests {
+		umi, ok := matchUMI(test.fragment, test.pattern)
+		if umi != test.umi || ok != test.ok {
+			t.Errorf("UMI of %s with pattern %s is (%q,%v), expected (%q,%v)",
+				test.fragment, test.pattern, umi, ok, test.umi, test.ok)
+		}
+	}
+}
+
+func TestUMIExtractor(t *testing.T) {
+	const (
+		forwardUMI = "acgtcagt"
+		reverseUMI = "ttgacgca"
+		spacer     = "gg"
+		insert     = "atatatcgcgcgatatatcgcgcgatatatcgcgcg"
+	)
+
+	library := MakeNGSLibrary()
+	marker, _ := library.GetMarker(_TestForwardPrimer, _TestReversePrimer)
+	primers := PrimerPair{_TestForwardPrimer, _TestReversePrimer}
+
+	// The read in the orientation of the forward primer
+	read := forwardUMI + spacer + _TestForwardPrimer + insert +
+		reverseComplement(reverseUMI+spacer+_TestReversePrimer)
+	begin := len(forwardUMI + spacer)
+	end := len(read) - len(reverseUMI+spacer)
+
+	tests := []struct {
+		name             string
+		forward, reverse string
+		read             string
+		begin, end       int
+		direct           bool
+		annotations      map[string]string
+		ok               bool
+	}{
+		{"forward read", "nnnnnnnn", "nnnnnnnn", read, begin, end, true,
+			map[string]string{
+				"obimultiplex_forward_umi": forwardUMI,
+				"obimultiplex_reverse_umi": reverseUMI,
+				"umi":                      forwardUMI + reverseUMI,
+			}, true},
+		{"reverse read", "nnnnnnnn", "nnnnnnnn", reverseComplement(read),
+			len(read) - end, len(read) - begin, false,
+			map[string]string{
+				"obimultiplex_forward_umi": forwardUMI,
+				"obimultiplex_reverse_umi": reverseUMI,
+				"umi":                      forwardUMI + reverseUMI,
+			}, true},
+		{"forward UMI only", "nnntnnnn", "", read, begin, end, true,
+			map[string]string{
+				"obimultiplex_forward_umi": "acgcagt",
+				"umi":                      "acgcagt",
+			}, true},
+		{"reverse UMI only", "", "nnnnnnnn", reverseComplement(read),
+			len(read) - end, len(read) - begin, false,
+			map[string]string{
+				"obimultiplex_reverse_umi": reverseUMI,
+				"umi":                      reverseUMI,
+			}, true},
+		{"anchor mismatch", "nnnannnn", "nnnnnnnn", read, begin, end, true,
+			map[string]string{}, false},
+		{"UMI before the read", "nnnnnnnnnn", "", read, begin, end, true,
+			map[string]string{}, false},
+		{"UMI after the read", "", "nnnnnnnnnn", read, begin, end, true,
+			map[string]string{}, false},
+		{"no UMI", "", "", read, begin, end, true,
+			map[string]string{}, true},
+	}
+
+	for _, test := range tests {
+		marker.SetForwardUMI(len(spacer), test.forward)
+		marker.SetReverseUMI(len(spacer), test.reverse)
+
+		sequence := obiseq.NewBioSequence(test.name, []byte(test.read), "")
+		annotations := obiseq.GetAnnotation()
+
+		if ok := library.UMIExtractor(sequence, annotations, primers, test.begin, test.end, test.direct); ok != test.ok {
+			t.Errorf("%s: extraction is %v, expected %v", test.name, ok, test.ok)
+			continue
+		}
+
+		if len(annotations) != len(test.annotations) {
+			t.Errorf("%s: annotations are %v, expected %v", test.name, annotations, test.annotations)
+			continue
+		}
+
+		for key, value := range test.annotations {
+			if annotations[key] != value {
+				t.Errorf("%s: %s is %v, expected %s", test.name, key, annotations[key], value)
+			}
+		}
+	}
+
+	// The primers of an unknown marker have no UMI
+	sequence := obiseq.NewBioSequence("other", []byte(read), "")
+	annotations := obiseq.GetAnnotation()
+	other := PrimerPair{strings.ToUpper(_TestForwardPrimer), _TestReversePrimer}
+	if !library.UMIExtractor(sequence, annotations, other, begin, end, true) || len(annotations) != 0 {
+		t.Errorf("UMIs extracted for an unknown marker: %v", annotations)
+	}
+}
@@ -10,6 +10,7 @@ type _Options struct {
 	unidentified    string
 	allowedMismatch int
 	allowsIndel     bool
+	longReads       bool
 	withProgressBar bool
 	parallelWorkers int
 	batchSize       int
@@ -65,6 +66,16 @@ func OptionAllowedIndel(allowed bool) WithOption {
 	return f
 }
 
+// OptionLongReads sets whether the reads are long noisy reads, where the
+// primers are searched with a higher error rate including indels.
+func OptionLongReads(yes bool) WithOption {
+	f := WithOption(func(opt Options) {
+		opt.pointer.longReads = yes
+	})
+
+	return f
+}
+
 // OptionParallelWorkers sets how many search
 // jobs will be run in parallel.
 func OptionParallelWorkers(nworkers int) WithOption {
@@ -101,6 +112,11 @@ func (options Options) AllowsIndels() bool {
 	return options.pointer.allowsIndel
 }
 
+// LongReads returns true if the reads are processed as long noisy reads.
+func (options Options) LongReads() bool {
+	return options.pointer.longReads
+}
+
 func (options Options) WithProgressBar() bool {
 	return options.pointer.withProgressBar
 }
@@ -125,6 +141,7 @@ func MakeOptions(setters []WithOption) Options {
 		unidentified:    "",
 		allowedMismatch: 0,
 		allowsIndel:     false,
+		longReads:       false,
 		withProgressBar: false,
 		parallelWorkers: obidefault.ParallelWorkers(),
 		batchSize:       obidefault.BatchSize(),
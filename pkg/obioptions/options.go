@@ -99,8 +99,8 @@ func ProcessParsedOptions(options *getoptions.GetOpt, parseErr error) {
 	if options.Called("taxonomy") {
 		__defaut_taxonomy_mutex__.Lock()
 		defer __defaut_taxonomy_mutex__.Unlock()
-		taxonomy, err := obiformats.LoadTaxonomy(
-			obidefault.SelectedTaxonomy(),
+		taxonomy, err := obiformats.LoadTaxonomies(
+			obidefault.SelectedTaxonomies(),
 			!obidefault.AreAlternativeNamesSelected(),
 			SeqAsTaxa(),
 		)
@@ -202,14 +202,16 @@ func GenerateOptionParser(program string,
 
 func LoadTaxonomyOptionSet(options *getoptions.GetOpt, required, alternatiive bool) {
 	if required {
-		options.StringVar(obidefault.SelectedTaxonomyPtr(), "taxonomy", obidefault.SelectedTaxonomy(),
+		options.StringSliceVar(obidefault.SelectedTaxonomyPtr(), "taxonomy", 1, 1,
 			options.Alias("t"),
 			options.Required(),
-			options.Description("Path to the taxonomy database."))
+			options.Description("Path to the taxonomy database. "+
+				"Repeat the option to graft CSV taxonomy extensions onto the first taxonomy."))
 	} else {
-		options.StringVar(obidefault.SelectedTaxonomyPtr(), "taxonomy", obidefault.SelectedTaxonomy(),
+		options.StringSliceVar(obidefault.SelectedTaxonomyPtr(), "taxonomy", 1, 1,
 			options.Alias("t"),
-			options.Description("Path to the taxonomy database."))
+			options.Description("Path to the taxonomy database. "+
+				"Repeat the option to graft CSV taxonomy extensions onto the first taxonomy."))
 	}
 	if alternatiive {
 		options.BoolVar(obidefault.AlternativeNamesSelectedPtr(), "alternative-names", obidefault.AreAlternativeNamesSelected(),
@@ -259,3 +261,16 @@ func SetDebugOn() {
 func SetDebugOff() {
 	_Debug = false
 }
+
+// RankNormalizationOptionSet registers the options controlling the
+// normalisation of taxonomic lineages onto a canonical rank list.
+func RankNormalizationOptionSet(options *getoptions.GetOpt) {
+	options.BoolVar(obidefault.NormalizeRanksPtr(), "normalize-ranks", obidefault.NormalizeRanks(),
+		options.Description("Normalise taxonomic lineages onto the canonical rank list, "+
+			"inferring incertae sedis placeholder taxa for the missing ranks."))
+
+	options.StringVar(obidefault.CanonicalRanksPtr(), "canonical-ranks", "",
+		options.ArgName("RANK,RANK,..."),
+		options.Description("Comma separated list of the canonical ranks, from the root to the leaves "+
+			"(default: kingdom,phylum,class,order,family,genus,species). Implies --normalize-ranks."))
+}
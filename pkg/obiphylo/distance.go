@@ -0,0 +1,264 @@
+package obiphylo
+
+import (
+	"math"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidist"
+)
+
+// newLeaves creates a leaf named after each label of a distance matrix.
+func newLeaves(dm *obidist.DistMatrix) []*PhyloNode {
+	nodes := make([]*PhyloNode, dm.Size())
+	for i := range nodes {
+		nodes[i] = NewPhyloNode()
+		nodes[i].Name = dm.GetLabel(i)
+	}
+	return nodes
+}
+
+// UPGMA builds a rooted ultrametric tree from a distance matrix by average
+// linkage clustering. Leaves are named after the labels of the matrix.
+//
+// Parameters:
+//   - dm: The distance matrix.
+//
+// Returns:
+//   - The root of the tree, nil for an empty matrix.
+func UPGMA(dm *obidist.DistMatrix) *PhyloNode {
+	n := dm.Size()
+	if n == 0 {
+		return nil
+	}
+
+	nodes := newLeaves(dm)
+	sizes := make([]int, n)
+	heights := make([]float64, n)
+	distances := dm.ToFullMatrix()
+
+	for i := range sizes {
+		sizes[i] = 1
+	}
+
+	for active := n; active > 1; active-- {
+		bi, bj := -1, -1
+		for i := range nodes {
+			if nodes[i] == nil {
+				continue
+			}
+			for j := i + 1; j < n; j++ {
+				if nodes[j] != nil && (bi < 0 || distances[i][j] < distances[bi][bj]) {
+					bi, bj = i, j
+				}
+			}
+		}
+
+		height := distances[bi][bj] / 2
+		node := NewPhyloNode()
+		node.AddChild(nodes[bi], max(0, height-heights[bi]))
+		node.AddChild(nodes[bj], max(0, height-heights[bj]))
+
+		for k := range nodes {
+			if nodes[k] != nil && k != bi && k != bj {
+				d := (distances[bi][k]*float64(sizes[bi]) + distances[bj][k]*float64(sizes[bj])) /
+					float64(sizes[bi]+sizes[bj])
+				distances[bi][k], distances[k][bi] = d, d
+			}
+		}
+
+		nodes[bi] = node
+		sizes[bi] += sizes[bj]
+		heights[bi] = height
+		nodes[bj] = nil
+	}
+
+	return nodes[0]
+}
+
+// NeighborJoining builds a tree from a distance matrix with the neighbour
+// joining algorithm of Saitou and Nei. The tree is rooted by MidpointRoot.
+// Negative branch lengths are set to zero. Leaves
+// are named after the labels of the matrix.
+//
+// Parameters:
+//   - dm: The distance matrix.
+//
+// Returns:
+//   - The root of the tree, nil for an empty matrix.
+func NeighborJoining(dm *obidist.DistMatrix) *PhyloNode {
+	n := dm.Size()
+	if n == 0 {
+		return nil
+	}
+
+	nodes := newLeaves(dm)
+	distances := dm.ToFullMatrix()
+	active := make([]int, n)
+	for i := range active {
+		active[i] = i
+	}
+
+	sums := make([]float64, n)
+
+	for r := n; r > 2; r-- {
+		for _, i := range active {
+			sums[i] = 0
+			for _, j := range active {
+				sums[i] += distances[i][j]
+			}
+		}
+
+		bi, bj := -1, -1
+		best := 0.0
+		for x, i := range active {
+			for _, j := range active[x+1:] {
+				q := float64(r-2)*distances[i][j] - sums[i] - sums[j]
+				if bi < 0 || q < best {
+					bi, bj, best = i, j, q
+				}
+			}
+		}
+
+		dij := distances[bi][bj]
+		li := dij/2 + (sums[bi]-sums[bj])/float64(2*(r-2))
+		lj := dij - li
+
+		node := NewPhyloNode()
+		node.AddChild(nodes[bi], max(0, li))
+		node.AddChild(nodes[bj], max(0, lj))
+
+		for _, k := range active {
+			if k != bi && k != bj {
+				d := (distances[bi][k] + distances[bj][k] - dij) / 2
+				distances[bi][k], distances[k][bi] = d, d
+			}
+		}
+
+		nodes[bi] = node
+		nodes[bj] = nil
+
+		for x, k := range active {
+			if k == bj {
+				active = append(active[:x], active[x+1:]...)
+				break
+			}
+		}
+	}
+
+	if len(active) == 1 {
+		return nodes[active[0]]
+	}
+
+	i, j := active[0], active[1]
+	root := NewPhyloNode()
+	root.AddChild(nodes[i], distances[i][j]/2)
+	root.AddChild(nodes[j], distances[i][j]/2)
+
+	return MidpointRoot(root)
+}
+
+// MidpointRoot roots a tree at the middle of the longest path between two
+// leaves. The nodes of the tree are reused, the former root being removed
+// if it is left with two neighbours. Missing branch lengths are considered
+// as null.
+//
+// Parameters:
+//   - root: The root of the tree.
+//
+// Returns:
+//   - The new root of the tree.
+func MidpointRoot(root *PhyloNode) *PhyloNode {
+	if root == nil || root.IsLeaf() {
+		return root
+	}
+
+	// Undirected view of the tree
+	neighbours := make(map[*PhyloNode]map[*PhyloNode]float64)
+	link := func(a, b *PhyloNode, length float64) {
+		if math.IsNaN(length) || length < 0 {
+			length = 0
+		}
+		if neighbours[a] == nil {
+			neighbours[a] = make(map[*PhyloNode]float64)
+		}
+		if neighbours[b] == nil {
+			neighbours[b] = make(map[*PhyloNode]float64)
+		}
+		neighbours[a][b] = length
+		neighbours[b][a] = length
+	}
+
+	for child, parent := range root.Parents() {
+		link(parent, child, parent.Children[child])
+	}
+
+	// Farthest leaf from a node, with the path leading to it
+	farthest := func(start *PhyloNode) (*PhyloNode, float64, map[*PhyloNode]*PhyloNode) {
+		from := map[*PhyloNode]*PhyloNode{start: nil}
+		best, bestDistance := start, -1.0
+
+		var walk func(node *PhyloNode, distance float64)
+		walk = func(node *PhyloNode, distance float64) {
+			// Ties are broken on the names to get a deterministic root
+			if len(neighbours[node]) == 1 &&
+				(distance > bestDistance || (distance == bestDistance && node.Name < best.Name)) {
+				best, bestDistance = node, distance
+			}
+			for next, length := range neighbours[node] {
+				if next != from[node] {
+					from[next] = node
+					walk(next, distance+length)
+				}
+			}
+		}
+
+		walk(start, 0)
+		return best, bestDistance, from
+	}
+
+	a, _, _ := farthest(root)
+	b, diameter, from := farthest(a)
+
+	// Looks for the branch including the middle of the path from b to a
+	half := diameter / 2
+	node, covered := b, 0.0
+	for from[node] != nil && covered+neighbours[node][from[node]] < half {
+		covered += neighbours[node][from[node]]
+		node = from[node]
+	}
+
+	newRoot := NewPhyloNode()
+	if parent := from[node]; parent != nil {
+		length := neighbours[node][parent]
+		delete(neighbours[node], parent)
+		delete(neighbours[parent], node)
+		link(newRoot, node, half-covered)
+		link(newRoot, parent, length-(half-covered))
+	} else {
+		newRoot = node
+	}
+
+	// Orients the branches from the new root
+	var orient func(node, parent *PhyloNode)
+	orient = func(node, parent *PhyloNode) {
+		node.Children = make(map[*PhyloNode]float64)
+		for next, length := range neighbours[node] {
+			if next != parent {
+				node.Children[next] = length
+				orient(next, node)
+			}
+		}
+	}
+
+	orient(newRoot, nil)
+
+	// Removes the former root if it became a simple relay
+	if root != newRoot && len(root.Children) == 1 && root.Name == "" {
+		parent := newRoot.Parents()[root]
+		for child, length := range root.Children {
+			parent.Children[child] = length + parent.Children[root]
+		}
+		delete(parent.Children, root)
+	}
+
+	return newRoot
+}
@@ -0,0 +1,182 @@
+package obiphylo
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// newickParser holds the state of the parsing of a Newick tree.
+type newickParser struct {
+	text string
+	pos  int
+}
+
+// ParseNewick parses a tree in the Newick format.
+//
+// Labels can be quoted with single quotes. Underscores of unquoted labels
+// are kept as is, and comments between square brackets are ignored. Nodes
+// without branch length are attached to their parent with a NaN distance.
+//
+// Parameters:
+//   - text: The Newick representation of the tree, ended by a semicolon.
+//
+// Returns:
+//   - The root of the tree.
+//   - An error if the text is not a valid Newick tree.
+func ParseNewick(text string) (*PhyloNode, error) {
+	parser := &newickParser{text: text}
+
+	root, _, err := parser.subtree()
+	if err != nil {
+		return nil, err
+	}
+
+	parser.skip()
+	if !parser.consume(';') {
+		return nil, parser.errorf("';' expected")
+	}
+
+	return root, nil
+}
+
+// ReadNewick reads the first tree of a Newick file.
+func ReadNewick(filename string) (*PhyloNode, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	text := string(content)
+	if end := strings.IndexByte(text, ';'); end >= 0 {
+		text = text[:end+1]
+	}
+
+	root, err := ParseNewick(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return root, nil
+}
+
+func (p *newickParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("newick: position %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// skip moves after the spaces and the comments.
+func (p *newickParser) skip() {
+	for p.pos < len(p.text) {
+		switch c := p.text[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.pos++
+		case c == '[':
+			end := strings.IndexByte(p.text[p.pos:], ']')
+			if end < 0 {
+				p.pos = len(p.text)
+			} else {
+				p.pos += end + 1
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *newickParser) consume(c byte) bool {
+	if p.pos < len(p.text) && p.text[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// subtree parses a node with its children, its label and its branch
+// length.
+func (p *newickParser) subtree() (*PhyloNode, float64, error) {
+	node := NewPhyloNode()
+	p.skip()
+
+	if p.consume('(') {
+		for {
+			child, length, err := p.subtree()
+			if err != nil {
+				return nil, 0, err
+			}
+			node.AddChild(child, length)
+
+			p.skip()
+			if p.consume(')') {
+				break
+			}
+			if !p.consume(',') {
+				return nil, 0, p.errorf("',' or ')' expected")
+			}
+		}
+	}
+
+	label, err := p.label()
+	if err != nil {
+		return nil, 0, err
+	}
+	node.Name = label
+
+	length := math.NaN()
+	p.skip()
+	if p.consume(':') {
+		p.skip()
+		start := p.pos
+		for p.pos < len(p.text) && strings.IndexByte("(),:;[ \t\n\r", p.text[p.pos]) < 0 {
+			p.pos++
+		}
+
+		length, err = strconv.ParseFloat(p.text[start:p.pos], 64)
+		if err != nil {
+			return nil, 0, p.errorf("invalid branch length %q", p.text[start:p.pos])
+		}
+	}
+
+	return node, length, nil
+}
+
+// newickLabel quotes a label if it contains characters reserved by the
+// Newick format.
+func newickLabel(label string) string {
+	if !strings.ContainsAny(label, "(),:;[]' \t\n\r") {
+		return label
+	}
+
+	return "'" + strings.ReplaceAll(label, "'", "''") + "'"
+}
+
+// label parses an optional node label.
+func (p *newickParser) label() (string, error) {
+	p.skip()
+
+	if p.consume('\'') {
+		var label strings.Builder
+		for {
+			end := strings.IndexByte(p.text[p.pos:], '\'')
+			if end < 0 {
+				return "", p.errorf("unterminated quoted label")
+			}
+			label.WriteString(p.text[p.pos : p.pos+end])
+			p.pos += end + 1
+
+			// Two consecutive quotes represent a quote
+			if !p.consume('\'') {
+				return label.String(), nil
+			}
+			label.WriteByte('\'')
+		}
+	}
+
+	start := p.pos
+	for p.pos < len(p.text) && strings.IndexByte("(),:;[ \t\n\r", p.text[p.pos]) < 0 {
+		p.pos++
+	}
+
+	return p.text[start:p.pos], nil
+}
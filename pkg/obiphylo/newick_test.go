@@ -0,0 +1,140 @@
+package obiphylo
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidist"
+)
+
+func leafNames(node *PhyloNode) []string {
+	names := make([]string, 0)
+	for _, leaf := range node.Leaves() {
+		names = append(names, leaf.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func treeLength(node *PhyloNode) float64 {
+	length := 0.0
+	for child, distance := range node.Children {
+		if !math.IsNaN(distance) {
+			length += distance
+		}
+		length += treeLength(child)
+	}
+	return length
+}
+
+func TestParseNewick(t *testing.T) {
+	root, err := ParseNewick(" ((A:1,'B c':2.5)[comment]ab:1, C:3e-1)root;")
+	if err != nil {
+		t.Fatalf("cannot parse the tree: %v", err)
+	}
+
+	if names := leafNames(root); !slices.Equal(names, []string{"A", "B c", "C"}) {
+		t.Errorf("leaves are %v", names)
+	}
+
+	if root.Name != "root" {
+		t.Errorf("root is named %q", root.Name)
+	}
+
+	if length := treeLength(root); math.Abs(length-4.8) > 1e-9 {
+		t.Errorf("tree length is %f, expected 4.8", length)
+	}
+
+	reparsed, err := ParseNewick(root.Newick(0))
+	if err != nil {
+		t.Fatalf("cannot parse the written tree: %v", err)
+	}
+
+	if length := treeLength(reparsed); math.Abs(length-4.8) > 1e-9 {
+		t.Errorf("written tree length is %f, expected 4.8", length)
+	}
+
+	untyped, err := ParseNewick("(A,B);")
+	if err != nil {
+		t.Fatalf("cannot parse a tree without lengths: %v", err)
+	}
+	for child := range untyped.Children {
+		if !math.IsNaN(untyped.GetDistanceToChild(child)) {
+			t.Errorf("missing length of %s is not NaN", child.Name)
+		}
+	}
+
+	for _, invalid := range []string{"(A,B)", "(A,B;", "(A:x,B);", "('A,B);"} {
+		if _, err := ParseNewick(invalid); err == nil {
+			t.Errorf("%q is parsed without error", invalid)
+		}
+	}
+}
+
+// siblings returns true if two leaves have the same parent.
+func siblings(root *PhyloNode, a, b string) bool {
+	parents := root.Parents()
+	var pa, pb *PhyloNode
+	for child, parent := range parents {
+		switch child.Name {
+		case a:
+			pa = parent
+		case b:
+			pb = parent
+		}
+	}
+	return pa != nil && pa == pb
+}
+
+func TestTreeBuilders(t *testing.T) {
+	// Distances along the tree ((A:1,B:2):1.5,(C:1,D:3):0.5)
+	labels := []string{"A", "B", "C", "D"}
+	distances := [][]float64{
+		{0, 3, 4, 6},
+		{3, 0, 5, 7},
+		{4, 5, 0, 4},
+		{6, 7, 4, 0},
+	}
+
+	dm := obidist.NewDistMatrixWithLabels(labels)
+	for i := range labels {
+		for j := i + 1; j < len(labels); j++ {
+			dm.Set(i, j, distances[i][j])
+		}
+	}
+
+	for name, build := range map[string]func(*obidist.DistMatrix) *PhyloNode{
+		"nj":    NeighborJoining,
+		"upgma": UPGMA,
+	} {
+		root := build(dm)
+
+		if names := leafNames(root); !slices.Equal(names, labels) {
+			t.Errorf("%s: leaves are %v", name, names)
+		}
+
+		if !siblings(root, "A", "B") || !siblings(root, "C", "D") {
+			t.Errorf("%s: wrong topology %s", name, root.Newick(0))
+		}
+	}
+
+	if length := treeLength(NeighborJoining(dm)); math.Abs(length-9) > 1e-9 {
+		t.Errorf("neighbour joining tree length is %f, expected 9", length)
+	}
+}
+
+func TestNameInternalNodes(t *testing.T) {
+	root, _ := ParseNewick("((C,D),(A,B)x);")
+	root.NameInternalNodes("node")
+
+	if root.Name != "node1" || !siblings(root, "C", "D") {
+		t.Fatalf("root is named %s", root.Name)
+	}
+
+	for child := range root.Children {
+		if child.Name != "x" && child.Name != "node2" {
+			t.Errorf("internal node is named %s", child.Name)
+		}
+	}
+}
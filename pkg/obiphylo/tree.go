@@ -3,6 +3,7 @@ package obiphylo
 import (
 	"fmt"
 	"math"
+	"slices"
 	"strings"
 )
 
@@ -60,7 +61,7 @@ func (n *PhyloNode) Newick(level int) string {
 		result.WriteByte(')')
 	}
 	if n.Name != "" {
-		result.WriteString(n.Name)
+		result.WriteString(newickLabel(n.Name))
 	}
 
 	if level == 0 {
@@ -69,3 +70,98 @@ func (n *PhyloNode) Newick(level int) string {
 
 	return result.String()
 }
+
+// IsLeaf returns true if the node has no child.
+func (n *PhyloNode) IsLeaf() bool {
+	return len(n.Children) == 0
+}
+
+// Leaves returns the leaves of the subtree rooted at the node.
+func (n *PhyloNode) Leaves() []*PhyloNode {
+	if n.IsLeaf() {
+		return []*PhyloNode{n}
+	}
+
+	leaves := make([]*PhyloNode, 0)
+	for child := range n.Children {
+		leaves = append(leaves, child.Leaves()...)
+	}
+
+	return leaves
+}
+
+// Parents returns the parent of each node of the subtree rooted at the node.
+// The root is not a key of the returned map.
+func (n *PhyloNode) Parents() map[*PhyloNode]*PhyloNode {
+	parents := make(map[*PhyloNode]*PhyloNode)
+
+	var walk func(node *PhyloNode)
+	walk = func(node *PhyloNode) {
+		for child := range node.Children {
+			parents[child] = node
+			walk(child)
+		}
+	}
+
+	walk(n)
+
+	return parents
+}
+
+// NameInternalNodes names the unnamed internal nodes of the subtree rooted
+// at the node with a prefix followed by a number. Nodes are numbered in
+// preorder, the children of a node being ordered by the smallest leaf name
+// of their subtrees, so that the names do not depend on the storage order
+// of the children.
+func (n *PhyloNode) NameInternalNodes(prefix string) {
+	smallest := make(map[*PhyloNode]string)
+
+	var minLeaf func(node *PhyloNode) string
+	minLeaf = func(node *PhyloNode) string {
+		if node.IsLeaf() {
+			smallest[node] = node.Name
+			return node.Name
+		}
+
+		first := true
+		for child := range node.Children {
+			name := minLeaf(child)
+			if first || name < smallest[node] {
+				smallest[node] = name
+				first = false
+			}
+		}
+
+		return smallest[node]
+	}
+
+	minLeaf(n)
+
+	k := 0
+	var walk func(node *PhyloNode)
+	walk = func(node *PhyloNode) {
+		if node.IsLeaf() {
+			return
+		}
+
+		if node.Name == "" {
+			k++
+			node.Name = fmt.Sprintf("%s%d", prefix, k)
+		}
+
+		children := make([]*PhyloNode, 0, len(node.Children))
+		for child := range node.Children {
+			children = append(children, child)
+		}
+
+		slices.SortFunc(children, func(a, b *PhyloNode) int {
+			return strings.Compare(smallest[a], smallest[b])
+		})
+
+		for _, child := range children {
+			walk(child)
+		}
+	}
+
+	walk(n)
+}
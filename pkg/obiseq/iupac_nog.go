@@ -8,7 +8,7 @@ var _iupac = [26]byte{
 	//  m   n  o  p  q  r
 	3, 15, 0, 0, 0, 5,
 	//  s  t  u   v  w  x
-	6, 8, 8, 13, 9, 0,
+	6, 8, 8, 7, 9, 0,
 	//  y   z
 	10, 0,
 }
@@ -26,3 +26,26 @@ func SameIUPACNuc(a, b byte) bool {
 	}
 	return a == b
 }
+
+var _iupacDecode = []byte("-acmgrsvtwyhkdbn")
+
+// IUPACBits returns the set of nucleotides represented by an IUPAC symbol,
+// as a bit field where the bits 0 to 3 correspond respectively to the
+// nucleotides A, C, G, T. Symbols that are not nucleotides return 0.
+func IUPACBits(nuc byte) byte {
+	if (nuc >= 'A') && (nuc <= 'Z') {
+		nuc |= 32
+	}
+
+	if (nuc >= 'a') && (nuc <= 'z') {
+		return _iupac[nuc-'a']
+	}
+
+	return 0
+}
+
+// IUPACNuc returns the lower case IUPAC symbol of a set of nucleotides
+// encoded as by IUPACBits. The empty set is encoded by a dash.
+func IUPACNuc(bits byte) byte {
+	return _iupacDecode[bits&15]
+}
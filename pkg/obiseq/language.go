@@ -9,6 +9,8 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
 	"github.com/PaesslerAG/gval"
 )
@@ -129,6 +131,56 @@ import (
 
 // }
 
+// languageTaxon converts an argument of a taxonomic function to a taxon of the
+// default taxonomy. The argument can be a taxid or a sequence.
+func languageTaxon(arg interface{}) (*obitax.Taxon, error) {
+	taxonomy := obitax.DefaultTaxonomy()
+
+	if taxonomy == nil {
+		return nil, errors.New("obi: no taxonomy loaded")
+	}
+
+	switch value := arg.(type) {
+	case *BioSequence:
+		taxon := value.Taxon(taxonomy)
+		if taxon == nil {
+			return nil, fmt.Errorf("obi: sequence %s has no valid taxid", value.Id())
+		}
+		return taxon, nil
+	case *obitax.Taxon:
+		return value, nil
+	}
+
+	taxid, err := obiutils.InterfaceToString(arg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	taxon, _, err := taxonomy.Taxon(taxid)
+
+	return taxon, err
+}
+
+// languageTaxa converts the two first arguments of a taxonomic function to taxa.
+func languageTaxa(args []interface{}) (*obitax.Taxon, *obitax.Taxon, error) {
+	if len(args) < 2 {
+		return nil, nil, errors.New("obi: two taxa are expected")
+	}
+
+	t1, err := languageTaxon(args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t2, err := languageTaxon(args[1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return t1, t2, nil
+}
+
 var OBILang = gval.NewLanguage(
 	gval.Full(),
 	gval.Function("len", func(args ...interface{}) (interface{}, error) {
@@ -308,4 +360,46 @@ var OBILang = gval.NewLanguage(
 
 		return str[startIndex:endIndex], nil
 	}),
+	gval.Function("taxon_depth", func(args ...interface{}) (interface{}, error) {
+		if len(args) < 1 {
+			return nil, errors.New("obi: a taxon is expected")
+		}
+
+		taxon, err := languageTaxon(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return taxon.Depth(), nil
+	}),
+	gval.Function("taxonomic_distance", func(args ...interface{}) (interface{}, error) {
+		t1, t2, err := languageTaxa(args)
+		if err != nil {
+			return nil, err
+		}
+		return t1.TaxonomicDistance(t2)
+	}),
+	gval.Function("lca_depth", func(args ...interface{}) (interface{}, error) {
+		t1, t2, err := languageTaxa(args)
+		if err != nil {
+			return nil, err
+		}
+		return t1.LCADepth(t2)
+	}),
+	gval.Function("shared_rank", func(args ...interface{}) (interface{}, error) {
+		t1, t2, err := languageTaxa(args)
+		if err != nil {
+			return nil, err
+		}
+		return t1.SharedRank(t2, obidefault.CanonicalRanks()...)
+	}),
 )
+
+// ExtendOBILang adds functions to the expression language. It allows the
+// packages depending on obiseq to provide their own functions. It must be
+// called before any expression is compiled, usually from an init function.
+//
+// Parameters:
+//   - functions: The functions to add, as returned by gval.Function.
+func ExtendOBILang(functions ...gval.Language) {
+	OBILang = gval.NewLanguage(append([]gval.Language{OBILang}, functions...)...)
+}
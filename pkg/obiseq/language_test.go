@@ -0,0 +1,30 @@
+package obiseq
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// Taxonomic functions called without enough taxa report it, instead of
+// failing on an out of range argument.
+func TestLanguageTaxonomicFunctionsArguments(t *testing.T) {
+	expressions := []string{
+		`taxon_depth()`,
+		`taxonomic_distance("9606")`,
+		`lca_depth("9606")`,
+		`shared_rank("9606")`,
+	}
+
+	for _, expression := range expressions {
+		exp, err := OBILang.NewEvaluable(expression)
+		if err != nil {
+			t.Fatalf("cannot parse %s: %v", expression, err)
+		}
+
+		if _, err := exp(context.Background(), map[string]interface{}{}); err == nil ||
+			!strings.HasPrefix(err.Error(), "obi:") {
+			t.Errorf("%s returns the error %v", expression, err)
+		}
+	}
+}
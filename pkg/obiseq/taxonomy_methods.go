@@ -161,6 +161,33 @@ func (sequence *BioSequence) SetTaxonAtRank(taxonomy *obitax.Taxonomy, rank stri
 	return taxonAtRank
 }
 
+// SetNormalizedTaxonAtRank behaves like SetTaxonAtRank, but relies on a
+// RankNormalizer. When the rank is missing from the lineage of the sequence
+// taxon, the name of the inferred incertae sedis placeholder is used instead
+// of NA. The placeholder is not a taxon of the taxonomy, so its taxid
+// column is set to NA.
+func (sequence *BioSequence) SetNormalizedTaxonAtRank(normalizer *obitax.RankNormalizer, rank string) *obitax.Taxon {
+	var taxonAtRank *obitax.Taxon
+
+	taxon := sequence.Taxon(normalizer.Taxonomy())
+	if taxon != nil {
+		taxonAtRank = normalizer.TaxonAtRank(taxon, rank)
+		if taxonAtRank != nil {
+			if taxonAtRank.IsPlaceholder() {
+				sequence.SetAttribute(rank+"_taxid", "NA")
+			} else {
+				sequence.SetAttribute(rank+"_taxid", taxonAtRank.String())
+			}
+			sequence.SetAttribute(rank+"_name", taxonAtRank.ScientificName())
+		} else {
+			sequence.SetAttribute(rank+"_taxid", "NA")
+			sequence.SetAttribute(rank+"_name", "NA")
+		}
+	}
+
+	return taxonAtRank
+}
+
 // Setting the species of a sequence.
 func (sequence *BioSequence) SetSpecies(taxonomy *obitax.Taxonomy) *obitax.Taxon {
 	return sequence.SetTaxonAtRank(taxonomy, "species")
@@ -228,3 +255,14 @@ func (sequence *BioSequence) SetTaxonomicRank(taxonomy *obitax.Taxonomy) string
 
 	return rank
 }
+
+// SetNormalizedTaxonomicRank annotates the sequence with the canonical rank
+// of its taxon, as computed by the RankNormalizer.
+func (sequence *BioSequence) SetNormalizedTaxonomicRank(normalizer *obitax.RankNormalizer) string {
+	taxon := sequence.Taxon(normalizer.Taxonomy())
+	rank := normalizer.Rank(taxon)
+
+	sequence.SetAttribute("taxonomic_rank", rank)
+
+	return rank
+}
@@ -0,0 +1,47 @@
+package obiseq
+
+import (
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+func TestSetNormalizedTaxonAtRankPlaceholder(t *testing.T) {
+	taxonomy := obitax.NewTaxonomy("test", "taxon", obiutils.AsciiDigitSet)
+
+	nodes := [][4]string{
+		{"1", "1", "no rank", "root"},
+		{"2", "1", "kingdom", "Metazoa"},
+		{"3", "2", "phylum", "Chordata"},
+		{"4", "3", "clade", "Craniata"},
+		{"5", "4", "family", "Hominidae"},
+	}
+
+	if err := taxonomy.AddNodes(nodes); err != nil {
+		t.Fatal(err)
+	}
+
+	normalizer := taxonomy.NewRankNormalizer()
+	sequence := NewBioSequence("seq", []byte("acgt"), "")
+	sequence.SetAttribute("taxid", "5")
+
+	// Class and order are missing in the lineage of Hominidae
+	if taxon := sequence.SetNormalizedTaxonAtRank(normalizer, "class"); !taxon.IsPlaceholder() {
+		t.Fatalf("a placeholder is expected at rank class")
+	}
+
+	if taxid, _ := sequence.GetStringAttribute("class_taxid"); taxid != "NA" {
+		t.Errorf("class_taxid is %s, expected NA", taxid)
+	}
+
+	if name, _ := sequence.GetStringAttribute("class_name"); name != "Craniata incertae sedis" {
+		t.Errorf("class_name is %s, expected Craniata incertae sedis", name)
+	}
+
+	sequence.SetNormalizedTaxonAtRank(normalizer, "family")
+	taxid, _ := sequence.GetStringAttribute("family_taxid")
+	if taxon, _, err := taxonomy.Taxon(taxid); err != nil || taxon.ScientificName() != "Hominidae" {
+		t.Errorf("family_taxid %s is not resolved to Hominidae", taxid)
+	}
+}
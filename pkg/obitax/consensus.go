@@ -0,0 +1,70 @@
+package obitax
+
+import (
+	"fmt"
+)
+
+// Consensus computes a majority consensus of a weighted set of taxa. The
+// support of a taxon is the total weight of the taxa belonging to its
+// subtree. The consensus is the deepest taxon whose support reaches the
+// threshold fraction of the total weight. With a threshold of 1, the
+// consensus is the strict LCA of the taxa.
+//
+// Parameters:
+//   - taxa: The taxa associated with their weights.
+//   - threshold: The minimal fraction of the total weight supporting the consensus.
+//
+// Returns:
+//   - The consensus taxon.
+//   - The fraction of the total weight supporting the consensus.
+//   - An error if the set is empty or if the taxonomy is unrooted.
+func (taxonomy *Taxonomy) Consensus(taxa map[*TaxNode]int, threshold float64) (*Taxon, float64, error) {
+	taxonomy = taxonomy.OrDefault(true)
+
+	if len(taxa) == 0 {
+		return nil, 0, fmt.Errorf("cannot compute the consensus of an empty taxon set")
+	}
+
+	if !taxonomy.HasRoot() {
+		return nil, 0, fmt.Errorf("taxa belong to an unrooted taxonomy")
+	}
+
+	support := make(map[*TaxNode]int)
+	total := 0
+
+	for node, weight := range taxa {
+		total += weight
+		taxon := &Taxon{Taxonomy: taxonomy, Node: node}
+		for ancestor := range taxon.IPath() {
+			support[ancestor.Node] += weight
+		}
+	}
+
+	var best *Taxon
+	bestDepth := -1
+	bestSupport := 0
+
+	for node, weight := range support {
+		if float64(weight) < threshold*float64(total)-1e-9 {
+			continue
+		}
+
+		taxon := &Taxon{Taxonomy: taxonomy, Node: node}
+		depth := taxon.Depth()
+
+		if depth > bestDepth ||
+			(depth == bestDepth && (weight > bestSupport ||
+				(weight == bestSupport && *node.id < *best.Node.id))) {
+			best = taxon
+			bestDepth = depth
+			bestSupport = weight
+		}
+	}
+
+	if best == nil {
+		best = taxonomy.Root()
+		bestSupport = total
+	}
+
+	return best, float64(bestSupport) / float64(total), nil
+}
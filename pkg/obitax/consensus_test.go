@@ -0,0 +1,37 @@
+package obitax
+
+import (
+	"testing"
+)
+
+func TestConsensus(t *testing.T) {
+	taxonomy := buildGappedTaxonomy(t)
+
+	taxon := func(taxid string) *TaxNode {
+		tx, _, _ := taxonomy.Taxon(taxid)
+		return tx.Node
+	}
+
+	hits := map[*TaxNode]int{
+		taxon("7"): 3, // Homo sapiens
+		taxon("8"): 1, // Hominidae environmental samples
+	}
+
+	strict, support, err := taxonomy.Consensus(hits, 1.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strict.ScientificName() != "Hominidae" || support != 1.0 {
+		t.Errorf("strict consensus should be Hominidae (1.0), got %s (%f)", strict.ScientificName(), support)
+	}
+
+	majority, support, _ := taxonomy.Consensus(hits, 0.7)
+	if majority.ScientificName() != "Homo sapiens" || support != 0.75 {
+		t.Errorf("majority consensus should be Homo sapiens (0.75), got %s (%f)",
+			majority.ScientificName(), support)
+	}
+
+	if _, _, err := taxonomy.Consensus(map[*TaxNode]int{}, 0.5); err == nil {
+		t.Errorf("consensus of an empty set should fail")
+	}
+}
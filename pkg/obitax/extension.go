@@ -0,0 +1,148 @@
+package obitax
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AddExtensionCode registers a secondary taxid code in the taxonomy.
+// Taxa identified with this code (e.g. "motu:A12") can then be grafted
+// below any node of the taxonomy, without interfering with the taxids
+// of the main source.
+//
+// Parameters:
+//   - code: The taxid code used by the extension source.
+//
+// Returns:
+//   - An error if the code is empty or equal to the main taxonomy code.
+func (taxonomy *Taxonomy) AddExtensionCode(code string) error {
+	taxonomy = taxonomy.OrDefault(true)
+
+	if taxonomy.ids.IsExtension(code) {
+		return nil
+	}
+
+	return taxonomy.ids.AddExtension(code)
+}
+
+// ExtensionCodes returns the list of the secondary taxid codes registered
+// in the taxonomy.
+//
+// Returns:
+//   - A slice of strings containing the extension codes.
+func (taxonomy *Taxonomy) ExtensionCodes() []string {
+	taxonomy = taxonomy.OrDefault(false)
+
+	if taxonomy == nil {
+		return make([]string, 0)
+	}
+
+	return taxonomy.ids.Extensions()
+}
+
+// HasCode checks if a taxid code is accepted by the taxonomy, either as
+// its main code or as one of its extension codes.
+//
+// Parameters:
+//   - code: The taxid code to check.
+//
+// Returns:
+//   - A boolean indicating whether the code is known by the taxonomy.
+func (taxonomy *Taxonomy) HasCode(code string) bool {
+	taxonomy = taxonomy.OrDefault(false)
+
+	if taxonomy == nil {
+		return false
+	}
+
+	return code == taxonomy.code || taxonomy.ids.IsExtension(code)
+}
+
+// GraftTaxon adds a taxon coming from an extension source to the taxonomy.
+// The taxid must be prefixed by an extension code, which is registered
+// if needed. The parent can belong either to the main taxonomy or to an
+// extension. The existence of the parent is not checked here, because an
+// extension file may declare a child before its parent; use CheckLineage
+// once every taxon has been grafted.
+//
+// Parameters:
+//   - code: The extension code of the grafted taxon.
+//   - taxid: The identifier of the taxon, with or without its code prefix.
+//   - parent: The identifier of the parent taxon, including its code prefix
+//     when it does not belong to the main taxonomy.
+//   - rank: The rank of the taxon.
+//   - name: The scientific name of the taxon.
+//
+// Returns:
+//   - A pointer to the grafted Taxon.
+//   - An error if the taxon cannot be added.
+func (taxonomy *Taxonomy) GraftTaxon(code, taxid, parent, rank, name string) (*Taxon, error) {
+	taxonomy = taxonomy.OrDefault(true)
+
+	if err := taxonomy.AddExtensionCode(code); err != nil {
+		return nil, err
+	}
+
+	if taxid == "" {
+		return nil, errors.New("cannot graft a taxon with an empty taxid")
+	}
+
+	if !strings.HasPrefix(taxid, code+":") {
+		taxid = code + ":" + taxid
+	}
+
+	taxon, err := taxonomy.AddTaxon(taxid, parent, rank, false, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if name != "" {
+		taxon.SetName(name, "scientific name")
+	}
+
+	return taxon, nil
+}
+
+// CheckLineage verifies that the path from the taxon to the root of the
+// taxonomy is complete. It detects missing parents and cycles, which can
+// both be introduced by an erroneous extension file.
+//
+// Parameters:
+//   - taxon: A pointer to the Taxon to be checked.
+//
+// Returns:
+//   - An error describing the first inconsistency found, or nil.
+func (taxonomy *Taxonomy) CheckLineage(taxon *Taxon) error {
+	taxonomy = taxonomy.OrDefault(true)
+
+	if taxonomy.root == nil {
+		return fmt.Errorf("taxonomy %s has no root node", taxonomy.name)
+	}
+
+	maxdepth := taxonomy.nodes.Len()
+
+	for depth := 0; !taxon.IsRoot(); depth++ {
+		if depth > maxdepth {
+			return fmt.Errorf("cycle detected in the lineage of taxon %s", *taxon.Node.id)
+		}
+
+		parent := taxon.Parent()
+
+		if parent == nil {
+			return fmt.Errorf("parent %s of taxon %s is not part of the taxonomy",
+				*taxon.Node.parent, *taxon.Node.id)
+		}
+
+		taxon = parent
+	}
+
+	return nil
+}
+
+// IsExtension returns true if the taxon was grafted onto the taxonomy from
+// an extension source.
+func (taxon *Taxon) IsExtension() bool {
+	return taxon != nil && taxon.Node.IsExtension()
+}
@@ -0,0 +1,87 @@
+package obitax
+
+import (
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+func buildTestTaxonomy(t *testing.T) *Taxonomy {
+	taxonomy := NewTaxonomy("test", "taxon", obiutils.AsciiDigitSet)
+
+	nodes := [][4]string{
+		{"1", "1", "no rank", "root"},
+		{"2", "1", "genus", "Homo"},
+		{"3", "2", "species", "Homo sapiens"},
+	}
+
+	if err := taxonomy.AddNodes(nodes); err != nil {
+		t.Fatal(err)
+	}
+
+	return taxonomy
+}
+
+func TestGraftTaxon(t *testing.T) {
+	taxonomy := buildTestTaxonomy(t)
+
+	taxon, err := taxonomy.GraftTaxon("motu", "A1", "taxon:2", "species", "Homo sp. A")
+	if err != nil {
+		t.Fatalf("GraftTaxon failed: %v", err)
+	}
+
+	if !taxon.IsExtension() {
+		t.Errorf("grafted taxon should be an extension taxon")
+	}
+
+	if got := taxon.Node.CodedId("taxon"); got != "motu:A1" {
+		t.Errorf("expected coded id motu:A1, got %s", got)
+	}
+
+	found, _, err := taxonomy.Taxon("motu:A1")
+	if err != nil || !found.SameAs(taxon) {
+		t.Fatalf("grafted taxon cannot be retrieved: %v", err)
+	}
+
+	if genus := found.Genus(); genus == nil || genus.ScientificName() != "Homo" {
+		t.Errorf("grafted taxon does not belong to genus Homo")
+	}
+
+	// An extension taxid without its code must not be confused with a main taxid
+	if _, _, err := taxonomy.Taxon("A1"); err == nil {
+		t.Errorf("A1 should not be found without its extension code")
+	}
+
+	child, err := taxonomy.GraftTaxon("motu", "motu:B1", "motu:A1", "no rank", "Homo sp. A1")
+	if err != nil {
+		t.Fatalf("cannot graft a child of an extension taxon: %v", err)
+	}
+
+	if err := taxonomy.CheckLineage(child); err != nil {
+		t.Errorf("unexpected lineage error: %v", err)
+	}
+}
+
+func TestGraftTaxonErrors(t *testing.T) {
+	taxonomy := buildTestTaxonomy(t)
+
+	if _, err := taxonomy.GraftTaxon("taxon", "A1", "taxon:2", "species", "x"); err == nil {
+		t.Errorf("grafting with the main taxonomy code should fail")
+	}
+
+	orphan, err := taxonomy.GraftTaxon("motu", "C1", "motu:unknown", "species", "x")
+	if err != nil {
+		t.Fatalf("GraftTaxon failed: %v", err)
+	}
+
+	if err := taxonomy.CheckLineage(orphan); err == nil {
+		t.Errorf("missing parent should be detected")
+	}
+
+	a, _ := taxonomy.GraftTaxon("motu", "D1", "motu:D2", "no rank", "x")
+	taxonomy.GraftTaxon("motu", "D2", "motu:D1", "no rank", "y")
+
+	if err := taxonomy.CheckLineage(a); err == nil {
+		t.Errorf("cycle should be detected")
+	}
+}
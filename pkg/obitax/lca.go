@@ -2,11 +2,13 @@ package obitax
 
 import (
 	"fmt"
+	"slices"
 )
 
 // LCA computes the Lowest Common Ancestor (LCA) of two Taxon instances.
-// It traverses the paths from both taxa to the root and finds the deepest
-// common node in the taxonomy hierarchy.
+// The LCA is obtained in constant time from the Euler tour index of the
+// taxonomy, built on first use. Taxa that are not part of the index, such as
+// placeholder taxa, are handled by comparing their paths to the root.
 //
 // Parameters:
 //   - t2: A pointer to another Taxon instance to find the LCA with.
@@ -45,6 +47,22 @@ func (t1 *Taxon) LCA(t2 *Taxon) (*Taxon, error) {
 		return nil, fmt.Errorf("taxa belong to an unrooted taxonomy")
 	}
 
+	if index := t1.Taxonomy.lcaIndex(); index != nil {
+		if node, ok := index.lca(t1.Node, t2.Node); ok {
+			return &Taxon{
+				Taxonomy: t1.Taxonomy,
+				Node:     node,
+			}, nil
+		}
+	}
+
+	// At least one of the taxa is not connected to the root in the index
+	// (e.g. a placeholder taxon): fall back on the comparison of the paths.
+	return t1.lcaByPath(t2), nil
+}
+
+// lcaByPath computes the LCA of two taxa by walking their paths to the root.
+func (t1 *Taxon) lcaByPath(t2 *Taxon) *Taxon {
 	p1 := t1.Path()
 	p2 := t2.Path()
 
@@ -59,5 +77,98 @@ func (t1 *Taxon) LCA(t2 *Taxon) (*Taxon, error) {
 	return &Taxon{
 		Taxonomy: t1.Taxonomy,
 		Node:     p1.slice[i1+1],
-	}, nil
+	}
+}
+
+// Depth returns the number of edges between the taxon and the root of its
+// taxonomy. The root has a depth of 0.
+//
+// Returns:
+//   - The depth of the taxon, or -1 if the taxon is nil.
+func (taxon *Taxon) Depth() int {
+	if taxon == nil || taxon.Node == nil {
+		return -1
+	}
+
+	if index := taxon.Taxonomy.lcaIndex(); index != nil {
+		if depth, ok := index.nodeDepth(taxon.Node); ok {
+			return depth
+		}
+	}
+
+	return taxon.Path().Len() - 1
+}
+
+// LCADepth returns the depth of the lowest common ancestor of two taxa.
+//
+// Parameters:
+//   - t2: A pointer to another Taxon instance.
+//
+// Returns:
+//   - The number of edges between the root and the LCA of the two taxa.
+//   - An error if the LCA cannot be computed.
+func (t1 *Taxon) LCADepth(t2 *Taxon) (int, error) {
+	lca, err := t1.LCA(t2)
+
+	if err != nil {
+		return -1, err
+	}
+
+	return lca.Depth(), nil
+}
+
+// TaxonomicDistance returns the number of edges of the path joining two
+// taxa through their lowest common ancestor.
+//
+// Parameters:
+//   - t2: A pointer to another Taxon instance.
+//
+// Returns:
+//   - The number of edges between the two taxa, 0 if they are identical.
+//   - An error if one of the taxa is nil or if their LCA cannot be computed.
+func (t1 *Taxon) TaxonomicDistance(t2 *Taxon) (int, error) {
+	if t1 == nil || t2 == nil {
+		return -1, fmt.Errorf("try to get distance with a nil taxon")
+	}
+
+	lca, err := t1.LCA(t2)
+
+	if err != nil {
+		return -1, err
+	}
+
+	return t1.Depth() + t2.Depth() - 2*lca.Depth(), nil
+}
+
+// SharedRank returns the deepest canonical rank shared by two taxa, that is
+// the rank of the deepest ancestor of their LCA (including the LCA itself)
+// whose rank belongs to the canonical ranks. If no rank is provided,
+// DefaultCanonicalRanks is used.
+//
+// Parameters:
+//   - t2: A pointer to another Taxon instance.
+//   - ranks: The canonical ranks to consider.
+//
+// Returns:
+//   - The deepest shared canonical rank, or "no rank" if the taxa only
+//     share ancestors above every canonical rank.
+//   - An error if the LCA cannot be computed.
+func (t1 *Taxon) SharedRank(t2 *Taxon, ranks ...string) (string, error) {
+	lca, err := t1.LCA(t2)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(ranks) == 0 {
+		ranks = DefaultCanonicalRanks
+	}
+
+	for taxon := range lca.IPath() {
+		if slices.Contains(ranks, taxon.Rank()) {
+			return taxon.Rank(), nil
+		}
+	}
+
+	return "no rank", nil
 }
@@ -0,0 +1,222 @@
+package obitax
+
+import (
+	"math/bits"
+)
+
+// lcaBlockSize is the size of the blocks used to decompose the Euler tour
+// for the range minimum queries. A query scans at most two partial blocks,
+// the full blocks in between are resolved by the sparse table.
+const lcaBlockSize = 32
+
+// lcaIndex is a precomputed index allowing lowest common ancestor queries
+// in constant time. It relies on the reduction of the LCA problem to a range
+// minimum query (RMQ) over the depths of the Euler tour of the taxonomy.
+//
+// Fields:
+//   - tour: The nodes of the taxonomy in the order of the Euler tour.
+//   - depth: The depth of each node of the tour (the root has depth 0).
+//   - first: The position of the first occurrence of each node in the tour.
+//   - sparse: A sparse table over the block minima. sparse[k][b] is the tour
+//     position of the shallowest node among the 2^k blocks starting at block b.
+type lcaIndex struct {
+	tour   []*TaxNode
+	depth  []int32
+	first  map[*TaxNode]int32
+	sparse [][]int32
+}
+
+// newLCAIndex builds the LCA index of a taxonomy. Only the taxa connected
+// to the root are indexed. It returns nil if the taxonomy has no root.
+func newLCAIndex(taxonomy *Taxonomy) *lcaIndex {
+	root := taxonomy.root
+
+	if root == nil {
+		return nil
+	}
+
+	children := make(map[*string][]*TaxNode, taxonomy.nodes.Len())
+
+	for id, node := range taxonomy.nodes.set {
+		if id == node.id && node != root {
+			children[node.parent] = append(children[node.parent], node)
+		}
+	}
+
+	n := taxonomy.nodes.Len()
+	index := &lcaIndex{
+		tour:  make([]*TaxNode, 0, 2*n-1),
+		depth: make([]int32, 0, 2*n-1),
+		first: make(map[*TaxNode]int32, n),
+	}
+
+	type frame struct {
+		node  *TaxNode
+		child int
+	}
+
+	stack := []frame{{root, 0}}
+	index.first[root] = 0
+	index.tour = append(index.tour, root)
+	index.depth = append(index.depth, 0)
+
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		sons := children[top.node.id]
+
+		if top.child < len(sons) {
+			son := sons[top.child]
+			top.child++
+
+			if _, ok := index.first[son]; ok {
+				// Already visited, can only occur with a corrupted taxonomy
+				continue
+			}
+
+			index.first[son] = int32(len(index.tour))
+			index.tour = append(index.tour, son)
+			index.depth = append(index.depth, int32(len(stack)))
+			stack = append(stack, frame{son, 0})
+		} else {
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				index.tour = append(index.tour, stack[len(stack)-1].node)
+				index.depth = append(index.depth, int32(len(stack)-1))
+			}
+		}
+	}
+
+	index.buildSparseTable()
+
+	return index
+}
+
+// buildSparseTable computes the sparse table over the minima of the blocks
+// of the Euler tour.
+func (index *lcaIndex) buildSparseTable() {
+	nblocks := (len(index.tour) + lcaBlockSize - 1) / lcaBlockSize
+	levels := bits.Len(uint(nblocks))
+
+	index.sparse = make([][]int32, levels)
+
+	base := make([]int32, nblocks)
+	for b := range base {
+		start := b * lcaBlockSize
+		base[b] = index.scan(start, min(start+lcaBlockSize, len(index.tour))-1)
+	}
+	index.sparse[0] = base
+
+	for k := 1; k < levels; k++ {
+		width := 1 << k
+		previous := index.sparse[k-1]
+		level := make([]int32, nblocks-width+1)
+
+		for b := range level {
+			level[b] = index.shallowest(previous[b], previous[b+width/2])
+		}
+
+		index.sparse[k] = level
+	}
+}
+
+// shallowest returns the tour position, among i and j, of the node
+// with the smallest depth.
+func (index *lcaIndex) shallowest(i, j int32) int32 {
+	if index.depth[j] < index.depth[i] {
+		return j
+	}
+	return i
+}
+
+// scan returns the tour position of the shallowest node between the
+// positions from and to (both included) by a linear scan.
+func (index *lcaIndex) scan(from, to int) int32 {
+	best := int32(from)
+	for i := from + 1; i <= to; i++ {
+		if index.depth[i] < index.depth[best] {
+			best = int32(i)
+		}
+	}
+	return best
+}
+
+// rmq returns the tour position of the shallowest node between the
+// positions from and to (both included).
+func (index *lcaIndex) rmq(from, to int) int32 {
+	if from > to {
+		from, to = to, from
+	}
+
+	bfrom := from / lcaBlockSize
+	bto := to / lcaBlockSize
+
+	if bfrom == bto {
+		return index.scan(from, to)
+	}
+
+	best := index.shallowest(
+		index.scan(from, (bfrom+1)*lcaBlockSize-1),
+		index.scan(bto*lcaBlockSize, to),
+	)
+
+	if bto-bfrom > 1 {
+		l, r := bfrom+1, bto-1
+		k := bits.Len(uint(r-l+1)) - 1
+		best = index.shallowest(best, index.shallowest(
+			index.sparse[k][l],
+			index.sparse[k][r-(1<<k)+1],
+		))
+	}
+
+	return best
+}
+
+// lca returns the lowest common ancestor of two nodes. The boolean is false
+// if one of the nodes is not indexed.
+func (index *lcaIndex) lca(n1, n2 *TaxNode) (*TaxNode, bool) {
+	i, ok1 := index.first[n1]
+	j, ok2 := index.first[n2]
+
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+
+	return index.tour[index.rmq(int(i), int(j))], true
+}
+
+// nodeDepth returns the depth of a node. The boolean is false if the node
+// is not indexed.
+func (index *lcaIndex) nodeDepth(node *TaxNode) (int, bool) {
+	i, ok := index.first[node]
+
+	if !ok {
+		return 0, false
+	}
+
+	return int(index.depth[i]), true
+}
+
+// lcaIndex returns the LCA index of the taxonomy, building it on first use.
+// The index is discarded each time the topology of the taxonomy is modified.
+func (taxonomy *Taxonomy) lcaIndex() *lcaIndex {
+	if index := taxonomy.lcaindex.Load(); index != nil {
+		return index
+	}
+
+	taxonomy.lcalock.Lock()
+	defer taxonomy.lcalock.Unlock()
+
+	if index := taxonomy.lcaindex.Load(); index != nil {
+		return index
+	}
+
+	index := newLCAIndex(taxonomy)
+	taxonomy.lcaindex.Store(index)
+
+	return index
+}
+
+// invalidateLCAIndex discards the LCA index of the taxonomy.
+func (taxonomy *Taxonomy) invalidateLCAIndex() {
+	taxonomy.lcaindex.Store(nil)
+}
@@ -0,0 +1,134 @@
+package obitax
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+func buildRandomTaxonomy(t testing.TB, size int, seed int64) *Taxonomy {
+	taxonomy := NewTaxonomy("random", "taxon", obiutils.AsciiDigitSet)
+	rng := rand.New(rand.NewSource(seed))
+
+	if _, err := taxonomy.AddTaxon("1", "1", "no rank", true, false); err != nil {
+		t.Fatalf("cannot add root: %v", err)
+	}
+
+	for i := 2; i <= size; i++ {
+		parent := rng.Intn(i-1) + 1
+		if _, err := taxonomy.AddTaxon(fmt.Sprint(i), fmt.Sprint(parent), "no rank", false, false); err != nil {
+			t.Fatalf("cannot add taxon %d: %v", i, err)
+		}
+	}
+
+	return taxonomy
+}
+
+func TestLCAIndexMatchesPaths(t *testing.T) {
+	taxonomy := buildRandomTaxonomy(t, 3000, 42)
+	rng := rand.New(rand.NewSource(7))
+
+	for i := 0; i < 2000; i++ {
+		t1, _, _ := taxonomy.Taxon(fmt.Sprint(rng.Intn(3000) + 1))
+		t2, _, _ := taxonomy.Taxon(fmt.Sprint(rng.Intn(3000) + 1))
+
+		lca, err := t1.LCA(t2)
+		if err != nil {
+			t.Fatalf("LCA failed: %v", err)
+		}
+
+		expected := t1.lcaByPath(t2)
+		if lca.Node != expected.Node {
+			t.Fatalf("LCA(%s,%s): expected %s, got %s",
+				t1.String(), t2.String(), expected.String(), lca.String())
+		}
+
+		if depth := t1.Depth(); depth != t1.Path().Len()-1 {
+			t.Fatalf("depth of %s: expected %d, got %d", t1.String(), t1.Path().Len()-1, depth)
+		}
+
+		distance, _ := t1.TaxonomicDistance(t2)
+		if distance != t1.Path().Len()+t2.Path().Len()-2*expected.Path().Len() {
+			t.Fatalf("wrong distance between %s and %s: %d", t1.String(), t2.String(), distance)
+		}
+	}
+}
+
+func TestLCAIndexInvalidation(t *testing.T) {
+	taxonomy := buildRandomTaxonomy(t, 10, 1)
+	root := taxonomy.Root()
+
+	if d := root.Depth(); d != 0 {
+		t.Fatalf("root depth should be 0, got %d", d)
+	}
+
+	added, err := taxonomy.AddTaxon("11", "10", "no rank", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent, _, _ := taxonomy.Taxon("10")
+	if added.Depth() != parent.Depth()+1 {
+		t.Errorf("new taxon should be indexed after its insertion")
+	}
+
+	lca, _ := added.LCA(parent)
+	if lca.Node != parent.Node {
+		t.Errorf("LCA of a taxon and its parent should be the parent")
+	}
+}
+
+func TestTaxonomicDistance(t *testing.T) {
+	taxonomy := buildGappedTaxonomy(t)
+
+	species, _, _ := taxonomy.Taxon("7")
+	samples, _, _ := taxonomy.Taxon("8")
+	phylum, _, _ := taxonomy.Taxon("3")
+
+	if d, _ := species.TaxonomicDistance(species); d != 0 {
+		t.Errorf("distance to itself should be 0, got %d", d)
+	}
+
+	if d, _ := species.TaxonomicDistance(samples); d != 3 {
+		t.Errorf("expected distance 3, got %d", d)
+	}
+
+	if d, _ := samples.LCADepth(species); d != 4 {
+		t.Errorf("expected LCA depth 4, got %d", d)
+	}
+
+	if r, _ := species.SharedRank(samples); r != "family" {
+		t.Errorf("expected shared rank family, got %s", r)
+	}
+
+	// Craniata (clade) is the LCA, the deepest canonical rank above is phylum
+	order, _ := taxonomy.AddTaxon("9", "4", "order", false, false)
+	if r, _ := order.SharedRank(species, "kingdom", "phylum", "family"); r != "phylum" {
+		t.Errorf("expected shared rank phylum, got %s", r)
+	}
+
+	if r, _ := phylum.SharedRank(taxonomy.Root()); r != "no rank" {
+		t.Errorf("expected no rank, got %s", r)
+	}
+}
+
+func BenchmarkLCA(b *testing.B) {
+	taxonomy := buildRandomTaxonomy(b, 100000, 42)
+	rng := rand.New(rand.NewSource(7))
+	pairs := make([][2]*Taxon, 1024)
+
+	for i := range pairs {
+		pairs[i][0], _, _ = taxonomy.Taxon(fmt.Sprint(rng.Intn(100000) + 1))
+		pairs[i][1], _, _ = taxonomy.Taxon(fmt.Sprint(rng.Intn(100000) + 1))
+	}
+
+	pairs[0][0].LCA(pairs[0][1])
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		p := pairs[i%len(pairs)]
+		p[0].LCA(p[1])
+	}
+}
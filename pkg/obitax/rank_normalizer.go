@@ -0,0 +1,232 @@
+package obitax
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// IncertaeSedisCode is the taxid code of the placeholder taxa created by a
+// RankNormalizer when a canonical rank is missing in a lineage.
+const IncertaeSedisCode = "incertae_sedis"
+
+// DefaultCanonicalRanks is the default list of canonical ranks used to
+// normalise taxonomic lineages, ordered from the root to the leaves.
+var DefaultCanonicalRanks = []string{
+	"kingdom",
+	"phylum",
+	"class",
+	"order",
+	"family",
+	"genus",
+	"species",
+}
+
+// RankNormalizer maps the taxa of a taxonomy onto a list of canonical ranks.
+// When a canonical rank is missing in the lineage of a taxon (e.g. a family
+// directly attached to a "clade" node below a class), a placeholder taxon
+// named "<ancestor> incertae sedis" is inferred for that rank.
+//
+// Placeholder taxa use the IncertaeSedisCode taxid code. They are attached to
+// their parent, the deepest real ancestor above the missing rank, but they are
+// not inserted in the taxonomy: they are only reachable through the
+// normalizer, which allows a RankNormalizer to be shared by concurrent
+// workers without modifying the taxonomy. As the taxonomy cannot resolve
+// their taxids, only their names must be exported.
+//
+// Fields:
+//   - taxonomy: The taxonomy whose taxa are normalised.
+//   - ranks: The canonical ranks, ordered from the root to the leaves.
+//   - pranks: The innerized version of the canonical ranks.
+//   - placeholders: The placeholder taxa already built, indexed by taxid.
+//   - lock: A mutex protecting the placeholders map.
+type RankNormalizer struct {
+	taxonomy     *Taxonomy
+	ranks        []string
+	pranks       []*string
+	placeholders map[string]*Taxon
+	lock         sync.Mutex
+}
+
+// NewRankNormalizer creates a RankNormalizer for the taxonomy. The canonical
+// ranks must be provided from the root to the leaves. If no rank is provided,
+// DefaultCanonicalRanks is used.
+//
+// Parameters:
+//   - ranks: The canonical rank list.
+//
+// Returns:
+//   - A pointer to the new RankNormalizer.
+func (taxonomy *Taxonomy) NewRankNormalizer(ranks ...string) *RankNormalizer {
+	taxonomy = taxonomy.OrDefault(true)
+
+	if len(ranks) == 0 {
+		ranks = DefaultCanonicalRanks
+	}
+
+	normalizer := &RankNormalizer{
+		taxonomy:     taxonomy,
+		ranks:        slices.Clone(ranks),
+		pranks:       make([]*string, len(ranks)),
+		placeholders: make(map[string]*Taxon),
+	}
+
+	for i, r := range ranks {
+		normalizer.pranks[i] = taxonomy.ranks.Innerize(r)
+	}
+
+	return normalizer
+}
+
+// Taxonomy returns the taxonomy normalised by the normalizer.
+func (normalizer *RankNormalizer) Taxonomy() *Taxonomy {
+	return normalizer.taxonomy
+}
+
+// Ranks returns the canonical ranks of the normalizer, from the root to the leaves.
+func (normalizer *RankNormalizer) Ranks() []string {
+	return normalizer.ranks
+}
+
+// RankIndex returns the position of a rank in the canonical rank list,
+// or -1 if the rank is not canonical.
+func (normalizer *RankNormalizer) RankIndex(rank string) int {
+	return slices.Index(normalizer.ranks, rank)
+}
+
+// placeholder returns the placeholder taxon standing for the missing rank
+// below the anchor taxon, creating it if needed.
+func (normalizer *RankNormalizer) placeholder(anchor *Taxon, irank int) *Taxon {
+	anchorId := strings.ReplaceAll(*anchor.Node.id, ":", "_")
+	taxid := fmt.Sprintf("%s:%s_%s", IncertaeSedisCode, normalizer.ranks[irank], anchorId)
+
+	normalizer.lock.Lock()
+	defer normalizer.lock.Unlock()
+
+	if taxon, ok := normalizer.placeholders[taxid]; ok {
+		return taxon
+	}
+
+	name := normalizer.taxonomy.names.Innerize(anchor.ScientificName() + " incertae sedis")
+
+	taxon := &Taxon{
+		Taxonomy: normalizer.taxonomy,
+		Node: &TaxNode{
+			id:             &taxid,
+			parent:         anchor.Node.id,
+			rank:           normalizer.pranks[irank],
+			scientificname: name,
+		},
+	}
+
+	normalizer.placeholders[taxid] = taxon
+
+	return taxon
+}
+
+// Lineage returns the normalised lineage of a taxon. The returned slice has
+// one entry per canonical rank. Entries corresponding to ranks located below
+// the taxon are nil. Missing ranks above the deepest canonical rank of the
+// lineage are filled with placeholder taxa.
+//
+// Parameters:
+//   - taxon: The taxon to normalise.
+//
+// Returns:
+//   - A slice of taxa aligned on the canonical ranks, or nil if taxon is nil.
+func (normalizer *RankNormalizer) Lineage(taxon *Taxon) []*Taxon {
+	if taxon == nil {
+		return nil
+	}
+
+	path := taxon.Path().slice // from the taxon to the root
+	lineage := make([]*Taxon, len(normalizer.ranks))
+	position := make([]int, len(normalizer.ranks))
+	deepest := -1
+
+	for i, prank := range normalizer.pranks {
+		position[i] = -1
+		for j, node := range path {
+			if node.rank == prank {
+				position[i] = j
+				lineage[i] = &Taxon{Taxonomy: taxon.Taxonomy, Node: node}
+				deepest = i
+				break
+			}
+		}
+	}
+
+	for i := deepest - 1; i >= 0; i-- {
+		if lineage[i] == nil {
+			// The next defined canonical rank below the missing one
+			next := i + 1
+			for lineage[next] == nil || lineage[next].IsPlaceholder() {
+				next++
+			}
+
+			anchor := path[min(position[next]+1, len(path)-1)]
+			lineage[i] = normalizer.placeholder(
+				&Taxon{Taxonomy: taxon.Taxonomy, Node: anchor}, i)
+		}
+	}
+
+	return lineage
+}
+
+// TaxonAtRank returns the taxon of the normalised lineage corresponding to a
+// canonical rank. A placeholder taxon is returned if the rank is missing in
+// the lineage. Nil is returned if the rank is not canonical or if the taxon
+// is not resolved down to this rank.
+//
+// Parameters:
+//   - taxon: The taxon to normalise.
+//   - rank: A canonical rank.
+//
+// Returns:
+//   - The taxon at the requested rank, or nil.
+func (normalizer *RankNormalizer) TaxonAtRank(taxon *Taxon, rank string) *Taxon {
+	i := normalizer.RankIndex(rank)
+
+	if i < 0 || taxon == nil {
+		return nil
+	}
+
+	return normalizer.Lineage(taxon)[i]
+}
+
+// Rank returns the normalised rank of a taxon: its own rank when it is
+// canonical, otherwise the deepest canonical rank at which the taxon is
+// resolved. "no rank" is returned for taxa located above every canonical rank.
+//
+// Parameters:
+//   - taxon: The taxon to normalise.
+//
+// Returns:
+//   - A canonical rank, or "no rank".
+func (normalizer *RankNormalizer) Rank(taxon *Taxon) string {
+	if taxon == nil {
+		return "NA"
+	}
+
+	if i := normalizer.RankIndex(taxon.Rank()); i >= 0 {
+		return normalizer.ranks[i]
+	}
+
+	lineage := normalizer.Lineage(taxon)
+
+	for i := len(lineage) - 1; i >= 0; i-- {
+		if lineage[i] != nil {
+			return normalizer.ranks[i]
+		}
+	}
+
+	return "no rank"
+}
+
+// IsPlaceholder returns true if the taxon is a placeholder inferred by a
+// RankNormalizer for a missing rank.
+func (taxon *Taxon) IsPlaceholder() bool {
+	return taxon != nil &&
+		strings.HasPrefix(*taxon.Node.id, IncertaeSedisCode+":")
+}
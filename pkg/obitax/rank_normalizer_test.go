@@ -0,0 +1,98 @@
+package obitax
+
+import (
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+func buildGappedTaxonomy(t *testing.T) *Taxonomy {
+	taxonomy := NewTaxonomy("test", "taxon", obiutils.AsciiDigitSet)
+
+	nodes := [][4]string{
+		{"1", "1", "no rank", "root"},
+		{"2", "1", "kingdom", "Metazoa"},
+		{"3", "2", "phylum", "Chordata"},
+		{"4", "3", "clade", "Craniata"},
+		{"5", "4", "family", "Hominidae"},
+		{"6", "5", "genus", "Homo"},
+		{"7", "6", "species", "Homo sapiens"},
+		{"8", "5", "no rank", "Hominidae environmental samples"},
+	}
+
+	if err := taxonomy.AddNodes(nodes); err != nil {
+		t.Fatal(err)
+	}
+
+	return taxonomy
+}
+
+func TestRankNormalizerLineage(t *testing.T) {
+	taxonomy := buildGappedTaxonomy(t)
+	normalizer := taxonomy.NewRankNormalizer()
+
+	species, _, _ := taxonomy.Taxon("7")
+	lineage := normalizer.Lineage(species)
+
+	expected := []string{
+		"Metazoa",
+		"Chordata",
+		"Craniata incertae sedis",
+		"Craniata incertae sedis",
+		"Hominidae",
+		"Homo",
+		"Homo sapiens",
+	}
+
+	for i, name := range expected {
+		if lineage[i] == nil {
+			t.Fatalf("rank %s should not be nil", normalizer.Ranks()[i])
+		}
+		if lineage[i].ScientificName() != name {
+			t.Errorf("rank %s: expected %s, got %s",
+				normalizer.Ranks()[i], name, lineage[i].ScientificName())
+		}
+	}
+
+	class := lineage[2]
+	if !class.IsPlaceholder() || class.Rank() != "class" {
+		t.Errorf("class should be a placeholder at rank class, got %s", class.String())
+	}
+
+	if parent := class.Parent(); parent == nil || parent.ScientificName() != "Craniata" {
+		t.Errorf("placeholder should be attached to Craniata")
+	}
+
+	if lineage[2].SameAs(lineage[3]) {
+		t.Errorf("class and order placeholders must be distinct")
+	}
+
+	// Placeholders are shared between lineages
+	genus, _, _ := taxonomy.Taxon("6")
+	if normalizer.TaxonAtRank(genus, "class") != class {
+		t.Errorf("placeholder taxa should be reused")
+	}
+
+	if normalizer.TaxonAtRank(genus, "species") != nil {
+		t.Errorf("a genus has no species")
+	}
+}
+
+func TestRankNormalizerRank(t *testing.T) {
+	taxonomy := buildGappedTaxonomy(t)
+	normalizer := taxonomy.NewRankNormalizer()
+
+	cases := map[string]string{
+		"1": "no rank",
+		"4": "phylum",
+		"6": "genus",
+		"8": "family",
+	}
+
+	for taxid, rank := range cases {
+		taxon, _, _ := taxonomy.Taxon(taxid)
+		if got := normalizer.Rank(taxon); got != rank {
+			t.Errorf("taxon %s: expected rank %s, got %s", taxid, rank, got)
+		}
+	}
+}
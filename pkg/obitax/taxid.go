@@ -11,18 +11,25 @@ import (
 type Taxid *string
 
 // TaxidFactory is a factory for creating Taxid instances from strings and integers.
+//
+// Besides its main code, a factory can accept identifiers prefixed by
+// extension codes. Such identifiers belong to taxa grafted onto the
+// taxonomy from another source, they are stored with their code prefix
+// (e.g. "motu:A12") to avoid any collision with the main identifiers.
 type TaxidFactory struct {
-	inner    *InnerString
-	code     string
-	alphabet obiutils.AsciiSet
+	inner      *InnerString
+	code       string
+	alphabet   obiutils.AsciiSet
+	extensions obiutils.Set[string]
 }
 
 // NewTaxidFactory creates and returns a new instance of TaxidFactory.
 func NewTaxidFactory(code string, alphabet obiutils.AsciiSet) *TaxidFactory {
 	return &TaxidFactory{
-		inner:    NewInnerString(),
-		code:     code,
-		alphabet: alphabet,
+		inner:      NewInnerString(),
+		code:       code,
+		alphabet:   alphabet,
+		extensions: obiutils.MakeSet[string](),
 	}
 	// Initialize and return a new TaxidFactory.
 }
@@ -37,6 +44,9 @@ func (f *TaxidFactory) FromString(taxid string) (Taxid, error) {
 	} else {
 		//obilog.Warnf("TaxidFactory.FromString: taxid %s -> -%s- -%s- ", taxid, part1, part2)
 		if part1 != f.code {
+			if f.extensions.Contains(part1) {
+				return f.fromExtension(part1, part2)
+			}
 			return nil, fmt.Errorf("taxid %s string does not start with taxonomy code %s", taxid, f.code)
 		}
 		taxid = part2
@@ -59,3 +69,41 @@ func (f *TaxidFactory) FromInt(taxid int) (Taxid, error) {
 	s := strconv.Itoa(taxid)        // Convert the integer to a string.
 	return f.inner.Innerize(s), nil // Return a new Taxid by innerizing the string.
 }
+
+// fromExtension builds the Taxid of an identifier belonging to the
+// extension code. Extension identifiers always use the default taxid
+// alphabet, whatever the alphabet of the main taxonomy.
+func (f *TaxidFactory) fromExtension(code, taxid string) (Taxid, error) {
+	taxid, err := DefaultTaxidAlphabet.FirstWord(taxid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Taxid(f.inner.Innerize(code + ":" + taxid)), nil
+}
+
+// AddExtension registers a new extension code accepted by the factory.
+// The extension code must differ from the main code of the factory.
+func (f *TaxidFactory) AddExtension(code string) error {
+	if code == "" {
+		return fmt.Errorf("empty extension code")
+	}
+
+	if code == f.code {
+		return fmt.Errorf("extension code %s is the main taxonomy code", code)
+	}
+
+	f.extensions.Add(code)
+	return nil
+}
+
+// IsExtension returns true if code is a registered extension code.
+func (f *TaxidFactory) IsExtension(code string) bool {
+	return f.extensions.Contains(code)
+}
+
+// Extensions returns the list of registered extension codes.
+func (f *TaxidFactory) Extensions() []string {
+	return f.extensions.Members()
+}
@@ -65,6 +65,28 @@ func (taxon *Taxon) Name(class string) string {
 	return taxon.Node.Name(pclass)
 }
 
+// AlternativeNames returns the alternative names of the Taxon, indexed by
+// their name class (e.g. "synonym", "common name"). The scientific name is
+// not included.
+//
+// Returns:
+//   - A map associating each name class to the corresponding name.
+func (taxon *Taxon) AlternativeNames() map[string]string {
+	names := make(map[string]string)
+
+	if taxon == nil || taxon.Node.alternatenames == nil {
+		return names
+	}
+
+	for class, name := range *taxon.Node.alternatenames {
+		if name != nil {
+			names[*class] = *name
+		}
+	}
+
+	return names
+}
+
 // IsNameEqual checks if the given name is equal to the name of the Taxon.
 // It compares the provided name with the name stored in the TaxNode.
 //
@@ -393,10 +415,14 @@ func (taxon *Taxon) AddChild(child string, replace bool) (*Taxon, error) {
 	}
 
 	if taxon.Taxonomy.code != code {
-		return nil, errors.New("taxonomy code mismatch")
+		// The child comes from a taxonomy extension: its taxid keeps its own code
+		if err := taxon.Taxonomy.AddExtensionCode(code); err != nil {
+			return nil, err
+		}
+		taxid = code + ":" + taxid
 	}
 
-	newTaxon, err := taxon.Taxonomy.AddTaxon(taxid, *taxon.Node.id, rank, false, replace)
+	newTaxon, err := taxon.Taxonomy.AddTaxon(taxid, taxon.Node.CodedId(taxon.Taxonomy.code), rank, false, replace)
 
 	if err != nil {
 		return nil, err
@@ -34,17 +34,37 @@ type TaxNode struct {
 // This is used internally when a parseable format is required (e.g. taxonomic_path).
 func (node *TaxNode) FullString(taxonomyCode string) string {
 	if node.HasScientificName() {
-		return fmt.Sprintf("%s:%v [%s]@%s",
-			taxonomyCode,
-			*node.id,
+		return fmt.Sprintf("%s [%s]@%s",
+			node.CodedId(taxonomyCode),
 			node.ScientificName(),
 			node.Rank(),
 		)
 	}
 
-	return fmt.Sprintf("%s:%v",
-		taxonomyCode,
-		*node.id)
+	return node.CodedId(taxonomyCode)
+}
+
+// CodedId returns the identifier of the TaxNode prefixed by its taxonomy code
+// ("taxonomyCode:id"). Nodes grafted from a taxonomy extension already carry
+// their own code in their identifier, which is returned unchanged.
+//
+// Parameters:
+//   - taxonomyCode: A string representing the code of the taxonomy to which the node belongs.
+//
+// Returns:
+//   - The identifier of the node prefixed by its taxonomy code.
+func (node *TaxNode) CodedId(taxonomyCode string) string {
+	if node.IsExtension() {
+		return *node.id
+	}
+
+	return taxonomyCode + ":" + *node.id
+}
+
+// IsExtension returns true if the TaxNode was grafted onto the taxonomy
+// from an extension source using its own taxid code.
+func (node *TaxNode) IsExtension() bool {
+	return node != nil && strings.IndexByte(*node.id, ':') >= 0
 }
 
 // String returns a string representation of the TaxNode, including the taxonomy code,
@@ -10,6 +10,8 @@ package obitax
 import (
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiphylo"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
@@ -30,6 +32,8 @@ import (
 //   - root: A pointer to the root TaxNode of the taxonomy.
 //   - matcher: A regular expression used for validating taxon identifiers.
 //   - index: A map that indexes taxa by their string representation for quick access.
+//   - lcaindex: The Euler tour index used for LCA queries, built on first use.
+//   - lcalock: A mutex serializing the construction of the LCA index.
 type Taxonomy struct {
 	name        string
 	code        string
@@ -40,6 +44,8 @@ type Taxonomy struct {
 	nodes       *TaxonSet
 	root        *TaxNode
 	index       map[*string]*TaxonSet
+	lcaindex    atomic.Pointer[lcaIndex]
+	lcalock     sync.Mutex
 }
 
 var DefaultTaxidAlphabet = obiutils.AsciiAlphaNumSet.Union(obiutils.AsciiUnderScore)
@@ -224,6 +230,7 @@ func (taxonomy *Taxonomy) AddTaxon(taxid, parent string, rank string, isRoot boo
 	n := &TaxNode{id, parentid, prank, nil, nil}
 
 	taxonomy.nodes.Insert(n)
+	taxonomy.invalidateLCAIndex()
 
 	if isRoot {
 		n.parent = n.id
@@ -236,6 +243,29 @@ func (taxonomy *Taxonomy) AddTaxon(taxid, parent string, rank string, isRoot boo
 	}, nil
 }
 
+// AddNodes adds a list of taxa, described as rows of a nodes table, to the
+// taxonomy. Each row holds the taxid, the parent taxid, the rank and the
+// scientific name of a taxon. A taxon being its own parent is the root of
+// the taxonomy. Parents are expected to be listed before their children.
+//
+// Parameters:
+//   - nodes: The rows of the nodes table.
+//
+// Returns:
+//   - An error if one of the taxa cannot be added.
+func (taxonomy *Taxonomy) AddNodes(nodes [][4]string) error {
+	for _, node := range nodes {
+		taxon, err := taxonomy.AddTaxon(node[0], node[1], node[2], node[0] == node[1], false)
+		if err != nil {
+			return fmt.Errorf("cannot add taxon %s: %v", node[0], err)
+		}
+
+		taxon.SetName(node[3], "scientific name")
+	}
+
+	return nil
+}
+
 // AddAlias adds an alias for an existing taxon in the taxonomy.
 // It associates a new taxon identifier with an existing taxon identifier,
 // allowing for alternative names to be used. If specified, it can replace
@@ -335,6 +365,7 @@ func (taxonomy *Taxonomy) Code() string {
 func (taxonomy *Taxonomy) SetRoot(root *Taxon) {
 	taxonomy = taxonomy.OrDefault(true)
 	taxonomy.root = root.Node
+	taxonomy.invalidateLCAIndex()
 }
 
 // Root returns the root taxon of the taxonomy.
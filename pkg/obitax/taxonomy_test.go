@@ -0,0 +1,34 @@
+package obitax
+
+import (
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+func TestAddNodes(t *testing.T) {
+	taxonomy := buildTestTaxonomy(t)
+
+	species, _, err := taxonomy.Taxon("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if species.ScientificName() != "Homo sapiens" || species.Rank() != "species" ||
+		species.Parent().ScientificName() != "Homo" {
+		t.Errorf("taxon 3 is %s (%s), child of %s", species.ScientificName(), species.Rank(), species.Parent())
+	}
+
+	if root := taxonomy.Root(); root == nil || root.String() != species.Parent().Parent().String() {
+		t.Errorf("root of the taxonomy is %v", root)
+	}
+
+	if err := taxonomy.AddNodes([][4]string{{"3", "2", "species", "Homo erectus"}}); err == nil {
+		t.Errorf("a taxid already present is added")
+	}
+
+	empty := NewTaxonomy("test", "taxon", obiutils.AsciiDigitSet)
+	if err := empty.AddNodes([][4]string{{"x", "1", "genus", "Homo"}}); err == nil {
+		t.Errorf("an invalid taxid is added")
+	}
+}
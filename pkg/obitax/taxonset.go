@@ -161,6 +161,30 @@ func (set *TaxonSet) Alias(id *string, taxon *Taxon) {
 	set.nalias++
 }
 
+// Aliases returns every alias registered in the TaxonSet, associated with
+// the taxon it refers to.
+//
+// Returns:
+//   - A map associating each alias identifier to its Taxon.
+func (set *TaxonSet) Aliases() map[string]*Taxon {
+	if set == nil {
+		return make(map[string]*Taxon)
+	}
+
+	aliases := make(map[string]*Taxon, set.nalias)
+
+	for id, node := range set.set {
+		if node.id != id {
+			aliases[*id] = &Taxon{
+				Taxonomy: set.taxonomy,
+				Node:     node,
+			}
+		}
+	}
+
+	return aliases
+}
+
 // IsAlias checks if the given identifier corresponds to an alias in the TaxonSet.
 // It retrieves the TaxNode associated with the identifier and returns true if the
 // node exists and its identifier is different from the provided identifier; otherwise, it returns false.
@@ -217,8 +241,8 @@ func (set *TaxonSet) Sort() *TaxonSlice {
 	for pushed {
 		pushed = false
 		for _, node := range set.set {
-			if !parent[node] && (parent[set.Get(node.parent).Node] ||
-				!set.Contains(node.parent) ||
+			if !parent[node] && (!set.Contains(node.parent) ||
+				parent[set.Get(node.parent).Node] ||
 				node == taxonomy.Root().Node) {
 				pushed = true
 				taxa.slice = append(taxa.slice, node)
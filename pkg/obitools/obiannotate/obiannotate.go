@@ -211,6 +211,30 @@ func AddTaxonAtRankWorker(taxonomy *obitax.Taxonomy, ranks ...string) obiseq.Seq
 	return f
 }
 
+// AddNormalizedTaxonAtRankWorker annotates sequences with their taxon at
+// each canonical rank, using placeholder taxa for the missing ranks.
+func AddNormalizedTaxonAtRankWorker(normalizer *obitax.RankNormalizer, ranks ...string) obiseq.SeqWorker {
+	f := func(s *obiseq.BioSequence) (obiseq.BioSequenceSlice, error) {
+		for _, r := range ranks {
+			s.SetNormalizedTaxonAtRank(normalizer, r)
+		}
+		return obiseq.BioSequenceSlice{s}, nil
+	}
+
+	return f
+}
+
+// AddNormalizedTaxonRankWorker annotates sequences with the canonical rank
+// of their taxon.
+func AddNormalizedTaxonRankWorker(normalizer *obitax.RankNormalizer) obiseq.SeqWorker {
+	f := func(s *obiseq.BioSequence) (obiseq.BioSequenceSlice, error) {
+		s.SetNormalizedTaxonomicRank(normalizer)
+		return obiseq.BioSequenceSlice{s}, nil
+	}
+
+	return f
+}
+
 func AddTaxonRankWorker(taxonomy *obitax.Taxonomy) obiseq.SeqWorker {
 	f := func(s *obiseq.BioSequence) (obiseq.BioSequenceSlice, error) {
 		s.SetTaxonomicRank(taxonomy)
@@ -268,9 +292,19 @@ func CLIAnnotationWorker() obiseq.SeqWorker {
 		annotator = annotator.ChainWorkers(w)
 	}
 
+	var normalizer *obitax.RankNormalizer
+	if obidefault.NormalizeRanks() && (CLIHasTaxonAtRank() || CLISetTaxonomicRank()) {
+		normalizer = obitax.DefaultTaxonomy().NewRankNormalizer(obidefault.CanonicalRanks()...)
+	}
+
 	if CLIHasTaxonAtRank() {
-		taxo := obitax.DefaultTaxonomy()
-		w := AddTaxonAtRankWorker(taxo, CLITaxonAtRank()...)
+		var w obiseq.SeqWorker
+		if normalizer != nil {
+			w = AddNormalizedTaxonAtRankWorker(normalizer, CLITaxonAtRank()...)
+		} else {
+			taxo := obitax.DefaultTaxonomy()
+			w = AddTaxonAtRankWorker(taxo, CLITaxonAtRank()...)
+		}
 		annotator = annotator.ChainWorkers(w)
 	}
 
@@ -281,8 +315,13 @@ func CLIAnnotationWorker() obiseq.SeqWorker {
 	}
 
 	if CLISetTaxonomicRank() {
-		taxo := obitax.DefaultTaxonomy()
-		w := AddTaxonRankWorker(taxo)
+		var w obiseq.SeqWorker
+		if normalizer != nil {
+			w = AddNormalizedTaxonRankWorker(normalizer)
+		} else {
+			taxo := obitax.DefaultTaxonomy()
+			w = AddTaxonRankWorker(taxo)
+		}
 		annotator = annotator.ChainWorkers(w)
 	}
 
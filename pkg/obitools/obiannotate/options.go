@@ -8,6 +8,7 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obioptions"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obigrep"
 	"github.com/DavidGamba/go-getoptions"
@@ -139,6 +140,7 @@ func OptionSet(options *getoptions.GetOpt) {
 	obiconvert.OptionSet(false)(options)
 	obigrep.SequenceSelectionOptionSet(options)
 	SequenceAnnotationOptionSet(options)
+	obioptions.RankNormalizationOptionSet(options)
 }
 
 // -S <KEY>:<PYTHON_EXPRESSION>, --set-tag=<KEY>:<PYTHON_EXPRESSION>
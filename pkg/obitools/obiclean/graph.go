@@ -14,6 +14,7 @@ import (
 
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obialign"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
 	"github.com/schollz/progressbar/v3"
 )
@@ -359,25 +360,35 @@ func extendSimilarityGraph(seqs *[]*seqPCR, step int, workers int) int {
 	nseq := len(*seqs)
 	running := sync.WaitGroup{}
 
-	linePairs := func(matrix *[]uint64, i int) {
+	linePairs := func(buffer *obialign.LCSBatchBuffer, i int) {
 		son := (*seqs)[i]
+		fathers := make([]int, 0)
 		for j := i + 1; j < nseq; j++ {
 			father := (*seqs)[j]
 			if father.Count > son.Count {
 				d, _, _, _ := obialign.D1Or0(son.Sequence, father.Sequence)
 
-				if d < 0 {
-					lcs, lali := obialign.FastLCSScore(son.Sequence, father.Sequence,
-						step,
-						matrix)
-					d := (lali - lcs)
-					if lcs >= 0 && d <= step && step > 0 {
-						son.Edges = append(son.Edges, makeEdge(j, d, -1, '-', '-'))
-						father.SonCount++
-						//a, b := minMax((*seqs)[i].Count, (*seqs)[j].Count)
-					}
+				if d < 0 && step > 0 {
+					fathers = append(fathers, j)
 				}
+			}
+		}
+
+		// The fathers too distant for D1Or0 are compared to the son at once
+		references := make(obiseq.BioSequenceSlice, len(fathers))
+		for k, j := range fathers {
+			references[k] = (*seqs)[j].Sequence
+		}
+
+		lcss, lalis := obialign.FastLCSScoreBatch(son.Sequence, references, step, buffer)
 
+		for k, j := range fathers {
+			lcs, lali := lcss[k], lalis[k]
+			d := (lali - lcs)
+			if lcs >= 0 && d <= step {
+				son.Edges = append(son.Edges, makeEdge(j, d, -1, '-', '-'))
+				(*seqs)[j].SonCount++
+				//a, b := minMax((*seqs)[i].Count, (*seqs)[j].Count)
 			}
 		}
 	}
@@ -386,10 +397,10 @@ func extendSimilarityGraph(seqs *[]*seqPCR, step int, workers int) int {
 	// idxChan := make(chan [][]Ratio)
 
 	ff := func() {
-		var matrix []uint64
+		buffer := obialign.LCSBatchBuffer{}
 
 		for i := range lineChan {
-			linePairs(&matrix, i)
+			linePairs(&buffer, i)
 		}
 
 		running.Done()
@@ -0,0 +1,297 @@
+package obicleandb
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
+)
+
+// TaxonomyIssue describes a problem detected on the taxonomic annotation
+// of a reference sequence.
+//
+// Fields:
+//   - Id: The identifier of the sequence.
+//   - Taxid: The taxid declared by the sequence.
+//   - Issue: A short code identifying the kind of problem.
+//   - Detail: A human readable description of the problem.
+type TaxonomyIssue struct {
+	Id     string
+	Taxid  string
+	Issue  string
+	Detail string
+}
+
+func addIssue(issues []TaxonomyIssue,
+	sequence *obiseq.BioSequence,
+	taxid, issue, detail string) []TaxonomyIssue {
+
+	var codes []string
+	if v, ok := sequence.GetAttribute("obicleandb_issues"); ok {
+		codes, _ = v.([]string)
+	}
+
+	if !slices.Contains(codes, issue) {
+		codes = append(codes, issue)
+	}
+	sequence.SetAttribute("obicleandb_issues", codes)
+
+	return append(issues, TaxonomyIssue{
+		Id:     sequence.Id(),
+		Taxid:  taxid,
+		Issue:  issue,
+		Detail: detail,
+	})
+}
+
+// CheckSequenceTaxon validates the taxonomic annotation of a single sequence.
+// It checks that the taxid is defined and valid in the taxonomy, and that
+// the species_name and scientific_name annotations, when present, are
+// consistent with the taxon designated by the taxid.
+//
+// The status of the taxid is stored in the obicleandb_taxid_status
+// attribute (valid, alias, unknown or missing) and the codes of the detected
+// problems in the obicleandb_issues attribute.
+//
+// Parameters:
+//   - taxonomy: The taxonomy used to validate the annotations.
+//   - sequence: The sequence to check.
+//
+// Returns:
+//   - The taxon associated to the sequence, or nil if the taxid is not valid.
+//   - The list of detected issues.
+func CheckSequenceTaxon(taxonomy *obitax.Taxonomy,
+	sequence *obiseq.BioSequence) (*obitax.Taxon, []TaxonomyIssue) {
+
+	issues := make([]TaxonomyIssue, 0)
+
+	taxid := sequence.Taxid()
+	if taxid == "NA" {
+		sequence.SetAttribute("obicleandb_taxid_status", "missing")
+		issues = addIssue(issues, sequence, taxid, "missing_taxid",
+			"sequence has no taxid annotation")
+		return nil, issues
+	}
+
+	taxon, isAlias, err := taxonomy.Taxon(taxid)
+
+	if err != nil {
+		sequence.SetAttribute("obicleandb_taxid_status", "unknown")
+		issues = addIssue(issues, sequence, taxid, "invalid_taxid", err.Error())
+		return nil, issues
+	}
+
+	if isAlias {
+		sequence.SetAttribute("obicleandb_taxid_status", "alias")
+		issues = addIssue(issues, sequence, taxid, "alias_taxid",
+			fmt.Sprintf("taxid is an alias of %s", taxon.String()))
+	} else {
+		sequence.SetAttribute("obicleandb_taxid_status", "valid")
+	}
+
+	if name, ok := sequence.GetStringAttribute("species_name"); ok && name != "NA" {
+		species := taxon.TaxonAtRank("species")
+		switch {
+		case species == nil:
+			issues = addIssue(issues, sequence, taxid, "species_name_mismatch",
+				fmt.Sprintf("species_name %q declared but taxid is not at or below species rank", name))
+		case !species.IsNameEqual(name, true):
+			issues = addIssue(issues, sequence, taxid, "species_name_mismatch",
+				fmt.Sprintf("species_name %q differs from %q", name, species.ScientificName()))
+		}
+	}
+
+	if name, ok := sequence.GetStringAttribute("scientific_name"); ok && name != "NA" {
+		if !taxon.IsNameEqual(name, true) {
+			issues = addIssue(issues, sequence, taxid, "scientific_name_mismatch",
+				fmt.Sprintf("scientific_name %q differs from %q", name, taxon.ScientificName()))
+		}
+	}
+
+	return taxon, issues
+}
+
+// isAboveRank reports whether a taxon is located strictly above a rank.
+// A taxon having an ancestor (or itself) at that rank is not above it. When
+// the rank is missing from its lineage, the position of the deepest
+// canonical rank of the lineage is compared to the position of the rank in
+// obitax.DefaultCanonicalRanks, so that a genus directly attached to an
+// order is not considered as above the family rank. A rank which is not
+// canonical cannot be positioned, and the taxon is then considered as above.
+//
+// Parameters:
+//   - taxon: The taxon to locate.
+//   - rank: The reference rank.
+//
+// Returns:
+//   - true if the taxon is above the rank, false otherwise.
+func isAboveRank(taxon *obitax.Taxon, rank string) bool {
+	if taxon.TaxonAtRank(rank) != nil {
+		return false
+	}
+
+	irank := slices.Index(obitax.DefaultCanonicalRanks, rank)
+	if irank < 0 {
+		return true
+	}
+
+	for t := range taxon.IPath() {
+		if i := slices.Index(obitax.DefaultCanonicalRanks, t.Rank()); i >= 0 {
+			return i < irank
+		}
+	}
+
+	return true
+}
+
+// CheckIdenticalSequences looks for identical sequences annotated with
+// distant taxa. Sequences are grouped by their nucleotide sequence, and for
+// each group the LCA of the annotated taxa is computed. When this LCA is
+// located above the given rank, every sequence of the group is flagged with
+// the identical_sequence_conflict issue and the LCA is stored in the
+// obicleandb_conflict_lca attribute.
+//
+// Parameters:
+//   - sequences: The sequences to check.
+//   - taxa: The taxa associated to each sequence (nil for invalid taxids).
+//   - rank: The rank above which an LCA is considered as a conflict.
+//
+// Returns:
+//   - The list of detected issues.
+func CheckIdenticalSequences(sequences obiseq.BioSequenceSlice,
+	taxa []*obitax.Taxon,
+	rank string) []TaxonomyIssue {
+
+	issues := make([]TaxonomyIssue, 0)
+	groups := make(map[string][]int)
+
+	for i, s := range sequences {
+		if taxa[i] != nil {
+			key := string(s.Sequence())
+			groups[key] = append(groups[key], i)
+		}
+	}
+
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+
+		lca := taxa[group[0]]
+		var err error
+		for _, i := range group[1:] {
+			lca, err = lca.LCA(taxa[i])
+			if err != nil {
+				log.Fatalf("Cannot compute LCA of sequence %s: %v", sequences[i].Id(), err)
+			}
+		}
+
+		if !isAboveRank(lca, rank) {
+			continue
+		}
+
+		ids := make([]string, len(group))
+		for j, i := range group {
+			ids[j] = sequences[i].Id()
+		}
+
+		for _, i := range group {
+			s := sequences[i]
+			s.SetAttribute("obicleandb_conflict_lca", lca.String())
+			issues = addIssue(issues, s, s.Taxid(), "identical_sequence_conflict",
+				fmt.Sprintf("identical to %s, LCA %s is above %s",
+					strings.Join(ids, ";"), lca.String(), rank))
+		}
+	}
+
+	return issues
+}
+
+// WriteTaxonomyIssues writes the list of issues as a CSV file.
+//
+// Parameters:
+//   - filename: The name of the CSV file, "-" for the standard output.
+//   - issues: The issues to report.
+//
+// Returns:
+//   - An error if the file cannot be written.
+func WriteTaxonomyIssues(filename string, issues []TaxonomyIssue) error {
+	var w *csv.Writer
+
+	if filename == "-" {
+		w = csv.NewWriter(os.Stdout)
+	} else {
+		f, err := os.Create(filename)
+		if err != nil {
+			return fmt.Errorf("cannot create report file %s: %w", filename, err)
+		}
+		defer f.Close()
+		w = csv.NewWriter(f)
+	}
+
+	if err := w.Write([]string{"id", "taxid", "issue", "detail"}); err != nil {
+		return err
+	}
+
+	for _, issue := range issues {
+		if err := w.Write([]string{issue.Id, issue.Taxid, issue.Issue, issue.Detail}); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// ICheckTaxonomyDB validates the taxonomic annotations of a reference
+// database. Every sequence is checked with CheckSequenceTaxon and the whole
+// database with CheckIdenticalSequences. The problems are reported in the
+// CSV file returned by CLICheckReport, and the annotated sequences are
+// returned as a new iterator.
+//
+// Parameters:
+//   - iterator: The reference sequences to validate.
+//
+// Returns:
+//   - An iterator over the annotated sequences.
+func ICheckTaxonomyDB(iterator obiiter.IBioSequence) obiiter.IBioSequence {
+	taxonomy := obitax.DefaultTaxonomy()
+	rank := CLIConflictRank()
+
+	if !slices.Contains(taxonomy.RankList(), rank) {
+		log.Fatalf("Rank %s is not defined in taxonomy %s", rank, taxonomy.Name())
+	}
+
+	source, references := iterator.Load()
+
+	log.Infof("Checking taxonomy of %d reference sequences", references.Len())
+
+	taxa := make([]*obitax.Taxon, references.Len())
+	issues := make([]TaxonomyIssue, 0)
+
+	for i, s := range references {
+		var si []TaxonomyIssue
+		taxa[i], si = CheckSequenceTaxon(taxonomy, s)
+		issues = append(issues, si...)
+	}
+
+	issues = append(issues, CheckIdenticalSequences(references, taxa, rank)...)
+
+	log.Infof("%d taxonomic issues detected", len(issues))
+
+	if err := WriteTaxonomyIssues(CLICheckReport(), issues); err != nil {
+		log.Fatalf("Cannot write the taxonomy report: %v", err)
+	}
+
+	log.Infof("Taxonomy report saved in file: %s", CLICheckReport())
+
+	return obiiter.IBatchOver(source, references, obidefault.BatchSize())
+}
@@ -0,0 +1,239 @@
+package obicleandb
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+// buildCheckTaxonomy builds a small taxonomy where the genus Mus is
+// directly attached to the order Rodentia, without any family.
+func buildCheckTaxonomy(t *testing.T) *obitax.Taxonomy {
+	taxonomy := obitax.NewTaxonomy("test", "taxon", obiutils.AsciiDigitSet)
+
+	nodes := [][4]string{
+		{"1", "1", "no rank", "root"},
+		{"2", "1", "kingdom", "Metazoa"},
+		{"3", "2", "phylum", "Chordata"},
+		{"4", "3", "class", "Mammalia"},
+		{"5", "4", "order", "Primates"},
+		{"6", "5", "family", "Hominidae"},
+		{"7", "6", "genus", "Homo"},
+		{"8", "7", "species", "Homo sapiens"},
+		{"9", "7", "species", "Homo erectus"},
+		{"10", "6", "genus", "Pan"},
+		{"11", "10", "species", "Pan troglodytes"},
+		{"12", "4", "order", "Rodentia"},
+		{"13", "12", "genus", "Mus"},
+		{"14", "13", "species", "Mus musculus"},
+		{"15", "13", "species", "Mus spretus"},
+	}
+
+	if err := taxonomy.AddNodes(nodes); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := taxonomy.AddAlias("100", "8", false); err != nil {
+		t.Fatalf("cannot add alias: %v", err)
+	}
+
+	return taxonomy
+}
+
+// checkSequence builds a sequence annotated with a taxid and optional
+// species_name and scientific_name attributes.
+func checkSequence(id, sequence, taxid, species, name string) *obiseq.BioSequence {
+	s := obiseq.NewBioSequence(id, []byte(sequence), "")
+
+	if taxid != "" {
+		s.SetAttribute("taxid", taxid)
+	}
+	if species != "" {
+		s.SetAttribute("species_name", species)
+	}
+	if name != "" {
+		s.SetAttribute("scientific_name", name)
+	}
+
+	return s
+}
+
+func issueCodes(issues []TaxonomyIssue) []string {
+	codes := make([]string, len(issues))
+	for i, issue := range issues {
+		codes[i] = issue.Issue
+	}
+
+	return codes
+}
+
+func TestCheckSequenceTaxon(t *testing.T) {
+	taxonomy := buildCheckTaxonomy(t)
+
+	tests := []struct {
+		name     string
+		sequence *obiseq.BioSequence
+		status   string
+		taxon    string
+		issues   []string
+	}{
+		{"valid annotation",
+			checkSequence("s1", "acgt", "8", "Homo sapiens", "homo sapiens"),
+			"valid", "Homo sapiens", []string{}},
+		{"missing taxid",
+			checkSequence("s2", "acgt", "", "", ""),
+			"missing", "", []string{"missing_taxid"}},
+		{"unknown taxid",
+			checkSequence("s3", "acgt", "999", "", ""),
+			"unknown", "", []string{"invalid_taxid"}},
+		{"alias taxid",
+			checkSequence("s4", "acgt", "100", "", ""),
+			"alias", "Homo sapiens", []string{"alias_taxid"}},
+		{"species name above species",
+			checkSequence("s5", "acgt", "7", "Homo sapiens", ""),
+			"valid", "Homo", []string{"species_name_mismatch"}},
+		{"different species name",
+			checkSequence("s6", "acgt", "8", "Homo erectus", ""),
+			"valid", "Homo sapiens", []string{"species_name_mismatch"}},
+		{"different scientific name",
+			checkSequence("s7", "acgt", "8", "", "Pan troglodytes"),
+			"valid", "Homo sapiens", []string{"scientific_name_mismatch"}},
+		{"NA names",
+			checkSequence("s8", "acgt", "13", "NA", "NA"),
+			"valid", "Mus", []string{}},
+	}
+
+	for _, test := range tests {
+		taxon, issues := CheckSequenceTaxon(taxonomy, test.sequence)
+
+		if status, _ := test.sequence.GetStringAttribute("obicleandb_taxid_status"); status != test.status {
+			t.Errorf("%s: status is %s, expected %s", test.name, status, test.status)
+		}
+
+		if (taxon == nil) != (test.taxon == "") || (taxon != nil && taxon.ScientificName() != test.taxon) {
+			t.Errorf("%s: taxon is %v, expected %s", test.name, taxon, test.taxon)
+		}
+
+		if codes := issueCodes(issues); !slices.Equal(codes, test.issues) {
+			t.Errorf("%s: issues are %v, expected %v", test.name, codes, test.issues)
+		}
+
+		for _, issue := range issues {
+			if issue.Id != test.sequence.Id() || issue.Taxid != test.sequence.Taxid() {
+				t.Errorf("%s: issue %+v does not refer to the sequence", test.name, issue)
+			}
+		}
+	}
+}
+
+func TestCheckIdenticalSequences(t *testing.T) {
+	taxonomy := buildCheckTaxonomy(t)
+
+	tests := []struct {
+		name     string
+		taxids   []string
+		rank     string
+		conflict string
+	}{
+		{"same species", []string{"8", "8"}, "genus", ""},
+		{"within the genus", []string{"8", "9"}, "genus", ""},
+		{"within the family", []string{"8", "11"}, "genus", "6"},
+		{"within the family at family rank", []string{"8", "11"}, "family", ""},
+		{"across orders", []string{"8", "14"}, "family", "4"},
+		{"lineage without the rank", []string{"14", "15"}, "family", ""},
+		{"lineage without the rank at species rank", []string{"14", "15"}, "species", "13"},
+		{"above the missing rank", []string{"13", "12"}, "family", "12"},
+		{"three sequences", []string{"8", "9", "11"}, "genus", "6"},
+	}
+
+	for _, test := range tests {
+		sequences := obiseq.MakeBioSequenceSlice(0)
+		taxa := make([]*obitax.Taxon, 0)
+
+		for i, taxid := range test.taxids {
+			taxon, _, err := taxonomy.Taxon(taxid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sequences = append(sequences, checkSequence(string(rune('a'+i)), "acgtacgt", taxid, "", ""))
+			taxa = append(taxa, taxon)
+		}
+
+		// A distinct sequence never conflicts
+		other, _, _ := taxonomy.Taxon("3")
+		sequences = append(sequences, checkSequence("other", "ttttgggg", "3", "", ""))
+		taxa = append(taxa, other)
+
+		issues := CheckIdenticalSequences(sequences, taxa, test.rank)
+
+		if test.conflict == "" {
+			if len(issues) != 0 {
+				t.Errorf("%s: unexpected conflicts %v", test.name, issues)
+			}
+			continue
+		}
+
+		if len(issues) != len(test.taxids) {
+			t.Errorf("%s: %d conflicts, expected %d", test.name, len(issues), len(test.taxids))
+			continue
+		}
+
+		for _, s := range sequences[:len(test.taxids)] {
+			lca, _ := s.GetStringAttribute("obicleandb_conflict_lca")
+			expected, _, _ := taxonomy.Taxon(test.conflict)
+			if lca != expected.String() {
+				t.Errorf("%s: sequence %s conflicts at %s, expected %s", test.name, s.Id(), lca, expected)
+			}
+		}
+
+		if _, ok := sequences[len(test.taxids)].GetAttribute("obicleandb_conflict_lca"); ok {
+			t.Errorf("%s: distinct sequence flagged as a conflict", test.name)
+		}
+	}
+}
+
+// Sequences without a valid taxon are ignored.
+func TestCheckIdenticalSequencesInvalidTaxa(t *testing.T) {
+	taxonomy := buildCheckTaxonomy(t)
+	taxon, _, _ := taxonomy.Taxon("8")
+
+	sequences := obiseq.BioSequenceSlice{
+		checkSequence("a", "acgt", "8", "", ""),
+		checkSequence("b", "acgt", "999", "", ""),
+	}
+
+	if issues := CheckIdenticalSequences(sequences, []*obitax.Taxon{taxon, nil}, "species"); len(issues) != 0 {
+		t.Errorf("unexpected conflicts %v", issues)
+	}
+}
+
+func TestWriteTaxonomyIssues(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "issues.csv")
+
+	issues := []TaxonomyIssue{
+		{"s1", "999", "invalid_taxid", "unknown taxid"},
+		{"s2", "8", "species_name_mismatch", `species_name "Homo erectus" differs from "Homo sapiens"`},
+	}
+
+	if err := WriteTaxonomyIssues(filename, issues); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `id,taxid,issue,detail
+s1,999,invalid_taxid,unknown taxid
+s2,8,species_name_mismatch,"species_name ""Homo erectus"" differs from ""Homo sapiens"""
+`
+	if string(data) != expected {
+		t.Errorf("report is\n%s\nexpected\n%s", data, expected)
+	}
+}
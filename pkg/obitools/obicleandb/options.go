@@ -1,15 +1,76 @@
 package obicleandb
 
 import (
+	"path/filepath"
+	"strings"
+
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obioptions"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obigrep"
 	"github.com/DavidGamba/go-getoptions"
 )
 
+var _CheckTaxonomy = false
+var _CheckReport = ""
+var _ConflictRank = "genus"
+
+// _DefaultCheckReport is the name of the report of the validation mode when
+// neither --check-report nor --out is given.
+const _DefaultCheckReport = "taxonomy_issues.csv"
+
+func CheckOptionSet(options *getoptions.GetOpt) {
+	options.BoolVar(&_CheckTaxonomy, "check-taxonomy", _CheckTaxonomy,
+		options.Description("Only validate the taxonomic annotations of the reference "+
+			"sequences against the taxonomy, without cleaning the database."))
+
+	options.StringVar(&_CheckReport, "check-report", _CheckReport,
+		options.ArgName("FILENAME"),
+		options.Description("Name of the CSV file where the problems detected "+
+			"by --check-taxonomy are reported. By default the report is written next "+
+			"to the output file, or to "+_DefaultCheckReport+" when the output is "+
+			"the standard output."))
+
+	options.StringVar(&_ConflictRank, "conflict-rank", _ConflictRank,
+		options.ArgName("RANK"),
+		options.Description("Identical sequences annotated with taxa whose LCA "+
+			"is above this rank are flagged by --check-taxonomy."))
+}
+
 func OptionSet(options *getoptions.GetOpt) {
 	obioptions.LoadTaxonomyOptionSet(options, true, false)
 	obiconvert.InputOptionSet(options)
 	obiconvert.OutputOptionSet(options)
 	obigrep.TaxonomySelectionOptionSet(options)
+	CheckOptionSet(options)
+}
+
+// CLICheckTaxonomy returns true if obicleandb has to run in validation mode.
+func CLICheckTaxonomy() bool {
+	return _CheckTaxonomy
+}
+
+// CLICheckReport returns the name of the CSV report file of the validation
+// mode. Unless it is set by --check-report, the report is named after the
+// output file, its extensions being replaced by _taxonomy_issues.csv.
+func CLICheckReport() string {
+	if _CheckReport != "" {
+		return _CheckReport
+	}
+
+	output := obiconvert.CLIOutPutFileName()
+	if output == "" || output == "-" {
+		return _DefaultCheckReport
+	}
+
+	dir, base := filepath.Split(output)
+	base = strings.TrimSuffix(base, ".gz")
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+
+	return filepath.Join(dir, base+"_"+_DefaultCheckReport)
+}
+
+// CLIConflictRank returns the rank above which the LCA of identical
+// sequences is considered as a taxonomic conflict.
+func CLIConflictRank() string {
+	return _ConflictRank
 }
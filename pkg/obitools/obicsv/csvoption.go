@@ -3,6 +3,7 @@ package obicsv
 import (
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiitercsv"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
 )
 
 // __options__ holds configuration options for processing.
@@ -10,27 +11,28 @@ import (
 type __options__ struct {
 	with_progress_bar bool // Indicates whether to display a progress bar
 	filename          string
-	buffer_size       int      // Size of the buffer for processing
-	batch_size        int      // Number of items to process in a batch
-	full_file_batch   bool     // Indicates whether to process the full file in a batch
-	parallel_workers  int      // Number of parallel workers to use
-	no_order          bool     // Indicates whether to process items in no specific order
-	closefile         bool     // Indicates whether to close the file after processing
-	appendfile        bool     // Indicates whether to append to the file instead of overwriting
-	compressed        bool     // Indicates whether the input data is compressed
-	skip_empty        bool     // Indicates whether to skip empty entries
-	csv_naomit        bool     // Indicates whether to omit NA values in CSV output
-	csv_id            bool     // Indicates whether to include ID in CSV output
-	csv_sequence      bool     // Indicates whether to include sequence in CSV output
-	csv_quality       bool     // Indicates whether to include quality in CSV output
-	csv_definition    bool     // Indicates whether to include definition in CSV output
-	csv_count         bool     // Indicates whether to include count in CSV output
-	csv_taxon         bool     // Indicates whether to include taxon in CSV output
-	csv_keys          []string // List of keys to include in CSV output
-	csv_separator     string   // Separator to use in CSV output
-	csv_navalue       string   // Value to use for NA entries in CSV output
-	csv_auto          bool     // Indicates whether to automatically determine CSV format
-	source            string   // Source of the data
+	buffer_size       int                    // Size of the buffer for processing
+	batch_size        int                    // Number of items to process in a batch
+	full_file_batch   bool                   // Indicates whether to process the full file in a batch
+	parallel_workers  int                    // Number of parallel workers to use
+	no_order          bool                   // Indicates whether to process items in no specific order
+	closefile         bool                   // Indicates whether to close the file after processing
+	appendfile        bool                   // Indicates whether to append to the file instead of overwriting
+	compressed        bool                   // Indicates whether the input data is compressed
+	skip_empty        bool                   // Indicates whether to skip empty entries
+	csv_naomit        bool                   // Indicates whether to omit NA values in CSV output
+	csv_id            bool                   // Indicates whether to include ID in CSV output
+	csv_sequence      bool                   // Indicates whether to include sequence in CSV output
+	csv_quality       bool                   // Indicates whether to include quality in CSV output
+	csv_definition    bool                   // Indicates whether to include definition in CSV output
+	csv_count         bool                   // Indicates whether to include count in CSV output
+	csv_taxon         bool                   // Indicates whether to include taxon in CSV output
+	csv_normalizer    *obitax.RankNormalizer // Normalizer used to add canonical rank columns
+	csv_keys          []string               // List of keys to include in CSV output
+	csv_separator     string                 // Separator to use in CSV output
+	csv_navalue       string                 // Value to use for NA entries in CSV output
+	csv_auto          bool                   // Indicates whether to automatically determine CSV format
+	source            string                 // Source of the data
 }
 
 // Options wraps the __options__ struct to provide a pointer to the options.
@@ -66,6 +68,7 @@ func MakeOptions(setters []WithOption) Options {
 		csv_definition:    false,
 		csv_count:         false,
 		csv_taxon:         false,
+		csv_normalizer:    nil,
 		csv_sequence:      true,
 		csv_quality:       false,
 		csv_separator:     ",",
@@ -165,6 +168,12 @@ func (opt Options) CSVTaxon() bool {
 	return opt.pointer.csv_taxon
 }
 
+// CSVRankNormalizer returns the RankNormalizer used to add one column per
+// canonical rank next to the taxon, or nil if no such column is requested.
+func (opt Options) CSVRankNormalizer() *obitax.RankNormalizer {
+	return opt.pointer.csv_normalizer
+}
+
 // CSVSequence returns whether the sequence should be included in the CSV output.
 // It retrieves the setting from the underlying options.
 func (opt Options) CSVSequence() bool {
@@ -434,6 +443,19 @@ func CSVTaxon(include bool) WithOption {
 	return f
 }
 
+// CSVNormalizedRanks returns a WithOption function that sets the RankNormalizer
+// used to add the scientific name of the taxon at every canonical rank to the
+// taxon columns.
+// Parameters:
+//   - normalizer: The RankNormalizer to use, or nil to disable these columns.
+func CSVNormalizedRanks(normalizer *obitax.RankNormalizer) WithOption {
+	f := WithOption(func(opt Options) {
+		opt.pointer.csv_normalizer = normalizer
+	})
+
+	return f
+}
+
 // CSVKey returns a WithOption function that adds a key to the list of keys to include in the CSV output.
 // Parameters:
 //   - key: A string specifying the key to include in the CSV output.
@@ -6,6 +6,7 @@ import (
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiitercsv"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
 )
 
@@ -36,6 +37,11 @@ func CLIWriteSequenceCSV(iterator obiiter.IBioSequence,
 		CSVNAValue(CLINAValue()),
 	)
 
+	if CLIPrintTaxon() && obidefault.NormalizeRanks() && obitax.HasDefaultTaxonomyDefined() {
+		normalizer := obitax.DefaultTaxonomy().NewRankNormalizer(obidefault.CanonicalRanks()...)
+		opts = append(opts, CSVNormalizedRanks(normalizer))
+	}
+
 	csvIter := NewCSVSequenceIterator(iterator, opts...)
 	newIter := CLICSVWriter(csvIter, terminalAction, opts...)
 
@@ -68,6 +68,7 @@ func OptionSet(options *getoptions.GetOpt) {
 	obiconvert.InputOptionSet(options)
 	obiconvert.OutputModeOptionSet(options, true)
 	obioptions.LoadTaxonomyOptionSet(options, false, false)
+	obioptions.RankNormalizationOptionSet(options)
 	CSVOptionSet(options)
 }
 
@@ -24,6 +24,12 @@ func CSVSequenceHeader(opt Options) obiitercsv.CSVHeader {
 
 	if opt.CSVTaxon() {
 		record.AppendField("taxid")
+
+		if normalizer := opt.CSVRankNormalizer(); normalizer != nil {
+			for _, rank := range normalizer.Ranks() {
+				record.AppendField(rank + "_name")
+			}
+		}
 	}
 
 	if opt.CSVDefinition() {
@@ -84,6 +90,17 @@ func CSVBatchFromSequences(batch obiiter.BioSequenceBatch, opt Options) obiiterc
 			}
 
 			record["taxid"] = taxid
+
+			if normalizer := opt.CSVRankNormalizer(); normalizer != nil {
+				lineage := normalizer.Lineage(taxon)
+				for i, rank := range normalizer.Ranks() {
+					if lineage != nil && lineage[i] != nil {
+						record[rank+"_name"] = lineage[i].ScientificName()
+					} else {
+						record[rank+"_name"] = opt.CSVNAValue()
+					}
+				}
+			}
 		}
 
 		if opt.CSVDefinition() {
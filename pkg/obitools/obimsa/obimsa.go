@@ -0,0 +1,195 @@
+package obimsa
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obialign"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// Group is a set of sequences aligned together.
+type Group struct {
+	Name      string
+	Sequences obiseq.BioSequenceSlice
+}
+
+// groupNames returns the names of the groups of a sequence according to
+// the value of an attribute. A map attribute defines a group for each of
+// its distinct values. A sequence without the attribute belongs to no
+// group.
+func groupNames(sequence *obiseq.BioSequence, attribute string) []string {
+	value, ok := sequence.GetAttribute(attribute)
+	if !ok {
+		return nil
+	}
+
+	names := make([]string, 0, 1)
+
+	switch value := value.(type) {
+	case map[string]string:
+		for _, v := range value {
+			names = append(names, v)
+		}
+	case map[string]interface{}:
+		for _, v := range value {
+			names = append(names, fmt.Sprint(v))
+		}
+	default:
+		names = append(names, fmt.Sprint(value))
+	}
+
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// GroupSequences splits a set of sequences into groups according to the
+// value of an attribute. Groups are ordered by their first sequence. A
+// sequence belonging to several groups is copied in each of them. A
+// sequence without the attribute forms a group by itself. With an empty
+// attribute name, all the sequences form a single group.
+//
+// Parameters:
+//   - sequences: The sequences to group.
+//   - attribute: The name of the attribute defining the groups.
+//
+// Returns:
+//   - The groups of sequences.
+func GroupSequences(sequences obiseq.BioSequenceSlice, attribute string) []Group {
+	if attribute == "" {
+		return []Group{{Name: "", Sequences: sequences}}
+	}
+
+	groups := make([]Group, 0)
+	index := make(map[string]int)
+
+	for _, sequence := range sequences {
+		names := groupNames(sequence, attribute)
+
+		if len(names) == 0 {
+			groups = append(groups, Group{
+				Name:      sequence.Id(),
+				Sequences: obiseq.BioSequenceSlice{sequence},
+			})
+			continue
+		}
+
+		for k, name := range names {
+			s := sequence
+			if k > 0 {
+				s = sequence.Copy()
+			}
+
+			g, ok := index[name]
+			if !ok {
+				g = len(groups)
+				index[name] = g
+				groups = append(groups, Group{Name: name})
+			}
+
+			groups[g].Sequences = append(groups[g].Sequences, s)
+		}
+	}
+
+	return groups
+}
+
+// AlignGroup aligns the sequences of a group. Each aligned sequence is
+// annotated with the name of its group.
+func AlignGroup(group Group, scoring *obialign.ScoringScheme, freeEndGaps bool) obiseq.BioSequenceSlice {
+	aligned := obialign.MultipleAlignment(group.Sequences, scoring, freeEndGaps)
+
+	for _, sequence := range aligned {
+		sequence.SetAttribute("msa_group", group.Name)
+	}
+
+	return aligned
+}
+
+// GroupConsensus builds the consensus sequence of an aligned group.
+//
+// Parameters:
+//   - group: The name of the group.
+//   - aligned: The aligned sequences of the group.
+//   - threshold: The fraction of the nucleotides represented by a consensus symbol.
+//
+// Returns:
+//   - The consensus sequence, whose count is the sum of the group counts.
+func GroupConsensus(group string, aligned obiseq.BioSequenceSlice, threshold float64) *obiseq.BioSequence {
+	id := group
+	if id == "" {
+		id = "consensus"
+	}
+
+	consensus := obiseq.NewBioSequence(id, obialign.AlignmentConsensus(aligned, threshold), "")
+
+	count := 0
+	for _, sequence := range aligned {
+		count += sequence.Count()
+	}
+
+	consensus.SetCount(count)
+	consensus.SetAttribute("msa_group", group)
+	consensus.SetAttribute("msa_size", len(aligned))
+
+	if len(aligned) > 0 {
+		consensus.SetAttribute("msa_length", aligned[0].Len())
+	}
+
+	return consensus
+}
+
+// CLIMultipleAlignment aligns the groups of sequences provided by the
+// iterator. It returns either the aligned sequences or the consensus of
+// each group, according to the --consensus option.
+func CLIMultipleAlignment(iterator obiiter.IBioSequence) obiiter.IBioSequence {
+	source, sequences := iterator.Load()
+	groups := GroupSequences(sequences, CLIGroupBy())
+
+	log.Infof("Aligning %d groups of sequences", len(groups))
+
+	scoring := CLIScoringScheme()
+	results := make([]obiseq.BioSequenceSlice, len(groups))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+
+	nworkers := obidefault.ParallelWorkers()
+	wg.Add(nworkers)
+
+	for w := 0; w < nworkers; w++ {
+		go func() {
+			defer wg.Done()
+			for g := range next {
+				aligned := AlignGroup(groups[g], scoring, CLIFreeEndGaps())
+
+				if CLIConsensusMode() {
+					aligned = obiseq.BioSequenceSlice{
+						GroupConsensus(groups[g].Name, aligned, CLIConsensusThreshold()),
+					}
+				}
+
+				results[g] = aligned
+			}
+		}()
+	}
+
+	for g := range groups {
+		next <- g
+	}
+	close(next)
+
+	wg.Wait()
+
+	output := obiseq.MakeBioSequenceSlice(0)
+	for _, result := range results {
+		output = append(output, result...)
+	}
+
+	return obiiter.IBatchOver(source, output, obidefault.BatchSize())
+}
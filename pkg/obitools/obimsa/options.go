@@ -0,0 +1,85 @@
+package obimsa
+
+import (
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obialign"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
+	"github.com/DavidGamba/go-getoptions"
+)
+
+var _GroupBy = ""
+var _Consensus = false
+var _ConsensusThreshold = 0.5
+var _FreeEndGaps = false
+var _Match = obialign.DefaultMatchScore
+var _Mismatch = obialign.DefaultMismatchScore
+var _GapOpen = obialign.DefaultGapOpen
+var _GapExtend = obialign.DefaultGapExtend
+
+// MSAOptionSet adds to a CLI the options tuning the multiple alignments.
+func MSAOptionSet(options *getoptions.GetOpt) {
+	options.StringVar(&_GroupBy, "group-by", _GroupBy,
+		options.Alias("g"),
+		options.ArgName("ATTRIBUTE"),
+		options.Description("Align separately the groups of sequences sharing the same value of this attribute "+
+			"(e.g. obiclean_cluster). A sequence annotated by a map belongs to the groups of every value of the map. "+
+			"By default all the sequences are aligned together."))
+
+	options.BoolVar(&_Consensus, "consensus", _Consensus,
+		options.Alias("C"),
+		options.Description("Write the consensus sequence of each group instead of the aligned sequences."))
+
+	options.Float64Var(&_ConsensusThreshold, "consensus-threshold", _ConsensusThreshold,
+		options.ArgName("#.###"),
+		options.Description("Fraction of the nucleotides of a column represented by the IUPAC symbol of the consensus."))
+
+	options.BoolVar(&_FreeEndGaps, "free-end-gaps", _FreeEndGaps,
+		options.Description("Do not penalize the gaps at the ends of the alignments."))
+
+	options.IntVar(&_Match, "match", _Match,
+		options.Description("Score of two identical nucleotides."))
+
+	options.IntVar(&_Mismatch, "mismatch", _Mismatch,
+		options.Description("Score of two different nucleotides."))
+
+	options.IntVar(&_GapOpen, "gap-open", _GapOpen,
+		options.Description("Penalty of the first position of a gap."))
+
+	options.IntVar(&_GapExtend, "gap-extend", _GapExtend,
+		options.Description("Penalty of each following position of a gap."))
+}
+
+// OptionSet adds to the basic option set every options declared for
+// the obimsa command
+func OptionSet(options *getoptions.GetOpt) {
+	obiconvert.OptionSet(false)(options)
+	MSAOptionSet(options)
+}
+
+// CLIGroupBy returns the attribute defining the groups of sequences, an
+// empty string when all the sequences are aligned together.
+func CLIGroupBy() string {
+	return _GroupBy
+}
+
+// CLIConsensusMode returns true if the consensus sequences are written
+// instead of the aligned sequences.
+func CLIConsensusMode() bool {
+	return _Consensus
+}
+
+// CLIConsensusThreshold returns the fraction of the nucleotides represented
+// by a consensus symbol.
+func CLIConsensusThreshold() float64 {
+	return _ConsensusThreshold
+}
+
+// CLIFreeEndGaps returns true if the end gaps are not penalized.
+func CLIFreeEndGaps() bool {
+	return _FreeEndGaps
+}
+
+// CLIScoringScheme returns the scoring scheme defined by the --match,
+// --mismatch, --gap-open and --gap-extend options.
+func CLIScoringScheme() *obialign.ScoringScheme {
+	return obialign.NewScoringScheme(_Match, _Mismatch, _GapOpen, _GapExtend)
+}
@@ -4,12 +4,73 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiformats"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obingslibrary"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
 )
 
+var _Stats *obingslibrary.DemultiplexStats
+
+// readName returns the identifier of a read without the /1, /2, /3 or /4
+// suffix marking the read of a fragment in the Illumina files.
+func readName(id string) string {
+	if n := len(id); n > 2 && id[n-2] == '/' && id[n-1] >= '1' && id[n-1] <= '4' {
+		return id[:n-2]
+	}
+
+	return id
+}
+
+// addIndexReads reads a file of index reads and appends their sequence to
+// the illumina_index attribute of the reads, separated by a + sign. The
+// reads and the index reads are matched by their identifiers, ignoring
+// their /1, /2, /3 or /4 suffix.
+func addIndexReads(iterator obiiter.IBioSequence, filename string) obiiter.IBioSequence {
+	indexes, err := obiformats.ReadSequencesFromFile(filename)
+
+	if err != nil {
+		log.Fatalf("Cannot open the index file %s: %v", filename, err)
+	}
+
+	log.Infof("Reading the index reads from file: %s", filename)
+
+	return iterator.CombineWith(indexes, func(sequence, index *obiseq.BioSequence) {
+		if readName(sequence.Id()) != readName(index.Id()) {
+			log.Fatalf("Read %s does not correspond to the index read %s in file %s",
+				sequence.Id(), index.Id(), filename)
+		}
+
+		value := index.String()
+		if previous, ok := sequence.GetStringAttribute("illumina_index"); ok {
+			value = previous + "+" + value
+		}
+
+		sequence.SetAttribute("illumina_index", value)
+	})
+}
+
+// CLIAddIndexReads annotates the reads with the index reads of the I1 and
+// I2 files given by the --index1 and --index2 options. The files must
+// list the reads in the same order.
+func CLIAddIndexReads(iterator obiiter.IBioSequence) obiiter.IBioSequence {
+	if !CLIHasIndexFiles() {
+		if CLIIndex2FileName() != "" {
+			log.Fatalf("The --index2 option requires the --index1 option")
+		}
+		return iterator
+	}
+
+	iterator = addIndexReads(iterator, CLIIndex1FileName())
+
+	if CLIIndex2FileName() != "" {
+		iterator = addIndexReads(iterator, CLIIndex2FileName())
+	}
+
+	return iterator
+}
+
 func IExtractBarcode(iterator obiiter.IBioSequence) (obiiter.IBioSequence, error) {
 
 	opts := make([]obingslibrary.WithOption, 0, 10)
@@ -17,6 +78,7 @@ func IExtractBarcode(iterator obiiter.IBioSequence) (obiiter.IBioSequence, error
 	opts = append(opts,
 		obingslibrary.OptionAllowedMismatches(CLIAllowedMismatch()),
 		obingslibrary.OptionAllowedIndel(CLIAllowsIndel()),
+		obingslibrary.OptionLongReads(CLILongReads()),
 		obingslibrary.OptionUnidentified(CLIUnidentifiedFileName()),
 		obingslibrary.OptionDiscardErrors(!CLIConservedErrors()),
 		obingslibrary.OptionParallelWorkers(obidefault.ParallelWorkers()),
@@ -30,6 +92,16 @@ func IExtractBarcode(iterator obiiter.IBioSequence) (obiiter.IBioSequence, error
 
 	worker := ngsfilter.ExtractMultiBarcodeSliceWorker(opts...)
 
+	if CLIHasStatsFile() {
+		_Stats = obingslibrary.NewDemultiplexStats(ngsfilter)
+		extract := worker
+		worker = func(sequences obiseq.BioSequenceSlice) (obiseq.BioSequenceSlice, error) {
+			results, err := extract(sequences)
+			_Stats.AddSlice(results)
+			return results, err
+		}
+	}
+
 	newIter := iterator.MakeISliceWorker(worker, false)
 	out := newIter
 
@@ -59,3 +131,17 @@ func IExtractBarcode(iterator obiiter.IBioSequence) (obiiter.IBioSequence, error
 
 	return out, nil
 }
+
+// CLIWriteStats writes the demultiplexing report requested by the --stats
+// option. It must be called once every sequence has been demultiplexed.
+func CLIWriteStats() {
+	if _Stats == nil {
+		return
+	}
+
+	if err := _Stats.WriteReport(CLIStatsFileName()); err != nil {
+		log.Fatalf("Cannot write the demultiplexing report: %v", err)
+	}
+
+	log.Infof("Demultiplexing report saved in file: %s", CLIStatsFileName())
+}
@@ -0,0 +1,82 @@
+package obimultiplex
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+func TestReadName(t *testing.T) {
+	tests := map[string]string{
+		"read":     "read",
+		"read/1":   "read",
+		"read/2":   "read",
+		"read/4":   "read",
+		"read/5":   "read/5",
+		"read/12":  "read/12",
+		"/1":       "/1",
+		"a:b:c/2":  "a:b:c",
+		"read_1/1": "read_1",
+	}
+
+	for id, name := range tests {
+		if n := readName(id); n != name {
+			t.Errorf("name of read %s is %s, expected %s", id, n, name)
+		}
+	}
+}
+
+// Both reads of a pair are annotated by the index reads, whose identifiers
+// can carry another suffix.
+func TestAddIndexReads(t *testing.T) {
+	forwards := obiseq.MakeBioSequenceSlice(0)
+	reverses := obiseq.MakeBioSequenceSlice(0)
+	index1 := strings.Builder{}
+	index2 := strings.Builder{}
+
+	for i := 0; i < 25; i++ {
+		forward := obiseq.NewBioSequence(fmt.Sprintf("read_%d/1", i), []byte("acgtacgtacgt"), "")
+		reverse := obiseq.NewBioSequence(fmt.Sprintf("read_%d/2", i), []byte("ttgcattgcatt"), "")
+		forward.PairTo(reverse)
+		forwards = append(forwards, forward)
+		reverses = append(reverses, reverse)
+
+		fmt.Fprintf(&index1, "@read_%d/3\n%s\n+\nIIIIIIII\n", i, "acgtacgt"[i%4:]+"acgtacgt"[:i%4])
+		fmt.Fprintf(&index2, "@read_%d\n%s\n+\nIIIIIIII\n", i, "ttggccaa")
+	}
+
+	directory := t.TempDir()
+	files := []string{filepath.Join(directory, "I1.fastq"), filepath.Join(directory, "I2.fastq")}
+	for i, content := range []string{index1.String(), index2.String()} {
+		if err := os.WriteFile(files[i], []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	iterator := obiiter.IBatchOver("test", forwards, 10)
+	iterator = addIndexReads(iterator, files[0])
+	iterator = addIndexReads(iterator, files[1])
+
+	_, reads := iterator.Load()
+
+	if len(reads) != len(forwards) {
+		t.Fatalf("%d reads annotated, expected %d", len(reads), len(forwards))
+	}
+
+	for _, read := range reads {
+		var i int
+		fmt.Sscanf(read.Id(), "read_%d/1", &i)
+		expected := "acgtacgt"[i%4:] + "acgtacgt"[:i%4] + "+ttggccaa"
+
+		for _, sequence := range []*obiseq.BioSequence{read, read.PairedWith()} {
+			if index, _ := sequence.GetStringAttribute("illumina_index"); index != expected {
+				t.Errorf("%s index is %q, expected %q", sequence.Id(), index, expected)
+			}
+		}
+	}
+}
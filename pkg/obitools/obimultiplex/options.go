@@ -1,8 +1,11 @@
 package obimultiplex
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiformats"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obingslibrary"
@@ -14,10 +17,15 @@ import (
 
 var _NGSFilterFile = ""
 var _askTemplate = false
+var _askCheck = false
 var _UnidentifiedFile = ""
 var _AllowedMismatch = 2
 var _AllowsIndel = false
+var _LongReads = false
 var _ConservedError = false
+var _StatsFile = ""
+var _Index1File = ""
+var _Index2File = ""
 
 // PCROptionSet defines every options related to a simulated PCR.
 //
@@ -39,6 +47,12 @@ func MultiplexOptionSet(options *getoptions.GetOpt) {
 	options.BoolVar(&_AllowsIndel, "with-indels", _AllowsIndel,
 		options.Description("Allows for indels during the primers matching."))
 
+	options.BoolVar(&_LongReads, "long-reads", _LongReads,
+		options.Description("Demultiplexes long noisy reads (Oxford Nanopore, PacBio). The primers "+
+			"are searched anywhere in the reads, on both strands, allowing for indels and "+
+			"for a number of errors proportional to their length. The tags are identified by "+
+			"an alignment tolerating indels, and the reads including several amplicons are split."))
+
 	options.StringVar(&_UnidentifiedFile, "unidentified", _UnidentifiedFile,
 		options.Alias("u"),
 		options.Description("Filename used to store the sequences unassigned to any sample."))
@@ -47,6 +61,28 @@ func MultiplexOptionSet(options *getoptions.GetOpt) {
 		options.Alias("e"),
 		options.Description("Used to specify the number of errors allowed for matching primers."))
 
+	options.StringVar(&_StatsFile, "stats", _StatsFile,
+		options.ArgName("FILENAME"),
+		options.Description("Write to this file a JSON report of the demultiplexing: the reads assigned "+
+			"to each sample, the unassigned reads by error reason, the primer and tag mismatch "+
+			"distributions and the read counts of every forward and reverse tag combination."))
+
+	options.StringVar(&_Index1File, "index1", _Index1File,
+		options.ArgName("FILENAME"),
+		options.Description("File of the I1 index reads (i7 index), used when the samples are "+
+			"identified by the index column of the NGSFilter file and the index is not in "+
+			"the read headers."))
+
+	options.StringVar(&_Index2File, "index2", _Index2File,
+		options.ArgName("FILENAME"),
+		options.Description("File of the I2 index reads (i5 index), used with --index1 "+
+			"for the dual indexed libraries."))
+
+	options.BoolVar(&_askCheck, "check", _askCheck,
+		options.Description("Check the NGSFilter file given by --tag-list, report all its problems "+
+			"and exit without reading any sequence."),
+	)
+
 	options.BoolVar(&_askTemplate, "template", _askTemplate,
 		options.Description("Print on the standard output an example of CSV configuration file."),
 	)
@@ -65,6 +101,13 @@ func CLIAllowedMismatch() int {
 func CLIAllowsIndel() bool {
 	return _AllowsIndel
 }
+
+// CLILongReads returns true if the reads must be demultiplexed as long noisy
+// reads.
+func CLILongReads() bool {
+	return _LongReads
+}
+
 func CLIUnidentifiedFileName() string {
 	return _UnidentifiedFile
 }
@@ -73,6 +116,26 @@ func CLIConservedErrors() bool {
 	return _UnidentifiedFile != "" || _ConservedError
 }
 
+func CLIHasStatsFile() bool {
+	return _StatsFile != ""
+}
+
+func CLIStatsFileName() string {
+	return _StatsFile
+}
+
+func CLIHasIndexFiles() bool {
+	return _Index1File != ""
+}
+
+func CLIIndex1FileName() string {
+	return _Index1File
+}
+
+func CLIIndex2FileName() string {
+	return _Index2File
+}
+
 func CLIHasNGSFilterFile() bool {
 	return _NGSFilterFile != ""
 }
@@ -94,6 +157,63 @@ func CLINGSFIlter() (*obingslibrary.NGSLibrary, error) {
 	return ngsfiler, nil
 }
 
+func CLIAskCheck() bool {
+	return _askCheck
+}
+
+// CLICheckNGSFilter checks the NGSFilter file and prints its problems on
+// the standard output.
+//
+// Returns:
+//   - True if no error was found, warnings being allowed.
+func CLICheckNGSFilter() bool {
+	file, err := os.Open(_NGSFilterFile)
+
+	if err != nil {
+		log.Fatalf("open file error: %v", err)
+	}
+
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+
+	if err != nil {
+		log.Fatalf("NGSfilter reading file error: %v", err)
+	}
+
+	// The CSV detector rejects the files whose rows have different numbers
+	// of columns, a problem that must be reported here. A file is thus
+	// considered as CSV if its first line is a parameter or a CSV header.
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "@param,") && !strings.Contains(line, "experiment,") {
+			log.Fatalf("Only the CSV NGSFilter files can be checked, %s is in the old format", _NGSFilterFile)
+		}
+
+		break
+	}
+
+	reader := bytes.NewReader(content)
+
+	problems := obiformats.CheckCSVNGSFilter(reader)
+
+	errors := 0
+	for _, problem := range problems {
+		fmt.Printf("%s: %s\n", _NGSFilterFile, problem)
+		if !problem.Warning {
+			errors++
+		}
+	}
+
+	fmt.Printf("%s: %d errors, %d warnings\n", _NGSFilterFile, errors, len(problems)-errors)
+
+	return errors == 0
+}
+
 func CLIAskConfigTemplate() bool {
 	return _askTemplate
 }
@@ -145,6 +265,20 @@ func CLIConfigTemplate() string {
 @param,forward_spacer,0
 @param,reverse_spacer,0
 #
+# Unique molecular identifiers (UMI) located in the tag region can be
+# extracted with the umi parameter. Its first value is the number of
+# nucleotides between the 3' end of the UMI and the 5' end of the primer,
+# its second value is the UMI pattern. The n positions of the pattern are
+# the random nucleotides of the UMI, other nucleotides are anchors that must
+# be found in the read. For a read made of an 8 nt UMI followed by an 8 nt
+# tag and the primer, the parameter is:
+#
+#       @param,umi,8,nnnnnnnn
+#
+# forward_umi and reverse_umi set the UMI of each primer, and the umi
+# parameter with three values the UMI of a specific primer. The UMIs are
+# stored in the umi attribute, used by obiuniq --umi to count molecules.
+#
 # A new method for designing indel proof tag is to not use one of the four 
 # nucleotides in their sequence and to flank the tag with this fourth nucleotide.
 # That nucleotide is the tag delimiter. Similarly, to the spacer value, 
@@ -171,12 +305,23 @@ func CLIConfigTemplate() string {
 #
 @param,primer_mismatches,2
 #
+# When the samples are identified by Illumina indexes (see the index column
+# below), the index_mismatches parameter gives the number of mismatches
+# allowed on each index. The default value is 0.
+#
+#       @param,index_mismatches,1
+#
 # The @indel parameter allows to specify if indel are allowed during the matching
 # of the primers to the sequence. The default value is false. forward_indel and
 # reverse_indel can be used to specify the value for each primer.
 #
 @param,indels,false
 #
+# With the --long-reads option, used for Oxford Nanopore or PacBio reads,
+# indels are always allowed on the primers, the number of errors allowed
+# is raised to 15% of the primer length, and the tags are identified by an
+# alignment tolerating indels whatever the @matching parameter.
+#
 ###
 ###  Description of the PCR multiplexed
 ###
@@ -200,6 +345,13 @@ func CLIConfigTemplate() string {
 #   For a given primer all the tags must have the same length.
 # - forward_primer: the forward primer sequence
 # - reverse_primer: the reverse primer sequence
+#
+# An optional index column gives the Illumina index pair identifying the
+# sequencing library of the sample, as i7+i5 (e.g. ACGTACGT+TTGATTGA) or i7
+# alone for single indexed libraries. The indexes are read from the Casava
+# headers of the reads (1:N:0:ACGTACGT+TTGATTGA) or from the I1 and I2 files
+# given by the --index1 and --index2 options. The same tags can be reused
+# in the libraries having different indexes.
 # 
 experiment,sample,sample_tag,forward_primer,reverse_primer
 wolf_diet,13a_F730603,aattaac,TTAGATACCCCACTATGC,TAGAACAGGCTCCTCTAG
@@ -0,0 +1,124 @@
+package obipairing
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obialign"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
+)
+
+// _CalibrationMinOverlap is the minimum length of the overlaps used to
+// calibrate the qualities, whatever the --min-overlap option.
+const _CalibrationMinOverlap = 40
+
+// CalibrateQualities learns the quality calibration from the first pairs of
+// reads. The pairs are aligned, and only the overlaps long and similar
+// enough to be trusted are recorded. The batches read for the calibration
+// are then sent back, unchanged, at the beginning of the returned iterator.
+//
+// Parameters:
+//   - iterator: The paired reads.
+//   - gap, scale, delta, fastAlign, fastModeRel: The alignment parameters,
+//     as for IAssemblePESequencesBatch.
+//   - minOverlap: The minimum length of a recorded overlap.
+//   - minIdentity: The minimum identity of a recorded overlap.
+//   - nreads: The number of pairs of reads used for the calibration.
+//
+// Returns:
+//   - The fitted quality calibration.
+//   - An iterator over all the paired reads.
+func CalibrateQualities(iterator obiiter.IBioSequence,
+	gap, scale float64, delta, minOverlap int,
+	minIdentity float64, fastAlign, fastModeRel bool,
+	nreads int) (*obialign.QualityCalibration, obiiter.IBioSequence) {
+
+	if !iterator.IsPaired() {
+		log.Fatalln("Sequence data must be paired")
+	}
+
+	calibration := obialign.NewQualityCalibration()
+	arena := obialign.MakePEAlignArena(150, 150)
+	shifts := make(map[int]int)
+	minOverlap = max(minOverlap, _CalibrationMinOverlap)
+
+	batches := make([]obiiter.BioSequenceBatch, 0)
+	aligned := 0
+
+	for aligned < nreads && iterator.Next() {
+		batch := iterator.Get()
+		batches = append(batches, batch)
+
+		for _, A := range batch.Slice() {
+			if aligned >= nreads {
+				break
+			}
+
+			B := A.PairedWith().ReverseComplement(false)
+			_, _, path, _, _, _ := obialign.PEAlign(
+				A, B,
+				gap, scale,
+				fastAlign, delta, fastModeRel,
+				arena, &shifts,
+			)
+			calibration.Record(A, B, path, minOverlap, minIdentity, arena)
+			B.Recycle()
+			aligned++
+		}
+	}
+
+	calibration.Fit()
+
+	log.Infof("Quality calibration learnt from %d overlaps out of %d read pairs",
+		calibration.Pairs(), aligned)
+
+	return calibration, _PrependBatches(batches, iterator)
+}
+
+// _PrependBatches builds an iterator sending a set of batches before the
+// ones of another iterator.
+func _PrependBatches(batches []obiiter.BioSequenceBatch,
+	iterator obiiter.IBioSequence) obiiter.IBioSequence {
+
+	newIter := obiiter.MakeIBioSequence()
+
+	newIter.Add(1)
+
+	go func() {
+		newIter.WaitAndClose()
+	}()
+
+	go func() {
+		for _, batch := range batches {
+			newIter.Push(batch)
+		}
+
+		for iterator.Next() {
+			newIter.Push(iterator.Get())
+		}
+
+		newIter.Done()
+	}()
+
+	if iterator.IsPaired() {
+		newIter.MarkAsPaired()
+	}
+
+	return newIter
+}
+
+// LogQualityCalibration logs the observed and the reported error rates of
+// the qualities.
+func LogQualityCalibration(calibration *obialign.QualityCalibration) {
+	report := calibration.Report()
+
+	if report.Positions > 0 {
+		log.Infof("Quality calibration: %d mismatches on %d aligned positions (%.4f%%)",
+			report.Mismatches, report.Positions,
+			float64(report.Mismatches)/float64(report.Positions)*100)
+	}
+
+	for _, q := range report.Qualities {
+		log.Infof("Quality %2d: %9d positions, reported error %.6f, observed error %.6f -> calibrated quality %d",
+			q.Quality, q.Observations, q.ReportedError, q.ObservedError, q.CalibratedQuality)
+	}
+}
@@ -16,6 +16,10 @@ var _MinIdentity = 0.9
 var _NoFastAlign = false
 var _FastScoreAbs = false
 var _PenaltyScale = 1.0
+var _Calibrate = false
+var _CalibrationReads = 10000
+var _LoadCalibration = ""
+var _SaveCalibration = ""
 
 func PairingOptionSet(options *getoptions.GetOpt) {
 	options.StringVar(&_ForwardFile, "forward-reads", "",
@@ -46,6 +50,19 @@ func PairingOptionSet(options *getoptions.GetOpt) {
 		options.Description("Do not run fast alignment heuristic."))
 	options.BoolVar(&_FastScoreAbs, "fast-absolute", _FastScoreAbs,
 		options.Description("Compute absolute fast score (no action in exact mode)."))
+	options.BoolVar(&_Calibrate, "calibrate", _Calibrate,
+		options.Description("Learn the actual error rate of each quality score from the overlaps "+
+			"of the first read pairs, and use it to compute the qualities of the consensus sequences."))
+	options.IntVar(&_CalibrationReads, "calibration-reads", _CalibrationReads,
+		options.ArgName("N"),
+		options.Description("Number of read pairs used to learn the quality calibration."))
+	options.StringVar(&_LoadCalibration, "load-calibration", _LoadCalibration,
+		options.ArgName("FILENAME"),
+		options.Description("Use the quality calibration saved in this file instead of learning it."))
+	options.StringVar(&_SaveCalibration, "save-calibration", _SaveCalibration,
+		options.ArgName("FILENAME"),
+		options.Description("Save to this file the quality calibration, with the observed and the "+
+			"reported error rates, in JSON format."))
 }
 
 func OptionSet(options *getoptions.GetOpt) {
@@ -105,3 +122,29 @@ func CLIFastMode() bool {
 func CLIFastRelativeScore() bool {
 	return !_FastScoreAbs
 }
+
+// CLICalibrate returns true if the quality calibration must be learnt from
+// the reads. Saving a calibration implies to learn it if it is not loaded.
+func CLICalibrate() bool {
+	return (_Calibrate || _SaveCalibration != "") && _LoadCalibration == ""
+}
+
+func CLICalibrationReads() int {
+	return _CalibrationReads
+}
+
+func CLIHasCalibrationFile() bool {
+	return _LoadCalibration != ""
+}
+
+func CLICalibrationFile() string {
+	return _LoadCalibration
+}
+
+func CLISaveCalibration() bool {
+	return _SaveCalibration != ""
+}
+
+func CLISaveCalibrationFile() string {
+	return _SaveCalibration
+}
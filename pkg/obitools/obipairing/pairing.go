@@ -2,6 +2,7 @@ package obipairing
 
 import (
 	"math"
+	"slices"
 
 	log "github.com/sirupsen/logrus"
 
@@ -9,7 +10,6 @@ import (
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
-	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
 )
 
 func _Abs(x int) int {
@@ -81,6 +81,14 @@ func JoinPairedSequence(seqA, seqB *obiseq.BioSequence, inplace bool) *obiseq.Bi
 // a given length, it is discarded and booth sequences are only
 // pasted using the obipairing.JoinPairedSequence function.
 //
+// The seq_a_single and seq_b_single statistics are the lengths of the
+// beginning of seqA and of the end of seqB that are not covered by the other
+// read. The beginning of seqB preceding seqA and the end of seqA following
+// seqB, as found in right alignments, are not single parts of the reads but
+// adapters read through a short insert. They are removed from the consensus,
+// reported by the seq_b_adapter and seq_a_adapter statistics, and are not
+// counted as single parts. Right alignments therefore have no single part.
+//
 // # Parameters
 //
 // - seqA, seqB: the pair of sequences to align.
@@ -104,6 +112,9 @@ func JoinPairedSequence(seqA, seqB *obiseq.BioSequence, inplace bool) *obiseq.Bi
 //
 // - fastModeRel: if set to true, the FAST score mode is set to relative score
 //
+// - calibration: the quality calibration used to compute the qualities of
+// the consensus, nil to use the reported qualities.
+//
 // # Returns
 //
 // An obiseq.BioSequence corresponding to the assembling of the both
@@ -111,6 +122,7 @@ func JoinPairedSequence(seqA, seqB *obiseq.BioSequence, inplace bool) *obiseq.Bi
 func AssemblePESequences(seqA, seqB *obiseq.BioSequence,
 	gap, scale float64, delta, minOverlap int, minIdentity float64, withStats bool,
 	inplace bool, fastAlign, fastModeRel bool,
+	calibration *obialign.QualityCalibration,
 	arenaAlign obialign.PEAlignArena, shifh_buff *map[int]int) *obiseq.BioSequence {
 
 	isLeftAlign, score, path, fastcount, over, fastscore := obialign.PEAlign(
@@ -120,15 +132,12 @@ func AssemblePESequences(seqA, seqB *obiseq.BioSequence,
 		arenaAlign, shifh_buff,
 	)
 
-	cons, match := obialign.BuildQualityConsensus(seqA, seqB, path, true, arenaAlign)
+	cons, match := obialign.BuildCalibratedQualityConsensus(seqA, seqB, path, true,
+		calibration, arenaAlign)
 
-	left := path[0]
-	right := 0
-	if path[len(path)-1] == 0 {
-		right = path[len(path)-2]
-	}
+	startA, startB, endA, endB := obialign.PEUnalignedEnds(path)
 	lcons := cons.Len()
-	aliLength := lcons - _Abs(left) - _Abs(right)
+	aliLength := lcons - startA - startB - endA - endB
 
 	identity := float64(match) / float64(aliLength)
 	if aliLength == 0 {
@@ -143,16 +152,30 @@ func AssemblePESequences(seqA, seqB *obiseq.BioSequence,
 	}
 
 	if aliLength >= minOverlap && identity >= minIdentity {
+		adapterB, adapterA := ReadThroughOverhangs(path)
+		readThrough := adapterA > 0 || adapterB > 0
+
+		if readThrough {
+			_TrimConsensus(cons, adapterB, lcons-adapterA)
+		}
+
 		annot["mode"] = "alignment"
+		annot["pairing_insert_size"] = cons.Len()
+		annot["pairing_read_through"] = readThrough
+
 		if withStats {
 			if isLeftAlign {
 				annot["ali_dir"] = "left"
-				annot["seq_a_single"] = obiutils.Abs(left)
-				annot["seq_b_single"] = obiutils.Abs(right)
 			} else {
 				annot["ali_dir"] = "right"
-				annot["seq_a_single"] = obiutils.Abs(right)
-				annot["seq_b_single"] = obiutils.Abs(left)
+			}
+			// The parts of the reads beyond the insert are not single
+			// parts of the reads but adapters, and have been removed.
+			annot["seq_a_single"] = startA
+			annot["seq_b_single"] = endB
+			if readThrough {
+				annot["seq_a_adapter"] = adapterA
+				annot["seq_b_adapter"] = adapterB
 			}
 		}
 		if inplace {
@@ -181,6 +204,53 @@ func AssemblePESequences(seqA, seqB *obiseq.BioSequence,
 	return cons
 }
 
+// ReadThroughOverhangs measures the parts of two aligned paired reads
+// extending beyond the insert. When the insert is shorter than a read, the
+// sequencing continues into the adapter: the end of seqA extends beyond the
+// end of seqB, and the beginning of seqB, which is reverse complemented,
+// precedes the beginning of seqA.
+//
+// Parameters:
+//   - path: The alignment path of seqA and seqB as returned by
+//     obialign.PEAlign.
+//
+// Returns:
+//   - The length of the beginning of seqB preceding seqA.
+//   - The length of the end of seqA following seqB.
+func ReadThroughOverhangs(path []int) (int, int) {
+	overhangB, overhangA := 0, 0
+
+	if len(path) == 0 {
+		return 0, 0
+	}
+
+	if path[0] > 0 {
+		overhangB = path[0]
+	}
+
+	if len(path) > 2 && path[len(path)-1] == 0 && path[len(path)-2] < 0 {
+		overhangA = -path[len(path)-2]
+	}
+
+	return overhangB, overhangA
+}
+
+// _TrimConsensus restricts a consensus sequence to the positions from
+// (included) to to (excluded). The positions of the mismatches annotated
+// on the consensus are updated accordingly.
+func _TrimConsensus(cons *obiseq.BioSequence, from, to int) {
+	qualities := slices.Clone(cons.Qualities()[from:to])
+	cons.SetSequence(cons.Sequence()[from:to])
+	cons.TakeQualities(qualities)
+
+	if mismatches, ok := cons.GetIntMap("pairing_mismatches"); ok && from > 0 {
+		for m, p := range mismatches {
+			mismatches[m] = p - from
+		}
+		cons.SetAttribute("pairing_mismatches", mismatches)
+	}
+}
+
 // IAssemblePESequencesBatch aligns paired reads.
 //
 // The function consumes an iterator over batches of paired sequences and
@@ -206,6 +276,9 @@ func AssemblePESequences(seqA, seqB *obiseq.BioSequence,
 // - withStats: indicates (true value) if the algorithm adds annotation to each
 // sequence on the quality of the aligned overlap.
 //
+// - calibration: the quality calibration used to compute the qualities of
+// the consensus sequences, nil to use the reported qualities.
+//
 // Two extra interger parameters can be added during the call of the function.
 // The first one indicates how many parallel workers run for aligning the sequences.
 // The second allows too specify the size of the channel buffer.
@@ -217,7 +290,8 @@ func AssemblePESequences(seqA, seqB *obiseq.BioSequence,
 func IAssemblePESequencesBatch(iterator obiiter.IBioSequence,
 	gap, scale float64, delta, minOverlap int,
 	minIdentity float64, fastAlign, fastModeRel,
-	withStats bool, sizes ...int) obiiter.IBioSequence {
+	withStats bool, calibration *obialign.QualityCalibration,
+	sizes ...int) obiiter.IBioSequence {
 
 	if !iterator.IsPaired() {
 		log.Fatalln("Sequence data must be paired")
@@ -249,7 +323,7 @@ func IAssemblePESequencesBatch(iterator obiiter.IBioSequence,
 				B := A.PairedWith()
 				cons[i] = AssemblePESequences(A, B.ReverseComplement(true),
 					gap, scale,
-					delta, minOverlap, minIdentity, withStats, true, fastAlign, fastModeRel, arena, &shifts)
+					delta, minOverlap, minIdentity, withStats, true, fastAlign, fastModeRel, calibration, arena, &shifts)
 			}
 			newIter.Push(obiiter.MakeBioSequenceBatch(
 				batch.Source(),
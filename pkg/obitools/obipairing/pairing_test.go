@@ -0,0 +1,246 @@
+package obipairing
+
+import (
+	"math/rand"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obialign"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+var _TestAdapterA = []byte("agatcggaagagcacacgtctgaactccagtcac")
+var _TestAdapterB = []byte("agatcggaagagcgtcgtgtagggaaagagtgt")
+
+func randomNucleotides(rng *rand.Rand, length int) []byte {
+	sequence := make([]byte, length)
+	for i := range sequence {
+		sequence[i] = "acgt"[rng.Intn(4)]
+	}
+	return sequence
+}
+
+// simulateRead builds a read of an insert followed, when the insert is too
+// short, by the adapter and random nucleotides.
+func simulateRead(rng *rand.Rand, id string, insert, adapter []byte, length int) *obiseq.BioSequence {
+	sequence := append([]byte{}, insert...)
+	sequence = append(sequence, adapter...)
+	sequence = append(sequence, randomNucleotides(rng, length)...)
+
+	read := obiseq.NewBioSequence(id, sequence[:length], "")
+	qualities := make(obiseq.Quality, length)
+	for i := range qualities {
+		qualities[i] = 38
+	}
+	read.SetQualities(qualities)
+
+	return read
+}
+
+// simulatePair builds a pair of reads of an insert, the second read being
+// reverse complemented as expected by AssemblePESequences.
+func simulatePair(rng *rand.Rand, insert []byte, lengthA, lengthB int) (*obiseq.BioSequence, *obiseq.BioSequence) {
+	reverse := obiseq.NewBioSequence("insert", append([]byte{}, insert...), "").ReverseComplement(true)
+
+	seqA := simulateRead(rng, "pair", insert, _TestAdapterA, lengthA)
+	seqB := simulateRead(rng, "pair", reverse.Sequence(), _TestAdapterB, lengthB)
+
+	return seqA, seqB.ReverseComplement(true)
+}
+
+func TestAssemblePESequencesGeometries(t *testing.T) {
+	tests := []struct {
+		name        string
+		insert      int
+		lengthA     int
+		lengthB     int
+		readThrough bool
+		adapterA    int
+		adapterB    int
+	}{
+		{"partial overlap", 200, 150, 150, false, 0, 0},
+		{"full overlap", 150, 150, 150, false, 0, 0},
+		{"read-through", 100, 150, 150, true, 50, 50},
+		{"short insert", 40, 150, 150, true, 110, 110},
+		{"read-through of the first read", 130, 150, 100, true, 20, 0},
+		{"read-through of the second read", 130, 100, 150, true, 0, 20},
+		{"first read included", 150, 100, 150, false, 0, 0},
+		{"second read included", 150, 150, 100, false, 0, 0},
+	}
+
+	rng := rand.New(rand.NewSource(7))
+	arena := obialign.MakePEAlignArena(150, 150)
+	shifts := make(map[int]int)
+
+	for _, test := range tests {
+		for _, fastAlign := range []bool{true, false} {
+			insert := randomNucleotides(rng, test.insert)
+			seqA, seqB := simulatePair(rng, insert, test.lengthA, test.lengthB)
+
+			cons := AssemblePESequences(seqA, seqB, 2, 1, 5, 20, 0.9, true,
+				true, fastAlign, true, nil, arena, &shifts)
+
+			if mode, _ := cons.GetStringAttribute("mode"); mode != "alignment" {
+				t.Errorf("%s (fast: %v): mode is %s", test.name, fastAlign, mode)
+				continue
+			}
+
+			if string(cons.Sequence()) != string(insert) {
+				t.Errorf("%s (fast: %v): consensus is\n%s\nexpected\n%s",
+					test.name, fastAlign, cons.Sequence(), insert)
+			}
+
+			if cons.Len() != len(cons.Qualities()) {
+				t.Errorf("%s (fast: %v): %d qualities for %d nucleotides",
+					test.name, fastAlign, len(cons.Qualities()), cons.Len())
+			}
+
+			if size, _ := cons.GetIntAttribute("pairing_insert_size"); size != test.insert {
+				t.Errorf("%s (fast: %v): insert size is %d, expected %d",
+					test.name, fastAlign, size, test.insert)
+			}
+
+			if readThrough, _ := cons.GetBoolAttribute("pairing_read_through"); readThrough != test.readThrough {
+				t.Errorf("%s (fast: %v): read-through is %v, expected %v",
+					test.name, fastAlign, readThrough, test.readThrough)
+			}
+
+			// The overlap of the reads, and their single parts, the
+			// adapters read through a short insert excepted.
+			overlap := min(test.insert, test.lengthA, test.lengthB, test.lengthA+test.lengthB-test.insert)
+			if length, _ := cons.GetIntAttribute("ali_length"); length != overlap {
+				t.Errorf("%s (fast: %v): alignment length is %d, expected %d",
+					test.name, fastAlign, length, overlap)
+			}
+
+			singleA, _ := cons.GetIntAttribute("seq_a_single")
+			singleB, _ := cons.GetIntAttribute("seq_b_single")
+			if singleA != max(test.insert-test.lengthB, 0) || singleB != max(test.insert-test.lengthA, 0) {
+				t.Errorf("%s (fast: %v): single parts of %d and %d nucleotides, expected %d and %d",
+					test.name, fastAlign, singleA, singleB,
+					max(test.insert-test.lengthB, 0), max(test.insert-test.lengthA, 0))
+			}
+
+			if test.readThrough {
+				adapterA, _ := cons.GetIntAttribute("seq_a_adapter")
+				adapterB, _ := cons.GetIntAttribute("seq_b_adapter")
+				if adapterA != test.adapterA || adapterB != test.adapterB {
+					t.Errorf("%s (fast: %v): adapters of %d and %d nucleotides, expected %d and %d",
+						test.name, fastAlign, adapterA, adapterB, test.adapterA, test.adapterB)
+				}
+			}
+		}
+	}
+}
+
+func TestAssemblePESequencesReadThroughMismatches(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+	arena := obialign.MakePEAlignArena(150, 150)
+	shifts := make(map[int]int)
+
+	insert := randomNucleotides(rng, 90)
+	seqA, seqB := simulatePair(rng, insert, 150, 150)
+
+	// seqB starts with 60 nucleotides of adapter, an error is added on a
+	// low quality nucleotide of seqB at the position 30 of the insert.
+	position := 60 + 30
+	if insert[30] == 'a' {
+		seqB.Sequence()[position] = 'c'
+	} else {
+		seqB.Sequence()[position] = 'a'
+	}
+	seqB.Qualities()[position] = 10
+
+	cons := AssemblePESequences(seqA, seqB, 2, 1, 5, 20, 0.9, true,
+		true, true, true, nil, arena, &shifts)
+
+	if string(cons.Sequence()) != string(insert) {
+		t.Fatalf("consensus is\n%s\nexpected\n%s", cons.Sequence(), insert)
+	}
+
+	mismatches, ok := cons.GetIntMap("pairing_mismatches")
+	if !ok || len(mismatches) != 1 {
+		t.Fatalf("one mismatch expected, found %v", mismatches)
+	}
+
+	for _, p := range mismatches {
+		if p != 31 {
+			t.Errorf("mismatch at position %d of the consensus, expected 31", p)
+		}
+	}
+}
+
+func TestAssemblePESequencesNoOverlap(t *testing.T) {
+	rng := rand.New(rand.NewSource(13))
+	arena := obialign.MakePEAlignArena(150, 150)
+	shifts := make(map[int]int)
+
+	insert := randomNucleotides(rng, 400)
+	seqA, seqB := simulatePair(rng, insert, 150, 150)
+
+	cons := AssemblePESequences(seqA, seqB, 2, 1, 5, 20, 0.9, true,
+		true, true, true, nil, arena, &shifts)
+
+	if mode, _ := cons.GetStringAttribute("mode"); mode != "join" {
+		t.Errorf("mode is %s, expected join", mode)
+	}
+
+	if cons.HasAttribute("pairing_insert_size") {
+		t.Errorf("insert size annotated on joined reads")
+	}
+}
+
+// A read-through pair is a right alignment, the beginning of seqB and the
+// end of seqA are adapters and not single parts of the reads.
+func TestAssemblePESequencesRightAlignment(t *testing.T) {
+	rng := rand.New(rand.NewSource(17))
+	arena := obialign.MakePEAlignArena(150, 150)
+	shifts := make(map[int]int)
+
+	for _, fastAlign := range []bool{true, false} {
+		insert := randomNucleotides(rng, 120)
+		seqA, seqB := simulatePair(rng, insert, 150, 150)
+
+		cons := AssemblePESequences(seqA, seqB, 2, 1, 5, 20, 0.9, true,
+			true, fastAlign, true, nil, arena, &shifts)
+
+		expected := map[string]interface{}{
+			"ali_dir":       "right",
+			"ali_length":    120,
+			"seq_ab_match":  120,
+			"seq_a_single":  0,
+			"seq_b_single":  0,
+			"seq_a_adapter": 30,
+			"seq_b_adapter": 30,
+		}
+
+		for key, value := range expected {
+			if attribute, _ := cons.GetAttribute(key); attribute != value {
+				t.Errorf("fast: %v: %s is %v, expected %v", fastAlign, key, attribute, value)
+			}
+		}
+	}
+}
+
+func TestReadThroughOverhangs(t *testing.T) {
+	tests := []struct {
+		path     []int
+		overhang [2]int
+	}{
+		{[]int{-50, 100, 50, 0}, [2]int{0, 0}},
+		{[]int{50, 100, -50, 0}, [2]int{50, 50}},
+		{[]int{0, 150}, [2]int{0, 0}},
+		{[]int{-30, 100, -20, 0}, [2]int{0, 20}},
+		{[]int{20, 100, 30, 0}, [2]int{20, 0}},
+		{[]int{-10, 0, 4, 100, 5, 0}, [2]int{0, 0}},
+		{[]int{12, 0, -3, 100, 8, 0, -6, 0}, [2]int{12, 6}},
+		{[]int{}, [2]int{0, 0}},
+	}
+
+	for _, test := range tests {
+		overhangB, overhangA := ReadThroughOverhangs(test.path)
+		if overhangB != test.overhang[0] || overhangA != test.overhang[1] {
+			t.Errorf("path %v: overhangs are %d and %d, expected %v",
+				test.path, overhangB, overhangA, test.overhang)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package obipairwise
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obialign"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// BestAlignment aligns a query against each target sequence and returns
+// the alignment with the best score. Among equally scoring alignments, the
+// first target is retained.
+//
+// Parameters:
+//   - query: The query sequence.
+//   - targets: The target sequences.
+//   - mode: The alignment mode.
+//   - scoring: The scoring scheme.
+//   - band: The width of the alignment band, a negative value for full alignments.
+//
+// Returns:
+//   - The best alignment.
+//   - The index of the best target, -1 if there is no target.
+func BestAlignment(query *obiseq.BioSequence,
+	targets obiseq.BioSequenceSlice,
+	mode obialign.AlignmentMode,
+	scoring *obialign.ScoringScheme,
+	band int) (obialign.PairwiseAlignment, int) {
+
+	var best obialign.PairwiseAlignment
+	besti := -1
+
+	for i, target := range targets {
+		ali := obialign.AlignAffine(query, target, mode, scoring, band)
+		if besti < 0 || ali.Score > best.Score {
+			best, besti = ali, i
+		}
+	}
+
+	return best, besti
+}
+
+// AnnotateAlignment annotates a query with its alignment on a target
+// sequence.
+func AnnotateAlignment(query, target *obiseq.BioSequence, ali obialign.PairwiseAlignment) {
+	query.SetAttribute("pairwise_target", target.Id())
+	query.SetAttribute("pairwise_mode", ali.Mode.String())
+	query.SetAttribute("pairwise_score", ali.Score)
+	query.SetAttribute("pairwise_cigar", ali.Cigar)
+	query.SetAttribute("pairwise_identity", ali.Identity())
+	query.SetAttribute("pairwise_length", ali.Length)
+	query.SetAttribute("pairwise_query_start", ali.StartA)
+	query.SetAttribute("pairwise_query_end", ali.EndA)
+	query.SetAttribute("pairwise_target_start", ali.StartB)
+	query.SetAttribute("pairwise_target_end", ali.EndB)
+}
+
+// PairwiseWorker returns a worker annotating each query with its best
+// alignment against the target sequences.
+func PairwiseWorker(targets obiseq.BioSequenceSlice,
+	mode obialign.AlignmentMode,
+	scoring *obialign.ScoringScheme,
+	band int) obiseq.SeqWorker {
+
+	return func(query *obiseq.BioSequence) (obiseq.BioSequenceSlice, error) {
+		ali, i := BestAlignment(query, targets, mode, scoring, band)
+
+		if i >= 0 {
+			AnnotateAlignment(query, targets[i], ali)
+		}
+
+		return obiseq.BioSequenceSlice{query}, nil
+	}
+}
+
+// CLIAlignSequences aligns the sequences provided by the iterator against
+// the target sequences given by the --target option.
+func CLIAlignSequences(iterator obiiter.IBioSequence) obiiter.IBioSequence {
+	targets := CLITargets()
+
+	if len(targets) == 0 {
+		log.Fatalf("No target sequence loaded")
+	}
+
+	log.Infof("Aligning sequences against %d target sequences", len(targets))
+
+	worker := PairwiseWorker(targets, CLIAlignmentMode(), CLIScoringScheme(), CLIBand())
+
+	return iterator.MakeIWorker(worker, false, obidefault.ParallelWorkers())
+}
@@ -0,0 +1,93 @@
+package obipairwise
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obialign"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiformats"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
+	"github.com/DavidGamba/go-getoptions"
+)
+
+var _Target = ""
+var _Mode = "global"
+var _Match = obialign.DefaultMatchScore
+var _Mismatch = obialign.DefaultMismatchScore
+var _GapOpen = obialign.DefaultGapOpen
+var _GapExtend = obialign.DefaultGapExtend
+var _Band = -1
+
+// PairwiseOptionSet adds to a CLI the options tuning the alignment of the
+// queries against the target sequences.
+func PairwiseOptionSet(options *getoptions.GetOpt) {
+	options.StringVar(&_Target, "target", _Target,
+		options.Alias("R"),
+		options.ArgName("FILENAME"),
+		options.Required("You must provide a target sequence file"),
+		options.Description("The name of the file containing the target sequences."))
+
+	options.StringVar(&_Mode, "mode", _Mode,
+		options.ArgName("global|local|egf"),
+		options.Description("The alignment mode: global (Needleman-Wunsch), local (Smith-Waterman) "+
+			"or egf (global alignment without end gap penalties)."))
+
+	options.IntVar(&_Match, "match", _Match,
+		options.Description("Score of two identical nucleotides."))
+
+	options.IntVar(&_Mismatch, "mismatch", _Mismatch,
+		options.Description("Score of two different nucleotides."))
+
+	options.IntVar(&_GapOpen, "gap-open", _GapOpen,
+		options.Description("Penalty of the first position of a gap."))
+
+	options.IntVar(&_GapExtend, "gap-extend", _GapExtend,
+		options.Description("Penalty of each following position of a gap."))
+
+	options.IntVar(&_Band, "band", _Band,
+		options.ArgName("N"),
+		options.Description("Restrict the alignments to a band of N diagonals around the main diagonals, "+
+			"a negative value computes the full alignments."))
+}
+
+// OptionSet adds to the basic option set every options declared for
+// the obipairwise command
+func OptionSet(options *getoptions.GetOpt) {
+	obiconvert.OptionSet(false)(options)
+	PairwiseOptionSet(options)
+}
+
+// CLITargets loads the target sequences.
+func CLITargets() obiseq.BioSequenceSlice {
+	targets, err := obiformats.ReadSequencesFromFile(_Target)
+
+	if err != nil {
+		log.Fatalf("Cannot open the target file %s: %v", _Target, err)
+	}
+
+	_, db := targets.Load()
+
+	return db
+}
+
+// CLIAlignmentMode returns the alignment mode selected by the --mode option.
+func CLIAlignmentMode() obialign.AlignmentMode {
+	mode, err := obialign.ParseAlignmentMode(_Mode)
+
+	if err != nil {
+		log.Fatalf("--mode: %v", err)
+	}
+
+	return mode
+}
+
+// CLIScoringScheme returns the scoring scheme defined by the --match,
+// --mismatch, --gap-open and --gap-extend options.
+func CLIScoringScheme() *obialign.ScoringScheme {
+	return obialign.NewScoringScheme(_Match, _Mismatch, _GapOpen, _GapExtend)
+}
+
+// CLIBand returns the width of the alignment band, -1 for full alignments.
+func CLIBand() int {
+	return _Band
+}
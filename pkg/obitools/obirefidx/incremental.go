@@ -0,0 +1,292 @@
+package obirefidx
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obialign"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obikmer"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
+)
+
+// indexProfile rebuilds, from the obitag_ref_index of a reference sequence,
+// the distance from the sequence to its closest reference for each taxon
+// of its lineage, as computed by IndexSequence. These distances are known
+// up to the last taxon recorded in the index, the following ones are only
+// known to be larger. They are reported as -1.
+//
+// Parameters:
+//   - index: The obitag_ref_index of the sequence.
+//   - path: The lineage of the sequence, from its taxon to the root.
+//
+// Returns:
+//   - The distance associated with each taxon of the lineage.
+//   - The position in the lineage of the last taxon recorded in the index,
+//     -1 if the index is not consistent with the lineage.
+func indexProfile(index map[int]string, path *obitax.TaxonSlice) ([]int, int) {
+	n := path.Len()
+	position := make(map[string]int, n)
+
+	for i := 0; i < n; i++ {
+		position[path.Taxon(i).String()] = i
+	}
+
+	profile := make([]int, n)
+	for i := range profile {
+		profile[i] = -1
+	}
+
+	last := -1
+	for distance, taxon := range index {
+		i, ok := position[taxon]
+		if !ok {
+			return nil, -1
+		}
+		profile[i] = distance
+		last = max(last, i)
+	}
+
+	for i := last - 1; i >= 0; i-- {
+		if profile[i] == -1 {
+			profile[i] = profile[i+1]
+		}
+	}
+
+	return profile, last
+}
+
+// isIndexAffected checks whether adding new reference sequences can change
+// the obitag_ref_index of an already indexed sequence. The index changes
+// only if a new sequence is closer to the indexed one than the closest
+// reference sharing the same LCA. When that distance is not recorded in
+// the index, the sequence is considered as affected. A new sequence sharing
+// only the root with the indexed one changes the index if it is not farther
+// than the last distance recorded in the index.
+//
+// Parameters:
+//   - seqidx: The index of the indexed sequence in the database.
+//   - first: The index of the first new sequence, new sequences are at the end of the database.
+//   - db: The reference database.
+//
+// Returns:
+//   - A boolean value indicating whether the index must be recomputed.
+func isIndexAffected(seqidx, first int, db *ReferenceDB) bool {
+	sequence := db.Sequences[seqidx]
+	index := sequence.OBITagRefIndex()
+
+	if index == nil {
+		return true
+	}
+
+	tseq := db.Taxa.Taxon(seqidx)
+	path := tseq.Path()
+	profile, last := indexProfile(index, path)
+
+	if last < 0 {
+		return true
+	}
+
+	plen := path.Len()
+	if plen < 2 {
+		return false
+	}
+
+	position := make(map[*obitax.TaxNode]int, plen)
+	for i := 0; i < plen; i++ {
+		position[path.Taxon(i).Node] = i
+	}
+
+	lcaCache := make(map[*obitax.TaxNode]int)
+	var matrix []uint64
+
+	for i := first; i < db.Len(); i++ {
+		taxon := db.Taxa.Taxon(i)
+		p, ok := lcaCache[taxon.Node]
+		if !ok {
+			lca, err := tseq.LCA(taxon)
+			if err != nil {
+				log.Fatalf("(%s,%s): %+v", tseq.String(), taxon.String(), err)
+			}
+			p = position[lca.Node]
+			lcaCache[taxon.Node] = p
+		}
+
+		var radius int
+		switch {
+		case p == plen-1:
+			radius = profile[last]
+		case profile[p] == -1:
+			return true
+		default:
+			radius = profile[p] - 1
+		}
+
+		if radius < 0 {
+			continue
+		}
+
+		reference := db.Sequences[i]
+		wordmin := max(sequence.Len(), reference.Len()) - 3 - 4*radius
+
+		if obikmer.Common4Mer(db.Kmers[seqidx], db.Kmers[i]) < wordmin {
+			continue
+		}
+
+		if radius <= 1 {
+			d, _, _, _ := obialign.D1Or0(sequence, reference)
+			if d >= 0 && d <= radius {
+				return true
+			}
+		} else {
+			lcs, _ := obialign.FastLCSScore(sequence, reference, radius, &matrix)
+			if lcs >= 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// UpdateReferenceDB adds new sequences to an indexed reference database.
+// The new sequences are indexed against the whole database, and only the
+// indexed sequences whose obitag_ref_index is changed by the new sequences
+// are indexed again. The result is the same as indexing the whole database.
+//
+// Parameters:
+//   - db: The indexed reference database.
+//   - additions: The new reference sequences. Sequences whose taxid is not
+//     described in the taxonomy are discarded.
+//
+// Returns:
+//   - The updated reference database, the indexed sequences come first.
+//   - The number of indexed sequences whose index has been recomputed.
+func UpdateReferenceDB(db *ReferenceDB, additions obiseq.BioSequenceSlice) (*ReferenceDB, int) {
+	added := MakeReferenceDB(additions, db.Taxonomy)
+	first := db.Len()
+
+	merged := &ReferenceDB{
+		Sequences: make(obiseq.BioSequenceSlice, 0, db.Len()+added.Len()),
+		Kmers:     make([]*obikmer.Table4mer, 0, db.Len()+added.Len()),
+		Taxa:      db.Taxonomy.NewTaxonSlice(0, db.Len()+added.Len()),
+		Taxonomy:  db.Taxonomy,
+		mapping:   db.mapping,
+	}
+
+	for _, part := range []*ReferenceDB{db, added} {
+		merged.Sequences = append(merged.Sequences, part.Sequences...)
+		merged.Kmers = append(merged.Kmers, part.Kmers...)
+		for i := 0; i < part.Len(); i++ {
+			merged.Taxa.Push(part.Taxa.Taxon(i))
+		}
+	}
+
+	affected := make([]bool, merged.Len())
+	for i := first; i < merged.Len(); i++ {
+		affected[i] = true
+	}
+
+	if added.Len() > 0 {
+		parallel(first, func(i int) {
+			affected[i] = isIndexAffected(i, first, merged)
+		})
+	}
+
+	updated := 0
+	for i := 0; i < first; i++ {
+		if affected[i] {
+			updated++
+		}
+	}
+
+	log.Infof("Indexing %d new sequences and updating %d indexed sequences on %d",
+		added.Len(), updated, first)
+
+	indices := make([]map[int]string, merged.Len())
+	parallel(merged.Len(), func(i int) {
+		if affected[i] {
+			indices[i] = IndexSequence(i, merged.Sequences, &merged.Kmers, merged.Taxa, merged.Taxonomy)
+		}
+	})
+
+	for i, index := range indices {
+		if index != nil {
+			merged.Sequences[i].SetOBITagRefIndex(index)
+		}
+	}
+
+	return merged, updated
+}
+
+// parallel runs a function on the integers from 0 to n-1 using the
+// default number of parallel workers.
+func parallel(n int, f func(i int)) {
+	next := make(chan int)
+	var wg sync.WaitGroup
+
+	nworkers := obidefault.ParallelWorkers()
+	wg.Add(nworkers)
+
+	for w := 0; w < nworkers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				f(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+
+	wg.Wait()
+}
+
+// CLIUpdateReferenceDB adds the sequences provided by the iterator to the
+// indexed reference DB given by the --indexed-db option. The updated
+// reference DB is returned through a new iterator.
+func CLIUpdateReferenceDB(iterator obiiter.IBioSequence) obiiter.IBioSequence {
+	taxonomy := obitax.DefaultTaxonomy()
+
+	if taxonomy == nil {
+		log.Fatal("No taxonomy loaded.")
+	}
+
+	db := CLIIndexedDB(taxonomy)
+	source, additions := iterator.Load()
+
+	merged, _ := UpdateReferenceDB(db, additions)
+
+	return obiiter.IBatchOver(source, merged.Sequences, obidefault.BatchSize())
+}
+
+// CLIIndexedDB loads the indexed reference DB given by the --indexed-db
+// option, either from a binary reference database or from a sequence file.
+func CLIIndexedDB(taxonomy *obitax.Taxonomy) *ReferenceDB {
+	if IsReferenceDBFile(CLIIndexedDBName()) {
+		db, err := OpenReferenceDB(CLIIndexedDBName(), taxonomy)
+
+		if err != nil {
+			log.Fatalf("Cannot load the indexed reference database: %v", err)
+		}
+
+		return db
+	}
+
+	iterator, err := obiconvert.CLIReadBioSequences(CLIIndexedDBName())
+
+	if err != nil {
+		log.Fatalf("Cannot open the indexed reference database %s: %v", CLIIndexedDBName(), err)
+	}
+
+	_, sequences := iterator.Load()
+
+	return MakeReferenceDB(sequences, taxonomy)
+}
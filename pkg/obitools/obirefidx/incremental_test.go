@@ -0,0 +1,192 @@
+package obirefidx
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+// evolve returns a copy of a sequence carrying a few random substitutions
+// and indels.
+func evolve(rng *rand.Rand, sequence []byte, events int) []byte {
+	nucs := []byte("acgt")
+	result := append([]byte{}, sequence...)
+
+	for e := 0; e < events; e++ {
+		i := rng.Intn(len(result))
+		switch rng.Intn(5) {
+		case 0:
+			result = append(result[:i], result[i+1:]...)
+		case 1:
+			result = append(result[:i], append([]byte{nucs[rng.Intn(4)]}, result[i:]...)...)
+		default:
+			result[i] = nucs[rng.Intn(4)]
+		}
+	}
+
+	return result
+}
+
+// buildTestReferences builds a taxonomy of families, genera and species
+// and a set of reference sequences evolving along it.
+func buildTestReferences(t *testing.T, rng *rand.Rand) (*obitax.Taxonomy, obiseq.BioSequenceSlice) {
+	taxonomy := obitax.NewTaxonomy("test", "taxon", obiutils.AsciiDigitSet)
+
+	if _, err := taxonomy.AddTaxon("1", "1", "no rank", true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	root := make([]byte, 100)
+	for i := range root {
+		root[i] = "acgt"[rng.Intn(4)]
+	}
+
+	references := obiseq.MakeBioSequenceSlice()
+	taxid := 1
+
+	addTaxon := func(parent int, rank string) int {
+		taxid++
+		taxon, err := taxonomy.AddTaxon(fmt.Sprint(taxid), fmt.Sprint(parent), rank, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		taxon.SetName(fmt.Sprintf("%s %d", rank, taxid), "scientific name")
+		return taxid
+	}
+
+	for f := 0; f < 3; f++ {
+		family := addTaxon(1, "family")
+		fseq := evolve(rng, root, 25)
+
+		for g := 0; g < 2; g++ {
+			genus := addTaxon(family, "genus")
+			gseq := evolve(rng, fseq, 8)
+
+			for s := 0; s < 2; s++ {
+				species := addTaxon(genus, "species")
+				sseq := evolve(rng, gseq, 3)
+
+				for r := 0; r < 2; r++ {
+					seq := obiseq.NewBioSequence(
+						fmt.Sprintf("ref_%d", len(references)),
+						evolve(rng, sseq, rng.Intn(3)), "")
+					seq.SetTaxid(fmt.Sprint(species))
+					references = append(references, seq)
+				}
+			}
+		}
+	}
+
+	return taxonomy, references
+}
+
+// copySequences returns a deep copy of a slice of sequences.
+func copySequences(sequences obiseq.BioSequenceSlice) obiseq.BioSequenceSlice {
+	copies := obiseq.MakeBioSequenceSlice(len(sequences))
+	for i, seq := range sequences {
+		copies[i] = seq.Copy()
+	}
+	return copies
+}
+
+// indexAll indexes every sequence of a reference database from scratch.
+func indexAll(db *ReferenceDB) {
+	for i := range db.Sequences {
+		db.Sequences[i].SetOBITagRefIndex(
+			IndexSequence(i, db.Sequences, &db.Kmers, db.Taxa, db.Taxonomy))
+	}
+}
+
+func TestUpdateReferenceDBMatchesFullRebuild(t *testing.T) {
+	for seed := int64(1); seed <= 4; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		taxonomy, references := buildTestReferences(t, rng)
+
+		rng.Shuffle(len(references), func(i, j int) {
+			references[i], references[j] = references[j], references[i]
+		})
+
+		full := MakeReferenceDB(copySequences(references), taxonomy)
+		indexAll(full)
+
+		expected := make(map[string]map[int]string, full.Len())
+		for _, seq := range full.Sequences {
+			expected[seq.Id()] = seq.OBITagRefIndex()
+		}
+
+		split := len(references) * 4 / 5
+		old := MakeReferenceDB(copySequences(references[:split]), taxonomy)
+		indexAll(old)
+
+		updated, n := UpdateReferenceDB(old, copySequences(references[split:]))
+
+		if updated.Len() != full.Len() {
+			t.Fatalf("seed %d: updated database has %d sequences, expected %d",
+				seed, updated.Len(), full.Len())
+		}
+
+		t.Logf("seed %d: %d indexed sequences updated on %d", seed, n, split)
+
+		for _, seq := range updated.Sequences {
+			if index := seq.OBITagRefIndex(); !reflect.DeepEqual(index, expected[seq.Id()]) {
+				t.Errorf("seed %d: %s index is %v, full rebuild gives %v",
+					seed, seq.Id(), index, expected[seq.Id()])
+			}
+		}
+	}
+}
+
+func TestUpdateReferenceDBSavedInPlace(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	taxonomy, references := buildTestReferences(t, rng)
+
+	split := len(references) * 4 / 5
+	old := MakeReferenceDB(copySequences(references[:split]), taxonomy)
+	indexAll(old)
+
+	filename := filepath.Join(t.TempDir(), "refs.obirefdb")
+	if err := WriteReferenceDB(old, filename); err != nil {
+		t.Fatalf("cannot write the database: %v", err)
+	}
+
+	mapped, err := OpenReferenceDB(filename, taxonomy)
+	if err != nil {
+		t.Fatalf("cannot open the database: %v", err)
+	}
+	defer mapped.Close()
+
+	updated, _ := UpdateReferenceDB(mapped, copySequences(references[split:]))
+
+	expected := make(map[string]map[int]string, updated.Len())
+	for _, seq := range updated.Sequences {
+		expected[seq.Id()] = seq.OBITagRefIndex()
+	}
+
+	// The updated database still relies on the mapping of the file it
+	// replaces.
+	if err := WriteReferenceDB(updated, filename); err != nil {
+		t.Fatalf("cannot save the updated database: %v", err)
+	}
+
+	reloaded, err := OpenReferenceDB(filename, taxonomy)
+	if err != nil {
+		t.Fatalf("cannot reopen the updated database: %v", err)
+	}
+	defer reloaded.Close()
+
+	if reloaded.Len() != len(references) {
+		t.Fatalf("reloaded database has %d sequences, expected %d", reloaded.Len(), len(references))
+	}
+
+	for _, seq := range reloaded.Sequences {
+		if index := seq.OBITagRefIndex(); !reflect.DeepEqual(index, expected[seq.Id()]) {
+			t.Errorf("%s index is %v once reloaded, expected %v", seq.Id(), index, expected[seq.Id()])
+		}
+	}
+}
@@ -83,8 +83,8 @@ func IndexSequence(seqidx int,
 
 	closest[0] = 0
 
-	// Initialize a matrix to store alignment scores
-	var matrix []uint64
+	// Initialize a buffer to store alignment scores
+	buffer := obialign.LCSBatchBuffer{}
 
 	// obilog.Warnf("%s : %s", sequence.Id(), pseq.String())
 
@@ -103,7 +103,22 @@ func IndexSequence(seqidx int,
 
 			ow := obiutils.Reverse(obiutils.IntOrder(shared), true)
 
-			for _, order := range ow {
+			// The next references are scored with the current minimum of
+			// errors, those below the 4-mer threshold or left to D1Or0 excepted.
+			scanned := make([]int, ns)
+			for k, order := range ow {
+				scanned[k] = (*seqidcs)[order]
+			}
+			scan := obialign.MakeLCSBatchScan(sequence, references, scanned, &buffer)
+			bound := func(p int) (int, bool) {
+				if mini == -1 {
+					return -1, true
+				}
+				wordmin := max(seq_len, references[scanned[p]].Len()) - 3 - 4*mini
+				return mini, shared[ow[p]] >= wordmin && mini != 0 && mini != 1
+			}
+
+			for p, order := range ow {
 				is := (*seqidcs)[order]
 				suject := references[is]
 
@@ -130,7 +145,7 @@ func IndexSequence(seqidx int,
 					}
 				} else {
 					// Perform a Fast LCS score calculation for the sequence and reference.
-					lcs, alilength = obialign.FastLCSScore(sequence, suject, mini, &matrix)
+					lcs, alilength = scan.Score(p, mini, bound)
 					if lcs >= 0 { // If LCS score is valid (non-negative).
 						errs = alilength - lcs // Calculate errors based on alignment length.
 					}
@@ -293,3 +308,21 @@ func IndexReferenceDB(iterator obiiter.IBioSequence) obiiter.IBioSequence {
 
 	return indexed.RebatchBySize(obidefault.BatchMem(), obidefault.BatchSizeMax())
 }
+
+// CLISaveReferenceDB saves the indexed sequences provided by the iterator as
+// a binary reference database when the --save-db option is set. The indexed
+// sequences are returned through a new iterator.
+func CLISaveReferenceDB(iterator obiiter.IBioSequence) obiiter.IBioSequence {
+	if !CLIShouldISaveRefDB() {
+		return iterator
+	}
+
+	source, references := iterator.Load()
+	db := MakeReferenceDB(references, obitax.DefaultTaxonomy())
+
+	if err := WriteReferenceDB(db, CLISaveRefDBName()); err != nil {
+		log.Fatalf("Cannot save the reference database: %v", err)
+	}
+
+	return obiiter.IBatchOver(source, db.Sequences, obidefault.BatchSize())
+}
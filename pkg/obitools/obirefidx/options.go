@@ -5,8 +5,39 @@ import (
 	"github.com/DavidGamba/go-getoptions"
 )
 
+var _SaveRefDB = ""
+var _IndexedDB = ""
+
 // OptionSet adds to the basic option set every options declared for
 // the obiuniq command
 func OptionSet(options *getoptions.GetOpt) {
 	obiconvert.OptionSet(false)(options)
+
+	options.StringVar(&_SaveRefDB, "save-db", _SaveRefDB,
+		options.ArgName("FILENAME"),
+		options.Description("The name of a file where to save the indexed reference DB "+
+			"as a binary reference database usable by obitag"))
+
+	options.StringVar(&_IndexedDB, "indexed-db", _IndexedDB,
+		options.ArgName("FILENAME"),
+		options.Description("An already indexed reference DB (sequence file or binary reference database) "+
+			"to update with the input sequences. Only the new sequences and the indexed sequences "+
+			"they are close to are indexed"))
+}
+
+func CLIShouldISaveRefDB() bool {
+	return _SaveRefDB != ""
+}
+
+func CLISaveRefDBName() string {
+	return _SaveRefDB
+}
+
+// CLIHasIndexedDB returns true if an indexed reference DB has to be updated.
+func CLIHasIndexedDB() bool {
+	return _IndexedDB != ""
+}
+
+func CLIIndexedDBName() string {
+	return _IndexedDB
 }
@@ -0,0 +1,569 @@
+package obirefidx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obikmer"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obilog"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
+)
+
+// The binary reference database file starts with a fixed size header
+// followed by three sections, each aligned on 8 bytes:
+//
+//   - the 4-mer tables: one Table4mer (256 little endian uint16) per sequence;
+//   - the string table: the number of strings, their offsets and their bytes.
+//     For the sequence i, strings 3i, 3i+1 and 3i+2 are its id, its
+//     nucleotides and its taxon. Strings 3n and 3n+1 are the name and the code
+//     of the taxonomy, the following ones are the taxa used by the indices;
+//   - the reference indices: n+1 entry offsets followed by the entries, each
+//     made of two uint32, the distance and the string index of the taxon.
+//     A sequence without entries has not been indexed yet.
+//
+// The 4-mer tables are used in place from the memory mapped file.
+const (
+	_RefDBMagic   = "OBIREFDB"
+	_RefDBVersion = 1
+)
+
+type refDBHeader struct {
+	Magic         [8]byte
+	Version       uint64
+	Count         uint64
+	Fingerprint   uint64
+	KmersOffset   uint64
+	StringsOffset uint64
+	IndexOffset   uint64
+}
+
+// ReferenceDB gathers everything obitag needs about a reference database:
+// the reference sequences, their 4-mer tables and their taxa.
+//
+// Fields:
+//   - Sequences: The reference sequences, annotated with their taxon and,
+//     when available, their obitag_ref_index.
+//   - Kmers: The 4-mer table of each sequence.
+//   - Taxa: The taxon of each sequence.
+//   - Taxonomy: The taxonomy the taxa belong to.
+//   - mapping: The memory mapped file the database was loaded from, if any.
+type ReferenceDB struct {
+	Sequences obiseq.BioSequenceSlice
+	Kmers     []*obikmer.Table4mer
+	Taxa      *obitax.TaxonSlice
+	Taxonomy  *obitax.Taxonomy
+	mapping   []byte
+}
+
+// MakeReferenceDB builds a ReferenceDB from a slice of reference sequences.
+// Sequences whose taxid is not described in the taxonomy are discarded.
+//
+// Parameters:
+//   - references: The reference sequences, the slice is reused.
+//   - taxonomy: The taxonomy used to resolve the taxids.
+//
+// Returns:
+//   - A pointer to the new ReferenceDB.
+func MakeReferenceDB(references obiseq.BioSequenceSlice, taxonomy *obitax.Taxonomy) *ReferenceDB {
+	taxonomy = taxonomy.OrDefault(true)
+
+	kmers := make([]*obikmer.Table4mer, len(references))
+	taxa := taxonomy.NewTaxonSlice(0, references.Len())
+	buffer := make([]byte, 0, 1000)
+
+	j := 0
+	for _, seq := range references {
+		taxon := seq.Taxon(taxonomy)
+		if taxon != nil && taxon.Node != nil {
+			references[j] = seq
+			kmers[j] = obikmer.Count4Mer(seq, &buffer, nil)
+			taxa.Push(taxon)
+			j++
+		} else {
+			obilog.Warnf("Taxid %s is not described in the taxonomy %s."+
+				" Sequence %s is discared from the reference database",
+				seq.Taxid(), taxonomy.Name(), seq.Id())
+		}
+	}
+
+	log.Infof("%d reference sequences conserved on %d", j, len(references))
+
+	return &ReferenceDB{
+		Sequences: references[:j],
+		Kmers:     kmers[:j],
+		Taxa:      taxa,
+		Taxonomy:  taxonomy,
+	}
+}
+
+// Len returns the number of sequences in the reference database.
+func (db *ReferenceDB) Len() int {
+	return len(db.Sequences)
+}
+
+// Fingerprint returns a hash of the part of the taxonomy used by the
+// reference database: every taxon of the database and all their ancestors,
+// described by their taxid, their parent, their rank and their scientific
+// name. Two taxonomies producing the same fingerprint assign the reference
+// sequences identically.
+func (db *ReferenceDB) Fingerprint() uint64 {
+	used := db.Taxonomy.NewTaxonSet()
+
+	for i := 0; i < db.Taxa.Len(); i++ {
+		for taxon := range db.Taxa.Taxon(i).IPath() {
+			if used.Contains(taxon.Node.Id()) {
+				break
+			}
+			used.InsertTaxon(taxon)
+		}
+	}
+
+	sorted := used.Sort()
+	lines := make([]string, 0, sorted.Len())
+	for i := 0; i < sorted.Len(); i++ {
+		taxon := sorted.Taxon(i)
+		lines = append(lines, fmt.Sprintf("%s\t%s\t%s\t%s\n",
+			*taxon.Node.Id(),
+			*taxon.Node.ParentId(),
+			taxon.Rank(),
+			taxon.ScientificName()))
+	}
+
+	slices.Sort(lines)
+
+	hash := fnv.New64a()
+	for _, line := range lines {
+		hash.Write([]byte(line))
+	}
+
+	return hash.Sum64()
+}
+
+// Close releases the memory mapped file backing the reference database.
+// The 4-mer tables must not be used after this call.
+func (db *ReferenceDB) Close() error {
+	if db.mapping == nil {
+		return nil
+	}
+
+	err := unmapFile(db.mapping)
+	db.mapping = nil
+	db.Kmers = nil
+
+	return err
+}
+
+// IsReferenceDBFile returns true if the file is a binary reference database.
+func IsReferenceDBFile(filename string) bool {
+	file, err := os.Open(filename)
+
+	if err != nil {
+		return false
+	}
+
+	defer file.Close()
+
+	magic := make([]byte, len(_RefDBMagic))
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return false
+	}
+
+	return string(magic) == _RefDBMagic
+}
+
+// offsetWriter is a buffered writer keeping track of the number of bytes
+// written, used to compute the offsets of the sections.
+type offsetWriter struct {
+	*bufio.Writer
+	offset uint64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.offset += uint64(n)
+	return n, err
+}
+
+func (w *offsetWriter) align() {
+	if pad := (8 - w.offset%8) % 8; pad > 0 {
+		w.Write(make([]byte, pad))
+	}
+}
+
+// WriteReferenceDB saves the reference database as a binary file that can
+// be reloaded with OpenReferenceDB.
+//
+// Parameters:
+//   - db: The reference database to save.
+//   - filename: The name of the file to create.
+//
+// The database is first written to a temporary file, which then replaces
+// the file named filename. A database mapped from that file, for instance
+// the one updated by UpdateReferenceDB, therefore remains valid while it is
+// saved.
+//
+// Returns:
+//   - An error if the file cannot be written.
+func WriteReferenceDB(db *ReferenceDB, filename string) (err error) {
+	file, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	defer func() {
+		if err != nil {
+			os.Remove(file.Name())
+		}
+	}()
+
+	if err := file.Chmod(0o644); err != nil {
+		return err
+	}
+
+	n := db.Len()
+	header := refDBHeader{
+		Version:     _RefDBVersion,
+		Count:       uint64(n),
+		Fingerprint: db.Fingerprint(),
+	}
+	copy(header.Magic[:], _RefDBMagic)
+
+	w := &offsetWriter{Writer: bufio.NewWriterSize(file, 1<<20)}
+
+	if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	w.align()
+
+	header.KmersOffset = w.offset
+	for _, table := range db.Kmers {
+		if err := binary.Write(w, binary.LittleEndian, table); err != nil {
+			return err
+		}
+	}
+	w.align()
+
+	// Strings are collected before being written, the labels of the
+	// indices are deduplicated.
+	strings := make([][]byte, 0, 3*n+2)
+	for i, seq := range db.Sequences {
+		strings = append(strings,
+			[]byte(seq.Id()),
+			seq.Sequence(),
+			[]byte(db.Taxa.Taxon(i).String()))
+	}
+	strings = append(strings, []byte(db.Taxonomy.Name()), []byte(db.Taxonomy.Code()))
+
+	labels := make(map[string]uint32)
+	entries := make([][][2]uint32, n)
+	indexed := 0
+
+	for i, seq := range db.Sequences {
+		idx := seq.OBITagRefIndex()
+		if idx == nil {
+			continue
+		}
+
+		indexed++
+		distances := make([]int, 0, len(idx))
+		for d := range idx {
+			distances = append(distances, d)
+		}
+		slices.Sort(distances)
+
+		for _, d := range distances {
+			label, ok := labels[idx[d]]
+			if !ok {
+				label = uint32(len(strings))
+				labels[idx[d]] = label
+				strings = append(strings, []byte(idx[d]))
+			}
+			entries[i] = append(entries[i], [2]uint32{uint32(d), label})
+		}
+	}
+
+	header.StringsOffset = w.offset
+	binary.Write(w, binary.LittleEndian, uint64(len(strings)))
+	position := uint64(0)
+	binary.Write(w, binary.LittleEndian, position)
+	for _, s := range strings {
+		position += uint64(len(s))
+		binary.Write(w, binary.LittleEndian, position)
+	}
+	for _, s := range strings {
+		w.Write(s)
+	}
+	w.align()
+
+	header.IndexOffset = w.offset
+	position = 0
+	binary.Write(w, binary.LittleEndian, position)
+	for _, e := range entries {
+		position += uint64(len(e))
+		binary.Write(w, binary.LittleEndian, position)
+	}
+	for _, e := range entries {
+		if err := binary.Write(w, binary.LittleEndian, e); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.LittleEndian, &header)
+	if _, err := file.WriteAt(buffer.Bytes(), 0); err != nil {
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(file.Name(), filename); err != nil {
+		return err
+	}
+
+	log.Infof("Reference database saved to %s (%d sequences, %d indexed)", filename, n, indexed)
+
+	return nil
+}
+
+// refDBReader decodes the sections of a memory mapped reference database.
+type refDBReader struct {
+	data    []byte
+	nstring uint64
+	offsets uint64
+	blob    uint64
+}
+
+// refDBSpan checks that count items of size bytes starting at offset fit
+// in length bytes, without overflowing.
+func refDBSpan(offset, count, size, length uint64) bool {
+	return offset <= length && count <= (length-offset)/size
+}
+
+// check verifies that the sections of a reference database
+// lie in the mapped data, and that every string and every index entry
+// referenced by the database lies in its section. The string table of the
+// reader is set up on success.
+func (r *refDBReader) check(header *refDBHeader) error {
+	length := uint64(len(r.data))
+	n := header.Count
+
+	if header.KmersOffset%8 != 0 || !refDBSpan(header.KmersOffset, n, 512, length) {
+		return fmt.Errorf("the 4-mer tables of %d sequences exceed the file", n)
+	}
+
+	if !refDBSpan(header.StringsOffset, 1, 8, length) {
+		return fmt.Errorf("the string table exceeds the file")
+	}
+
+	r.nstring = r.uint64At(header.StringsOffset)
+	r.offsets = header.StringsOffset + 8
+
+	if !refDBSpan(r.offsets, r.nstring, 8, length-8) {
+		return fmt.Errorf("the offsets of %d strings exceed the file", r.nstring)
+	}
+
+	if r.nstring < 3*n+2 {
+		return fmt.Errorf("%d strings for %d sequences", r.nstring, n)
+	}
+
+	r.blob = r.offsets + 8*(r.nstring+1)
+	previous := uint64(0)
+	for i := uint64(0); i <= r.nstring; i++ {
+		offset := r.uint64At(r.offsets + 8*i)
+		if offset < previous || !refDBSpan(r.blob, offset, 1, length) {
+			return fmt.Errorf("the string %d exceeds the string table", i)
+		}
+		previous = offset
+	}
+
+	if !refDBSpan(header.IndexOffset, n+1, 8, length) {
+		return fmt.Errorf("the reference indices of %d sequences exceed the file", n)
+	}
+
+	entries := header.IndexOffset + 8*(n+1)
+	previous = 0
+	for i := uint64(0); i <= n; i++ {
+		offset := r.uint64At(header.IndexOffset + 8*i)
+		if offset < previous || !refDBSpan(entries, offset, 8, length) {
+			return fmt.Errorf("the reference index of sequence %d exceeds the file", i)
+		}
+		previous = offset
+	}
+
+	for e := uint64(0); e < previous; e++ {
+		if label := binary.LittleEndian.Uint32(r.data[entries+8*e+4:]); uint64(label) >= r.nstring {
+			return fmt.Errorf("the reference index entry %d refers to the unknown string %d", e, label)
+		}
+	}
+
+	return nil
+}
+
+func (r *refDBReader) uint64At(offset uint64) uint64 {
+	return binary.LittleEndian.Uint64(r.data[offset : offset+8])
+}
+
+func (r *refDBReader) bytes(i uint64) []byte {
+	from := r.uint64At(r.offsets + 8*i)
+	to := r.uint64At(r.offsets + 8*(i+1))
+	return r.data[r.blob+from : r.blob+to]
+}
+
+func (r *refDBReader) string(i uint64) string {
+	return string(r.bytes(i))
+}
+
+// OpenReferenceDB loads a binary reference database written by
+// WriteReferenceDB. The file is memory mapped and the 4-mer tables are used
+// in place. The taxonomy must be the one the database was built with: an
+// error is returned if one of the reference taxa is unknown, or if the
+// lineages of the reference taxa differ from those recorded in the file.
+//
+// Parameters:
+//   - filename: The name of the reference database file.
+//   - taxonomy: The taxonomy used to resolve the reference taxa.
+//
+// Returns:
+//   - A pointer to the loaded ReferenceDB.
+//   - An error if the file is not a valid reference database or if the
+//     taxonomy does not match.
+func OpenReferenceDB(filename string, taxonomy *obitax.Taxonomy) (*ReferenceDB, error) {
+	taxonomy = taxonomy.OrDefault(false)
+
+	if taxonomy == nil {
+		return nil, fmt.Errorf("a taxonomy is required to load the reference database %s", filename)
+	}
+
+	data, err := mapFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := decodeReferenceDB(filename, data, taxonomy)
+
+	if err != nil {
+		unmapFile(data)
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func decodeReferenceDB(filename string, data []byte, taxonomy *obitax.Taxonomy) (*ReferenceDB, error) {
+	var header refDBHeader
+
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &header); err != nil ||
+		string(header.Magic[:]) != _RefDBMagic {
+		return nil, fmt.Errorf("%s is not an obitag reference database", filename)
+	}
+
+	if header.Version != _RefDBVersion {
+		return nil, fmt.Errorf("%s: unsupported reference database version %d", filename, header.Version)
+	}
+
+	n := header.Count
+	reader := &refDBReader{data: data}
+
+	if err := reader.check(&header); err != nil {
+		return nil, fmt.Errorf("%s is a corrupted reference database: %v", filename, err)
+	}
+
+	dbtaxonomy := reader.string(3 * n)
+	dbcode := reader.string(3*n + 1)
+
+	if dbcode != taxonomy.Code() {
+		return nil, fmt.Errorf("reference database %s was built with taxonomy %s (code %s), not with %s (code %s)",
+			filename, dbtaxonomy, dbcode, taxonomy.Name(), taxonomy.Code())
+	}
+
+	db := &ReferenceDB{
+		Sequences: make(obiseq.BioSequenceSlice, n),
+		Kmers:     make([]*obikmer.Table4mer, n),
+		Taxa:      taxonomy.NewTaxonSlice(0, int(n)),
+		Taxonomy:  taxonomy,
+		mapping:   data,
+	}
+
+	for i := uint64(0); i < n; i++ {
+		taxid := reader.string(3*i + 2)
+		taxon, _, err := taxonomy.Taxon(taxid)
+
+		if err != nil {
+			return nil, fmt.Errorf("reference database %s was built with another version of taxonomy %s: %v",
+				filename, dbtaxonomy, err)
+		}
+
+		seq := obiseq.NewBioSequence(reader.string(3*i), reader.bytes(3*i+1), "")
+		seq.SetTaxon(taxon)
+		db.Sequences[i] = seq
+		db.Taxa.Push(taxon)
+	}
+
+	if fingerprint := db.Fingerprint(); fingerprint != header.Fingerprint {
+		return nil, fmt.Errorf("reference database %s was built with another version of taxonomy %s "+
+			"(fingerprint %016x instead of %016x), the database must be rebuilt",
+			filename, dbtaxonomy, fingerprint, header.Fingerprint)
+	}
+
+	if hostIsLittleEndian() {
+		tables := unsafe.Slice(
+			(*obikmer.Table4mer)(unsafe.Pointer(&data[header.KmersOffset])), n)
+		for i := range tables {
+			db.Kmers[i] = &tables[i]
+		}
+	} else {
+		for i := uint64(0); i < n; i++ {
+			table := new(obikmer.Table4mer)
+			offset := header.KmersOffset + 512*i
+			binary.Read(bytes.NewReader(data[offset:offset+512]), binary.LittleEndian, table)
+			db.Kmers[i] = table
+		}
+	}
+
+	entries := header.IndexOffset + 8*(n+1)
+	for i := uint64(0); i < n; i++ {
+		from := reader.uint64At(header.IndexOffset + 8*i)
+		to := reader.uint64At(header.IndexOffset + 8*(i+1))
+
+		if from == to {
+			continue
+		}
+
+		idx := make(map[int]string, to-from)
+		for e := from; e < to; e++ {
+			offset := entries + 8*e
+			distance := binary.LittleEndian.Uint32(data[offset:])
+			label := binary.LittleEndian.Uint32(data[offset+4:])
+			idx[int(distance)] = reader.string(uint64(label))
+		}
+
+		db.Sequences[i].SetOBITagRefIndex(idx)
+	}
+
+	log.Infof("Reference database %s loaded (%d sequences)", filename, n)
+
+	return db, nil
+}
+
+func hostIsLittleEndian() bool {
+	probe := uint16(1)
+	return *(*byte)(unsafe.Pointer(&probe)) == 1
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package obirefidx
+
+import (
+	"os"
+)
+
+// mapFile reads a whole file in memory on systems without mmap support.
+func mapFile(filename string) ([]byte, error) {
+	return os.ReadFile(filename)
+}
+
+// unmapFile releases a file loaded by mapFile.
+func unmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package obirefidx
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mapFile maps a whole file in memory in read only mode.
+func mapFile(filename string) ([]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size() == 0 {
+		return nil, fmt.Errorf("%s is an empty file", filename)
+	}
+
+	return syscall.Mmap(int(file.Fd()), 0, int(info.Size()),
+		syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// unmapFile releases a file mapped by mapFile.
+func unmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,110 @@
+package obirefidx
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTestReferenceDB writes an indexed reference database and returns
+// the database and the name of its file.
+func writeTestReferenceDB(t *testing.T, seed int64) (*ReferenceDB, string) {
+	rng := rand.New(rand.NewSource(seed))
+	taxonomy, references := buildTestReferences(t, rng)
+
+	db := MakeReferenceDB(references, taxonomy)
+	indexAll(db)
+
+	// A sequence left without index
+	db.Sequences[0].SetOBITagRefIndex(nil)
+
+	filename := filepath.Join(t.TempDir(), "refs.obirefdb")
+	if err := WriteReferenceDB(db, filename); err != nil {
+		t.Fatalf("cannot write the database: %v", err)
+	}
+
+	return db, filename
+}
+
+func TestReferenceDBRoundTrip(t *testing.T) {
+	db, filename := writeTestReferenceDB(t, 3)
+
+	if !IsReferenceDBFile(filename) {
+		t.Fatalf("%s is not recognized as a reference database", filename)
+	}
+
+	loaded, err := OpenReferenceDB(filename, db.Taxonomy)
+	if err != nil {
+		t.Fatalf("cannot open the database: %v", err)
+	}
+	defer loaded.Close()
+
+	if loaded.Len() != db.Len() {
+		t.Fatalf("%d sequences loaded, expected %d", loaded.Len(), db.Len())
+	}
+
+	if loaded.Fingerprint() != db.Fingerprint() {
+		t.Errorf("fingerprint is %016x, expected %016x", loaded.Fingerprint(), db.Fingerprint())
+	}
+
+	for i, seq := range db.Sequences {
+		other := loaded.Sequences[i]
+
+		if other.Id() != seq.Id() || string(other.Sequence()) != string(seq.Sequence()) {
+			t.Errorf("sequence %d is %s (%s), expected %s (%s)",
+				i, other.Id(), other.Sequence(), seq.Id(), seq.Sequence())
+		}
+
+		if loaded.Taxa.Taxon(i).String() != db.Taxa.Taxon(i).String() {
+			t.Errorf("%s taxon is %s, expected %s", seq.Id(), loaded.Taxa.Taxon(i), db.Taxa.Taxon(i))
+		}
+
+		if *loaded.Kmers[i] != *db.Kmers[i] {
+			t.Errorf("%s 4-mer table differs", seq.Id())
+		}
+
+		if !reflect.DeepEqual(other.OBITagRefIndex(), seq.OBITagRefIndex()) {
+			t.Errorf("%s index is %v, expected %v", seq.Id(), other.OBITagRefIndex(), seq.OBITagRefIndex())
+		}
+	}
+}
+
+func TestReferenceDBCorrupted(t *testing.T) {
+	db, filename := writeTestReferenceDB(t, 4)
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every truncation of the file must be detected
+	for length := 0; length < len(data); length += 1 + length/16 {
+		truncated := append([]byte{}, data[:length]...)
+		if _, err := decodeReferenceDB(filename, truncated, db.Taxonomy); err == nil {
+			t.Errorf("file truncated to %d bytes on %d is accepted", length, len(data))
+		}
+	}
+
+	var header refDBHeader
+	headerSize := binary.Size(header)
+
+	// Offsets and counts of the header beyond the end of the file
+	for field := 2; field < headerSize/8; field++ {
+		if field == 3 {
+			// The fingerprint is not an offset
+			continue
+		}
+
+		for _, value := range []uint64{uint64(len(data)) + 8, 1 << 62, ^uint64(0)} {
+			corrupted := append([]byte{}, data...)
+			binary.LittleEndian.PutUint64(corrupted[8*field:], value)
+
+			if _, err := decodeReferenceDB(filename, corrupted, db.Taxonomy); err == nil {
+				t.Errorf("header field %d set to %d is accepted", field, value)
+			}
+		}
+	}
+}
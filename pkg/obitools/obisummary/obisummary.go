@@ -3,6 +3,8 @@ package obisummary
 import (
 	"sync"
 
+	log "github.com/sirupsen/logrus"
+
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
@@ -24,6 +26,7 @@ type DataSummary struct {
 	sample_singletons   map[string]int
 	sample_obiclean_bad map[string]int
 	map_summaries       map[string]map[string]int
+	quality             *QualitySummary
 }
 
 func NewDataSummary() *DataSummary {
@@ -98,6 +101,7 @@ func (data1 *DataSummary) Add(data2 *DataSummary) *DataSummary {
 	rep.sample_variants = sumUpdateIntMap(data1.sample_variants, data2.sample_variants)
 	rep.sample_singletons = sumUpdateIntMap(data1.sample_singletons, data2.sample_singletons)
 	rep.sample_obiclean_bad = sumUpdateIntMap(data1.sample_obiclean_bad, data2.sample_obiclean_bad)
+	rep.quality = data1.quality.Add(data2.quality)
 
 	for k, m1 := range data1.map_summaries {
 		rep.map_summaries[k] = m1
@@ -141,6 +145,10 @@ func (data *DataSummary) Update(s *obiseq.BioSequence) *DataSummary {
 		}
 	}
 
+	if data.quality != nil {
+		data.quality.Update(s)
+	}
+
 	if s.HasAttribute("obiclean_status") {
 		data.has_obiclean_status++
 	}
@@ -173,11 +181,18 @@ func ISummary(iterator obiiter.IBioSequence, summarise []string) map[string]inte
 
 	summaries := make([]*DataSummary, nproc)
 
+	if CLIQualityReport() && (CLIKmerSize() < 1 || CLIKmerSize() > 12) {
+		log.Fatalf("The k-mer size must be between 1 and 12 (%d)", CLIKmerSize())
+	}
+
 	for n := 0; n < nproc; n++ {
 		summaries[n] = NewDataSummary()
 		for _, v := range summarise {
 			summaries[n].map_summaries[v] = make(map[string]int)
 		}
+		if CLIQualityReport() {
+			summaries[n].quality = NewQualitySummary(CLIKmerSize(), CLIOverrepresented())
+		}
 	}
 
 	ff := func(iseq obiiter.IBioSequence, summary *DataSummary) {
@@ -270,5 +285,15 @@ func ISummary(iterator obiiter.IBioSequence, summarise []string) map[string]inte
 		dict["map_summaries"] = mapDict
 	}
 
+	if rep.quality != nil {
+		dict["quality"] = rep.quality.Report()
+
+		if CLIHasCSVReport() {
+			if err := rep.quality.WriteCSV(CLICSVReportPrefix()); err != nil {
+				log.Fatalf("Cannot write the quality report: %v", err)
+			}
+		}
+	}
+
 	return dict
 }
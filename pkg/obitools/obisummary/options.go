@@ -12,6 +12,10 @@ import (
 var __json_output__ = false
 var __yaml_output__ = false
 var __map_summary__ = make([]string, 0)
+var __quality_report__ = false
+var __kmer_size__ = 7
+var __overrepresented__ = 20
+var __csv_report__ = ""
 
 func SummaryOptionSet(options *getoptions.GetOpt) {
 	options.BoolVar(&__json_output__, "json-output", false,
@@ -22,6 +26,21 @@ func SummaryOptionSet(options *getoptions.GetOpt) {
 
 	options.StringSliceVar(&__map_summary__, "map", 1, 1,
 		options.Description("Name of a map attribute."))
+
+	options.BoolVar(&__quality_report__, "quality", false,
+		options.Description("Add a quality report: per-position quality distributions, base composition, "+
+			"N content, length distribution, duplication levels, over-represented sequences and k-mers."))
+
+	options.IntVar(&__kmer_size__, "kmer-size", __kmer_size__,
+		options.Description("Size of the k-mers counted by the quality report (1 to 12)."))
+
+	options.IntVar(&__overrepresented__, "overrepresented", __overrepresented__,
+		options.Description("Number of over-represented sequences and k-mers listed by the quality report."))
+
+	options.StringVar(&__csv_report__, "csv-report", __csv_report__,
+		options.ArgName("PREFIX"),
+		options.Description("Also write each metric of the quality report in a CSV file named PREFIX_<metric>.csv. "+
+			"Implies --quality."))
 }
 
 func OptionSet(options *getoptions.GetOpt) {
@@ -44,3 +63,23 @@ func CLIHasMapSummary() bool {
 func CLIMapSummary() []string {
 	return __map_summary__
 }
+
+func CLIQualityReport() bool {
+	return __quality_report__ || CLIHasCSVReport()
+}
+
+func CLIKmerSize() int {
+	return __kmer_size__
+}
+
+func CLIOverrepresented() int {
+	return __overrepresented__
+}
+
+func CLIHasCSVReport() bool {
+	return __csv_report__ != ""
+}
+
+func CLICSVReportPrefix() string {
+	return __csv_report__
+}
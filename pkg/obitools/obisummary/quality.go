@@ -0,0 +1,523 @@
+package obisummary
+
+import (
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obikmer"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// _MaxQuality is the highest quality score recorded, higher scores are
+// counted with it.
+const _MaxQuality = 93
+
+// _MaxTrackedSequences is the number of distinct sequences followed to
+// estimate the duplication levels, as FastQC does. Once it is reached, only
+// the occurrences of the already followed sequences are counted.
+const _MaxTrackedSequences = 100000
+
+// _OverrepresentedFraction is the fraction of the reads above which a
+// sequence is reported as over-represented.
+const _OverrepresentedFraction = 0.001
+
+// _DuplicationLevels are the lower bounds of the duplication levels.
+var _DuplicationLevels = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 50, 100, 500, 1000, 5000, 10000}
+
+// QualitySummary accumulates the per-position and per-read statistics of a
+// set of reads: qualities, base composition, lengths, duplication levels,
+// over-represented sequences and k-mers.
+type QualitySummary struct {
+	reads           int
+	kmerSize        int
+	overrepresented int
+	qualities       [][_MaxQuality + 1]int
+	composition     [][5]int
+	lengths         map[int]int
+	sequences       map[string]int
+	tracked         int
+	kmers           map[uint64]int
+}
+
+// NewQualitySummary creates an empty quality summary.
+//
+// Parameters:
+//   - kmerSize: The size of the k-mers counted, between 1 and 12.
+//   - overrepresented: The number of over-represented sequences and k-mers
+//     reported.
+//
+// Returns:
+//   - The new summary.
+func NewQualitySummary(kmerSize, overrepresented int) *QualitySummary {
+	return &QualitySummary{
+		kmerSize:        kmerSize,
+		overrepresented: overrepresented,
+		qualities:       make([][_MaxQuality + 1]int, 0),
+		composition:     make([][5]int, 0),
+		lengths:         make(map[int]int),
+		sequences:       make(map[string]int),
+		kmers:           make(map[uint64]int),
+	}
+}
+
+// nucleotideIndex returns the column of a nucleotide in the composition
+// table: a, c, g, t, and n for the ambiguous nucleotides.
+func nucleotideIndex(nuc byte) int {
+	switch nuc {
+	case 'a':
+		return 0
+	case 'c':
+		return 1
+	case 'g':
+		return 2
+	case 't', 'u':
+		return 3
+	}
+
+	return 4
+}
+
+// Update adds a read to the summary. The read is weighted by its count.
+func (data *QualitySummary) Update(s *obiseq.BioSequence) *QualitySummary {
+	count := s.Count()
+	sequence := s.Sequence()
+
+	data.reads += count
+	data.lengths[len(sequence)] += count
+
+	for len(data.composition) < len(sequence) {
+		data.composition = append(data.composition, [5]int{})
+	}
+
+	for i, nuc := range sequence {
+		data.composition[i][nucleotideIndex(nuc)] += count
+	}
+
+	if s.HasQualities() {
+		qualities := s.Qualities()
+
+		for len(data.qualities) < len(qualities) {
+			data.qualities = append(data.qualities, [_MaxQuality + 1]int{})
+		}
+
+		for i, q := range qualities {
+			data.qualities[i][min(int(q), _MaxQuality)] += count
+		}
+	}
+
+	if n, ok := data.sequences[string(sequence)]; ok || len(data.sequences) < _MaxTrackedSequences {
+		data.sequences[string(sequence)] = n + count
+		data.tracked += count
+	}
+
+	// The k-mers are counted on the parts of the read without ambiguous
+	// nucleotides. They are stored in a map rather than in a table indexed
+	// by all the possible k-mers, which would be allocated by every worker.
+	from := 0
+	for i := 0; i <= len(sequence); i++ {
+		if i == len(sequence) || nucleotideIndex(sequence[i]) == 4 {
+			for kmer := range obikmer.IterKmers(sequence[from:i], data.kmerSize) {
+				data.kmers[kmer] += count
+			}
+			from = i + 1
+		}
+	}
+
+	return data
+}
+
+// Add merges two quality summaries. The summaries are modified.
+func (data1 *QualitySummary) Add(data2 *QualitySummary) *QualitySummary {
+	if data1 == nil {
+		return data2
+	}
+
+	if data2 == nil {
+		return data1
+	}
+
+	if len(data1.qualities) < len(data2.qualities) {
+		data1.qualities, data2.qualities = data2.qualities, data1.qualities
+	}
+
+	for i, hist := range data2.qualities {
+		for q, n := range hist {
+			data1.qualities[i][q] += n
+		}
+	}
+
+	if len(data1.composition) < len(data2.composition) {
+		data1.composition, data2.composition = data2.composition, data1.composition
+	}
+
+	for i, counts := range data2.composition {
+		for j, n := range counts {
+			data1.composition[i][j] += n
+		}
+	}
+
+	for length, n := range data2.lengths {
+		data1.lengths[length] += n
+	}
+
+	for sequence, n := range data2.sequences {
+		data1.sequences[sequence] += n
+	}
+
+	for kmer, n := range data2.kmers {
+		data1.kmers[kmer] += n
+	}
+
+	data1.reads += data2.reads
+	data1.tracked += data2.tracked
+
+	return data1
+}
+
+// PositionQuality describes the distribution of the qualities at a
+// position of the reads.
+type PositionQuality struct {
+	Position    int     `json:"position" yaml:"position"`
+	Mean        float64 `json:"mean" yaml:"mean"`
+	Median      int     `json:"median" yaml:"median"`
+	Quartile1   int     `json:"q25" yaml:"q25"`
+	Quartile3   int     `json:"q75" yaml:"q75"`
+	Decile1     int     `json:"p10" yaml:"p10"`
+	Decile9     int     `json:"p90" yaml:"p90"`
+	Nucleotides int     `json:"nucleotides" yaml:"nucleotides"`
+}
+
+// PositionComposition gives the percentages of each nucleotide at a
+// position of the reads.
+type PositionComposition struct {
+	Position int     `json:"position" yaml:"position"`
+	A        float64 `json:"a" yaml:"a"`
+	C        float64 `json:"c" yaml:"c"`
+	G        float64 `json:"g" yaml:"g"`
+	T        float64 `json:"t" yaml:"t"`
+	N        float64 `json:"n" yaml:"n"`
+}
+
+// LengthCount gives the number of reads of a length.
+type LengthCount struct {
+	Length int `json:"length" yaml:"length"`
+	Reads  int `json:"reads" yaml:"reads"`
+}
+
+// DuplicationLevel gives the percentage of the reads belonging to the
+// sequences occurring a number of times in a range.
+type DuplicationLevel struct {
+	Level   string  `json:"level" yaml:"level"`
+	Percent float64 `json:"percent" yaml:"percent"`
+}
+
+// Overrepresented describes a sequence or a k-mer occurring more often
+// than the others.
+type Overrepresented struct {
+	Sequence string  `json:"sequence" yaml:"sequence"`
+	Count    int     `json:"count" yaml:"count"`
+	Percent  float64 `json:"percent" yaml:"percent"`
+}
+
+// quantile returns the smallest quality whose cumulative count reaches a
+// fraction of the total.
+func quantile(hist [_MaxQuality + 1]int, total int, fraction float64) int {
+	threshold := fraction * float64(total)
+	cumulative := 0
+
+	for q, n := range hist {
+		cumulative += n
+		if float64(cumulative) >= threshold {
+			return q
+		}
+	}
+
+	return _MaxQuality
+}
+
+// PerPositionQuality returns the distribution of the qualities at each
+// position of the reads.
+func (data *QualitySummary) PerPositionQuality() []PositionQuality {
+	report := make([]PositionQuality, 0, len(data.qualities))
+
+	for i, hist := range data.qualities {
+		total, sum := 0, 0
+		for q, n := range hist {
+			total += n
+			sum += q * n
+		}
+
+		if total == 0 {
+			continue
+		}
+
+		report = append(report, PositionQuality{
+			Position:    i + 1,
+			Mean:        float64(sum) / float64(total),
+			Median:      quantile(hist, total, 0.5),
+			Quartile1:   quantile(hist, total, 0.25),
+			Quartile3:   quantile(hist, total, 0.75),
+			Decile1:     quantile(hist, total, 0.1),
+			Decile9:     quantile(hist, total, 0.9),
+			Nucleotides: total,
+		})
+	}
+
+	return report
+}
+
+// BaseComposition returns the percentages of each nucleotide at each
+// position of the reads, the ambiguous nucleotides being counted as n.
+func (data *QualitySummary) BaseComposition() []PositionComposition {
+	report := make([]PositionComposition, 0, len(data.composition))
+
+	for i, counts := range data.composition {
+		total := counts[0] + counts[1] + counts[2] + counts[3] + counts[4]
+		if total == 0 {
+			continue
+		}
+
+		percent := func(n int) float64 {
+			return 100 * float64(n) / float64(total)
+		}
+
+		report = append(report, PositionComposition{
+			Position: i + 1,
+			A:        percent(counts[0]),
+			C:        percent(counts[1]),
+			G:        percent(counts[2]),
+			T:        percent(counts[3]),
+			N:        percent(counts[4]),
+		})
+	}
+
+	return report
+}
+
+// LengthDistribution returns the number of reads of each length.
+func (data *QualitySummary) LengthDistribution() []LengthCount {
+	report := make([]LengthCount, 0, len(data.lengths))
+
+	for length, n := range data.lengths {
+		report = append(report, LengthCount{length, n})
+	}
+
+	slices.SortFunc(report, func(a, b LengthCount) int {
+		return cmp.Compare(a.Length, b.Length)
+	})
+
+	return report
+}
+
+// DuplicationLevels returns the percentages of the followed reads
+// belonging to sequences occurring 1, 2, ..., 9, 10 to 49, ... times.
+func (data *QualitySummary) DuplicationLevels() []DuplicationLevel {
+	counts := make([]int, len(_DuplicationLevels))
+
+	for _, n := range data.sequences {
+		level := 0
+		for level+1 < len(_DuplicationLevels) && n >= _DuplicationLevels[level+1] {
+			level++
+		}
+		counts[level] += n
+	}
+
+	report := make([]DuplicationLevel, len(_DuplicationLevels))
+	for i, bound := range _DuplicationLevels {
+		label := strconv.Itoa(bound)
+		if bound >= 10 {
+			label = fmt.Sprintf(">=%d", bound)
+		}
+
+		report[i] = DuplicationLevel{Level: label}
+		if data.tracked > 0 {
+			report[i].Percent = 100 * float64(counts[i]) / float64(data.tracked)
+		}
+	}
+
+	return report
+}
+
+// OverrepresentedSequences returns the most frequent sequences
+// representing more than 0.1% of the reads.
+func (data *QualitySummary) OverrepresentedSequences() []Overrepresented {
+	report := make([]Overrepresented, 0)
+
+	for sequence, n := range data.sequences {
+		if float64(n) > _OverrepresentedFraction*float64(data.reads) {
+			report = append(report, Overrepresented{
+				Sequence: sequence,
+				Count:    n,
+				Percent:  100 * float64(n) / float64(data.reads),
+			})
+		}
+	}
+
+	return topOverrepresented(report, data.overrepresented)
+}
+
+// OverrepresentedKmers returns the most frequent k-mers, with their
+// percentage among all the k-mers of the reads.
+func (data *QualitySummary) OverrepresentedKmers() []Overrepresented {
+	total := 0
+	for _, n := range data.kmers {
+		total += n
+	}
+
+	report := make([]Overrepresented, 0)
+	buffer := make([]byte, data.kmerSize)
+
+	for kmer, n := range data.kmers {
+		if n > 0 {
+			report = append(report, Overrepresented{
+				Sequence: string(obikmer.DecodeKmer(kmer, data.kmerSize, buffer)),
+				Count:    n,
+				Percent:  100 * float64(n) / float64(total),
+			})
+		}
+	}
+
+	return topOverrepresented(report, data.overrepresented)
+}
+
+// topOverrepresented keeps the most frequent elements.
+func topOverrepresented(report []Overrepresented, top int) []Overrepresented {
+	slices.SortFunc(report, func(a, b Overrepresented) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Sequence, b.Sequence))
+	})
+
+	return report[:min(top, len(report))]
+}
+
+// Report returns the quality summary as a map ready to be serialized in
+// JSON or YAML.
+func (data *QualitySummary) Report() map[string]interface{} {
+	return map[string]interface{}{
+		"per_position_quality": data.PerPositionQuality(),
+		"base_composition":     data.BaseComposition(),
+		"length_distribution":  data.LengthDistribution(),
+		"duplication": map[string]interface{}{
+			"tracked_reads":      data.tracked,
+			"distinct_sequences": len(data.sequences),
+			"levels":             data.DuplicationLevels(),
+		},
+		"overrepresented_sequences": data.OverrepresentedSequences(),
+		"overrepresented_kmers":     data.OverrepresentedKmers(),
+	}
+}
+
+// writeCSV writes a table to a CSV file.
+func writeCSV(filename string, header []string, rows [][]string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write(header)
+	writer.WriteAll(rows)
+
+	return writer.Error()
+}
+
+// formatFloat formats a percentage or a mean for the CSV files.
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', 3, 64)
+}
+
+// WriteCSV writes each metric of the summary in its own CSV file, named
+// by adding the name of the metric to a prefix: <prefix>_quality.csv,
+// <prefix>_composition.csv, <prefix>_n_content.csv, <prefix>_length.csv,
+// <prefix>_duplication.csv, <prefix>_overrepresented.csv and
+// <prefix>_kmers.csv.
+//
+// Parameters:
+//   - prefix: The prefix of the file names.
+//
+// Returns:
+//   - An error if a file cannot be written.
+func (data *QualitySummary) WriteCSV(prefix string) error {
+	rows := make([][]string, 0)
+	for _, p := range data.PerPositionQuality() {
+		rows = append(rows, []string{
+			strconv.Itoa(p.Position), formatFloat(p.Mean),
+			strconv.Itoa(p.Median), strconv.Itoa(p.Quartile1), strconv.Itoa(p.Quartile3),
+			strconv.Itoa(p.Decile1), strconv.Itoa(p.Decile9), strconv.Itoa(p.Nucleotides),
+		})
+	}
+	if err := writeCSV(prefix+"_quality.csv",
+		[]string{"position", "mean", "median", "q25", "q75", "p10", "p90", "nucleotides"},
+		rows); err != nil {
+		return err
+	}
+
+	composition := data.BaseComposition()
+
+	rows = rows[:0]
+	for _, p := range composition {
+		rows = append(rows, []string{
+			strconv.Itoa(p.Position),
+			formatFloat(p.A), formatFloat(p.C), formatFloat(p.G), formatFloat(p.T),
+		})
+	}
+	if err := writeCSV(prefix+"_composition.csv",
+		[]string{"position", "a", "c", "g", "t"},
+		rows); err != nil {
+		return err
+	}
+
+	rows = rows[:0]
+	for _, p := range composition {
+		rows = append(rows, []string{strconv.Itoa(p.Position), formatFloat(p.N)})
+	}
+	if err := writeCSV(prefix+"_n_content.csv",
+		[]string{"position", "n"},
+		rows); err != nil {
+		return err
+	}
+
+	rows = rows[:0]
+	for _, l := range data.LengthDistribution() {
+		rows = append(rows, []string{strconv.Itoa(l.Length), strconv.Itoa(l.Reads)})
+	}
+	if err := writeCSV(prefix+"_length.csv",
+		[]string{"length", "reads"},
+		rows); err != nil {
+		return err
+	}
+
+	rows = rows[:0]
+	for _, d := range data.DuplicationLevels() {
+		rows = append(rows, []string{d.Level, formatFloat(d.Percent)})
+	}
+	if err := writeCSV(prefix+"_duplication.csv",
+		[]string{"level", "percent"},
+		rows); err != nil {
+		return err
+	}
+
+	for _, table := range []struct {
+		name    string
+		entries []Overrepresented
+	}{
+		{"overrepresented", data.OverrepresentedSequences()},
+		{"kmers", data.OverrepresentedKmers()},
+	} {
+		rows = rows[:0]
+		for _, o := range table.entries {
+			rows = append(rows, []string{o.Sequence, strconv.Itoa(o.Count), formatFloat(o.Percent)})
+		}
+		if err := writeCSV(prefix+"_"+table.name+".csv",
+			[]string{"sequence", "count", "percent"},
+			rows); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
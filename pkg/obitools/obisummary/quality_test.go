@@ -0,0 +1,187 @@
+package obisummary
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// qualityReads builds random reads of various lengths, with and without
+// qualities or ambiguous nucleotides.
+func qualityReads(rng *rand.Rand, n int) obiseq.BioSequenceSlice {
+	reads := obiseq.MakeBioSequenceSlice(0)
+
+	for i := 0; i < n; i++ {
+		sequence := make([]byte, 20+rng.Intn(30))
+		for j := range sequence {
+			sequence[j] = "acgtacgtacgtn"[rng.Intn(13)]
+		}
+
+		// Some duplicated reads
+		if i%5 == 0 && i > 0 {
+			sequence = reads[i-1].Sequence()
+		}
+
+		qualities := make([]byte, len(sequence))
+		for j := range qualities {
+			qualities[j] = byte(rng.Intn(42))
+		}
+
+		var read *obiseq.BioSequence
+		if i%7 == 0 {
+			read = obiseq.NewBioSequence(fmt.Sprintf("read_%d", i), sequence, "")
+		} else {
+			read = obiseq.NewBioSequenceWithQualities(fmt.Sprintf("read_%d", i), sequence, "", qualities)
+		}
+		read.SetCount(1 + rng.Intn(3))
+
+		reads = append(reads, read)
+	}
+
+	return reads
+}
+
+// A summary merged from several workers equals the summary of all the reads.
+func TestQualitySummaryAdd(t *testing.T) {
+	reads := qualityReads(rand.New(rand.NewSource(48)), 200)
+
+	whole := NewQualitySummary(4, 10)
+	for _, read := range reads {
+		whole.Update(read)
+	}
+
+	parts := []*QualitySummary{NewQualitySummary(4, 10), NewQualitySummary(4, 10), NewQualitySummary(4, 10)}
+	for i, read := range reads {
+		parts[(i*7)%len(parts)].Update(read)
+	}
+
+	var merged *QualitySummary
+	for _, part := range parts {
+		merged = merged.Add(part)
+	}
+	merged = merged.Add(nil)
+
+	if !reflect.DeepEqual(merged.Report(), whole.Report()) {
+		t.Errorf("merged summary differs from the summary of all the reads")
+	}
+
+	if merged.reads != whole.reads || merged.tracked != whole.tracked || !reflect.DeepEqual(merged.kmers, whole.kmers) {
+		t.Errorf("merged summary counts %d reads and %d k-mers, expected %d and %d",
+			merged.reads, len(merged.kmers), whole.reads, len(whole.kmers))
+	}
+}
+
+func TestQualitySummaryUpdate(t *testing.T) {
+	summary := NewQualitySummary(2, 3)
+
+	read := obiseq.NewBioSequenceWithQualities("read", []byte("acgt"), "", []byte{30, 30, 20, 10})
+	read.SetCount(3)
+	summary.Update(read)
+	summary.Update(obiseq.NewBioSequence("ambiguous", []byte("aancg"), ""))
+
+	kmers := map[string]int{"ac": 3, "cg": 4, "gt": 3, "aa": 1}
+	for _, kmer := range summary.OverrepresentedKmers() {
+		if kmers[kmer.Sequence] != kmer.Count {
+			t.Errorf("k-mer %s counted %d times, expected %d", kmer.Sequence, kmer.Count, kmers[kmer.Sequence])
+		}
+	}
+
+	composition := summary.BaseComposition()
+	if len(composition) != 5 || composition[2].N != 25 || composition[4].G != 100 {
+		t.Errorf("base composition is %+v", composition)
+	}
+
+	quality := summary.PerPositionQuality()
+	if len(quality) != 4 || quality[0].Nucleotides != 3 {
+		t.Errorf("per position qualities are %+v", quality)
+	}
+}
+
+func TestQualitySummaryWriteCSV(t *testing.T) {
+	summary := NewQualitySummary(2, 3)
+
+	read := obiseq.NewBioSequenceWithQualities("a", []byte("acgt"), "", []byte{30, 30, 20, 10})
+	read.SetCount(3)
+	summary.Update(read)
+	summary.Update(obiseq.NewBioSequenceWithQualities("b", []byte("aag"), "", []byte{10, 20, 30}))
+
+	prefix := filepath.Join(t.TempDir(), "summary")
+	if err := summary.WriteCSV(prefix); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"quality": `position,mean,median,q25,q75,p10,p90,nucleotides
+1,25.000,30,10,30,10,30,4
+2,27.500,30,20,30,20,30,4
+3,22.500,20,20,20,20,30,4
+4,10.000,10,10,10,10,10,3
+`,
+		"composition": `position,a,c,g,t
+1,100.000,0.000,0.000,0.000
+2,25.000,75.000,0.000,0.000
+3,0.000,0.000,100.000,0.000
+4,0.000,0.000,0.000,100.000
+`,
+		"n_content": `position,n
+1,0.000
+2,0.000
+3,0.000
+4,0.000
+`,
+		"length": `length,reads
+3,1
+4,3
+`,
+		"duplication": `level,percent
+1,25.000
+2,0.000
+3,75.000
+4,0.000
+5,0.000
+6,0.000
+7,0.000
+8,0.000
+9,0.000
+>=10,0.000
+>=50,0.000
+>=100,0.000
+>=500,0.000
+>=1000,0.000
+>=5000,0.000
+>=10000,0.000
+`,
+		"overrepresented": `sequence,count,percent
+acgt,3,75.000
+aag,1,25.000
+`,
+		"kmers": `sequence,count,percent
+ac,3,27.273
+cg,3,27.273
+gt,3,27.273
+`,
+	}
+
+	for name, content := range expected {
+		data, err := os.ReadFile(prefix + "_" + name + ".csv")
+		if err != nil {
+			t.Errorf("cannot read the %s file: %v", name, err)
+			continue
+		}
+
+		if string(data) != content {
+			t.Errorf("%s file is\n%s\nexpected\n%s", name, data, content)
+		}
+	}
+
+	if err := summary.WriteCSV(filepath.Join(prefix, "missing", "summary")); err == nil ||
+		!strings.Contains(err.Error(), "missing") {
+		t.Errorf("writing in a missing directory returns %v", err)
+	}
+}
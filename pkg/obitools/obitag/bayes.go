@@ -0,0 +1,531 @@
+package obitag
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obikmer"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obirefidx"
+)
+
+const _BayesModelVersion = 1
+
+// bayesPosting records that Count reference sequences of the taxon
+// number Taxon contain a given word.
+type bayesPosting struct {
+	Taxon int32
+	Count int32
+}
+
+// BayesModel is a naive Bayes k-mer classifier in the spirit of the RDP
+// classifier (Wang et al. 2007). Each taxon of the reference database is
+// described by the probabilities of observing each word (k-mer) in one of
+// its sequences:
+//
+//	P(w|g) = (m_g(w) + P_w) / (M_g + 1)
+//
+// where M_g is the number of reference sequences of taxon g, m_g(w) the
+// number of them containing the word w, and P_w = (n(w) + 0.5) / (N + 1)
+// the prior probability of the word among the N reference sequences.
+//
+// Fields:
+//   - k: The word size.
+//   - taxonomy: The taxonomy the taxa belong to.
+//   - taxa: The taxa described by the model.
+//   - sizes: The number of reference sequences of each taxon (M_g).
+//   - nwords: The number of reference sequences containing each word (n(w)).
+//   - nseqs: The number of reference sequences (N).
+//   - logprior: The log of the prior probability of each word.
+//   - postings: For each word, the taxa containing it.
+//   - smallest: The index of the taxon with the fewest sequences.
+//   - scratch: A pool of buffers used by the classifications.
+type BayesModel struct {
+	k        int
+	taxonomy *obitax.Taxonomy
+	taxa     *obitax.TaxonSlice
+	sizes    []int32
+	nwords   []uint32
+	nseqs    int
+	logprior []float64
+	postings [][]bayesPosting
+	smallest int
+	scratch  sync.Pool
+}
+
+// bayesScratch holds the per classification buffers.
+type bayesScratch struct {
+	scores  []float64
+	touched []int32
+	words   []uint64
+	sample  []uint64
+}
+
+// sequenceWords returns the distinct words of size k of a sequence,
+// ignoring the words overlapping an ambiguous nucleotide.
+func sequenceWords(sequence []byte, k int, buffer *[]uint64) []uint64 {
+	words := obikmer.EncodeKmers(sequence, k, buffer)
+
+	if words == nil {
+		return nil
+	}
+
+	// Position of the last ambiguous nucleotide seen
+	last := -1
+	for p := 0; p < k-1; p++ {
+		if !isACGT(sequence[p]) {
+			last = p
+		}
+	}
+
+	valid := words[:0]
+	for i, w := range words {
+		if end := i + k - 1; !isACGT(sequence[end]) {
+			last = end
+		}
+		if last < i {
+			valid = append(valid, w)
+		}
+	}
+
+	slices.Sort(valid)
+	valid = slices.Compact(valid)
+
+	*buffer = valid
+	return valid
+}
+
+func isACGT(nuc byte) bool {
+	switch nuc | 0x20 {
+	case 'a', 'c', 'g', 't', 'u':
+		return true
+	}
+	return false
+}
+
+// TrainBayesModel builds a naive Bayes classifier from a reference database.
+// Reference sequences are grouped by taxon, every distinct taxon of the
+// database being a class of the classifier.
+//
+// Parameters:
+//   - references: The reference database.
+//   - k: The word size, between 4 and 10.
+//
+// Returns:
+//   - A pointer to the trained BayesModel.
+//   - An error if the word size is invalid.
+func TrainBayesModel(references *obirefidx.ReferenceDB, k int) (*BayesModel, error) {
+	if k < 4 || k > 10 {
+		return nil, fmt.Errorf("invalid word size %d, it must be between 4 and 10", k)
+	}
+
+	taxonomy := references.Taxonomy
+	classes := make(map[*obitax.TaxNode]int32)
+	taxa := taxonomy.NewTaxonSlice(0, 100)
+	sizes := make([]int32, 0, 100)
+	counts := make(map[uint64]map[int32]int32)
+	nwords := make([]uint32, 1<<(2*k))
+	var buffer []uint64
+
+	for i, seq := range references.Sequences {
+		taxon := references.Taxa.Taxon(i)
+		class, ok := classes[taxon.Node]
+
+		if !ok {
+			class = int32(taxa.Len())
+			classes[taxon.Node] = class
+			taxa.Push(taxon)
+			sizes = append(sizes, 0)
+		}
+
+		sizes[class]++
+
+		for _, w := range sequenceWords(seq.Sequence(), k, &buffer) {
+			nwords[w]++
+			bytaxon, ok := counts[w]
+			if !ok {
+				bytaxon = make(map[int32]int32)
+				counts[w] = bytaxon
+			}
+			bytaxon[class]++
+		}
+	}
+
+	postings := make([][]bayesPosting, len(nwords))
+	for w, bytaxon := range counts {
+		list := make([]bayesPosting, 0, len(bytaxon))
+		for class, count := range bytaxon {
+			list = append(list, bayesPosting{class, count})
+		}
+		slices.SortFunc(list, func(a, b bayesPosting) int { return int(a.Taxon - b.Taxon) })
+		postings[w] = list
+	}
+
+	model := &BayesModel{
+		k:        k,
+		taxonomy: taxonomy,
+		taxa:     taxa,
+		sizes:    sizes,
+		nwords:   nwords,
+		nseqs:    references.Len(),
+		postings: postings,
+	}
+
+	model.setup()
+
+	log.Infof("Naive Bayes model trained on %d sequences describing %d taxa (k=%d)",
+		model.nseqs, taxa.Len(), k)
+
+	return model, nil
+}
+
+// setup computes the values derived from the word counts.
+func (model *BayesModel) setup() {
+	model.logprior = make([]float64, len(model.nwords))
+	for w, n := range model.nwords {
+		model.logprior[w] = math.Log((float64(n) + 0.5) / float64(model.nseqs+1))
+	}
+
+	model.smallest = 0
+	for i, size := range model.sizes {
+		if size < model.sizes[model.smallest] {
+			model.smallest = i
+		}
+	}
+
+	ntaxa := len(model.sizes)
+	model.scratch.New = func() any {
+		return &bayesScratch{
+			scores:  make([]float64, ntaxa),
+			touched: make([]int32, 0, ntaxa),
+		}
+	}
+}
+
+// Taxa returns the taxa described by the model.
+func (model *BayesModel) Taxa() *obitax.TaxonSlice {
+	return model.taxa
+}
+
+// WordSize returns the size of the words used by the model.
+func (model *BayesModel) WordSize() int {
+	return model.k
+}
+
+// bestTaxon returns the class maximising the log likelihood of a word set.
+//
+// The words absent from a taxon contribute log(P_w) - log(M_g + 1), so the
+// likelihood of a class is computed as a common term plus a correction for
+// the words it contains. Only the classes sharing at least one word with the
+// query, and the class with the fewest sequences, can be the best one.
+func (model *BayesModel) bestTaxon(words []uint64, scratch *bayesScratch) int32 {
+	scores := scratch.scores
+	touched := scratch.touched[:0]
+
+	for _, w := range words {
+		prior := math.Exp(model.logprior[w])
+		for _, p := range model.postings[w] {
+			if scores[p.Taxon] == 0 {
+				touched = append(touched, p.Taxon)
+			}
+			scores[p.Taxon] += math.Log(float64(p.Count)+prior) - model.logprior[w]
+		}
+	}
+
+	n := float64(len(words))
+	best := int32(model.smallest)
+	bestScore := -n * math.Log(float64(model.sizes[best])+1)
+
+	for _, class := range touched {
+		score := scores[class] - n*math.Log(float64(model.sizes[class])+1)
+		if score > bestScore || (score == bestScore && class < best) {
+			best = class
+			bestScore = score
+		}
+		scores[class] = 0
+	}
+
+	scratch.touched = touched
+	return best
+}
+
+// Classify assigns a sequence to a taxon of the model. The best scoring
+// taxon is first identified using all the words of the sequence. The
+// confidence of each taxon of its lineage is then estimated by bootstrap:
+// the classification is repeated on random subsamples of one eighth of the
+// words, and the confidence of a taxon is the fraction of replicates
+// assigned to one of its descendants.
+//
+// Parameters:
+//   - sequence: The sequence to classify.
+//   - bootstrap: The number of bootstrap replicates.
+//
+// Returns:
+//   - The path from the best scoring taxon to the root, or nil if the
+//     sequence is too short to be classified.
+//   - The confidence associated with each taxon of the path.
+func (model *BayesModel) Classify(sequence *obiseq.BioSequence, bootstrap int) (*obitax.TaxonSlice, []float64) {
+	scratch := model.scratch.Get().(*bayesScratch)
+	defer model.scratch.Put(scratch)
+
+	words := sequenceWords(sequence.Sequence(), model.k, &scratch.words)
+
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	best := model.taxa.Taxon(int(model.bestTaxon(words, scratch)))
+	path := best.Path()
+	confidences := make([]float64, path.Len())
+
+	seed := fnv.New64a()
+	seed.Write([]byte(sequence.Id()))
+	seed.Write(sequence.Sequence())
+	rng := rand.New(rand.NewPCG(seed.Sum64(), uint64(len(words))))
+
+	size := max(1, len(words)/8)
+	winners := make(map[int32]int, bootstrap)
+
+	for b := 0; b < bootstrap; b++ {
+		sample := scratch.sample[:0]
+		for i := 0; i < size; i++ {
+			sample = append(sample, words[rng.IntN(len(words))])
+		}
+		scratch.sample = sample
+		winners[model.bestTaxon(sample, scratch)]++
+	}
+
+	for class, count := range winners {
+		winner := model.taxa.Taxon(int(class))
+		lca, err := winner.LCA(best)
+		if err != nil {
+			continue
+		}
+		// Every taxon of the path above the LCA contains the winner
+		depth := lca.Depth()
+		for i := path.Len() - 1; i >= 0 && path.Len()-1-i <= depth; i-- {
+			confidences[i] += float64(count)
+		}
+	}
+
+	for i := range confidences {
+		confidences[i] /= float64(bootstrap)
+	}
+
+	return path, confidences
+}
+
+type bayesModelFile struct {
+	Version      int
+	K            int
+	TaxonomyName string
+	TaxonomyCode string
+	Taxa         []string
+	Sizes        []int32
+	NWords       []uint32
+	NSeqs        int
+	Words        []uint64
+	Postings     [][]bayesPosting
+}
+
+// SaveBayesModel writes the model to a gzip compressed file.
+//
+// Parameters:
+//   - model: The model to save.
+//   - filename: The name of the file to create.
+//
+// Returns:
+//   - An error if the file cannot be written.
+func SaveBayesModel(model *BayesModel, filename string) error {
+	data := bayesModelFile{
+		Version:      _BayesModelVersion,
+		K:            model.k,
+		TaxonomyName: model.taxonomy.Name(),
+		TaxonomyCode: model.taxonomy.Code(),
+		Taxa:         make([]string, model.taxa.Len()),
+		Sizes:        model.sizes,
+		NWords:       model.nwords,
+		NSeqs:        model.nseqs,
+	}
+
+	for i := range data.Taxa {
+		data.Taxa[i] = model.taxa.Taxon(i).String()
+	}
+
+	for w, list := range model.postings {
+		if len(list) > 0 {
+			data.Words = append(data.Words, uint64(w))
+			data.Postings = append(data.Postings, list)
+		}
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	zipped := gzip.NewWriter(file)
+
+	if err := gob.NewEncoder(zipped).Encode(&data); err != nil {
+		return err
+	}
+
+	if err := zipped.Close(); err != nil {
+		return err
+	}
+
+	log.Infof("Naive Bayes model saved to %s", filename)
+
+	return file.Close()
+}
+
+// LoadBayesModel reads a model saved by SaveBayesModel. The taxa of the model
+// are resolved in the provided taxonomy.
+//
+// Parameters:
+//   - filename: The name of the model file.
+//   - taxonomy: The taxonomy the model was trained with.
+//
+// Returns:
+//   - A pointer to the loaded BayesModel.
+//   - An error if the file cannot be read or if a taxon of the model is
+//     unknown in the taxonomy.
+func LoadBayesModel(filename string, taxonomy *obitax.Taxonomy) (*BayesModel, error) {
+	taxonomy = taxonomy.OrDefault(false)
+
+	if taxonomy == nil {
+		return nil, fmt.Errorf("a taxonomy is required to load the model %s", filename)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	zipped, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a naive Bayes model: %v", filename, err)
+	}
+
+	var data bayesModelFile
+	if err := gob.NewDecoder(zipped).Decode(&data); err != nil {
+		return nil, fmt.Errorf("%s is not a naive Bayes model: %v", filename, err)
+	}
+
+	if data.Version != _BayesModelVersion {
+		return nil, fmt.Errorf("%s: unsupported model version %d", filename, data.Version)
+	}
+
+	if data.TaxonomyCode != taxonomy.Code() {
+		return nil, fmt.Errorf("model %s was trained with taxonomy %s (code %s), not with %s (code %s)",
+			filename, data.TaxonomyName, data.TaxonomyCode, taxonomy.Name(), taxonomy.Code())
+	}
+
+	model := &BayesModel{
+		k:        data.K,
+		taxonomy: taxonomy,
+		taxa:     taxonomy.NewTaxonSlice(0, len(data.Taxa)),
+		sizes:    data.Sizes,
+		nwords:   data.NWords,
+		nseqs:    data.NSeqs,
+		postings: make([][]bayesPosting, len(data.NWords)),
+	}
+
+	for _, taxid := range data.Taxa {
+		taxon, _, err := taxonomy.Taxon(taxid)
+		if err != nil {
+			return nil, fmt.Errorf("model %s was trained with another version of taxonomy %s: %v",
+				filename, data.TaxonomyName, err)
+		}
+		model.taxa.Push(taxon)
+	}
+
+	for i, w := range data.Words {
+		model.postings[w] = data.Postings[i]
+	}
+
+	model.setup()
+
+	log.Infof("Naive Bayes model %s loaded (%d taxa, k=%d)", filename, model.taxa.Len(), model.k)
+
+	return model, nil
+}
+
+// BayesIdentify assigns a sequence using a naive Bayes model. The sequence
+// is assigned to the deepest taxon of the best lineage whose bootstrap
+// confidence reaches the threshold. The confidence of each canonical rank of
+// the lineage is stored in the obitag_rank_confidence attribute.
+//
+// Parameters:
+//   - sequence: The sequence to assign.
+//   - model: The classifier.
+//   - bootstrap: The number of bootstrap replicates.
+//   - threshold: The minimal confidence of the assigned taxon.
+//
+// Returns:
+//   - The annotated sequence.
+func BayesIdentify(sequence *obiseq.BioSequence,
+	model *BayesModel,
+	bootstrap int,
+	threshold float64) *obiseq.BioSequence {
+
+	path, confidences := model.Classify(sequence, bootstrap)
+
+	taxon := model.taxonomy.Root()
+	confidence := 1.0
+	ranks := canonicalRanks()
+
+	byrank := make(map[string]float64)
+
+	if path != nil {
+		for i := path.Len() - 1; i >= 0; i-- {
+			t := path.Taxon(i)
+			if slices.Contains(ranks, t.Rank()) {
+				byrank[t.Rank()] = confidences[i]
+			}
+			if confidences[i] >= threshold {
+				taxon = t
+				confidence = confidences[i]
+			}
+		}
+
+		sequence.SetAttribute("obitag_bestmatch", path.Taxon(0).String())
+	}
+
+	sequence.SetTaxon(taxon)
+	sequence.SetAttribute("obitag_rank", taxon.Rank())
+	sequence.SetAttribute("obitag_confidence", confidence)
+	sequence.SetAttribute("obitag_rank_confidence", byrank)
+	sequence.SetAttribute("obitag_similarity_method", "bayes")
+
+	return sequence
+}
+
+func BayesIdentifySeqWorker(model *BayesModel, bootstrap int, threshold float64) obiseq.SeqWorker {
+	return func(sequence *obiseq.BioSequence) (obiseq.BioSequenceSlice, error) {
+		return obiseq.BioSequenceSlice{BayesIdentify(sequence, model, bootstrap, threshold)}, nil
+	}
+}
+
+func CLIBayesAssignTaxonomy(iterator obiiter.IBioSequence,
+	model *BayesModel,
+) obiiter.IBioSequence {
+
+	worker := BayesIdentifySeqWorker(model, CLIBootstrap(), CLIMinConfidence())
+
+	return iterator.MakeIWorker(worker, false, obidefault.ParallelWorkers(), 0)
+}
@@ -0,0 +1,194 @@
+package obitag
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obirefidx"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+// bayesReferences builds a reference database of a few genera of two
+// species each, every species being represented by several related
+// sequences. The sequences of the genera are returned too.
+func bayesReferences(t *testing.T, rng *rand.Rand) (*obirefidx.ReferenceDB, map[string][]byte) {
+	taxonomy := obitax.NewTaxonomy("test", "taxon", obiutils.AsciiDigitSet)
+
+	if _, err := taxonomy.AddTaxon("1", "1", "no rank", true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	root := make([]byte, 200)
+	for i := range root {
+		root[i] = "acgt"[rng.Intn(4)]
+	}
+
+	taxid := 1
+	addTaxon := func(parent int, rank string) *obitax.Taxon {
+		taxid++
+		taxon, err := taxonomy.AddTaxon(fmt.Sprint(taxid), fmt.Sprint(parent), rank, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		taxon.SetName(fmt.Sprintf("%s %d", rank, taxid), "scientific name")
+		return taxon
+	}
+
+	genera := make(map[string][]byte)
+	references := obiseq.MakeBioSequenceSlice()
+
+	for g := 0; g < 3; g++ {
+		genus := addTaxon(1, "genus")
+		gseq := evolve(rng, root, 60)
+		genera[genus.String()] = gseq
+		gid := taxid
+
+		for s := 0; s < 2; s++ {
+			species := addTaxon(gid, "species")
+			sseq := evolve(rng, gseq, 12)
+
+			for r := 0; r < 5; r++ {
+				seq := obiseq.NewBioSequence(fmt.Sprintf("ref_%d", len(references)),
+					evolve(rng, sseq, rng.Intn(3)), "")
+				seq.SetTaxon(species)
+				references = append(references, seq)
+			}
+		}
+	}
+
+	return obirefidx.MakeReferenceDB(references, taxonomy), genera
+}
+
+func TestBayesClassifyTraining(t *testing.T) {
+	references, _ := bayesReferences(t, rand.New(rand.NewSource(37)))
+
+	model, err := TrainBayesModel(references, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if model.Taxa().Len() != 6 {
+		t.Errorf("the model describes %d taxa, expected 6", model.Taxa().Len())
+	}
+
+	for i, seq := range references.Sequences {
+		path, confidences := model.Classify(seq, 100)
+		expected := references.Taxa.Taxon(i)
+
+		if path == nil || path.Taxon(0).Node != expected.Node {
+			t.Errorf("%s is classified as %v, expected %s", seq.Id(), path, expected)
+			continue
+		}
+
+		// The confidences never decrease towards the root
+		for j := 1; j < len(confidences); j++ {
+			if confidences[j] < confidences[j-1] {
+				t.Errorf("%s: confidence of %s (%f) below the one of %s (%f)", seq.Id(),
+					path.Taxon(j), confidences[j], path.Taxon(j-1), confidences[j-1])
+			}
+		}
+
+		if confidences[len(confidences)-1] != 1 {
+			t.Errorf("%s: confidence of the root is %f", seq.Id(), confidences[len(confidences)-1])
+		}
+
+		if confidences[1] < 0.9 {
+			t.Errorf("%s: confidence of the genus %s is only %f", seq.Id(), path.Taxon(1), confidences[1])
+		}
+
+		annotated := BayesIdentify(seq.Copy(), model, 100, 0.5)
+		if taxon := annotated.Taxon(references.Taxonomy); taxon == nil || taxon.Node != expected.Node {
+			t.Errorf("%s is assigned to %v, expected %s", seq.Id(), taxon, expected)
+		}
+	}
+}
+
+// A sequence of an unknown species of a genus is assigned to the genus.
+func TestBayesConfidenceFallback(t *testing.T) {
+	rng := rand.New(rand.NewSource(41))
+	references, genera := bayesReferences(t, rng)
+
+	model, err := TrainBayesModel(references, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for genus, sequence := range genera {
+		query := obiseq.NewBioSequence("query_"+genus, evolve(rng, sequence, 12), "")
+
+		path, confidences := model.Classify(query, 100)
+		if path == nil || path.Len() < 2 || path.Taxon(1).String() != genus {
+			t.Errorf("%s is classified as %v, expected a species of %s", query.Id(), path, genus)
+			continue
+		}
+
+		if confidences[0] >= 0.9 {
+			t.Errorf("%s: confidence of the species %s is %f", query.Id(), path.Taxon(0), confidences[0])
+		}
+
+		annotated := BayesIdentify(query, model, 100, 0.9)
+		if taxon := annotated.Taxon(references.Taxonomy); taxon == nil || taxon.String() != genus {
+			t.Errorf("%s is assigned to %v, expected %s", query.Id(), taxon, genus)
+		}
+
+		if rank, _ := annotated.GetStringAttribute("obitag_rank"); rank != "genus" {
+			t.Errorf("%s is assigned at rank %s, expected genus", query.Id(), rank)
+		}
+	}
+}
+
+func TestBayesModelSaveLoad(t *testing.T) {
+	rng := rand.New(rand.NewSource(43))
+	references, genera := bayesReferences(t, rng)
+
+	model, err := TrainBayesModel(references, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "model.gz")
+	if err := SaveBayesModel(model, filename); err != nil {
+		t.Fatalf("cannot save the model: %v", err)
+	}
+
+	loaded, err := LoadBayesModel(filename, references.Taxonomy)
+	if err != nil {
+		t.Fatalf("cannot load the model: %v", err)
+	}
+
+	if loaded.WordSize() != model.WordSize() || loaded.Taxa().Len() != model.Taxa().Len() {
+		t.Fatalf("loaded model has k=%d and %d taxa, expected k=%d and %d taxa",
+			loaded.WordSize(), loaded.Taxa().Len(), model.WordSize(), model.Taxa().Len())
+	}
+
+	for i := 0; i < model.Taxa().Len(); i++ {
+		if loaded.Taxa().Taxon(i).Node != model.Taxa().Taxon(i).Node {
+			t.Errorf("taxon %d is %s, expected %s", i, loaded.Taxa().Taxon(i), model.Taxa().Taxon(i))
+		}
+	}
+
+	queries := append(obiseq.BioSequenceSlice{}, references.Sequences...)
+	for genus, sequence := range genera {
+		queries = append(queries, obiseq.NewBioSequence("query_"+genus, evolve(rng, sequence, 12), ""))
+	}
+
+	for _, query := range queries {
+		path, confidences := model.Classify(query, 50)
+		lpath, lconfidences := loaded.Classify(query, 50)
+
+		if lpath.Taxon(0).Node != path.Taxon(0).Node || !reflect.DeepEqual(lconfidences, confidences) {
+			t.Errorf("%s is classified as %s %v by the loaded model, expected %s %v",
+				query.Id(), lpath.Taxon(0), lconfidences, path.Taxon(0), confidences)
+		}
+	}
+
+	other := obitax.NewTaxonomy("other", "other", obiutils.AsciiDigitSet)
+	if _, err := LoadBayesModel(filename, other); err == nil {
+		t.Errorf("model loaded with another taxonomy")
+	}
+}
@@ -0,0 +1,263 @@
+package obitag
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obialign"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obikmer"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+// Hit describes the similarity between a query sequence and a reference
+// sequence.
+type Hit struct {
+	Query     string
+	Reference string
+	Taxon     *obitax.Taxon
+	Identity  float64 // LCS divided by the alignment length
+	Length    int     // Length of the alignment
+	Errors    int     // Alignment length minus LCS
+
+	// The full alignment, only computed on request
+	Alignment *obialign.LCSAlignment
+
+	index int // Index of the reference sequence
+}
+
+// FindHits looks for the reference sequences similar to a query sequence.
+// The references are examined by decreasing number of shared 4-mers, as in
+// FindClosests, and the hits are ranked by increasing number of errors and
+// decreasing identity.
+//
+// Parameters:
+//   - sequence: The query sequence.
+//   - references: The reference sequences.
+//   - refcounts: The 4-mer tables of the reference sequences.
+//   - taxa: The taxa of the reference sequences.
+//   - maxhits: The maximum number of hits reported, 0 for no limit.
+//   - minidentity: The minimal identity of a reported hit.
+//   - full: A boolean value indicating whether to compute the full alignment of each hit.
+//
+// Returns:
+//   - The hits, best first.
+func FindHits(sequence *obiseq.BioSequence,
+	references obiseq.BioSequenceSlice,
+	refcounts []*obikmer.Table4mer,
+	taxa *obitax.TaxonSlice,
+	maxhits int,
+	minidentity float64,
+	full bool) []Hit {
+
+	if sequence.Len() < 5 || len(references) == 0 {
+		return []Hit{}
+	}
+
+	seqwords := obikmer.Count4Mer(sequence, nil, nil)
+	cw := make([]int, len(refcounts))
+
+	for i, ref := range refcounts {
+		cw[i] = obikmer.Common4Mer(seqwords, ref)
+	}
+
+	o := obiutils.Reverse(obiutils.IntOrder(cw), true)
+
+	hits := make([]Hit, 0, max(maxhits, 1))
+
+	less := func(a, b Hit) bool {
+		return a.Errors < b.Errors ||
+			(a.Errors == b.Errors && a.Identity > b.Identity)
+	}
+
+	// The maximum number of errors of a hit: bounded by the identity
+	// threshold, and by the worst kept hit when the list is full.
+	maxError := func(ref *obiseq.BioSequence) int {
+		maxe := -1
+		if minidentity > 0 {
+			maxe = int((1 - minidentity) * float64(sequence.Len()+ref.Len()))
+		}
+		if maxhits > 0 && len(hits) == maxhits {
+			worst := hits[len(hits)-1].Errors
+			if maxe == -1 || worst < maxe {
+				maxe = worst
+			}
+		}
+		return maxe
+	}
+
+	// This bound depends on the reference length: a shorter reference
+	// examined later can still satisfy its own bound.
+	skipped := func(order, maxe int) bool {
+		longest := max(sequence.Len(), references[order].Len())
+		return maxe >= 0 && cw[order] < longest-3-4*maxe
+	}
+
+	// The next references are scored with their current maximum number of
+	// errors, those skipped or left to D1Or0 excepted.
+	scan := obialign.MakeLCSBatchScan(sequence, references, o, nil)
+	bound := func(p int) (int, bool) {
+		maxe := maxError(references[o[p]])
+		return maxe, !skipped(o[p], maxe) && maxe != 0 && maxe != 1
+	}
+
+	for p, order := range o {
+		ref := references[order]
+		longest := max(sequence.Len(), ref.Len())
+
+		// Once the list is full, no reference sharing fewer 4-mers than
+		// required by the worst kept hit for the query length can enter
+		// it. As the references are sorted by decreasing number of shared
+		// 4-mers, the scan can stop.
+		if maxhits > 0 && len(hits) == maxhits &&
+			cw[order] < sequence.Len()-3-4*hits[len(hits)-1].Errors {
+			break
+		}
+
+		maxe := maxError(ref)
+		if skipped(order, maxe) {
+			continue
+		}
+
+		lcs, alilength := -1, -1
+		if maxe == 0 || maxe == 1 {
+			d, _, _, _ := obialign.D1Or0(sequence, ref)
+			if d >= 0 && d <= maxe {
+				alilength = longest
+				lcs = alilength - d
+			}
+		} else {
+			lcs, alilength = scan.Score(p, maxe, bound)
+		}
+
+		if lcs < 0 {
+			continue
+		}
+
+		hit := Hit{
+			Query:     sequence.Id(),
+			Reference: ref.Id(),
+			Taxon:     taxa.Taxon(order),
+			Identity:  float64(lcs) / float64(alilength),
+			Length:    alilength,
+			Errors:    alilength - lcs,
+			index:     order,
+		}
+
+		if hit.Identity < minidentity {
+			continue
+		}
+
+		if maxhits > 0 && len(hits) == maxhits {
+			if !less(hit, hits[len(hits)-1]) {
+				continue
+			}
+			hits = hits[:len(hits)-1]
+		}
+
+		i := sort.Search(len(hits), func(i int) bool { return less(hit, hits[i]) })
+		hits = append(hits, Hit{})
+		copy(hits[i+1:], hits[i:])
+		hits[i] = hit
+	}
+
+	if full {
+		for i := range hits {
+			ali := obialign.AlignLCS(sequence, references[hits[i].index])
+			hits[i].Alignment = &ali
+		}
+	}
+
+	return hits
+}
+
+// HitTableWriter writes hits as a tab separated table, in the spirit of the
+// BLAST tabular output (-outfmt 6). The columns are: query id, reference id,
+// percentage of identity, alignment length, mismatches, gap openings, query
+// start and end, reference start and end, errors, and reference taxid.
+// Values requiring the full alignment are reported as NA when it has not
+// been computed.
+//
+// The writer can be shared by several goroutines, the hits of a query are
+// written as a single block.
+type HitTableWriter struct {
+	file  *os.File
+	mutex sync.Mutex
+}
+
+// NewHitTableWriter creates the file and writes the header of the table.
+func NewHitTableWriter(filename string) (*HitTableWriter, error) {
+	file, err := os.Create(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = fmt.Fprintln(file, "#query_id\treference_id\tidentity\tlength\tmismatches\tgap_opens\t"+
+		"query_start\tquery_end\treference_start\treference_end\terrors\treference_taxid")
+
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &HitTableWriter{file: file}, nil
+}
+
+// Write appends the hits of a query to the table.
+func (w *HitTableWriter) Write(hits []Hit) error {
+	var buffer bytes.Buffer
+
+	for _, hit := range hits {
+		fmt.Fprintf(&buffer, "%s\t%s\t%.2f\t%d\t", hit.Query, hit.Reference, hit.Identity*100, hit.Length)
+
+		if ali := hit.Alignment; ali != nil {
+			fmt.Fprintf(&buffer, "%d\t%d\t%d\t%d\t%d\t%d\t",
+				ali.Mismatches, ali.GapOpens, ali.StartA, ali.EndA, ali.StartB, ali.EndB)
+		} else {
+			buffer.WriteString("NA\tNA\tNA\tNA\tNA\tNA\t")
+		}
+
+		taxid := "NA"
+		if hit.Taxon != nil {
+			taxid = hit.Taxon.String()
+		}
+
+		fmt.Fprintf(&buffer, "%d\t%s\n", hit.Errors, taxid)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	_, err := w.file.Write(buffer.Bytes())
+	return err
+}
+
+// Close closes the underlying file.
+func (w *HitTableWriter) Close() error {
+	return w.file.Close()
+}
+
+// HitTableSeqWorker returns a worker writing the hits of each sequence to
+// the table. The sequences are returned unchanged.
+func HitTableSeqWorker(writer *HitTableWriter,
+	references obiseq.BioSequenceSlice,
+	refcounts []*obikmer.Table4mer,
+	taxa *obitax.TaxonSlice,
+	maxhits int,
+	minidentity float64,
+	full bool) obiseq.SeqWorker {
+	return func(sequence *obiseq.BioSequence) (obiseq.BioSequenceSlice, error) {
+		hits := FindHits(sequence, references, refcounts, taxa, maxhits, minidentity, full)
+
+		if err := writer.Write(hits); err != nil {
+			return nil, fmt.Errorf("cannot write the hits of %s: %v", sequence.Id(), err)
+		}
+
+		return obiseq.BioSequenceSlice{sequence}, nil
+	}
+}
@@ -11,7 +11,6 @@ import (
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obikmer"
-	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obilog"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obirefidx"
@@ -78,8 +77,6 @@ func FindClosests(sequence *obiseq.BioSequence,
 		return obiseq.BioSequenceSlice{}, 1000, 0, "NA", []int{}
 	}
 
-	var matrix []uint64
-
 	seqwords := obikmer.Count4Mer(sequence, nil, nil)
 	cw := make([]int, len(refcounts))
 
@@ -99,7 +96,14 @@ func FindClosests(sequence *obiseq.BioSequence,
 	maxe := -1
 	wordmin := 0
 
-	for _, order := range o {
+	// The next references are scored with the current maximum number of
+	// errors, those below the 4-mer threshold or left to D1Or0 excepted.
+	scan := obialign.MakeLCSBatchScan(sequence, references, o, nil)
+	bound := func(p int) (int, bool) {
+		return maxe, cw[o[p]] >= wordmin && maxe != 0 && maxe != 1
+	}
+
+	for p, order := range o {
 		ref := references[order]
 		score := int(1e9)
 
@@ -116,7 +120,7 @@ func FindClosests(sequence *obiseq.BioSequence,
 				lcs = alilength - score
 			}
 		} else {
-			lcs, alilength = obialign.FastLCSScore(sequence, references[order], maxe, &matrix)
+			lcs, alilength = scan.Score(p, maxe, bound)
 			if lcs >= 0 {
 				score = alilength - lcs
 			}
@@ -165,6 +169,8 @@ func FindClosests(sequence *obiseq.BioSequence,
 // - taxa: A TaxonSet.
 // - taxo: A pointer to a Taxonomy.
 // - runExact: A boolean value indicating whether to run exact matching.
+// - consensus: The fraction of best matches supporting the assignment, 1.0 for a strict LCA.
+// - alternatives: A boolean value indicating whether to annotate the alternative assignments.
 //
 // Returns:
 // - A pointer to a BioSequence.
@@ -173,11 +179,39 @@ func Identify(sequence *obiseq.BioSequence,
 	refcounts []*obikmer.Table4mer,
 	taxa *obitax.TaxonSlice,
 	taxo *obitax.Taxonomy,
-	runExact bool) *obiseq.BioSequence {
+	runExact bool,
+	consensus float64,
+	alternatives bool) *obiseq.BioSequence {
 
 	bests, differences, identity, bestmatch, seqidxs := FindClosests(sequence, references, refcounts, runExact)
+
+	return assignClosests(sequence, bests, differences, identity, bestmatch, seqidxs,
+		references, refcounts, taxa, taxo, consensus, alternatives)
+}
+
+// assignClosests assigns a sequence to the LCA of the taxa associated by the
+// reference index to the best matches found at the given number of
+// differences, or to the majority consensus of the taxa of these matches.
+func assignClosests(sequence *obiseq.BioSequence,
+	bests obiseq.BioSequenceSlice,
+	differences int,
+	identity float64,
+	bestmatch string,
+	seqidxs []int,
+	references obiseq.BioSequenceSlice,
+	refcounts []*obikmer.Table4mer,
+	taxa *obitax.TaxonSlice,
+	taxo *obitax.Taxonomy,
+	consensus float64,
+	alternatives bool) *obiseq.BioSequence {
+
 	taxon := (*obitax.Taxon)(nil)
 
+	// Every best match must be examined to report alternatives or
+	// to compute a majority consensus
+	exhaustive := alternatives || consensus < 1.0
+	hits := make(map[*obitax.TaxNode]int, len(bests))
+
 	if identity >= 0.5 && differences >= 0 {
 		newidx := 0
 		for i, best := range bests {
@@ -213,7 +247,13 @@ func Identify(sequence *obiseq.BioSequence,
 				taxon = match_taxon
 			}
 
-			if taxon.IsRoot() {
+			// The alternatives and the consensus rely on the taxon of the
+			// match itself, not on the LCA stored in its reference index
+			if node := taxa.Get(seqidxs[i]); node != nil {
+				hits[node]++
+			}
+
+			if taxon.IsRoot() && !exhaustive {
 				break
 			}
 
@@ -221,6 +261,12 @@ func Identify(sequence *obiseq.BioSequence,
 
 		log.Debugln(sequence.Id(), "Best matches:", len(bests), "New index:", newidx)
 
+		if consensus < 1.0 && len(hits) > 0 {
+			var support float64
+			taxon, support, _ = taxo.Consensus(hits, consensus)
+			sequence.SetAttribute("obitag_consensus_support", support)
+		}
+
 		sequence.SetTaxon(taxon)
 
 	} else {
@@ -234,51 +280,130 @@ func Identify(sequence *obiseq.BioSequence,
 	sequence.SetAttribute("obitag_match_count", len(bests))
 	sequence.SetAttribute("obitag_similarity_method", "lcs")
 
+	if alternatives {
+		AnnotateAlternatives(sequence, taxon, hits)
+	}
+
 	return sequence
 }
 
+// canonicalRanks returns the ranks used to report per rank results.
+func canonicalRanks() []string {
+	if ranks := obidefault.CanonicalRanks(); len(ranks) > 0 {
+		return ranks
+	}
+
+	return obitax.DefaultCanonicalRanks
+}
+
+// AnnotateAlternatives describes the taxa of the best matches of a sequence.
+// The following attributes are added:
+//   - obitag_best_taxa: the taxa of the best matches with their counts;
+//   - obitag_rank_distribution: for each canonical rank, the distribution of
+//     the best matches among the taxa of that rank;
+//   - obitag_rank_agreement: for each canonical rank of the assigned taxon
+//     lineage, the fraction of best matches agreeing with the assignment.
+//
+// Parameters:
+//   - sequence: The sequence to annotate.
+//   - taxon: The taxon assigned to the sequence.
+//   - hits: The taxa of the best matches with their counts.
+func AnnotateAlternatives(sequence *obiseq.BioSequence,
+	taxon *obitax.Taxon,
+	hits map[*obitax.TaxNode]int) {
+
+	total := 0
+	besttaxa := make(map[string]int, len(hits))
+	for node, count := range hits {
+		hit := &obitax.Taxon{Taxonomy: taxon.Taxonomy, Node: node}
+		besttaxa[hit.String()] = count
+		total += count
+	}
+
+	distribution := make(map[string]map[string]int)
+	agreement := make(map[string]float64)
+
+	for _, rank := range canonicalRanks() {
+		assigned := taxon.TaxonAtRank(rank)
+		agree := 0
+
+		for node, count := range hits {
+			hit := (&obitax.Taxon{Taxonomy: taxon.Taxonomy, Node: node}).TaxonAtRank(rank)
+
+			if hit == nil {
+				continue
+			}
+
+			if distribution[rank] == nil {
+				distribution[rank] = make(map[string]int)
+			}
+			distribution[rank][hit.String()] += count
+
+			if assigned != nil && hit.Node == assigned.Node {
+				agree += count
+			}
+		}
+
+		if assigned != nil && total > 0 {
+			agreement[rank] = float64(agree) / float64(total)
+		}
+	}
+
+	sequence.SetAttribute("obitag_best_taxa", besttaxa)
+	sequence.SetAttribute("obitag_rank_distribution", distribution)
+	sequence.SetAttribute("obitag_rank_agreement", agreement)
+}
+
 func IdentifySeqWorker(references obiseq.BioSequenceSlice,
 	refcounts []*obikmer.Table4mer,
 	taxa *obitax.TaxonSlice,
 	taxo *obitax.Taxonomy,
-	runExact bool) obiseq.SeqWorker {
+	runExact bool,
+	consensus float64,
+	alternatives bool) obiseq.SeqWorker {
 	return func(sequence *obiseq.BioSequence) (obiseq.BioSequenceSlice, error) {
-		return obiseq.BioSequenceSlice{Identify(sequence, references, refcounts, taxa, taxo, runExact)}, nil
+		return obiseq.BioSequenceSlice{Identify(sequence, references, refcounts, taxa, taxo,
+			runExact, consensus, alternatives)}, nil
 	}
 }
 
+var _hitTableWriter *HitTableWriter
+
 func CLIAssignTaxonomy(iterator obiiter.IBioSequence,
-	references obiseq.BioSequenceSlice,
-	taxo *obitax.Taxonomy,
+	references *obirefidx.ReferenceDB,
 ) obiiter.IBioSequence {
 
-	refcounts := make(
-		[]*obikmer.Table4mer,
-		len(references))
-
-	taxa := taxo.NewTaxonSlice(0, references.Len())
-	buffer := make([]byte, 0, 1000)
-
-	j := 0
-	for _, seq := range references {
-		taxon := seq.Taxon(taxo)
-		if taxon != nil && taxon.Node != nil {
-			references[j] = seq
-			refcounts[j] = obikmer.Count4Mer(seq, &buffer, nil)
-			taxa.Push(taxon)
-			j++
-		} else {
-			obilog.Warnf("Taxid %s is not described in the taxonomy %s."+
-				" Sequence %s is discared from the reference database",
-				seq.Taxid(), taxo.Name(), seq.Id())
-		}
+	identify := IdentifySeqWorker
+	if CLIUnmergedPairsMode() {
+		identify = PairedIdentifySeqWorker
 	}
 
-	log.Infof("%d reference sequences conserved on %d", j, len(references))
-	references = references[:j]
-	refcounts = refcounts[:j]
+	worker := identify(references.Sequences, references.Kmers,
+		references.Taxa, references.Taxonomy, CLIRunExact(),
+		CLIConsensusThreshold(), CLIWithAlternatives())
+
+	if CLIHasHitTable() {
+		var err error
+		_hitTableWriter, err = NewHitTableWriter(CLIHitTableName())
+
+		if err != nil {
+			log.Fatalf("Cannot create the hits table %s: %v", CLIHitTableName(), err)
+		}
 
-	worker := IdentifySeqWorker(references, refcounts, taxa, taxo, CLIRunExact())
+		worker = HitTableSeqWorker(_hitTableWriter,
+			references.Sequences, references.Kmers, references.Taxa,
+			CLIMaxHits(), CLIMinHitIdentity(), CLIFullAlignment()).ChainWorkers(worker)
+	}
 
 	return iterator.MakeIWorker(worker, false, obidefault.ParallelWorkers(), 0)
 }
+
+// CLICloseHitTable closes the hits table opened by CLIAssignTaxonomy, if any.
+// It must be called once every sequence has been processed.
+func CLICloseHitTable() {
+	if _hitTableWriter != nil {
+		if err := _hitTableWriter.Close(); err != nil {
+			log.Errorf("Cannot close the hits table: %v", err)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package obitag
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obialign"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obikmer"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+// evolve returns a copy of a sequence carrying a few random substitutions
+// and indels.
+func evolve(rng *rand.Rand, sequence []byte, events int) []byte {
+	nucs := []byte("acgt")
+	result := append([]byte{}, sequence...)
+
+	for e := 0; e < events; e++ {
+		i := rng.Intn(len(result))
+		switch rng.Intn(5) {
+		case 0:
+			result = append(result[:i], result[i+1:]...)
+		case 1:
+			result = append(result[:i], append([]byte{nucs[rng.Intn(4)]}, result[i:]...)...)
+		default:
+			result[i] = nucs[rng.Intn(4)]
+		}
+	}
+
+	return result
+}
+
+// testReferences builds clusters of related reference sequences, their
+// 4-mer tables, and queries drawn from the same clusters.
+func testReferences(rng *rand.Rand, nrefs, nqueries int) (obiseq.BioSequenceSlice,
+	obiseq.BioSequenceSlice, []*obikmer.Table4mer, *obitax.TaxonSlice) {
+
+	clusters := make([][]byte, 10)
+	for c := range clusters {
+		clusters[c] = make([]byte, 120)
+		for i := range clusters[c] {
+			clusters[c][i] = "acgt"[rng.Intn(4)]
+		}
+	}
+
+	references := obiseq.MakeBioSequenceSlice(nrefs)
+	refcounts := make([]*obikmer.Table4mer, nrefs)
+	for r := range references {
+		sequence := evolve(rng, clusters[rng.Intn(len(clusters))], rng.Intn(20))
+		references[r] = obiseq.NewBioSequence(fmt.Sprintf("ref_%d", r), sequence, "")
+		refcounts[r] = obikmer.Count4Mer(references[r], nil, nil)
+	}
+
+	queries := obiseq.MakeBioSequenceSlice(nqueries)
+	for q := range queries {
+		sequence := evolve(rng, clusters[rng.Intn(len(clusters))], rng.Intn(10))
+		queries[q] = obiseq.NewBioSequence(fmt.Sprintf("query_%d", q), sequence, "")
+	}
+
+	taxonomy := obitax.NewTaxonomy("test", "taxon", obiutils.AsciiDigitSet)
+	taxa := taxonomy.NewTaxonSlice(nrefs, nrefs)
+
+	return references, queries, refcounts, taxa
+}
+
+// scalarClosests is FindClosests computing each LCS with FastLCSScore.
+func scalarClosests(sequence *obiseq.BioSequence,
+	references obiseq.BioSequenceSlice,
+	refcounts []*obikmer.Table4mer) (int, float64, string, []int) {
+
+	var matrix []uint64
+
+	seqwords := obikmer.Count4Mer(sequence, nil, nil)
+	cw := make([]int, len(refcounts))
+	for i, ref := range refcounts {
+		cw[i] = obikmer.Common4Mer(seqwords, ref)
+	}
+
+	o := obiutils.Reverse(obiutils.IntOrder(cw), true)
+
+	bestidxs := make([]int, 0)
+	bestId := 0.0
+	bestmatch := references[o[0]].Id()
+	maxe := -1
+	wordmin := 0
+
+	for _, order := range o {
+		ref := references[order]
+		score := int(1e9)
+
+		if cw[order] < wordmin {
+			break
+		}
+
+		lcs, alilength := -1, -1
+		if maxe == 0 || maxe == 1 {
+			d, _, _, _ := obialign.D1Or0(sequence, ref)
+			if d >= 0 {
+				score = d
+				alilength = max(sequence.Len(), ref.Len())
+				lcs = alilength - score
+			}
+		} else {
+			lcs, alilength = obialign.FastLCSScore(sequence, ref, maxe, &matrix)
+			if lcs >= 0 {
+				score = alilength - lcs
+			}
+		}
+
+		if lcs >= 0 {
+			if maxe == -1 || score < maxe {
+				bestidxs = bestidxs[:0]
+				maxe = score
+				wordmin = max(0, max(sequence.Len(), ref.Len())-3-4*maxe)
+				bestId = float64(lcs) / float64(alilength)
+				bestmatch = ref.Id()
+			}
+
+			if score == maxe {
+				bestidxs = append(bestidxs, order)
+				id := float64(lcs) / float64(alilength)
+				if id > bestId {
+					bestId = id
+					bestmatch = ref.Id()
+				}
+			}
+		}
+	}
+
+	return maxe, bestId, bestmatch, bestidxs
+}
+
+// scalarHits is FindHits computing each LCS with FastLCSScore.
+func scalarHits(sequence *obiseq.BioSequence,
+	references obiseq.BioSequenceSlice,
+	refcounts []*obikmer.Table4mer,
+	maxhits int,
+	minidentity float64) []Hit {
+
+	var matrix []uint64
+
+	seqwords := obikmer.Count4Mer(sequence, nil, nil)
+	cw := make([]int, len(refcounts))
+	for i, ref := range refcounts {
+		cw[i] = obikmer.Common4Mer(seqwords, ref)
+	}
+
+	o := obiutils.Reverse(obiutils.IntOrder(cw), true)
+
+	hits := make([]Hit, 0, max(maxhits, 1))
+	less := func(a, b Hit) bool {
+		return a.Errors < b.Errors ||
+			(a.Errors == b.Errors && a.Identity > b.Identity)
+	}
+
+	for _, order := range o {
+		ref := references[order]
+		longest := max(sequence.Len(), ref.Len())
+
+		if maxhits > 0 && len(hits) == maxhits &&
+			cw[order] < sequence.Len()-3-4*hits[len(hits)-1].Errors {
+			break
+		}
+
+		maxe := -1
+		if minidentity > 0 {
+			maxe = int((1 - minidentity) * float64(sequence.Len()+ref.Len()))
+		}
+		if maxhits > 0 && len(hits) == maxhits {
+			worst := hits[len(hits)-1].Errors
+			if maxe == -1 || worst < maxe {
+				maxe = worst
+			}
+		}
+
+		if maxe >= 0 && cw[order] < longest-3-4*maxe {
+			continue
+		}
+
+		lcs, alilength := -1, -1
+		if maxe == 0 || maxe == 1 {
+			d, _, _, _ := obialign.D1Or0(sequence, ref)
+			if d >= 0 && d <= maxe {
+				alilength = longest
+				lcs = alilength - d
+			}
+		} else {
+			lcs, alilength = obialign.FastLCSScore(sequence, ref, maxe, &matrix)
+		}
+
+		if lcs < 0 {
+			continue
+		}
+
+		hit := Hit{
+			Query:     sequence.Id(),
+			Reference: ref.Id(),
+			Identity:  float64(lcs) / float64(alilength),
+			Length:    alilength,
+			Errors:    alilength - lcs,
+			index:     order,
+		}
+
+		if hit.Identity < minidentity {
+			continue
+		}
+
+		if maxhits > 0 && len(hits) == maxhits {
+			if !less(hit, hits[len(hits)-1]) {
+				continue
+			}
+			hits = hits[:len(hits)-1]
+		}
+
+		i := sort.Search(len(hits), func(i int) bool { return less(hit, hits[i]) })
+		hits = append(hits, Hit{})
+		copy(hits[i+1:], hits[i:])
+		hits[i] = hit
+	}
+
+	return hits
+}
+
+func TestFindClosests(t *testing.T) {
+	rng := rand.New(rand.NewSource(17))
+	references, queries, refcounts, _ := testReferences(rng, 500, 50)
+
+	for _, query := range queries {
+		_, maxe, bestId, bestmatch, bestidxs := FindClosests(query, references, refcounts, false)
+		emaxe, ebestId, ebestmatch, ebestidxs := scalarClosests(query, references, refcounts)
+
+		if maxe != emaxe || bestId != ebestId || bestmatch != ebestmatch ||
+			!reflect.DeepEqual(bestidxs, ebestidxs) {
+			t.Errorf("%s: FindClosests gives (%d,%f,%s,%v), expected (%d,%f,%s,%v)",
+				query.Id(), maxe, bestId, bestmatch, bestidxs,
+				emaxe, ebestId, ebestmatch, ebestidxs)
+		}
+	}
+}
+
+func TestFindHits(t *testing.T) {
+	rng := rand.New(rand.NewSource(19))
+	references, queries, refcounts, taxa := testReferences(rng, 500, 30)
+
+	for _, maxhits := range []int{0, 1, 10} {
+		for _, minidentity := range []float64{0, 0.9} {
+			for _, query := range queries {
+				hits := FindHits(query, references, refcounts, taxa, maxhits, minidentity, false)
+				expected := scalarHits(query, references, refcounts, maxhits, minidentity)
+
+				if len(hits) != len(expected) {
+					t.Fatalf("%s (%d,%.1f): %d hits, expected %d",
+						query.Id(), maxhits, minidentity, len(hits), len(expected))
+				}
+
+				for i := range hits {
+					if hits[i].index != expected[i].index || hits[i].Errors != expected[i].Errors ||
+						hits[i].Length != expected[i].Length {
+						t.Errorf("%s (%d,%.1f): hit %d is %s (%d errors), expected %s (%d errors)",
+							query.Id(), maxhits, minidentity, i,
+							hits[i].Reference, hits[i].Errors, expected[i].Reference, expected[i].Errors)
+					}
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkFindClosestsScalar(b *testing.B) {
+	references, queries, refcounts, _ := testReferences(rand.New(rand.NewSource(1)), 2000, 20)
+
+	for b.Loop() {
+		for _, query := range queries {
+			scalarClosests(query, references, refcounts)
+		}
+	}
+}
+
+func BenchmarkFindClosests(b *testing.B) {
+	references, queries, refcounts, _ := testReferences(rand.New(rand.NewSource(1)), 2000, 20)
+
+	for b.Loop() {
+		for _, query := range queries {
+			FindClosests(query, references, refcounts, false)
+		}
+	}
+}
+
+func BenchmarkFindHitsScalar(b *testing.B) {
+	references, queries, refcounts, _ := testReferences(rand.New(rand.NewSource(1)), 2000, 20)
+
+	for b.Loop() {
+		for _, query := range queries {
+			scalarHits(query, references, refcounts, 10, 0)
+		}
+	}
+}
+
+func BenchmarkFindHits(b *testing.B) {
+	references, queries, refcounts, taxa := testReferences(rand.New(rand.NewSource(1)), 2000, 20)
+
+	for b.Loop() {
+		for _, query := range queries {
+			FindHits(query, references, refcounts, taxa, 10, 0, false)
+		}
+	}
+}
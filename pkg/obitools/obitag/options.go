@@ -1,14 +1,16 @@
 package obitag
 
 import (
+	"os"
+
 	log "github.com/sirupsen/logrus"
 
-	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiformats"
-	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiphylo"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
 	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
-	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obirefidx"
 	"github.com/DavidGamba/go-getoptions"
 )
 
@@ -16,22 +18,120 @@ var _RefDB = ""
 var _SaveRefDB = ""
 var _RunExact = false
 var _GeomSim = false
+var _Method = "lcs"
+var _BayesModel = ""
+var _SaveBayesModel = ""
+var _Bootstrap = 100
+var _MinConfidence = 0.8
+var _WordSize = 8
+var _ConsensusThreshold = 1.0
+var _Alternatives = false
+var _HitTable = ""
+var _MaxHits = 10
+var _MinHitIdentity = 0.0
+var _FullAlignment = false
+var _UnmergedPairs = false
+var _ReferenceTree = ""
+var _TreeMethod = "nj"
+var _MaxTreeSize = 2000
+var _SaveTree = ""
 
 func TagOptionSet(options *getoptions.GetOpt) {
 	options.StringVar(&_RefDB, "reference-db", _RefDB,
 		options.Alias("R"),
-		options.Required(),
 		options.ArgName("FILENAME"),
-		options.Description("The name of the file containing the reference DB"))
+		options.Description("The name of the file containing the reference DB. "+
+			"It can be a sequence file or a binary reference database built by obirefidx or obitag --save-db"))
 
 	options.StringVar(&_SaveRefDB, "save-db", _SaveRefDB,
 		options.ArgName("FILENAME"),
-		options.Description("The name of a file where to save the reference DB with its indices"))
+		options.Description("The name of a file where to save the reference DB with its indices "+
+			"as a binary reference database"))
 
 	options.BoolVar(&_GeomSim, "geometric", _GeomSim,
 		options.Alias("G"),
 		options.Description("Activate the experimental geometric similarity heuristic"))
 
+	options.StringVar(&_Method, "method", _Method,
+		options.ArgName("lcs|geometric|bayes|placement"),
+		options.Description("The assignment method: nearest neighbours based on the LCS (lcs), "+
+			"the experimental geometric heuristic (geometric), a naive Bayes k-mer classifier (bayes) "+
+			"or the placement on a tree of the reference sequences (placement)"))
+
+	options.StringVar(&_ReferenceTree, "reference-tree", _ReferenceTree,
+		options.ArgName("FILENAME"),
+		options.Description("A Newick file describing the tree of the reference sequences used by "+
+			"the placement method. Its leaves must be named after the reference sequence ids, "+
+			"its branch lengths are rescaled to the LCS distances between the references. "+
+			"If not provided, the tree is built from the LCS distances between the references"))
+
+	options.StringVar(&_TreeMethod, "tree-method", _TreeMethod,
+		options.ArgName("nj|upgma"),
+		options.Description("The method used to build the reference tree: neighbour joining (nj) or UPGMA (upgma)"))
+
+	options.IntVar(&_MaxTreeSize, "max-tree-size", _MaxTreeSize,
+		options.ArgName("N"),
+		options.Description("Maximum number of reference sequences the reference tree is built for. "+
+			"Larger reference DBs require a tree provided by --reference-tree"))
+
+	options.StringVar(&_SaveTree, "save-tree", _SaveTree,
+		options.ArgName("FILENAME"),
+		options.Description("The name of a file where to save the reference tree in Newick format, "+
+			"internal nodes being named as in the obitag_placement_edge attribute"))
+
+	options.StringVar(&_BayesModel, "bayes-model", _BayesModel,
+		options.ArgName("FILENAME"),
+		options.Description("A naive Bayes model saved by --save-model, used instead of training "+
+			"a new model on the reference DB"))
+
+	options.StringVar(&_SaveBayesModel, "save-model", _SaveBayesModel,
+		options.ArgName("FILENAME"),
+		options.Description("The name of a file where to save the trained naive Bayes model"))
+
+	options.IntVar(&_Bootstrap, "bootstrap", _Bootstrap,
+		options.ArgName("N"),
+		options.Description("Number of bootstrap replicates used to estimate the confidence "+
+			"of the naive Bayes assignments"))
+
+	options.Float64Var(&_MinConfidence, "min-confidence", _MinConfidence,
+		options.ArgName("#.###"),
+		options.Description("Minimal bootstrap confidence of a naive Bayes assignment"))
+
+	options.Float64Var(&_ConsensusThreshold, "consensus-threshold", _ConsensusThreshold,
+		options.ArgName("#.###"),
+		options.Description("Assign the sequence to the deepest taxon supported by at least this "+
+			"fraction of the best matches. The default value 1.0 corresponds to the strict LCA"))
+
+	options.BoolVar(&_Alternatives, "alternatives", _Alternatives,
+		options.Description("Annotate the taxa of the best matches, their distribution at each "+
+			"canonical rank and the per rank agreement of the best matches with the assignment"))
+
+	options.StringVar(&_HitTable, "hits-table", _HitTable,
+		options.ArgName("FILENAME"),
+		options.Description("Write the reference hits of each query to a tab separated table "+
+			"in the style of the BLAST tabular output (lcs method only)"))
+
+	options.IntVar(&_MaxHits, "max-hits", _MaxHits,
+		options.ArgName("N"),
+		options.Description("Maximum number of hits reported per query in the hits table, 0 for no limit"))
+
+	options.Float64Var(&_MinHitIdentity, "min-hit-identity", _MinHitIdentity,
+		options.ArgName("#.###"),
+		options.Description("Minimal identity of the hits reported in the hits table"))
+
+	options.BoolVar(&_FullAlignment, "full-alignment", _FullAlignment,
+		options.Description("Compute the full alignment of each reported hit to fill the mismatch, "+
+			"gap and position columns of the hits table"))
+
+	options.BoolVar(&_UnmergedPairs, "unmerged-pairs", _UnmergedPairs,
+		options.Description("Identify separately both reads of the pairs that obipairing failed to "+
+			"merge (mode=join), combining their end gap free alignment scores. "+
+			"Implied by the --paired-with option"))
+
+	options.IntVar(&_WordSize, "word-size", _WordSize,
+		options.ArgName("K"),
+		options.Description("Size of the words used by the naive Bayes classifier"))
+
 	// options.BoolVar(&_RunExact, "exact", _RunExact,
 	// 	options.Alias("E"),
 	// 	options.Description("Desactivate the heuristic limitating the sequence comparisons"))
@@ -41,7 +141,7 @@ func TagOptionSet(options *getoptions.GetOpt) {
 // OptionSet adds to the basic option set every options declared for
 // the obiuniq command
 func OptionSet(options *getoptions.GetOpt) {
-	obiconvert.OptionSet(false)(options)
+	obiconvert.OptionSet(true)(options)
 	TagOptionSet(options)
 }
 
@@ -61,58 +161,211 @@ func CLIRefDB() obiseq.BioSequenceSlice {
 	return db
 }
 
+// CLIHasBinaryRefDB returns true if the reference DB is a binary
+// reference database.
+func CLIHasBinaryRefDB() bool {
+	return obirefidx.IsReferenceDBFile(_RefDB)
+}
+
+// CLIReferenceDB loads the reference DB, either from a binary reference
+// database or from a sequence file. In the latter case, the 4-mer tables
+// and the taxa of the reference sequences are computed.
+func CLIReferenceDB(taxonomy *obitax.Taxonomy) *obirefidx.ReferenceDB {
+	if CLIHasBinaryRefDB() {
+		db, err := obirefidx.OpenReferenceDB(_RefDB, taxonomy)
+
+		if err != nil {
+			log.Fatalf("Cannot load the reference database: %v", err)
+		}
+
+		return db
+	}
+
+	return obirefidx.MakeReferenceDB(CLIRefDB(), taxonomy)
+}
+
 func CLIGeometricMode() bool {
-	return _GeomSim
+	return _GeomSim || _Method == "geometric"
 }
 
-func CLIShouldISaveRefDB() bool {
-	return _SaveRefDB != ""
+// CLIMethod returns the assignment method requested by the --method option.
+func CLIMethod() string {
+	if _GeomSim {
+		return "geometric"
+	}
+
+	switch _Method {
+	case "lcs", "geometric", "bayes", "placement":
+	default:
+		log.Fatalf("Unknown assignment method %s (lcs, geometric, bayes or placement)", _Method)
+	}
+
+	return _Method
 }
 
-func CLISaveRefetenceDB(db obiseq.BioSequenceSlice) {
-	if CLIShouldISaveRefDB() {
-		idb := obiiter.IBatchOver("", db, 1000)
+func CLIBayesMode() bool {
+	return CLIMethod() == "bayes"
+}
 
-		var newIter obiiter.IBioSequence
+func CLIHasRefDB() bool {
+	return _RefDB != ""
+}
 
-		opts := make([]obiformats.WithOption, 0, 10)
+func CLIHasBayesModel() bool {
+	return _BayesModel != ""
+}
 
-		switch obiconvert.CLIOutputFastHeaderFormat() {
-		case "json":
-			opts = append(opts, obiformats.OptionsFastSeqHeaderFormat(obiformats.FormatFastSeqJsonHeader))
-		case "obi":
-			opts = append(opts, obiformats.OptionsFastSeqHeaderFormat(obiformats.FormatFastSeqOBIHeader))
-		default:
-			opts = append(opts, obiformats.OptionsFastSeqHeaderFormat(obiformats.FormatFastSeqJsonHeader))
-		}
+func CLIBootstrap() int {
+	return max(1, _Bootstrap)
+}
 
-		nworkers := obidefault.ParallelWorkers() / 4
-		if nworkers < 2 {
-			nworkers = 2
+func CLIMinConfidence() float64 {
+	return _MinConfidence
+}
+
+func CLIConsensusThreshold() float64 {
+	if _ConsensusThreshold <= 0 || _ConsensusThreshold > 1 {
+		log.Fatalf("The consensus threshold must be in ]0,1] (%f)", _ConsensusThreshold)
+	}
+
+	return _ConsensusThreshold
+}
+
+func CLIWithAlternatives() bool {
+	return _Alternatives
+}
+
+// CLIHasHitTable returns true if a hits table has been requested.
+func CLIHasHitTable() bool {
+	return _HitTable != ""
+}
+
+func CLIHitTableName() string {
+	return _HitTable
+}
+
+func CLIMaxHits() int {
+	return max(0, _MaxHits)
+}
+
+func CLIMinHitIdentity() float64 {
+	return _MinHitIdentity
+}
+
+func CLIFullAlignment() bool {
+	return _FullAlignment
+}
+
+// CLIUnmergedPairsMode returns true if the reads of unmerged pairs must
+// be identified separately.
+func CLIUnmergedPairsMode() bool {
+	return _UnmergedPairs || obiconvert.CLIHasPairedFile()
+}
+
+func CLIWordSize() int {
+	return _WordSize
+}
+
+// CLIBayesModel returns the naive Bayes model, either loaded from the file
+// given by --bayes-model or trained on the reference DB. The model is saved
+// if --save-model is set.
+func CLIBayesModel(references *obirefidx.ReferenceDB, taxonomy *obitax.Taxonomy) *BayesModel {
+	var model *BayesModel
+	var err error
+
+	if CLIHasBayesModel() {
+		model, err = LoadBayesModel(_BayesModel, taxonomy)
+	} else {
+		model, err = TrainBayesModel(references, CLIWordSize())
+	}
+
+	if err != nil {
+		log.Fatalf("Cannot set up the naive Bayes classifier: %v", err)
+	}
+
+	if _SaveBayesModel != "" {
+		if err := SaveBayesModel(model, _SaveBayesModel); err != nil {
+			log.Fatalf("Cannot save the naive Bayes model: %v", err)
 		}
+	}
 
-		opts = append(opts, obiformats.OptionsParallelWorkers(nworkers))
-		opts = append(opts, obiformats.OptionsBatchSize(obidefault.BatchSize()))
+	return model
+}
+
+// CLITreeMethod returns the method used to build the reference tree.
+func CLITreeMethod() string {
+	switch _TreeMethod {
+	case "nj", "upgma":
+	default:
+		log.Fatalf("Unknown tree building method %s (nj or upgma)", _TreeMethod)
+	}
+
+	return _TreeMethod
+}
+
+// CLIMaxTreeSize returns the maximum number of reference sequences the
+// reference tree is built for.
+func CLIMaxTreeSize() int {
+	return _MaxTreeSize
+}
 
-		opts = append(opts, obiformats.OptionsCompressed(obidefault.CompressOutput()))
+// CLIReferenceTree returns the reference tree used by the placement method,
+// either read from the file given by --reference-tree or built from the
+// reference DB. The tree is saved if --save-tree is set.
+func CLIReferenceTree(references *obirefidx.ReferenceDB) *ReferenceTree {
+	var root *obiphylo.PhyloNode
 
+	if _ReferenceTree != "" {
 		var err error
+		root, err = obiphylo.ReadNewick(_ReferenceTree)
 
-		switch obiconvert.CLIOutputFormat() {
-		case "fastq":
-			newIter, err = obiformats.WriteFastqToFile(idb, _SaveRefDB, opts...)
-		case "fasta":
-			newIter, err = obiformats.WriteFastaToFile(idb, _SaveRefDB, opts...)
-		default:
-			newIter, err = obiformats.WriteSequencesToFile(idb, _SaveRefDB, opts...)
+		if err != nil {
+			log.Fatalf("Cannot read the reference tree: %v", err)
 		}
+	} else {
+		var err error
+		root, err = BuildReferenceTree(references, CLITreeMethod(), CLIMaxTreeSize())
 
 		if err != nil {
-			log.Fatalf("Write file error: %v", err)
+			log.Fatalf("Cannot build the reference tree: %v. Provide a tree with --reference-tree "+
+				"or raise --max-tree-size", err)
+		}
+	}
+
+	tree, err := NewReferenceTree(root, references)
+
+	if err != nil {
+		log.Fatalf("Cannot set up the reference tree: %v", err)
+	}
+
+	if _ReferenceTree != "" {
+		scale, err := tree.FitDistanceScale()
+
+		if err != nil {
+			log.Fatalf("Cannot use the reference tree %s: %v", _ReferenceTree, err)
+		}
+
+		log.Infof("Branch lengths of the reference tree are %.4g times the LCS distances", scale)
+	}
+
+	if _SaveTree != "" {
+		if err := os.WriteFile(_SaveTree, []byte(tree.Root.Newick(0)), 0o644); err != nil {
+			log.Fatalf("Cannot save the reference tree: %v", err)
 		}
+	}
 
-		newIter.Recycle()
-		obiutils.WaitForLastPipe()
+	return tree
+}
+
+func CLIShouldISaveRefDB() bool {
+	return _SaveRefDB != ""
+}
+
+func CLISaveRefetenceDB(db *obirefidx.ReferenceDB) {
+	if CLIShouldISaveRefDB() {
+		if err := obirefidx.WriteReferenceDB(db, _SaveRefDB); err != nil {
+			log.Fatalf("Write file error: %v", err)
+		}
 	}
 }
 
@@ -0,0 +1,289 @@
+package obitag
+
+import (
+	"bytes"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obialign"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obikmer"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+// The separator inserted by obipairing between two reads that do not overlap.
+var _JoinSeparator = []byte("..........")
+
+// SplitJoinedPair recovers the forward and reverse reads of a sequence built
+// by obipairing from a pair of non-overlapping reads. Both reads are in the
+// orientation of the joined sequence.
+//
+// Parameters:
+//   - sequence: The joined sequence.
+//
+// Returns:
+//   - The forward read.
+//   - The reverse read.
+//   - A boolean value indicating whether the sequence is a joined pair.
+func SplitJoinedPair(sequence *obiseq.BioSequence) (*obiseq.BioSequence, *obiseq.BioSequence, bool) {
+	if mode, ok := sequence.GetStringAttribute("mode"); !ok || mode != "join" {
+		return nil, nil, false
+	}
+
+	raw := sequence.Sequence()
+	start := bytes.Index(raw, _JoinSeparator)
+
+	if start < 0 {
+		return nil, nil, false
+	}
+
+	end := start
+	for end < len(raw) && raw[end] == '.' {
+		end++
+	}
+
+	if start == 0 || end == len(raw) {
+		return nil, nil, false
+	}
+
+	forward := obiseq.NewBioSequence(sequence.Id()+"_F", raw[:start], "")
+	reverse := obiseq.NewBioSequence(sequence.Id()+"_R", raw[end:], "")
+
+	return forward, reverse, true
+}
+
+// PairedFindClosests finds the reference sequences closest to a pair of
+// reads that do not overlap. The forward read is expected at the beginning
+// of the reference and the reverse read at its end: the reference is cut in
+// two parts in proportion of the read lengths, and each read is aligned on
+// its part with an end gap free LCS alignment. A pair hitting a reference
+// in the wrong order or orientation therefore accumulates differences. The
+// differences between the pair and a reference are the positions of both
+// alignments left out of the LCS, the identity is the LCS divided by the
+// length of the alignments. Both reads must be in the orientation of the
+// reference sequences.
+//
+// As for FindClosests, the references are examined by decreasing number of
+// shared 4-mers, and the alignments are banded by the differences of the
+// best match found so far.
+//
+// Parameters:
+//   - forward: The forward read.
+//   - reverse: The reverse read, reverse complemented.
+//   - references: A slice of reference sequences to compare against.
+//   - refcounts: A slice of reference sequence counts.
+//
+// Returns:
+//   - bests: a slice of the closest bio sequences.
+//   - maxe: the number of differences of the best matches.
+//   - bestId: the best identity.
+//   - bestmatch: the id of the best match.
+//   - bestidxs: a slice of the best indexes.
+func PairedFindClosests(forward, reverse *obiseq.BioSequence,
+	references obiseq.BioSequenceSlice,
+	refcounts []*obikmer.Table4mer) (obiseq.BioSequenceSlice, int, float64, string, []int) {
+
+	if forward.Len() < 5 || reverse.Len() < 5 {
+		return obiseq.BioSequenceSlice{}, 1000, 0, "NA", []int{}
+	}
+
+	var matrix []uint64
+
+	fwords := obikmer.Count4Mer(forward, nil, nil)
+	rwords := obikmer.Count4Mer(reverse, nil, nil)
+	fw := make([]int, len(refcounts))
+	rw := make([]int, len(refcounts))
+	cw := make([]int, len(refcounts))
+
+	for i, ref := range refcounts {
+		fw[i] = obikmer.Common4Mer(fwords, ref)
+		rw[i] = obikmer.Common4Mer(rwords, ref)
+		cw[i] = fw[i] + rw[i]
+	}
+
+	o := obiutils.Reverse(obiutils.IntOrder(cw), true)
+
+	bests := obiseq.MakeBioSequenceSlice()
+	bestidxs := make([]int, 0)
+	bestId := 0.0
+	bestmatch := references[o[0]].Id()
+
+	readlength := forward.Len() + reverse.Len()
+
+	// Each read shares at most its length minus 3 4-mers with a reference
+	maxwords := readlength - 6
+
+	// A difference removes at most 4 of the 4-mers shared by a read
+	minerrors := func(read *obiseq.BioSequence, words int) int {
+		return (max(0, read.Len()-3-words) + 3) / 4
+	}
+
+	// The differences of a read are the positions of its alignment that
+	// are not in the LCS. The read bases outside of the reference part
+	// are counted as differences. Alignments beyond the bound are not
+	// computed exactly, they are reported with a length of -1.
+	align := func(read, ref []byte, bound int) (int, int) {
+		lcs, length, _ := obialign.FastLCSEGFScoreByte(ref, read, bound, true, &matrix)
+		length = max(length, len(read))
+		if lcs < 0 || (bound >= 0 && length-lcs > bound) {
+			return -1, -1
+		}
+		return lcs, length
+	}
+
+	maxe := -1
+	wordmin := 0
+
+	for _, order := range o {
+		if cw[order] < wordmin {
+			break
+		}
+
+		fmin := minerrors(forward, fw[order])
+		rmin := minerrors(reverse, rw[order])
+
+		if maxe >= 0 && fmin+rmin > maxe {
+			continue
+		}
+
+		ref := references[order].Sequence()
+		cut := len(ref) * forward.Len() / readlength
+
+		fbound, rbound := -1, -1
+		if maxe >= 0 {
+			fbound = maxe - rmin
+		}
+
+		flcs, flength := align(forward.Sequence(), ref[:cut], fbound)
+		if flcs < 0 {
+			continue
+		}
+
+		if maxe >= 0 {
+			rbound = maxe - (flength - flcs)
+		}
+
+		rlcs, rlength := align(reverse.Sequence(), ref[cut:], rbound)
+		if rlcs < 0 {
+			continue
+		}
+
+		lcs := flcs + rlcs
+		length := flength + rlength
+		score := length - lcs
+
+		if maxe == -1 || score < maxe {
+			bests = bests[:0]
+			bestidxs = bestidxs[:0]
+
+			maxe = score
+			wordmin = max(0, maxwords-4*maxe)
+			bestId = float64(lcs) / float64(length)
+			bestmatch = references[order].Id()
+		}
+
+		if score == maxe {
+			bests = append(bests, references[order])
+			bestidxs = append(bestidxs, order)
+		}
+	}
+
+	if len(bests) == 0 {
+		return obiseq.BioSequenceSlice{}, 1000, 0, "NA", []int{}
+	}
+
+	log.Debugln("Closest Paired Match", forward.Id(), maxe, bestId, bestmatch, bestidxs, len(bests))
+	return bests, maxe, bestId, bestmatch, bestidxs
+}
+
+// pairedDifferences extrapolates the differences between a pair of reads
+// and its best matches to the complete references, as the reference indices
+// are computed from complete sequences. The differences are scaled by the
+// length of the longest best match over the length of the reads.
+func pairedDifferences(differences, readlength int, bests obiseq.BioSequenceSlice) int {
+	longest := 0
+	for _, best := range bests {
+		longest = max(longest, best.Len())
+	}
+
+	if differences <= 0 || longest <= readlength {
+		return differences
+	}
+
+	return (differences*longest + readlength - 1) / readlength
+}
+
+// PairedIdentify makes the taxonomic identification of a pair of reads that
+// do not overlap. The differences between the pair and a reference are the
+// sum of the differences of both reads, extrapolated to the complete
+// reference. The identification then follows the same rules as Identify.
+//
+// Parameters:
+//   - sequence: The sequence to annotate with the identification.
+//   - forward: The forward read.
+//   - reverse: The reverse read, reverse complemented.
+//   - references: A BioSequenceSlice.
+//   - refcounts: A slice of pointers to Table4mer.
+//   - taxa: A TaxonSet.
+//   - taxo: A pointer to a Taxonomy.
+//   - consensus: The fraction of best matches supporting the assignment, 1.0 for a strict LCA.
+//   - alternatives: A boolean value indicating whether to annotate the alternative assignments.
+//
+// Returns:
+//   - The annotated sequence.
+func PairedIdentify(sequence, forward, reverse *obiseq.BioSequence,
+	references obiseq.BioSequenceSlice,
+	refcounts []*obikmer.Table4mer,
+	taxa *obitax.TaxonSlice,
+	taxo *obitax.Taxonomy,
+	consensus float64,
+	alternatives bool) *obiseq.BioSequence {
+
+	bests, differences, identity, bestmatch, seqidxs := PairedFindClosests(forward, reverse, references, refcounts)
+	differences = pairedDifferences(differences, forward.Len()+reverse.Len(), bests)
+
+	sequence = assignClosests(sequence, bests, differences, identity, bestmatch, seqidxs,
+		references, refcounts, taxa, taxo, consensus, alternatives)
+
+	sequence.SetAttribute("obitag_similarity_method", "lcs_paired")
+
+	return sequence
+}
+
+// PairedIdentifySeqWorker returns a worker identifying the pairs of reads
+// that do not overlap. A pair is either a sequence paired with its mate, or
+// a sequence joined by obipairing. Other sequences are identified by
+// Identify.
+func PairedIdentifySeqWorker(references obiseq.BioSequenceSlice,
+	refcounts []*obikmer.Table4mer,
+	taxa *obitax.TaxonSlice,
+	taxo *obitax.Taxonomy,
+	runExact bool,
+	consensus float64,
+	alternatives bool) obiseq.SeqWorker {
+	return func(sequence *obiseq.BioSequence) (obiseq.BioSequenceSlice, error) {
+		if sequence.IsPaired() {
+			mate := sequence.PairedWith()
+			PairedIdentify(sequence, sequence, mate.ReverseComplement(false),
+				references, refcounts, taxa, taxo, consensus, alternatives)
+
+			for key, value := range sequence.Annotations() {
+				if key == "taxid" || strings.HasPrefix(key, "obitag_") {
+					mate.SetAttribute(key, value)
+				}
+			}
+
+			return obiseq.BioSequenceSlice{sequence}, nil
+		}
+
+		if forward, reverse, ok := SplitJoinedPair(sequence); ok {
+			return obiseq.BioSequenceSlice{PairedIdentify(sequence, forward, reverse,
+				references, refcounts, taxa, taxo, consensus, alternatives)}, nil
+		}
+
+		return obiseq.BioSequenceSlice{Identify(sequence, references, refcounts, taxa, taxo,
+			runExact, consensus, alternatives)}, nil
+	}
+}
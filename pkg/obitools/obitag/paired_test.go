@@ -0,0 +1,176 @@
+package obitag
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obialign"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obikmer"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+// testPairs builds pairs of non-overlapping reads from the beginning and
+// the end of evolved copies of the references.
+func testPairs(rng *rand.Rand, references obiseq.BioSequenceSlice, npairs int) ([]*obiseq.BioSequence, []*obiseq.BioSequence) {
+	forwards := make([]*obiseq.BioSequence, npairs)
+	reverses := make([]*obiseq.BioSequence, npairs)
+
+	for p := range forwards {
+		sequence := evolve(rng, references[rng.Intn(len(references))].Sequence(), rng.Intn(8))
+		length := 30 + rng.Intn(15)
+		forwards[p] = obiseq.NewBioSequence(fmt.Sprintf("pair_%d_F", p), sequence[:length], "")
+		reverses[p] = obiseq.NewBioSequence(fmt.Sprintf("pair_%d_R", p), sequence[len(sequence)-length:], "")
+	}
+
+	return forwards, reverses
+}
+
+// scalarPairedClosests is PairedFindClosests computing each alignment
+// without band.
+func scalarPairedClosests(forward, reverse *obiseq.BioSequence,
+	references obiseq.BioSequenceSlice,
+	refcounts []*obikmer.Table4mer) (int, float64, string, []int) {
+
+	var matrix []uint64
+
+	fwords := obikmer.Count4Mer(forward, nil, nil)
+	rwords := obikmer.Count4Mer(reverse, nil, nil)
+	cw := make([]int, len(refcounts))
+	for i, ref := range refcounts {
+		cw[i] = obikmer.Common4Mer(fwords, ref) + obikmer.Common4Mer(rwords, ref)
+	}
+
+	o := obiutils.Reverse(obiutils.IntOrder(cw), true)
+
+	bestidxs := make([]int, 0)
+	bestId := 0.0
+	bestmatch := references[o[0]].Id()
+	readlength := forward.Len() + reverse.Len()
+	maxe := -1
+	wordmin := 0
+
+	for _, order := range o {
+		if cw[order] < wordmin {
+			break
+		}
+
+		ref := references[order].Sequence()
+		cut := len(ref) * forward.Len() / readlength
+
+		flcs, flength, _ := obialign.FastLCSEGFScoreByte(ref[:cut], forward.Sequence(), -1, true, &matrix)
+		rlcs, rlength, _ := obialign.FastLCSEGFScoreByte(ref[cut:], reverse.Sequence(), -1, true, &matrix)
+
+		lcs := flcs + rlcs
+		length := max(flength, forward.Len()) + max(rlength, reverse.Len())
+		score := length - lcs
+
+		if maxe == -1 || score < maxe {
+			bestidxs = bestidxs[:0]
+			maxe = score
+			wordmin = max(0, readlength-6-4*maxe)
+			bestId = float64(lcs) / float64(length)
+			bestmatch = references[order].Id()
+		}
+
+		if score == maxe {
+			bestidxs = append(bestidxs, order)
+		}
+	}
+
+	return maxe, bestId, bestmatch, bestidxs
+}
+
+func TestSplitJoinedPair(t *testing.T) {
+	tests := []struct {
+		sequence string
+		mode     string
+		forward  string
+		reverse  string
+		ok       bool
+	}{
+		{"acgtacgt..........ttgcaat", "join", "acgtacgt", "ttgcaat", true},
+		{"acgtacgt..........ttgcaat", "alignment", "", "", false},
+		{"acgtacgtttgcaat", "join", "", "", false},
+		{"..........ttgcaat", "join", "", "", false},
+		{"acgtacgt..........", "join", "", "", false},
+	}
+
+	for _, test := range tests {
+		sequence := obiseq.NewBioSequence("pair", []byte(test.sequence), "")
+		sequence.SetAttribute("mode", test.mode)
+
+		forward, reverse, ok := SplitJoinedPair(sequence)
+		if ok != test.ok {
+			t.Errorf("%s (%s): split is %v, expected %v", test.sequence, test.mode, ok, test.ok)
+			continue
+		}
+
+		if ok && (string(forward.Sequence()) != test.forward || string(reverse.Sequence()) != test.reverse) {
+			t.Errorf("%s: split in %s and %s, expected %s and %s",
+				test.sequence, forward.Sequence(), reverse.Sequence(), test.forward, test.reverse)
+		}
+	}
+}
+
+func TestPairedFindClosests(t *testing.T) {
+	rng := rand.New(rand.NewSource(29))
+	references, _, refcounts, _ := testReferences(rng, 500, 0)
+	forwards, reverses := testPairs(rng, references, 50)
+
+	for p, forward := range forwards {
+		_, maxe, bestId, bestmatch, bestidxs := PairedFindClosests(forward, reverses[p], references, refcounts)
+		emaxe, ebestId, ebestmatch, ebestidxs := scalarPairedClosests(forward, reverses[p], references, refcounts)
+
+		if maxe != emaxe || bestId != ebestId || bestmatch != ebestmatch ||
+			!reflect.DeepEqual(bestidxs, ebestidxs) {
+			t.Errorf("%s: PairedFindClosests gives (%d,%f,%s,%v), expected (%d,%f,%s,%v)",
+				forward.Id(), maxe, bestId, bestmatch, bestidxs,
+				emaxe, ebestId, ebestmatch, ebestidxs)
+		}
+	}
+}
+
+// The reads must hit the reference in the expected order.
+func TestPairedFindClosestsOrder(t *testing.T) {
+	rng := rand.New(rand.NewSource(31))
+	references, _, refcounts, _ := testReferences(rng, 1, 0)
+
+	reference := references[0].Sequence()
+	forward := obiseq.NewBioSequence("forward", reference[:40], "")
+	reverse := obiseq.NewBioSequence("reverse", reference[len(reference)-40:], "")
+
+	if _, maxe, _, _, _ := PairedFindClosests(forward, reverse, references, refcounts); maxe != 0 {
+		t.Errorf("pair in the expected order has %d differences", maxe)
+	}
+
+	if _, maxe, _, _, _ := PairedFindClosests(reverse, forward, references, refcounts); maxe < 20 {
+		t.Errorf("pair in the reverse order has only %d differences", maxe)
+	}
+}
+
+func TestPairedDifferences(t *testing.T) {
+	bests := obiseq.BioSequenceSlice{
+		obiseq.NewBioSequence("short", make([]byte, 100), ""),
+		obiseq.NewBioSequence("long", make([]byte, 120), ""),
+	}
+
+	tests := []struct {
+		differences, readlength, expected int
+	}{
+		{0, 60, 0},
+		{3, 60, 6},
+		{5, 60, 10},
+		{1, 50, 3},
+		{2, 150, 2},
+	}
+
+	for _, test := range tests {
+		if d := pairedDifferences(test.differences, test.readlength, bests); d != test.expected {
+			t.Errorf("%d differences on %d nucleotides are extrapolated to %d, expected %d",
+				test.differences, test.readlength, d, test.expected)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package obitag
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obialign"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiphylo"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obirefidx"
+)
+
+// ReferenceTree is a phylogenetic tree whose leaves are reference
+// sequences. It is used to place query sequences on the tree and to assign
+// them to the lowest common ancestor of the taxa of the clade they are
+// placed in.
+type ReferenceTree struct {
+	Root      *obiphylo.PhyloNode
+	sequences obiseq.BioSequenceSlice
+	leaves    []*obiphylo.PhyloNode
+	parents   map[*obiphylo.PhyloNode]*obiphylo.PhyloNode
+	depths    map[*obiphylo.PhyloNode]int
+	taxa      map[*obiphylo.PhyloNode]*obitax.Taxon
+	sizes     map[*obiphylo.PhyloNode]int
+	heights   map[*obiphylo.PhyloNode]float64
+	taxonomy  *obitax.Taxonomy
+	scale     float64
+}
+
+// _DistanceScalePairs is the number of pairs of references used to relate
+// the branch lengths of a reference tree to the LCS distances.
+const _DistanceScalePairs = 1000
+
+// BuildReferenceTree builds a tree of the reference sequences from their
+// LCS distances. The distance matrix grows with the square of the number of
+// references and the tree building with its cube, so the tree is only built
+// for databases of at most maxReferences sequences.
+//
+// Parameters:
+//   - references: The reference database.
+//   - method: The tree building method, "nj" for neighbour joining or
+//     "upgma".
+//   - maxReferences: The largest number of references a tree is built for.
+//
+// Returns:
+//   - The root of the tree, whose leaves are named after the reference
+//     sequences.
+//   - An error if the database has more than maxReferences sequences.
+func BuildReferenceTree(references *obirefidx.ReferenceDB, method string, maxReferences int) (*obiphylo.PhyloNode, error) {
+	if references.Len() > maxReferences {
+		return nil, fmt.Errorf("%d reference sequences exceed the limit of %d sequences for building a tree",
+			references.Len(), maxReferences)
+	}
+
+	log.Infof("Computing the distances between the %d reference sequences", references.Len())
+	dm := obialign.LCSDistanceMatrix(references.Sequences)
+
+	if method == "upgma" {
+		return obiphylo.UPGMA(dm), nil
+	}
+
+	return obiphylo.NeighborJoining(dm), nil
+}
+
+// NewReferenceTree associates the leaves of a tree with the sequences of a
+// reference database having the same identifiers. Leaves without reference
+// sequence are ignored, as are the reference sequences absent from the tree.
+// The unnamed internal nodes are named node1, node2, ... to identify the
+// placement edges. The branch lengths are assumed to be LCS distances, as
+// for a tree built by BuildReferenceTree, see FitDistanceScale otherwise.
+//
+// Parameters:
+//   - root: The root of the tree.
+//   - references: The reference database.
+//
+// Returns:
+//   - A pointer to the new ReferenceTree.
+//   - An error if no leaf corresponds to a reference sequence.
+func NewReferenceTree(root *obiphylo.PhyloNode, references *obirefidx.ReferenceDB) (*ReferenceTree, error) {
+	if root == nil {
+		return nil, fmt.Errorf("empty reference tree")
+	}
+
+	root.NameInternalNodes("node")
+
+	byid := make(map[string][]int, references.Len())
+	for i, sequence := range references.Sequences {
+		byid[sequence.Id()] = append(byid[sequence.Id()], i)
+	}
+
+	tree := &ReferenceTree{
+		Root:     root,
+		parents:  root.Parents(),
+		depths:   make(map[*obiphylo.PhyloNode]int),
+		taxa:     make(map[*obiphylo.PhyloNode]*obitax.Taxon),
+		sizes:    make(map[*obiphylo.PhyloNode]int),
+		heights:  make(map[*obiphylo.PhyloNode]float64),
+		taxonomy: references.Taxonomy,
+		scale:    1,
+	}
+
+	unknown := 0
+	for _, leaf := range root.Leaves() {
+		indices := byid[leaf.Name]
+		if len(indices) == 0 {
+			unknown++
+			continue
+		}
+
+		i := indices[0]
+		byid[leaf.Name] = indices[1:]
+
+		tree.sequences = append(tree.sequences, references.Sequences[i])
+		tree.leaves = append(tree.leaves, leaf)
+		tree.taxa[leaf] = references.Taxa.Taxon(i)
+		tree.sizes[leaf] = 1
+	}
+
+	if len(tree.leaves) == 0 {
+		return nil, fmt.Errorf("no leaf of the tree corresponds to a reference sequence")
+	}
+
+	if unknown > 0 {
+		log.Warnf("%d leaves of the reference tree are not reference sequences", unknown)
+	}
+
+	if missing := references.Len() - len(tree.leaves); missing > 0 {
+		log.Warnf("%d reference sequences are not in the reference tree", missing)
+	}
+
+	var annotate func(node *obiphylo.PhyloNode, depth int, height float64)
+	annotate = func(node *obiphylo.PhyloNode, depth int, height float64) {
+		tree.depths[node] = depth
+		tree.heights[node] = height
+
+		for child := range node.Children {
+			annotate(child, depth+1, height+tree.branchLength(child))
+
+			if tree.sizes[child] > 0 {
+				tree.taxa[node], _ = tree.taxa[node].LCA(tree.taxa[child])
+				tree.sizes[node] += tree.sizes[child]
+			}
+		}
+	}
+
+	annotate(root, 0, 0)
+
+	log.Infof("Reference tree with %d reference sequences", len(tree.leaves))
+
+	return tree, nil
+}
+
+// branchLength returns the length of the branch above a node, missing
+// lengths being considered as null.
+func (tree *ReferenceTree) branchLength(node *obiphylo.PhyloNode) float64 {
+	parent, ok := tree.parents[node]
+	if !ok {
+		return 0
+	}
+
+	length := parent.GetDistanceToChild(node)
+	if math.IsNaN(length) || length < 0 {
+		return 0
+	}
+
+	return length
+}
+
+// FitDistanceScale relates the branch lengths of the tree to the LCS
+// distances between its reference sequences, when the tree has not been
+// built from them. The scale is the ratio of the sums of the distances
+// along the tree and of the LCS distances, over pairs of references drawn
+// at random. The placement radius is then expressed in branch length units.
+//
+// Returns:
+//   - The number of branch length units per unit of LCS distance.
+//   - An error if the branch lengths cannot be related to the LCS distances.
+func (tree *ReferenceTree) FitDistanceScale() (float64, error) {
+	n := len(tree.leaves)
+	if n < 2 {
+		return 0, fmt.Errorf("at least two reference sequences are needed")
+	}
+
+	// The leaves are sorted to draw the same pairs whatever the tree layout
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return strings.Compare(tree.sequences[a].Id(), tree.sequences[b].Id())
+	})
+
+	rng := rand.New(rand.NewSource(1))
+	var matrix []uint64
+	patristic, lcsdist := 0.0, 0.0
+
+	for p := 0; p < _DistanceScalePairs; p++ {
+		k := rng.Intn(n)
+		i, j := order[k], order[(k+1+rng.Intn(n-1))%n]
+
+		a, b := tree.leaves[i], tree.leaves[j]
+		lcs, length := obialign.FastLCSScore(tree.sequences[i], tree.sequences[j], -1, &matrix)
+		if length > 0 {
+			lcsdist += 1 - float64(lcs)/float64(length)
+		}
+		patristic += tree.heights[a] + tree.heights[b] - 2*tree.heights[tree.lca(a, b)]
+	}
+
+	if patristic <= 0 || lcsdist <= 0 {
+		return 0, fmt.Errorf("the branch lengths of the tree cannot be related to the LCS distances")
+	}
+
+	tree.scale = patristic / lcsdist
+
+	return tree.scale, nil
+}
+
+// lca returns the lowest common ancestor of two nodes of the tree.
+func (tree *ReferenceTree) lca(a, b *obiphylo.PhyloNode) *obiphylo.PhyloNode {
+	for tree.depths[a] > tree.depths[b] {
+		a = tree.parents[a]
+	}
+	for tree.depths[b] > tree.depths[a] {
+		b = tree.parents[b]
+	}
+	for a != b {
+		a, b = tree.parents[a], tree.parents[b]
+	}
+	return a
+}
+
+// neighbours returns the leaves whose distance along the tree to a leaf is
+// at most a given distance.
+func (tree *ReferenceTree) neighbours(leaf *obiphylo.PhyloNode, radius float64) []*obiphylo.PhyloNode {
+	neighbours := make([]*obiphylo.PhyloNode, 0)
+
+	var walk func(node, from *obiphylo.PhyloNode, distance float64)
+	walk = func(node, from *obiphylo.PhyloNode, distance float64) {
+		if distance > radius {
+			return
+		}
+
+		if tree.sizes[node] == 1 && node.IsLeaf() {
+			neighbours = append(neighbours, node)
+		}
+
+		if parent, ok := tree.parents[node]; ok && parent != from {
+			walk(parent, node, distance+tree.branchLength(node))
+		}
+
+		for child := range node.Children {
+			if child != from {
+				walk(child, node, distance+tree.branchLength(child))
+			}
+		}
+	}
+
+	walk(leaf, nil, 0)
+
+	return neighbours
+}
+
+// Placement describes the position of a query sequence on a reference tree.
+type Placement struct {
+	// Clade is the node below the placement edge.
+	Clade *obiphylo.PhyloNode
+	// Taxon is the lowest common ancestor of the taxa of the clade.
+	Taxon *obitax.Taxon
+	// Leaves is the number of reference sequences of the clade.
+	Leaves int
+	// Distance is the LCS distance of the query to its closest references.
+	Distance float64
+	// Bests are the closest reference sequences.
+	Bests obiseq.BioSequenceSlice
+}
+
+// Place places a query sequence on the reference tree. The closest
+// reference sequences are identified by their LCS distance to the query.
+// The query is placed on the branch above the smallest clade including
+// these references and every reference closer to one of them along the
+// tree than the query is. The query is thus placed deeper in the tree as
+// it is more similar to the references. The LCS distance of the query is
+// converted to branch length units by the scale of the tree.
+//
+// Parameters:
+//   - sequence: The query sequence.
+//   - buffer: A buffer reused between calls, it can be nil.
+//
+// Returns:
+//   - The placement of the sequence.
+func (tree *ReferenceTree) Place(sequence *obiseq.BioSequence, buffer *obialign.LCSBatchBuffer) Placement {
+	scores, lengths := obialign.FastLCSScoreBatch(sequence, tree.sequences, -1, buffer)
+
+	distance := 1.0
+	bests := make([]int, 0)
+
+	for i, score := range scores {
+		d := 1.0
+		if lengths[i] > 0 {
+			d = 1 - float64(score)/float64(lengths[i])
+		}
+
+		switch {
+		case d < distance:
+			distance = d
+			bests = append(bests[:0], i)
+		case d == distance && d < 1:
+			bests = append(bests, i)
+		}
+	}
+
+	if len(bests) == 0 {
+		return Placement{
+			Clade:    tree.Root,
+			Taxon:    tree.taxonomy.Root(),
+			Leaves:   tree.sizes[tree.Root],
+			Distance: 1,
+		}
+	}
+
+	clade := tree.leaves[bests[0]]
+	placement := Placement{
+		Distance: distance,
+		Bests:    make(obiseq.BioSequenceSlice, 0, len(bests)),
+	}
+
+	for _, i := range bests {
+		placement.Bests = append(placement.Bests, tree.sequences[i])
+		for _, neighbour := range tree.neighbours(tree.leaves[i], distance*tree.scale) {
+			clade = tree.lca(clade, neighbour)
+		}
+	}
+
+	placement.Clade = clade
+	placement.Taxon = tree.taxa[clade]
+	placement.Leaves = tree.sizes[clade]
+
+	return placement
+}
+
+// PlacementIdentify assigns a sequence by placing it on a reference tree.
+//
+// The following attributes are set:
+//   - obitag_placement_edge: the name of the node below the placement edge,
+//   - obitag_placement_leaves: the number of references below the placement edge,
+//   - obitag_bestid, obitag_bestmatch, obitag_match_count: the identity and
+//     the closest references, as for the lcs method.
+//
+// Parameters:
+//   - sequence: The sequence to assign.
+//   - tree: The reference tree.
+//   - buffer: A buffer reused between calls, it can be nil.
+//
+// Returns:
+//   - The annotated sequence.
+func PlacementIdentify(sequence *obiseq.BioSequence,
+	tree *ReferenceTree,
+	buffer *obialign.LCSBatchBuffer) *obiseq.BioSequence {
+
+	placement := tree.Place(sequence, buffer)
+
+	bestmatch := ""
+	if len(placement.Bests) > 0 {
+		bestmatch = placement.Bests[0].Id()
+	}
+
+	sequence.SetTaxon(placement.Taxon)
+	sequence.SetAttribute("obitag_rank", placement.Taxon.Rank())
+	sequence.SetAttribute("obitag_bestid", 1-placement.Distance)
+	sequence.SetAttribute("obitag_bestmatch", bestmatch)
+	sequence.SetAttribute("obitag_match_count", len(placement.Bests))
+	sequence.SetAttribute("obitag_placement_edge", placement.Clade.Name)
+	sequence.SetAttribute("obitag_placement_leaves", placement.Leaves)
+	sequence.SetAttribute("obitag_similarity_method", "placement")
+
+	return sequence
+}
+
+func PlacementIdentifySeqWorker(tree *ReferenceTree) obiseq.SeqWorker {
+	// The worker is run concurrently, each run borrows a buffer
+	buffers := sync.Pool{
+		New: func() any { return &obialign.LCSBatchBuffer{} },
+	}
+
+	return func(sequence *obiseq.BioSequence) (obiseq.BioSequenceSlice, error) {
+		buffer := buffers.Get().(*obialign.LCSBatchBuffer)
+		defer buffers.Put(buffer)
+		return obiseq.BioSequenceSlice{PlacementIdentify(sequence, tree, buffer)}, nil
+	}
+}
+
+func CLIPlacementAssignTaxonomy(iterator obiiter.IBioSequence,
+	references *obirefidx.ReferenceDB,
+) obiiter.IBioSequence {
+
+	worker := PlacementIdentifySeqWorker(CLIReferenceTree(references))
+
+	return iterator.MakeIWorker(worker, false, obidefault.ParallelWorkers(), 0)
+}
@@ -0,0 +1,137 @@
+package obitag
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiphylo"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitax"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obirefidx"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiutils"
+)
+
+// placementReferences builds a reference database of a few species, each
+// represented by several related sequences.
+func placementReferences(t *testing.T, rng *rand.Rand) *obirefidx.ReferenceDB {
+	taxonomy := obitax.NewTaxonomy("test", "taxon", obiutils.AsciiDigitSet)
+
+	if _, err := taxonomy.AddTaxon("1", "1", "no rank", true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	root := make([]byte, 100)
+	for i := range root {
+		root[i] = "acgt"[rng.Intn(4)]
+	}
+
+	references := obiseq.MakeBioSequenceSlice()
+	for s := 2; s < 8; s++ {
+		taxon, err := taxonomy.AddTaxon(fmt.Sprint(s), "1", "species", false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		taxon.SetName(fmt.Sprintf("species %d", s), "scientific name")
+
+		sseq := evolve(rng, root, 20)
+		for r := 0; r < 4; r++ {
+			seq := obiseq.NewBioSequence(fmt.Sprintf("ref_%d", len(references)),
+				evolve(rng, sseq, rng.Intn(4)), "")
+			seq.SetTaxid(fmt.Sprint(s))
+			references = append(references, seq)
+		}
+	}
+
+	return obirefidx.MakeReferenceDB(references, taxonomy)
+}
+
+// scaleBranchLengths multiplies every branch length of a tree.
+func scaleBranchLengths(node *obiphylo.PhyloNode, factor float64) {
+	for child, length := range node.Children {
+		node.Children[child] = length * factor
+		scaleBranchLengths(child, factor)
+	}
+}
+
+func TestBuildReferenceTreeLimit(t *testing.T) {
+	references := placementReferences(t, rand.New(rand.NewSource(19)))
+
+	if _, err := BuildReferenceTree(references, "upgma", references.Len()-1); err == nil {
+		t.Errorf("tree of %d references built with a limit of %d", references.Len(), references.Len()-1)
+	}
+
+	root, err := BuildReferenceTree(references, "upgma", references.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if leaves := len(root.Leaves()); leaves != references.Len() {
+		t.Errorf("tree has %d leaves, expected %d", leaves, references.Len())
+	}
+}
+
+func TestPlacementBranchLengthScale(t *testing.T) {
+	rng := rand.New(rand.NewSource(23))
+	references := placementReferences(t, rng)
+
+	root, err := BuildReferenceTree(references, "nj", references.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := NewReferenceTree(root, references)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := make(obiseq.BioSequenceSlice, 20)
+	expected := make([]string, len(queries))
+	for q := range queries {
+		reference := references.Sequences[rng.Intn(references.Len())]
+		queries[q] = obiseq.NewBioSequence("query",
+			evolve(rng, reference.Sequence(), 1+rng.Intn(8)), "")
+		expected[q] = tree.Place(queries[q], nil).Clade.Name
+	}
+
+	scale, err := tree.FitDistanceScale()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same tree with branch lengths in other units
+	scaleBranchLengths(root, 100)
+	scaled, err := NewReferenceTree(root, references)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rescale, err := scaled.FitDistanceScale()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if math.Abs(rescale/scale-100) > 1e-6 {
+		t.Errorf("scale of the scaled tree is %g, expected %g", rescale, 100*scale)
+	}
+
+	// The placement radius is expressed in the units of the tree, the
+	// scale of the tree built from the LCS distances being replaced by 1
+	scaled.scale = 100
+	for q, query := range queries {
+		if clade := scaled.Place(query, nil).Clade.Name; clade != expected[q] {
+			t.Errorf("query %d is placed above %s, expected %s", q, clade, expected[q])
+		}
+	}
+
+	scaleBranchLengths(root, 0)
+	flat, err := NewReferenceTree(root, references)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := flat.FitDistanceScale(); err == nil {
+		t.Errorf("a tree without branch lengths is accepted")
+	}
+}
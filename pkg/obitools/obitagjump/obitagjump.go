@@ -0,0 +1,352 @@
+package obitagjump
+
+import (
+	"encoding/csv"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obingslibrary"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// Library groups the samples amplified with the same primer pair and, for
+// the indexed libraries, sequenced with the same Illumina indexes. Tag
+// jumps only occur between the samples of a library.
+type Library struct {
+	Index    obingslibrary.IndexPair
+	Primers  obingslibrary.PrimerPair
+	Samples  []string
+	Controls []string
+
+	// Reads is the total count of the sequences in the samples of the library.
+	Reads int
+
+	// UnusedRate is the rate estimated from the unused tag combinations,
+	// ControlRate the one estimated from the negative controls. They are
+	// negative when no estimate is available.
+	UnusedRate  float64
+	ControlRate float64
+
+	// Rate is the fraction of the reads of a sequence expected in each
+	// sample of the library because of tag jumps.
+	Rate float64
+}
+
+// Name returns the name of the library, built from its index pair and its
+// primers.
+func (library *Library) Name() string {
+	name := library.Primers.Forward + ":" + library.Primers.Reverse
+
+	if !library.Index.IsEmpty() {
+		name = library.Index.String() + "/" + name
+	}
+
+	return name
+}
+
+// Suspicion describes a count of a sequence in a sample lower than the
+// level expected from tag jumps.
+type Suspicion struct {
+	Id       string
+	Sample   string
+	Library  *Library
+	Count    int
+	Total    int
+	Expected float64
+}
+
+// MakeLibraries groups the samples of an NGSFilter description by index
+// and primer pair.
+//
+// Parameters:
+//   - ngsfilter: The description of the PCRs.
+//   - isControl: A predicate identifying the negative controls.
+//
+// Returns:
+//   - The libraries sorted by primers.
+//   - The library of each sample.
+func MakeLibraries(ngsfilter *obingslibrary.NGSLibrary,
+	isControl func(string) bool) ([]*Library, map[string]*Library) {
+
+	libraries := make([]*Library, 0)
+	bysample := make(map[string]*Library)
+
+	for index, indexed := range ngsfilter.Libraries() {
+		for primers, marker := range indexed.Markers {
+			library := &Library{
+				Index:       index,
+				Primers:     primers,
+				UnusedRate:  -1,
+				ControlRate: -1,
+			}
+
+			for _, pcr := range marker.Samples() {
+				if other, ok := bysample[pcr.Sample]; ok {
+					if other != library {
+						log.Warnf("Sample %s belongs to several libraries, only %s is considered",
+							pcr.Sample, other.Name())
+					}
+					continue
+				}
+
+				bysample[pcr.Sample] = library
+				if isControl(pcr.Sample) {
+					library.Controls = append(library.Controls, pcr.Sample)
+				} else {
+					library.Samples = append(library.Samples, pcr.Sample)
+				}
+			}
+
+			slices.Sort(library.Samples)
+			slices.Sort(library.Controls)
+			libraries = append(libraries, library)
+		}
+	}
+
+	slices.SortFunc(libraries, func(a, b *Library) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	return libraries, bysample
+}
+
+// sampleCounts returns the counts of a sequence per sample, nil if the
+// sequence has no statistics on the sample attribute.
+func sampleCounts(sequence *obiseq.BioSequence, sampleKey string) map[string]int {
+	if !sequence.HasStatsOn(sampleKey) {
+		return nil
+	}
+
+	return sequence.StatsOn(obiseq.MakeStatsOnDescription(sampleKey), "NA").Map()
+}
+
+// EstimateRates estimates the tag-jump rate of each library.
+//
+// The rate estimated from the unused tag combinations is the mean count of
+// these combinations divided by the reads of the library in the
+// demultiplexing report. The rate estimated from the negative controls is
+// the mean count of the controls divided by the reads of the library in the
+// sequences. The rate of a library is the largest available estimate.
+//
+// Parameters:
+//   - libraries: The libraries.
+//   - bysample: The library of each sample.
+//   - sequences: The sequences, as produced by obiuniq.
+//   - sampleKey: The attribute containing the sample names.
+//   - report: A demultiplexing report produced by obimultiplex, it can be nil.
+//   - rate: A rate used for every library instead of the estimates if not negative.
+func EstimateRates(libraries []*Library,
+	bysample map[string]*Library,
+	sequences obiseq.BioSequenceSlice,
+	sampleKey string,
+	report *obingslibrary.DemultiplexReport,
+	rate float64) {
+
+	controls := make(map[string]int)
+
+	for _, sequence := range sequences {
+		for sample, count := range sampleCounts(sequence, sampleKey) {
+			if library, ok := bysample[sample]; ok {
+				library.Reads += count
+				if slices.Contains(library.Controls, sample) {
+					controls[sample] += count
+				}
+			}
+		}
+	}
+
+	for _, library := range libraries {
+		if report != nil {
+			for _, marker := range report.Markers {
+				jumps := marker.TagJumps
+				total := jumps.UsedReads + jumps.UnusedReads
+
+				if marker.Index == library.Index.String() &&
+					strings.EqualFold(marker.ForwardPrimer, library.Primers.Forward) &&
+					strings.EqualFold(marker.ReversePrimer, library.Primers.Reverse) &&
+					jumps.UnusedCombinations > 0 && total > 0 {
+					library.UnusedRate = jumps.MeanUnusedReads / float64(total)
+				}
+			}
+		}
+
+		if len(library.Controls) > 0 && library.Reads > 0 {
+			sum := 0
+			for _, control := range library.Controls {
+				sum += controls[control]
+			}
+			library.ControlRate = float64(sum) / float64(len(library.Controls)) / float64(library.Reads)
+		}
+
+		if rate >= 0 {
+			library.Rate = rate
+		} else {
+			library.Rate = max(0, library.UnusedRate, library.ControlRate)
+		}
+
+		log.Infof("Library %s: %d reads in %d samples and %d controls, tag-jump rate %g",
+			library.Name(), library.Reads, len(library.Samples), len(library.Controls), library.Rate)
+	}
+}
+
+// FilterTagJumps removes, or flags, the counts of the sequences in the
+// samples lower than the level expected from tag jumps. In a library, this
+// level is the rate of the library multiplied by the total count of the
+// sequence in the samples of the library. The counts of the negative
+// controls and of the samples absent from the libraries are kept.
+//
+// In flag mode, the suspected counts are stored in the tagjump_suspected
+// attribute. Otherwise, they are removed from the statistics on the sample
+// attribute, the count of the sequence is decreased accordingly and the
+// sequences left without any count are discarded.
+//
+// Parameters:
+//   - sequences: The sequences, as produced by obiuniq.
+//   - bysample: The library of each sample, with its estimated rate.
+//   - sampleKey: The attribute containing the sample names.
+//   - factor: A factor applied to the expected contamination level.
+//   - flagOnly: A boolean value indicating whether the counts are only flagged.
+//
+// Returns:
+//   - The filtered sequences.
+//   - The suspected counts.
+func FilterTagJumps(sequences obiseq.BioSequenceSlice,
+	bysample map[string]*Library,
+	sampleKey string,
+	factor float64,
+	flagOnly bool) (obiseq.BioSequenceSlice, []Suspicion) {
+
+	filtered := obiseq.MakeBioSequenceSlice(0)
+	suspicions := make([]Suspicion, 0)
+
+	for _, sequence := range sequences {
+		counts := sampleCounts(sequence, sampleKey)
+
+		totals := make(map[*Library]int)
+		for sample, count := range counts {
+			if library, ok := bysample[sample]; ok {
+				totals[library] += count
+			}
+		}
+
+		suspected := make(map[string]int)
+		for sample, count := range counts {
+			library, ok := bysample[sample]
+			if !ok || slices.Contains(library.Controls, sample) {
+				continue
+			}
+
+			expected := library.Rate * float64(totals[library])
+			if float64(count) < factor*expected {
+				suspected[sample] = count
+				suspicions = append(suspicions, Suspicion{
+					Id:       sequence.Id(),
+					Sample:   sample,
+					Library:  library,
+					Count:    count,
+					Total:    totals[library],
+					Expected: expected,
+				})
+			}
+		}
+
+		if len(suspected) > 0 {
+			if flagOnly {
+				sequence.SetAttribute("tagjump_suspected", suspected)
+			} else {
+				kept := make(map[string]int, len(counts))
+				total := 0
+				for sample, count := range counts {
+					if _, ok := suspected[sample]; !ok {
+						kept[sample] = count
+						total += count
+					}
+				}
+
+				if len(kept) == 0 {
+					continue
+				}
+
+				sequence.SetAttribute(obiseq.StatsOnSlotName(sampleKey), obiseq.MapAsStatsOnValues(kept))
+				sequence.SetCount(total)
+			}
+		}
+
+		filtered = append(filtered, sequence)
+	}
+
+	slices.SortFunc(suspicions, func(a, b Suspicion) int {
+		if c := strings.Compare(a.Id, b.Id); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Sample, b.Sample)
+	})
+
+	return filtered, suspicions
+}
+
+// WriteSuspicions writes the suspected counts to a CSV file.
+func WriteSuspicions(filename string, suspicions []Suspicion) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"id", "sample", "library", "count", "library_count", "rate", "expected"})
+
+	for _, s := range suspicions {
+		writer.Write([]string{
+			s.Id,
+			s.Sample,
+			s.Library.Name(),
+			strconv.Itoa(s.Count),
+			strconv.Itoa(s.Total),
+			strconv.FormatFloat(s.Library.Rate, 'g', 6, 64),
+			strconv.FormatFloat(s.Expected, 'f', 3, 64),
+		})
+	}
+
+	writer.Flush()
+
+	if err := writer.Error(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func CLITagJumpFilter(iterator obiiter.IBioSequence) obiiter.IBioSequence {
+	libraries, bysample := MakeLibraries(CLINGSFilter(), CLIIsNegativeControl())
+
+	source, sequences := iterator.Load()
+
+	EstimateRates(libraries, bysample, sequences, CLISampleAttribute(),
+		CLIDemultiplexReport(), CLIRate())
+
+	filtered, suspicions := FilterTagJumps(sequences, bysample, CLISampleAttribute(),
+		CLIFactor(), CLIFlagOnly())
+
+	action := "Removed"
+	if CLIFlagOnly() {
+		action = "Flagged"
+	}
+
+	log.Infof("%s %d counts suspected to result from tag jumps, %d sequences discarded",
+		action, len(suspicions), len(sequences)-len(filtered))
+
+	if CLIReportFile() != "" {
+		if err := WriteSuspicions(CLIReportFile(), suspicions); err != nil {
+			log.Fatalf("Cannot write the tag-jump report: %v", err)
+		}
+	}
+
+	return obiiter.IBatchOver(source, filtered, obidefault.BatchSize())
+}
@@ -0,0 +1,223 @@
+package obitagjump
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obingslibrary"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+const (
+	_TestForwardPrimer = "gggcaatcctgagccaa"
+	_TestReversePrimer = "ccattgagtctctgcacctatc"
+)
+
+// testLibraries builds the libraries of a marker used by the samples s1,
+// s2 and the negative control neg1, and of a second marker used by s3.
+func testLibraries() ([]*Library, map[string]*Library) {
+	ngsfilter := obingslibrary.MakeNGSLibrary()
+
+	marker, _ := ngsfilter.GetMarker(_TestForwardPrimer, _TestReversePrimer)
+	for i, sample := range []string{"s1", "s2", "neg1"} {
+		pcr, _ := marker.GetPCR(fmt.Sprintf("acgtacg%c", "acg"[i]), "ttgcattg")
+		pcr.Experiment = "test"
+		pcr.Sample = sample
+	}
+
+	other, _ := ngsfilter.GetMarker("ttagggatcc", "ggatccctaa")
+	pcr, _ := other.GetPCR("acgtacga", "ttgcattg")
+	pcr.Experiment = "test"
+	pcr.Sample = "s3"
+
+	return MakeLibraries(&ngsfilter, func(sample string) bool {
+		return strings.HasPrefix(sample, "neg")
+	})
+}
+
+// testSequence builds a sequence with its counts per sample.
+func testSequence(id string, counts map[string]int) *obiseq.BioSequence {
+	sequence := obiseq.NewBioSequence(id, []byte("acgtacgtacgt"), "")
+
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+
+	sequence.SetAttribute(obiseq.StatsOnSlotName("sample"), obiseq.MapAsStatsOnValues(counts))
+	sequence.SetCount(total)
+
+	return sequence
+}
+
+func TestMakeLibraries(t *testing.T) {
+	libraries, bysample := testLibraries()
+
+	if len(libraries) != 2 {
+		t.Fatalf("%d libraries built, expected 2", len(libraries))
+	}
+
+	library := bysample["s1"]
+	if library.Primers != (obingslibrary.PrimerPair{Forward: _TestForwardPrimer, Reverse: _TestReversePrimer}) {
+		t.Errorf("s1 belongs to library %s", library.Name())
+	}
+
+	if !reflect.DeepEqual(library.Samples, []string{"s1", "s2"}) ||
+		!reflect.DeepEqual(library.Controls, []string{"neg1"}) {
+		t.Errorf("library %s has samples %v and controls %v", library.Name(), library.Samples, library.Controls)
+	}
+
+	if bysample["s2"] != library || bysample["neg1"] != library || bysample["s3"] == library {
+		t.Errorf("samples are not grouped by marker")
+	}
+}
+
+func TestEstimateRates(t *testing.T) {
+	sequences := obiseq.BioSequenceSlice{
+		testSequence("seq1", map[string]int{"s1": 600, "s2": 380, "neg1": 20, "s3": 100}),
+		testSequence("seq2", map[string]int{"s1": 990, "s2": 10, "unknown": 50}),
+	}
+
+	report := &obingslibrary.DemultiplexReport{
+		Markers: []obingslibrary.MarkerReport{
+			{
+				ForwardPrimer: strings.ToUpper(_TestForwardPrimer),
+				ReversePrimer: strings.ToUpper(_TestReversePrimer),
+				TagJumps: obingslibrary.TagJumpStats{
+					UsedCombinations:   3,
+					UnusedCombinations: 2,
+					UsedReads:          920,
+					UnusedReads:        80,
+					MeanUsedReads:      920.0 / 3.0,
+					MeanUnusedReads:    40,
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		report      *obingslibrary.DemultiplexReport
+		rate        float64
+		unusedRate  float64
+		controlRate float64
+		expected    float64
+	}{
+		{"unused combinations and controls", report, -1, 0.04, 0.01, 0.04},
+		{"controls only", nil, -1, -1, 0.01, 0.01},
+		{"fixed rate", report, 0.5, 0.04, 0.01, 0.5},
+	}
+
+	for _, test := range tests {
+		_, bysample := testLibraries()
+		library := bysample["s1"]
+
+		EstimateRates([]*Library{library, bysample["s3"]}, bysample, sequences, "sample", test.report, test.rate)
+
+		if library.Reads != 2000 {
+			t.Errorf("%s: library counts %d reads, expected 2000", test.name, library.Reads)
+		}
+
+		if math.Abs(library.UnusedRate-test.unusedRate) > 1e-12 ||
+			math.Abs(library.ControlRate-test.controlRate) > 1e-12 ||
+			math.Abs(library.Rate-test.expected) > 1e-12 {
+			t.Errorf("%s: rates are %g (unused), %g (controls) and %g, expected %g, %g and %g",
+				test.name, library.UnusedRate, library.ControlRate, library.Rate,
+				test.unusedRate, test.controlRate, test.expected)
+		}
+
+		// Neither unused combinations nor controls for the second library
+		if other := bysample["s3"]; other.Reads != 100 || other.Rate != max(0, test.rate) ||
+			other.UnusedRate != -1 || other.ControlRate != -1 {
+			t.Errorf("%s: library %s has %d reads and rates %g, %g and %g", test.name, other.Name(),
+				other.Reads, other.UnusedRate, other.ControlRate, other.Rate)
+		}
+	}
+}
+
+func TestFilterTagJumps(t *testing.T) {
+	tests := []struct {
+		name      string
+		counts    map[string]int
+		rate      float64
+		factor    float64
+		flagOnly  bool
+		kept      map[string]int
+		suspected map[string]int
+		total     int
+	}{
+		{"no tag jump",
+			map[string]int{"s1": 600, "s2": 400}, 0.02, 1, false,
+			map[string]int{"s1": 600, "s2": 400}, nil, 1000},
+		{"count removed",
+			map[string]int{"s1": 990, "s2": 10}, 0.02, 1, false,
+			map[string]int{"s1": 990}, map[string]int{"s2": 10}, 1000},
+		{"count flagged",
+			map[string]int{"s1": 990, "s2": 10}, 0.02, 1, true,
+			map[string]int{"s1": 990, "s2": 10}, map[string]int{"s2": 10}, 1000},
+		{"control kept",
+			map[string]int{"s1": 600, "s2": 395, "neg1": 5}, 0.02, 1, false,
+			map[string]int{"s1": 600, "s2": 395, "neg1": 5}, nil, 1000},
+		{"other libraries",
+			map[string]int{"s1": 990, "s2": 10, "s3": 5, "unknown": 1}, 0.02, 1, false,
+			map[string]int{"s1": 990, "s3": 5, "unknown": 1}, map[string]int{"s2": 10}, 1000},
+		{"factor",
+			map[string]int{"s1": 600, "s2": 380, "neg1": 20}, 0.2, 3, false,
+			map[string]int{"s1": 600, "neg1": 20}, map[string]int{"s2": 380}, 1000},
+		{"every count removed",
+			map[string]int{"s1": 5, "s2": 5}, 0.02, 100, false,
+			nil, map[string]int{"s1": 5, "s2": 5}, 10},
+	}
+
+	for _, test := range tests {
+		_, bysample := testLibraries()
+		bysample["s1"].Rate = test.rate
+		bysample["s3"].Rate = 0.5
+
+		sequence := testSequence("seq", test.counts)
+		filtered, suspicions := FilterTagJumps(obiseq.BioSequenceSlice{sequence},
+			bysample, "sample", test.factor, test.flagOnly)
+
+		if len(suspicions) != len(test.suspected) {
+			t.Errorf("%s: %d counts suspected, expected %d", test.name, len(suspicions), len(test.suspected))
+		}
+
+		for _, suspicion := range suspicions {
+			if suspicion.Count != test.suspected[suspicion.Sample] || suspicion.Total != test.total ||
+				suspicion.Expected != test.rate*float64(test.total) {
+				t.Errorf("%s: unexpected suspicion %+v", test.name, suspicion)
+			}
+		}
+
+		if test.kept == nil {
+			if len(filtered) != 0 {
+				t.Errorf("%s: sequence without any count kept", test.name)
+			}
+			continue
+		}
+
+		if len(filtered) != 1 {
+			t.Errorf("%s: sequence discarded", test.name)
+			continue
+		}
+
+		total := 0
+		for _, count := range test.kept {
+			total += count
+		}
+
+		if counts := sampleCounts(sequence, "sample"); !reflect.DeepEqual(counts, test.kept) || sequence.Count() != total {
+			t.Errorf("%s: counts are %v (total %d), expected %v (total %d)",
+				test.name, counts, sequence.Count(), test.kept, total)
+		}
+
+		flagged, ok := sequence.GetAttribute("tagjump_suspected")
+		if ok != (test.flagOnly && len(test.suspected) > 0) ||
+			(ok && !reflect.DeepEqual(flagged, test.suspected)) {
+			t.Errorf("%s: flagged counts are %v, expected %v", test.name, flagged, test.suspected)
+		}
+	}
+}
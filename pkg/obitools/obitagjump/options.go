@@ -0,0 +1,175 @@
+package obitagjump
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiformats"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obingslibrary"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
+	"github.com/DavidGamba/go-getoptions"
+)
+
+var _NGSFilterFile = ""
+var _StatsFile = ""
+var _SampleAttribute = "sample"
+var _NegativeControls = []string{}
+var _Rate = -1.0
+var _Factor = 1.0
+var _FlagOnly = false
+var _ReportFile = ""
+
+// TagJumpOptionSet adds to a CLI the options of the tag-jump filter.
+func TagJumpOptionSet(options *getoptions.GetOpt) {
+	options.StringVar(&_NGSFilterFile, "tag-list", _NGSFilterFile,
+		options.Alias("s"),
+		options.ArgName("FILENAME"),
+		options.Description("File name of the NGSFilter file describing the PCRs of the libraries."))
+
+	options.StringVar(&_StatsFile, "stats", _StatsFile,
+		options.ArgName("FILENAME"),
+		options.Description("Demultiplexing report produced by obimultiplex --stats. "+
+			"The reads of its unused tag combinations are used to estimate the tag-jump rates."))
+
+	options.StringVar(&_SampleAttribute, "sample", _SampleAttribute,
+		options.ArgName("ATTRIBUTE"),
+		options.Description("Attribute containing the sample names, summarized by obiuniq "+
+			"in the merged_<ATTRIBUTE> attribute."))
+
+	options.StringSliceVar(&_NegativeControls, "negative-control", 1, 1,
+		options.ArgName("PATTERN"),
+		options.Description("Name of a negative control sample. Shell patterns like 'neg_*' are allowed. "+
+			"The reads of the negative controls are used to estimate the tag-jump rates."))
+
+	options.Float64Var(&_Rate, "rate", _Rate,
+		options.ArgName("#.###"),
+		options.Description("Use this tag-jump rate for every library instead of estimating it."))
+
+	options.Float64Var(&_Factor, "factor", _Factor,
+		options.ArgName("#.###"),
+		options.Description("Multiplies the expected contamination level below which a count is removed."))
+
+	options.BoolVar(&_FlagOnly, "flag", _FlagOnly,
+		options.Description("Do not remove the suspected counts, only annotate them in the tagjump_suspected attribute."))
+
+	options.StringVar(&_ReportFile, "report", _ReportFile,
+		options.ArgName("FILENAME"),
+		options.Description("Write the removed (or flagged) counts to this CSV file."))
+}
+
+// OptionSet adds to the basic option set every options declared for
+// the obitagjump command
+func OptionSet(options *getoptions.GetOpt) {
+	obiconvert.OptionSet(false)(options)
+	TagJumpOptionSet(options)
+}
+
+func CLIHasNGSFilterFile() bool {
+	return _NGSFilterFile != ""
+}
+
+// CLINGSFilter reads the NGSFilter file describing the libraries.
+func CLINGSFilter() *obingslibrary.NGSLibrary {
+	file, err := os.Open(_NGSFilterFile)
+
+	if err != nil {
+		log.Fatalf("Cannot open the NGSFilter file: %v", err)
+	}
+
+	defer file.Close()
+
+	library, err := obiformats.ReadNGSFilter(file)
+
+	if err != nil {
+		log.Fatalf("Cannot read the NGSFilter file %s: %v", _NGSFilterFile, err)
+	}
+
+	return library
+}
+
+// CLIDemultiplexReport returns the demultiplexing report given by the
+// --stats option, nil if none was provided.
+func CLIDemultiplexReport() *obingslibrary.DemultiplexReport {
+	if _StatsFile == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(_StatsFile)
+	if err != nil {
+		log.Fatalf("Cannot read the demultiplexing report: %v", err)
+	}
+
+	report := &obingslibrary.DemultiplexReport{}
+	if err := json.Unmarshal(content, report); err != nil {
+		log.Fatalf("Cannot parse the demultiplexing report %s: %v", _StatsFile, err)
+	}
+
+	return report
+}
+
+func CLISampleAttribute() string {
+	return _SampleAttribute
+}
+
+// CLIIsNegativeControl returns a predicate testing if a sample is a
+// negative control.
+func CLIIsNegativeControl() func(string) bool {
+	for _, pattern := range _NegativeControls {
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.Fatalf("Invalid negative control pattern %s: %v", pattern, err)
+		}
+	}
+
+	return func(sample string) bool {
+		for _, pattern := range _NegativeControls {
+			if ok, _ := path.Match(pattern, sample); ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// CLIRate returns the tag-jump rate set by the --rate option, or a
+// negative value if the rates must be estimated.
+func CLIRate() float64 {
+	if _Rate > 1 {
+		log.Fatalf("The tag-jump rate must be at most 1 (%f)", _Rate)
+	}
+
+	return _Rate
+}
+
+func CLIFactor() float64 {
+	if _Factor < 0 {
+		log.Fatalf("The factor must be positive (%f)", _Factor)
+	}
+
+	return _Factor
+}
+
+func CLIFlagOnly() bool {
+	return _FlagOnly
+}
+
+func CLIReportFile() string {
+	return _ReportFile
+}
+
+// CLICheckOptions checks that a source of tag-jump rates is available.
+func CLICheckOptions() error {
+	if !CLIHasNGSFilterFile() {
+		return fmt.Errorf("an NGSFilter file must be provided (--tag-list option)")
+	}
+
+	if _Rate < 0 && _StatsFile == "" && len(_NegativeControls) == 0 {
+		return fmt.Errorf("the tag-jump rates need a demultiplexing report (--stats), " +
+			"negative controls (--negative-control) or a fixed rate (--rate)")
+	}
+
+	return nil
+}
@@ -76,7 +76,7 @@ func IPCRTagPESequencesBatch(iterator obiiter.IBioSequence,
 					A.Copy(), B.ReverseComplement(false),
 					gap, scale,
 					delta, minOverlap, minIdentity, withStats, true,
-					fastAlign, fastScoreRel, arena, &shifts,
+					fastAlign, fastScoreRel, nil, arena, &shifts,
 				)
 
 				barcodes, err := ngsfilter.ExtractMultiBarcode(consensus)
@@ -120,6 +120,48 @@ func CLINewickWriter(iterator *obitax.ITaxon,
 	return newIter
 }
 
+// CLISequenceTaxaIterator returns an iterator over the taxa referenced by the
+// sequences of the files provided with the --add-taxa-from option. Each taxon
+// is returned only once.
+func CLISequenceTaxaIterator() *obitax.ITaxon {
+	taxonomy := obitax.DefaultTaxonomy()
+
+	sequences, err := obiconvert.CLIReadBioSequences(CLITaxaFromSequencesFiles()...)
+
+	if err != nil {
+		log.Fatalf("Cannot read the sequence files: %v", err)
+	}
+
+	taxa := taxonomy.NewTaxonSet()
+
+	for sequences.Next() {
+		batch := sequences.Get()
+		for _, sequence := range batch.Slice() {
+			if taxon := sequence.Taxon(taxonomy); taxon != nil {
+				taxa.InsertTaxon(taxon)
+			}
+		}
+	}
+
+	log.Infof("%d taxa referenced by the sequences", taxa.Len())
+
+	return taxa.Iterator()
+}
+
+// CLITaxdumpWriter writes the taxa provided by the iterator, completed by
+// their ancestors, as a NCBI taxdump directory named by the --out option.
+func CLITaxdumpWriter(iterator *obitax.ITaxon) {
+	directory := obiconvert.CLIOutPutFileName()
+
+	if directory == "-" {
+		log.Fatal("A taxdump cannot be written to stdout, use the --out option to name the directory")
+	}
+
+	if err := obiformats.WriteNCBITaxDump(iterator, directory); err != nil {
+		log.Fatalf("Cannot write the taxdump: %v", err)
+	}
+}
+
 func CLIDownloadNCBITaxdump() error {
 	now := time.Now()
 	dateStr := now.Format("20060102") // In Go, this specific date is used as reference for formatting
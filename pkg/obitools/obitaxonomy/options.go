@@ -27,6 +27,8 @@ var __extract_taxonomy__ = false
 var __newick__ = false
 var __newick_with_leaves__ = false
 var __newick_without_root__ = false
+var __taxdump__ = false
+var __taxa_from_sequences__ = make([]string, 0)
 
 func FilterTaxonomyOptionSet(options *getoptions.GetOpt) {
 	options.BoolVar(&__rank_list__, "rank-list", false,
@@ -86,6 +88,15 @@ func OptionSet(options *getoptions.GetOpt) {
 	options.BoolVar(&__newick_without_root__, "without-root", __newick_without_root__,
 		options.Description("If used, do not include the non-branched path to the root in the output"),
 	)
+	options.BoolVar(&__taxdump__, "taxdump-output", __taxdump__,
+		options.Description("Write the resulting taxa and all their ancestors as a NCBI taxdump "+
+			"directory (nodes.dmp, names.dmp, merged.dmp) named by the --out option"),
+	)
+	options.StringSliceVar(&__taxa_from_sequences__, "add-taxa-from", 1, 1,
+		options.ArgName("FILENAME"),
+		options.Description("Adds to the output every taxon referenced by the sequences of <FILENAME>. "+
+			"Several --add-taxa-from options can be combined."),
+	)
 
 }
 
@@ -182,3 +193,15 @@ func CLINewickWithoutRoot() bool {
 func CLIAskForRankList() bool {
 	return __rank_list__
 }
+
+func CLIAsTaxdump() bool {
+	return __taxdump__
+}
+
+func CLIHasTaxaFromSequences() bool {
+	return len(__taxa_from_sequences__) > 0
+}
+
+func CLITaxaFromSequencesFiles() []string {
+	return __taxa_from_sequences__
+}
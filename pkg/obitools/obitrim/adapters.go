@@ -0,0 +1,165 @@
+package obitrim
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiapat"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// _AdapterPresets associates the names usable with the --adapter option to
+// the beginning of the common Illumina adapters, as read at the 3' end of
+// the reads when the insert is shorter than the reads.
+var _AdapterPresets = map[string]string{
+	"truseq":   "agatcggaagagc",
+	"nextera":  "ctgtctcttatacacatct",
+	"smallrna": "tggaattctcgg",
+}
+
+// _DefaultAdapters are the adapters looked for when none is specified.
+var _DefaultAdapters = []string{"truseq", "nextera"}
+
+// Adapter is an adapter looked for at the 3' end of the reads.
+type Adapter struct {
+	Name      string
+	Sequence  string
+	errorRate float64
+	pattern   obiapat.ApatPattern
+}
+
+// MakeAdapter builds an adapter from a preset name or a nucleotide sequence.
+//
+// Parameters:
+//   - adapter: The name of a preset (truseq, nextera, smallrna) or the
+//     sequence of the adapter.
+//   - errorRate: The fraction of errors allowed on the adapter.
+//   - allowsIndels: Whether indels are allowed when matching the adapter.
+//
+// Returns:
+//   - The adapter.
+//   - An error if the sequence cannot be used as a pattern.
+func MakeAdapter(adapter string, errorRate float64, allowsIndels bool) (*Adapter, error) {
+	name := strings.ToLower(adapter)
+	sequence, ok := _AdapterPresets[name]
+
+	if !ok {
+		sequence = name
+	}
+
+	pattern, err := obiapat.MakeApatPattern(sequence,
+		int(errorRate*float64(len(sequence))),
+		allowsIndels)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid adapter %s: %v", adapter, err)
+	}
+
+	return &Adapter{
+		Name:      name,
+		Sequence:  sequence,
+		errorRate: errorRate,
+		pattern:   pattern,
+	}, nil
+}
+
+// Locate looks for the adapter in a read. The whole adapter is searched
+// first anywhere in the read. If it is not found, the read is checked for
+// ending with the beginning of the adapter on at least minOverlap
+// nucleotides. As for the approximate pattern matching, the IUPAC codes
+// of the adapter and of the read match any of the nucleotides they stand
+// for.
+//
+// Parameters:
+//   - sequence: The read sequence.
+//   - aseq: The read prepared for the approximate pattern matching.
+//   - minOverlap: The minimum length of the adapter at the end of the read.
+//
+// Returns:
+//   - The position of the adapter in the read, -1 if it is not found.
+//   - true if only the beginning of the adapter is found at the end of the
+//     read.
+func (adapter *Adapter) Locate(sequence []byte, aseq obiapat.ApatSequence, minOverlap int) (int, bool) {
+	if len(sequence) >= adapter.pattern.Len() {
+		matches := adapter.pattern.AllMatches(aseq, 0, -1)
+		if len(matches) > 0 {
+			return max(0, matches[0][0]), false
+		}
+	}
+
+	for length := min(len(adapter.Sequence)-1, len(sequence)); length >= minOverlap; length-- {
+		maxErrors := int(adapter.errorRate * float64(length))
+		suffix := sequence[len(sequence)-length:]
+		errors := 0
+
+		for i := 0; i < length && errors <= maxErrors; i++ {
+			if !obiseq.SameIUPACNuc(suffix[i], adapter.Sequence[i]) {
+				errors++
+			}
+		}
+
+		if errors <= maxErrors {
+			return len(sequence) - length, true
+		}
+	}
+
+	return -1, false
+}
+
+// Primer is a primer looked for at the 5' end of the reads.
+type Primer struct {
+	Sequence string
+	pattern  obiapat.ApatPattern
+}
+
+// MakePrimer builds a primer from its sequence.
+//
+// Parameters:
+//   - primer: The sequence of the primer, IUPAC codes are allowed.
+//   - mismatches: The number of errors allowed on the primer.
+//   - allowsIndels: Whether indels are allowed when matching the primer.
+//
+// Returns:
+//   - The primer.
+//   - An error if the sequence cannot be used as a pattern.
+func MakePrimer(primer string, mismatches int, allowsIndels bool) (*Primer, error) {
+	sequence := strings.ToLower(primer)
+	pattern, err := obiapat.MakeApatPattern(sequence, mismatches, allowsIndels)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid primer %s: %v", primer, err)
+	}
+
+	return &Primer{
+		Sequence: sequence,
+		pattern:  pattern,
+	}, nil
+}
+
+// Locate looks for the primer at the beginning of a read.
+//
+// Parameters:
+//   - aseq: The read prepared for the approximate pattern matching.
+//   - maxStart: The maximum position of the first nucleotide of the primer
+//     in the read.
+//
+// Returns:
+//   - The position following the primer in the read, -1 if the primer is
+//     not found.
+func (primer *Primer) Locate(aseq obiapat.ApatSequence, maxStart int) int {
+	if aseq.Len() < primer.pattern.Len() {
+		return -1
+	}
+
+	best := -1
+	errors := math.MaxInt
+
+	for _, match := range primer.pattern.AllMatches(aseq, 0, maxStart+primer.pattern.Len()) {
+		if match[0] <= maxStart && match[2] < errors {
+			best, errors = match[1], match[2]
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,103 @@
+package obitrim
+
+import (
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiapat"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+const _TestInsert = "ccattgagtctctgcacctatcgggcaatcctgagccaatt"
+
+// locateAdapter locates an adapter in a read given as a string.
+func locateAdapter(t *testing.T, adapter *Adapter, read string) (int, bool) {
+	sequence := obiseq.NewBioSequence("read", []byte(read), "")
+	aseq, err := obiapat.MakeApatSequence(sequence, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aseq.Free()
+
+	return adapter.Locate(sequence.Sequence(), aseq, 3)
+}
+
+func TestAdapterLocate(t *testing.T) {
+	truseq, err := MakeAdapter("TruSeq", 0.1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ambiguous, err := MakeAdapter("agatcrgaagagc", 0.1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		adapter  *Adapter
+		read     string
+		position int
+		partial  bool
+	}{
+		{"whole adapter", truseq, _TestInsert + "agatcggaagagcacacgtctgaa", len(_TestInsert), false},
+		{"whole adapter with an error", truseq, _TestInsert + "agatcgcaagagcacacgtctgaa", len(_TestInsert), false},
+		{"whole adapter ending the read", truseq, _TestInsert + "agatcggaagagc", len(_TestInsert), false},
+		{"adapter beginning the read", truseq, "agatcggaagagcacacgtctgaa", 0, false},
+		{"adapter suffix", truseq, _TestInsert + "agatcgga", len(_TestInsert), true},
+		{"shortest adapter suffix", truseq, _TestInsert + "aga", len(_TestInsert), true},
+		{"adapter suffix with an error", truseq, _TestInsert + "agatcgcaag", len(_TestInsert), true},
+		{"adapter suffix with too many errors", truseq, _TestInsert + "agttcgga", -1, false},
+		{"too short adapter suffix", truseq, _TestInsert + "ag", -1, false},
+		{"no adapter", truseq, _TestInsert, -1, false},
+		{"IUPAC adapter", ambiguous, _TestInsert + "agatcagaagagcacacgtctgaa", len(_TestInsert), false},
+		{"IUPAC adapter suffix", ambiguous, _TestInsert + "agatcgga", len(_TestInsert), true},
+		{"IUPAC adapter suffix mismatch", ambiguous, _TestInsert + "agatctga", -1, false},
+		{"IUPAC read suffix", truseq, _TestInsert + "agatcnga", len(_TestInsert), true},
+	}
+
+	for _, test := range tests {
+		position, partial := locateAdapter(t, test.adapter, test.read)
+		if position != test.position || partial != test.partial {
+			t.Errorf("%s: adapter located at %d (partial: %v), expected %d (partial: %v)",
+				test.name, position, partial, test.position, test.partial)
+		}
+	}
+}
+
+func TestPrimerLocate(t *testing.T) {
+	const primer = "gggcaatcctgagccaa"
+
+	p, err := MakePrimer("GGGCAATCCTGAGCCAA", 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		read     string
+		maxStart int
+		end      int
+	}{
+		{"primer at the beginning", primer + _TestInsert, 0, len(primer)},
+		{"shifted primer", "ac" + primer + _TestInsert, 2, 2 + len(primer)},
+		{"primer too far", "ac" + primer + _TestInsert, 1, -1},
+		{"primer with an error", "gggcaatgctgagccaa" + _TestInsert, 0, len(primer)},
+		{"best primer match", "gggcaatgctgagccaa" + "ac" + primer + _TestInsert, 20, 19 + len(primer)},
+		{"no primer", _TestInsert, 10, -1},
+		{"short read", "gggcaatcc", 0, -1},
+	}
+
+	for _, test := range tests {
+		sequence := obiseq.NewBioSequence(test.name, []byte(test.read), "")
+		aseq, err := obiapat.MakeApatSequence(sequence, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if end := p.Locate(aseq, test.maxStart); end != test.end {
+			t.Errorf("%s: primer ends at %d, expected %d", test.name, end, test.end)
+		}
+
+		aseq.Free()
+	}
+}
@@ -0,0 +1,170 @@
+package obitrim
+
+import (
+	"fmt"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obitools/obiconvert"
+	"github.com/DavidGamba/go-getoptions"
+)
+
+var _Adapters = []string{}
+var _AdapterErrorRate = 0.1
+var _MinAdapterOverlap = 3
+var _Primers = []string{}
+var _PrimerMismatches = 2
+var _PrimerMaxStart = 10
+var _AllowsIndels = false
+var _QualityMethod = "window"
+var _WindowSize = 4
+var _MinQuality = 20.0
+var _MottLimit = 0.05
+var _MinLength = 20
+var _StatsFile = ""
+
+// TrimOptionSet adds to a CLI the options of the trimming.
+func TrimOptionSet(options *getoptions.GetOpt) {
+	options.StringSliceVar(&_Adapters, "adapter", 1, 1,
+		options.Alias("a"),
+		options.ArgName("ADAPTER"),
+		options.Description("Adapter to remove from the 3' end of the reads: a sequence or one of "+
+			"the presets truseq, nextera and smallrna. The option can be repeated. "+
+			"By default truseq and nextera adapters are removed, use none to disable adapter trimming."))
+
+	options.Float64Var(&_AdapterErrorRate, "adapter-error-rate", _AdapterErrorRate,
+		options.ArgName("#.###"),
+		options.Description("Fraction of errors allowed when matching an adapter."))
+
+	options.IntVar(&_MinAdapterOverlap, "min-adapter-overlap", _MinAdapterOverlap,
+		options.Description("Minimum length of the beginning of an adapter found at the end of a read to trim it."))
+
+	options.StringSliceVar(&_Primers, "primer", 1, 1,
+		options.Alias("p"),
+		options.ArgName("PRIMER"),
+		options.Description("Primer to remove from the 5' end of the reads. The option can be repeated."))
+
+	options.IntVar(&_PrimerMismatches, "primer-mismatches", _PrimerMismatches,
+		options.Description("Number of errors allowed when matching a primer."))
+
+	options.IntVar(&_PrimerMaxStart, "primer-max-start", _PrimerMaxStart,
+		options.Description("Maximum number of nucleotides preceding a primer at the beginning of a read."))
+
+	options.BoolVar(&_AllowsIndels, "with-indels", _AllowsIndels,
+		options.Description("Allows for indels when matching the adapters and the primers."))
+
+	options.StringVar(&_QualityMethod, "quality-method", _QualityMethod,
+		options.ArgName("METHOD"),
+		options.Description("Method used to trim the low quality ends of the reads: "+
+			"window (sliding window), mott (modified Mott algorithm) or none."),
+		options.ValidValues("window", "mott", "none"))
+
+	options.IntVar(&_WindowSize, "window-size", _WindowSize,
+		options.Description("Size of the sliding window used by the window quality trimming."))
+
+	options.Float64Var(&_MinQuality, "min-quality", _MinQuality,
+		options.Alias("q"),
+		options.Description("Minimum mean quality of the sliding window used by the window quality trimming."))
+
+	options.Float64Var(&_MottLimit, "mott-limit", _MottLimit,
+		options.ArgName("#.###"),
+		options.Description("Error probability limit used by the mott quality trimming."))
+
+	options.IntVar(&_MinLength, "min-length", _MinLength,
+		options.Alias("l"),
+		options.Description("Minimum length of a trimmed read. With paired reads, "+
+			"both the reads are discarded when one of them is too short."))
+
+	options.StringVar(&_StatsFile, "stats", _StatsFile,
+		options.ArgName("FILENAME"),
+		options.Description("Write to this file a JSON report of the trimming with the statistics of each adapter and primer."))
+}
+
+func OptionSet(options *getoptions.GetOpt) {
+	obiconvert.OptionSet(true)(options)
+	TrimOptionSet(options)
+}
+
+// CLIAdapters returns the adapters to remove from the reads.
+func CLIAdapters() []string {
+	if len(_Adapters) == 0 {
+		return _DefaultAdapters
+	}
+
+	if len(_Adapters) == 1 && _Adapters[0] == "none" {
+		return []string{}
+	}
+
+	return _Adapters
+}
+
+func CLIAdapterErrorRate() float64 {
+	return _AdapterErrorRate
+}
+
+func CLIMinAdapterOverlap() int {
+	return _MinAdapterOverlap
+}
+
+func CLIPrimers() []string {
+	return _Primers
+}
+
+func CLIPrimerMismatches() int {
+	return _PrimerMismatches
+}
+
+func CLIPrimerMaxStart() int {
+	return _PrimerMaxStart
+}
+
+func CLIAllowsIndels() bool {
+	return _AllowsIndels
+}
+
+func CLIQualityMethod() string {
+	return _QualityMethod
+}
+
+func CLIWindowSize() int {
+	return _WindowSize
+}
+
+func CLIMinQuality() float64 {
+	return _MinQuality
+}
+
+func CLIMottLimit() float64 {
+	return _MottLimit
+}
+
+func CLIMinLength() int {
+	return _MinLength
+}
+
+func CLIHasStatsFile() bool {
+	return _StatsFile != ""
+}
+
+func CLIStatsFileName() string {
+	return _StatsFile
+}
+
+// CLICheckOptions checks the consistency of the trimming options.
+func CLICheckOptions() error {
+	if _AdapterErrorRate < 0 || _AdapterErrorRate >= 1 {
+		return fmt.Errorf("the adapter error rate must be in [0,1[ (%f)", _AdapterErrorRate)
+	}
+
+	if _MinAdapterOverlap < 1 {
+		return fmt.Errorf("the minimum adapter overlap must be at least 1 (%d)", _MinAdapterOverlap)
+	}
+
+	if _WindowSize < 1 {
+		return fmt.Errorf("the window size must be at least 1 (%d)", _WindowSize)
+	}
+
+	if _MottLimit <= 0 || _MottLimit >= 1 {
+		return fmt.Errorf("the mott limit must be in ]0,1[ (%f)", _MottLimit)
+	}
+
+	return nil
+}
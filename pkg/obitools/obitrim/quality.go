@@ -0,0 +1,78 @@
+package obitrim
+
+import (
+	"math"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// SlidingWindowTrim looks for the 3' end of a read after a quality
+// trimming. The read is scanned from its 5' end with a window, and cut at
+// the beginning of the first window whose mean quality is below a
+// threshold. The low quality nucleotides ending the kept part are also
+// removed.
+//
+// Parameters:
+//   - qualities: The qualities of the read.
+//   - window: The size of the window.
+//   - minQuality: The minimum mean quality of a window.
+//
+// Returns:
+//   - The position where the read is cut.
+func SlidingWindowTrim(qualities obiseq.Quality, window int, minQuality float64) int {
+	window = max(1, min(window, len(qualities)))
+	threshold := minQuality * float64(window)
+	end := len(qualities)
+
+	sum := 0
+	for i, q := range qualities {
+		sum += int(q)
+		if i >= window {
+			sum -= int(qualities[i-window])
+		}
+
+		if i >= window-1 && float64(sum) < threshold {
+			end = i - window + 1
+			break
+		}
+	}
+
+	for end > 0 && float64(qualities[end-1]) < minQuality {
+		end--
+	}
+
+	return end
+}
+
+// MottTrim looks for the part of a read kept by the modified Mott
+// algorithm. Each nucleotide is scored by the difference between an error
+// probability limit and its own error probability, and the part of the
+// read with the highest total score is kept.
+//
+// Parameters:
+//   - qualities: The qualities of the read.
+//   - limit: The error probability limit.
+//
+// Returns:
+//   - The positions of the beginning and of the end of the kept part.
+func MottTrim(qualities obiseq.Quality, limit float64) (int, int) {
+	bestFrom, bestTo := 0, 0
+	bestScore := 0.0
+
+	from := 0
+	score := 0.0
+
+	for i, q := range qualities {
+		score += limit - math.Pow(10, -float64(q)/10)
+
+		if score <= 0 {
+			score = 0
+			from = i + 1
+		} else if score > bestScore {
+			bestScore = score
+			bestFrom, bestTo = from, i+1
+		}
+	}
+
+	return bestFrom, bestTo
+}
@@ -0,0 +1,53 @@
+package obitrim
+
+import (
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+func TestSlidingWindowTrim(t *testing.T) {
+	tests := []struct {
+		name       string
+		qualities  obiseq.Quality
+		window     int
+		minQuality float64
+		end        int
+	}{
+		{"high quality", obiseq.Quality{30, 30, 30, 30, 30, 30}, 4, 20, 6},
+		{"low quality end", obiseq.Quality{30, 30, 30, 30, 30, 30, 10, 10, 10, 10}, 2, 20, 6},
+		{"mean of the window", obiseq.Quality{30, 30, 30, 12, 40, 2, 2, 2}, 3, 20, 3},
+		{"low quality before the window", obiseq.Quality{30, 30, 30, 30, 10, 35, 35, 0, 0}, 4, 20, 4},
+		{"window longer than the read", obiseq.Quality{30, 30}, 10, 20, 2},
+		{"low quality read", obiseq.Quality{10, 10}, 10, 20, 0},
+		{"empty read", obiseq.Quality{}, 4, 20, 0},
+	}
+
+	for _, test := range tests {
+		if end := SlidingWindowTrim(test.qualities, test.window, test.minQuality); end != test.end {
+			t.Errorf("%s: read cut at %d, expected %d", test.name, end, test.end)
+		}
+	}
+}
+
+func TestMottTrim(t *testing.T) {
+	tests := []struct {
+		name      string
+		qualities obiseq.Quality
+		from, to  int
+	}{
+		{"high quality", obiseq.Quality{30, 30, 30, 30}, 0, 4},
+		{"low quality ends", obiseq.Quality{2, 2, 30, 30, 30, 2, 2}, 2, 5},
+		{"short drop kept", obiseq.Quality{30, 30, 10, 30, 30, 30}, 0, 6},
+		{"long drop", obiseq.Quality{30, 2, 30, 30}, 2, 4},
+		{"low quality read", obiseq.Quality{2, 2, 2}, 0, 0},
+		{"empty read", obiseq.Quality{}, 0, 0},
+	}
+
+	for _, test := range tests {
+		if from, to := MottTrim(test.qualities, 0.05); from != test.from || to != test.to {
+			t.Errorf("%s: read kept from %d to %d, expected from %d to %d",
+				test.name, from, to, test.from, test.to)
+		}
+	}
+}
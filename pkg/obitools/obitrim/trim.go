@@ -0,0 +1,390 @@
+package obitrim
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiapat"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obidefault"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiiter"
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// AdapterStats reports the reads trimmed because of an adapter.
+type AdapterStats struct {
+	Name           string `json:"name"`
+	Sequence       string `json:"sequence"`
+	Reads          int    `json:"trimmed_reads"`
+	FullMatches    int    `json:"full_matches"`
+	PartialMatches int    `json:"partial_matches"`
+	MateInferred   int    `json:"mate_inferred"`
+	TrimmedBases   int    `json:"trimmed_bases"`
+}
+
+// PrimerStats reports the reads starting with a primer.
+type PrimerStats struct {
+	Sequence     string `json:"sequence"`
+	Reads        int    `json:"trimmed_reads"`
+	TrimmedBases int    `json:"trimmed_bases"`
+}
+
+// TrimReport summarizes the trimming of the reads.
+type TrimReport struct {
+	Reads               int             `json:"reads"`
+	Paired              bool            `json:"paired"`
+	KeptReads           int             `json:"kept_reads"`
+	TooShortReads       int             `json:"too_short_reads"`
+	QualityTrimmedReads int             `json:"quality_trimmed_reads"`
+	QualityTrimmedBases int             `json:"quality_trimmed_bases"`
+	Adapters            []*AdapterStats `json:"adapters"`
+	Primers             []*PrimerStats  `json:"primers"`
+}
+
+// Trimmer removes the adapters, the primers and the low quality ends of
+// the reads.
+type Trimmer struct {
+	adapters       []*Adapter
+	minOverlap     int
+	primers        []*Primer
+	primerMaxStart int
+	quality        string
+	window         int
+	minQuality     float64
+	mottLimit      float64
+	minLength      int
+
+	mutex  sync.Mutex
+	report TrimReport
+}
+
+// _ReadTrimming describes the trimming of a read.
+type _ReadTrimming struct {
+	from, to     int
+	adapter      int
+	adapterBases int
+	partial      bool
+	inferred     bool
+	primer       int
+	primerBases  int
+	quality      int
+}
+
+// CLIMakeTrimmer builds a trimmer from the command line options.
+func CLIMakeTrimmer() *Trimmer {
+	trimmer := &Trimmer{
+		minOverlap:     CLIMinAdapterOverlap(),
+		primerMaxStart: CLIPrimerMaxStart(),
+		quality:        CLIQualityMethod(),
+		window:         CLIWindowSize(),
+		minQuality:     CLIMinQuality(),
+		mottLimit:      CLIMottLimit(),
+		minLength:      CLIMinLength(),
+	}
+
+	for _, a := range CLIAdapters() {
+		adapter, err := MakeAdapter(a, CLIAdapterErrorRate(), CLIAllowsIndels())
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		trimmer.adapters = append(trimmer.adapters, adapter)
+		trimmer.report.Adapters = append(trimmer.report.Adapters, &AdapterStats{
+			Name:     adapter.Name,
+			Sequence: adapter.Sequence,
+		})
+	}
+
+	for _, p := range CLIPrimers() {
+		primer, err := MakePrimer(p, CLIPrimerMismatches(), CLIAllowsIndels())
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		trimmer.primers = append(trimmer.primers, primer)
+		trimmer.report.Primers = append(trimmer.report.Primers, &PrimerStats{
+			Sequence: primer.Sequence,
+		})
+	}
+
+	return trimmer
+}
+
+// locateAdapter looks for the first adapter occurring in a read.
+func (trimmer *Trimmer) locateAdapter(sequence *obiseq.BioSequence, aseq obiapat.ApatSequence) (int, int, bool) {
+	cut, found, partial := sequence.Len(), -1, false
+
+	for i, adapter := range trimmer.adapters {
+		position, p := adapter.Locate(sequence.Sequence(), aseq, trimmer.minOverlap)
+		if position >= 0 && position < cut {
+			cut, found, partial = position, i, p
+		}
+	}
+
+	return cut, found, partial
+}
+
+// locatePrimer looks for a primer at the beginning of a read.
+func (trimmer *Trimmer) locatePrimer(aseq obiapat.ApatSequence, to int) (int, int) {
+	for i, primer := range trimmer.primers {
+		if end := primer.Locate(aseq, trimmer.primerMaxStart); end >= 0 && end <= to {
+			return end, i
+		}
+	}
+
+	return 0, -1
+}
+
+// qualityBounds looks for the part of a read kept by the quality trimming.
+func (trimmer *Trimmer) qualityBounds(qualities obiseq.Quality) (int, int) {
+	switch trimmer.quality {
+	case "window":
+		return 0, SlidingWindowTrim(qualities, trimmer.window, trimmer.minQuality)
+	case "mott":
+		return MottTrim(qualities, trimmer.mottLimit)
+	}
+
+	return 0, len(qualities)
+}
+
+// locate computes the trimming of a read, except the quality trimming
+// which must be done once the adapter position is known.
+func (trimmer *Trimmer) locate(sequence *obiseq.BioSequence) _ReadTrimming {
+	trimming := _ReadTrimming{
+		to:      sequence.Len(),
+		adapter: -1,
+		primer:  -1,
+	}
+
+	if sequence.Len() == 0 || (len(trimmer.adapters) == 0 && len(trimmer.primers) == 0) {
+		return trimming
+	}
+
+	aseq, err := obiapat.MakeApatSequence(sequence, false)
+	if err != nil {
+		log.Fatalf("error in building apat sequence : %v\n", err)
+	}
+	defer aseq.Free()
+
+	trimming.to, trimming.adapter, trimming.partial = trimmer.locateAdapter(sequence, aseq)
+	trimming.from, trimming.primer = trimmer.locatePrimer(aseq, trimming.to)
+
+	return trimming
+}
+
+// trimQuality restricts the trimming of a read to its high quality part.
+func (trimmer *Trimmer) trimQuality(sequence *obiseq.BioSequence, trimming *_ReadTrimming) {
+	if trimmer.quality == "none" || !sequence.HasQualities() || trimming.from >= trimming.to {
+		return
+	}
+
+	from, to := trimmer.qualityBounds(sequence.Qualities()[trimming.from:trimming.to])
+	if to <= from {
+		from, to = 0, 0
+	}
+
+	trimming.quality = trimming.to - trimming.from - (to - from)
+	trimming.from, trimming.to = trimming.from+from, trimming.from+to
+}
+
+// apply trims a read and annotates it.
+func (trimmer *Trimmer) apply(sequence *obiseq.BioSequence, trimming _ReadTrimming) {
+	if trimming.adapter >= 0 {
+		sequence.SetAttribute("obitrim_adapter", trimmer.adapters[trimming.adapter].Name)
+	}
+
+	if trimming.primer >= 0 {
+		sequence.SetAttribute("obitrim_primer", trimmer.primers[trimming.primer].Sequence)
+	}
+
+	if trimming.quality > 0 {
+		sequence.SetAttribute("obitrim_quality_trimmed", trimming.quality)
+	}
+
+	if trimming.from == 0 && trimming.to == sequence.Len() {
+		return
+	}
+
+	var qualities obiseq.Quality
+	if sequence.HasQualities() {
+		qualities = slices.Clone(sequence.Qualities()[trimming.from:trimming.to])
+	}
+
+	sequence.SetSequence(sequence.Sequence()[trimming.from:trimming.to])
+
+	if qualities != nil {
+		sequence.TakeQualities(qualities)
+	}
+}
+
+// record adds the trimming of a read to the statistics.
+func (trimmer *Trimmer) record(trimming _ReadTrimming) {
+	trimmer.mutex.Lock()
+	defer trimmer.mutex.Unlock()
+
+	if trimming.adapter >= 0 {
+		stats := trimmer.report.Adapters[trimming.adapter]
+		stats.Reads++
+		switch {
+		case trimming.inferred:
+			stats.MateInferred++
+		case trimming.partial:
+			stats.PartialMatches++
+		default:
+			stats.FullMatches++
+		}
+		stats.TrimmedBases += trimming.adapterBases
+	}
+
+	if trimming.primer >= 0 {
+		stats := trimmer.report.Primers[trimming.primer]
+		stats.Reads++
+		stats.TrimmedBases += trimming.primerBases
+	}
+
+	if trimming.quality > 0 {
+		trimmer.report.QualityTrimmedReads++
+		trimmer.report.QualityTrimmedBases += trimming.quality
+	}
+}
+
+// trim trims a read or, if it is paired, both the reads of the pair. The
+// reads of a pair are cut at the same insert length when an adapter is
+// found in one of them.
+//
+// Returns:
+//   - true if the read, and its mate, are long enough to be kept.
+func (trimmer *Trimmer) trim(sequence *obiseq.BioSequence) bool {
+	reads := []*obiseq.BioSequence{sequence}
+	if sequence.IsPaired() {
+		reads = append(reads, sequence.PairedWith())
+	}
+
+	trimmings := make([]_ReadTrimming, len(reads))
+	insert := -1
+	adapter := -1
+
+	for i, read := range reads {
+		trimmings[i] = trimmer.locate(read)
+		if trimmings[i].adapter >= 0 && (insert < 0 || trimmings[i].to < insert) {
+			insert, adapter = trimmings[i].to, trimmings[i].adapter
+		}
+	}
+
+	kept := true
+
+	for i, read := range reads {
+		trimming := &trimmings[i]
+
+		if insert >= 0 && trimming.adapter < 0 && insert < read.Len() {
+			trimming.adapter, trimming.inferred = adapter, true
+		}
+
+		if insert >= 0 {
+			trimming.to = min(read.Len(), insert)
+			trimming.from = min(trimming.from, trimming.to)
+		}
+
+		trimming.adapterBases = read.Len() - trimming.to
+		trimming.primerBases = trimming.from
+
+		trimmer.trimQuality(read, trimming)
+		trimmer.record(*trimming)
+		trimmer.apply(read, *trimming)
+
+		kept = kept && read.Len() >= trimmer.minLength
+	}
+
+	trimmer.mutex.Lock()
+	trimmer.report.Reads += len(reads)
+	trimmer.report.Paired = len(reads) > 1
+	if kept {
+		trimmer.report.KeptReads += len(reads)
+	} else {
+		trimmer.report.TooShortReads += len(reads)
+	}
+	trimmer.mutex.Unlock()
+
+	return kept
+}
+
+// SliceWorker returns a worker trimming the reads of a slice and
+// discarding the ones becoming too short.
+func (trimmer *Trimmer) SliceWorker() obiseq.SeqSliceWorker {
+	worker := func(sequences obiseq.BioSequenceSlice) (obiseq.BioSequenceSlice, error) {
+		kept := sequences[:0]
+
+		for _, sequence := range sequences {
+			if trimmer.trim(sequence) {
+				kept = append(kept, sequence)
+			} else {
+				sequence.Recycle()
+			}
+		}
+
+		return kept, nil
+	}
+
+	return worker
+}
+
+// Report returns the statistics of the trimming.
+func (trimmer *Trimmer) Report() TrimReport {
+	trimmer.mutex.Lock()
+	defer trimmer.mutex.Unlock()
+
+	return trimmer.report
+}
+
+// WriteReport writes the statistics of the trimming to a file in JSON
+// format.
+//
+// Parameters:
+//   - filename: The name of the file.
+//
+// Returns:
+//   - An error if the file cannot be written.
+func (trimmer *Trimmer) WriteReport(filename string) error {
+	output, err := json.MarshalIndent(trimmer.Report(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, append(output, '\n'), 0o644)
+}
+
+var _Trimmer *Trimmer
+
+// CLITrimSequences trims the reads according to the command line options.
+//
+// Parameters:
+//   - iterator: The reads, paired or not.
+//
+// Returns:
+//   - The trimmed reads long enough to be kept.
+func CLITrimSequences(iterator obiiter.IBioSequence) obiiter.IBioSequence {
+	_Trimmer = CLIMakeTrimmer()
+
+	trimmed := iterator.MakeISliceWorker(_Trimmer.SliceWorker(),
+		false,
+		obidefault.ParallelWorkers())
+
+	return trimmed
+}
+
+// CLIWriteStats writes the trimming report requested by the --stats option.
+// It must be called once every read has been trimmed.
+func CLIWriteStats() {
+	if _Trimmer == nil || !CLIHasStatsFile() {
+		return
+	}
+
+	if err := _Trimmer.WriteReport(CLIStatsFileName()); err != nil {
+		log.Fatalf("Cannot write the trimming report: %v", err)
+	}
+
+	log.Infof("Trimming report saved in file: %s", CLIStatsFileName())
+}
@@ -0,0 +1,119 @@
+package obitrim
+
+import (
+	"testing"
+
+	"git.metabarcoding.org/obitools/obitools4/obitools4/pkg/obiseq"
+)
+
+// testTrimmer builds a trimmer looking for the TruSeq adapter, without
+// quality trimming.
+func testTrimmer(t *testing.T, minLength int) *Trimmer {
+	adapter, err := MakeAdapter("truseq", 0.1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Trimmer{
+		adapters:   []*Adapter{adapter},
+		minOverlap: 3,
+		quality:    "none",
+		minLength:  minLength,
+		report: TrimReport{
+			Adapters: []*AdapterStats{{Name: adapter.Name, Sequence: adapter.Sequence}},
+		},
+	}
+}
+
+func reverseComplement(sequence string) string {
+	return obiseq.NewBioSequence("", []byte(sequence), "").ReverseComplement(true).String()
+}
+
+func TestTrimPairedInsert(t *testing.T) {
+	const (
+		truseq = "agatcggaagagcacacgtctgaa"
+		filler = "ttttccccttttccccttttcccc"
+	)
+
+	insert := _TestInsert[:30]
+
+	tests := []struct {
+		name              string
+		forward, reverse  string
+		lengths           [2]int
+		full, partial     int
+		inferred          int
+		forwardAnnotation bool
+	}{
+		{"adapter in the forward read",
+			insert + truseq, reverseComplement(insert) + filler,
+			[2]int{30, 30}, 1, 0, 1, true},
+		{"adapter suffix in the reverse read",
+			insert + "gtgtcgtgtagggaaagagt", reverseComplement(insert) + "agatcgga",
+			[2]int{30, 30}, 0, 1, 1, true},
+		{"adapters in both reads",
+			insert + truseq, reverseComplement(insert)[:28] + truseq,
+			[2]int{28, 28}, 2, 0, 0, true},
+		{"mate shorter than the insert",
+			insert + truseq, reverseComplement(insert)[:25],
+			[2]int{30, 25}, 1, 0, 0, true},
+		{"no adapter",
+			insert + filler, reverseComplement(insert) + filler,
+			[2]int{54, 54}, 0, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		trimmer := testTrimmer(t, 0)
+
+		forward := obiseq.NewBioSequence("read", []byte(test.forward), "")
+		reverse := obiseq.NewBioSequence("read", []byte(test.reverse), "")
+		forward.PairTo(reverse)
+
+		if !trimmer.trim(forward) {
+			t.Errorf("%s: pair discarded", test.name)
+			continue
+		}
+
+		if forward.Len() != test.lengths[0] || reverse.Len() != test.lengths[1] {
+			t.Errorf("%s: reads trimmed to %d and %d nucleotides, expected %d and %d",
+				test.name, forward.Len(), reverse.Len(), test.lengths[0], test.lengths[1])
+		}
+
+		if forward.Len() == len(insert) && forward.String() != insert {
+			t.Errorf("%s: forward read trimmed to %s, expected %s", test.name, forward.String(), insert)
+		}
+
+		if _, ok := forward.GetStringAttribute("obitrim_adapter"); ok != test.forwardAnnotation {
+			t.Errorf("%s: forward read annotated by an adapter: %v", test.name, ok)
+		}
+
+		stats := trimmer.Report().Adapters[0]
+		if stats.FullMatches != test.full || stats.PartialMatches != test.partial || stats.MateInferred != test.inferred {
+			t.Errorf("%s: %d full, %d partial and %d inferred adapters, expected %d, %d and %d",
+				test.name, stats.FullMatches, stats.PartialMatches, stats.MateInferred,
+				test.full, test.partial, test.inferred)
+		}
+
+		if report := trimmer.Report(); report.Reads != 2 || report.KeptReads != 2 || !report.Paired {
+			t.Errorf("%s: report counts %d reads and %d kept, paired: %v",
+				test.name, report.Reads, report.KeptReads, report.Paired)
+		}
+	}
+}
+
+// Both reads of a pair are discarded when one of them becomes too short.
+func TestTrimPairedTooShort(t *testing.T) {
+	trimmer := testTrimmer(t, 30)
+
+	forward := obiseq.NewBioSequence("read", []byte(_TestInsert[:29]+"agatcggaagagcacacg"), "")
+	reverse := obiseq.NewBioSequence("read", []byte(reverseComplement(_TestInsert)), "")
+	forward.PairTo(reverse)
+
+	if trimmer.trim(forward) {
+		t.Errorf("pair of %d and %d nucleotides kept", forward.Len(), reverse.Len())
+	}
+
+	if report := trimmer.Report(); report.TooShortReads != 2 || report.KeptReads != 0 {
+		t.Errorf("report counts %d too short and %d kept reads", report.TooShortReads, report.KeptReads)
+	}
+}
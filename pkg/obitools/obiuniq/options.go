@@ -11,6 +11,11 @@ var _InMemory = false
 var _chunks = 100
 var _NAValue = "NA"
 var _NoSingleton = false
+var _UMI = false
+var _UMIAttribute = "umi"
+var _UMISample = "sample"
+var _UMIDistance = 1
+var _UMIMethod = "directional"
 
 // UniqueOptionSet sets up unique options for the obiuniq command.
 //
@@ -43,6 +48,29 @@ func UniqueOptionSet(options *getoptions.GetOpt) {
 	options.IntVar(&_chunks, "chunk-count", _chunks,
 		options.Description("In how many chunk the dataset is pre-devided for speeding up the process."))
 
+	options.BoolVar(&_UMI, "umi", _UMI,
+		options.Description("Counts molecules instead of reads, using the unique molecular identifiers "+
+			"extracted by obimultiplex. The reads of a sample sharing a UMI are counted once. "+
+			"The number of reads is stored in the umi_reads attribute."))
+
+	options.StringVar(&_UMIAttribute, "umi-attribute", _UMIAttribute,
+		options.ArgName("ATTRIBUTE"),
+		options.Description("Attribute containing the UMIs."))
+
+	options.StringVar(&_UMISample, "umi-sample", _UMISample,
+		options.ArgName("ATTRIBUTE"),
+		options.Description("Attribute containing the sample names, the UMIs are grouped separately in each sample."))
+
+	options.IntVar(&_UMIDistance, "umi-distance", _UMIDistance,
+		options.ArgName("N"),
+		options.Description("Maximum number of differences between two UMIs resulting from the same molecule."))
+
+	options.StringVar(&_UMIMethod, "umi-method", _UMIMethod,
+		options.ArgName("METHOD"),
+		options.Description("Method used to group the UMIs resulting from the same molecule: "+
+			"exact, network or directional."),
+		options.ValidValues("exact", "network", "directional"))
+
 }
 
 // OptionSet adds to the basic option set every options declared for
@@ -150,3 +178,30 @@ func CLINoSingleton() bool {
 func SetNoSingleton(noSingleton bool) {
 	_NoSingleton = noSingleton
 }
+
+// CLIHasUMI returns true if the molecules are counted using the UMIs.
+func CLIHasUMI() bool {
+	return _UMI
+}
+
+// CLIUMIAttribute returns the attribute containing the UMIs.
+func CLIUMIAttribute() string {
+	return _UMIAttribute
+}
+
+// CLIUMISample returns the attribute containing the sample names used to
+// group the UMIs.
+func CLIUMISample() string {
+	return _UMISample
+}
+
+// CLIUMIDistance returns the maximum number of differences between two
+// UMIs resulting from the same molecule.
+func CLIUMIDistance() int {
+	return _UMIDistance
+}
+
+// CLIUMIMethod returns the method used to group the UMIs.
+func CLIUMIMethod() string {
+	return _UMIMethod
+}
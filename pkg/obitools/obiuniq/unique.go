@@ -48,6 +48,22 @@ func CLIUnique(sequences obiiter.IBioSequence) obiiter.IBioSequence {
 	options = append(options,
 		obichunk.OptionSubCategory(CLIKeys()...))
 
+	//
+	// Considers if the molecules are counted using the UMIs
+	//
+	// --umi
+
+	if CLIHasUMI() {
+		log.Printf("Counting molecules using the UMIs of the %s attribute (%s method, %d differences)",
+			CLIUMIAttribute(), CLIUMIMethod(), CLIUMIDistance())
+		options = append(options,
+			obichunk.OptionUMI(CLIUMIAttribute()),
+			obichunk.OptionUMISample(CLIUMISample()),
+			obichunk.OptionUMIDistance(CLIUMIDistance()),
+			obichunk.OptionUMIMethod(CLIUMIMethod()),
+		)
+	}
+
 	options = append(options,
 		obichunk.OptionsParallelWorkers(
 			obidefault.ParallelWorkers()),